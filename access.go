@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+var errForbidden = errors.New("permission denied")
+
+// Access restricts who may run and change an alias through the daemon. The
+// lists hold user names and "group:<name>" entries; an empty list lets
+// every user in. Access is only enforced by cmdex serve, except for
+// approvals, which cmdex run asks for too: whoever can open the database
+// file directly can do anything with it.
+type Access struct {
+	// ReadOnly refuses every change except by administrators.
+	ReadOnly bool     `json:"read_only,omitempty" yaml:"read_only,omitempty"`
+	Run      []string `json:"run,omitempty" yaml:"run,omitempty"`
+	Edit     []string `json:"edit,omitempty" yaml:"edit,omitempty"`
+	// Approve lists who may approve runs. When set, every run of the
+	// alias waits for approval by one of them; see cmdex approvals.
+	Approve []string `json:"approve,omitempty" yaml:"approve,omitempty"`
+}
+
+// apiUser is a client authenticated by the daemon.
+type apiUser struct {
+	name   string
+	groups []string
+	admin  bool
+}
+
+// matches reports whether u is named by one of the entries of list.
+func (u *apiUser) matches(list []string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, entry := range list {
+		if group, ok := strings.CutPrefix(entry, "group:"); ok {
+			if contains(u.groups, group) {
+				return true
+			}
+		} else if entry == u.name {
+			return true
+		}
+	}
+	return false
+}
+
+// canRun reports whether u may run a.
+func (u *apiUser) canRun(a *Alias) bool {
+	return u.admin || a.Access == nil || u.matches(a.Access.Run)
+}
+
+// canEdit reports whether u may change a.
+func (u *apiUser) canEdit(a *Alias) bool {
+	if u.admin || a.Access == nil {
+		return true
+	}
+	return !a.Access.ReadOnly && u.matches(a.Access.Edit)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// answersBucket holds placeholder answers remembered with run --remember,
+// keyed by project directory and alias.
+var answersBucket = []byte("answers")
+
+// projectDir returns the project the current directory belongs to: the
+// closest enclosing git work tree, or the directory itself.
+func projectDir() string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for dir := cwd; ; {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return cwd
+		}
+		dir = parent
+	}
+}
+
+func answersKey(project, alias string) []byte {
+	return []byte(project + "\x00" + aliasKey(alias))
+}
+
+// loadAnswers returns the answers remembered for alias in the current
+// project, by placeholder number.
+func loadAnswers(alias string) (map[int]string, error) {
+	var answers map[int]string
+	err := db.View(func(tx *bolt.Tx) error {
+		key := answersKey(projectDir(), alias)
+		v := tx.Bucket(answersBucket).Get(key)
+		if v == nil {
+			return nil
+		}
+		v, err := decodeValue(string(key), v)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(v, &answers)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading remembered answers: %w", err)
+	}
+	return answers, nil
+}
+
+// rememberAnswers adds answers to those remembered for alias in the
+// current project.
+func rememberAnswers(alias string, answers map[int]string) error {
+	if err := writableDB(); err != nil {
+		return err
+	}
+	project := projectDir()
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(answersBucket)
+		key := answersKey(project, alias)
+		all := make(map[int]string)
+		if v := b.Get(key); v != nil {
+			v, err := decodeValue(string(key), v)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(v, &all); err != nil {
+				return err
+			}
+		}
+		var numbers []int
+		for n, answer := range answers {
+			all[n] = answer
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+		var names []string
+		for _, n := range numbers {
+			names = append(names, "$"+strconv.Itoa(n))
+		}
+		v, err := json.Marshal(all)
+		if err != nil {
+			return err
+		}
+		if v, err = encodeValue(string(key), v); err != nil {
+			return err
+		}
+		if err := b.Put(key, v); err != nil {
+			return err
+		}
+		return writeAudit(tx, auditEntry{Action: "remember", Target: alias, Detail: strings.Join(names, " ") + " in " + project})
+	})
+	if err != nil {
+		return fmt.Errorf("remembering answers: %w", err)
+	}
+	return nil
+}
+
+// forgetAnswers drops the answers remembered for alias in the current
+// project.
+func forgetAnswers(alias string) error {
+	if err := writableDB(); err != nil {
+		return err
+	}
+	project := projectDir()
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(answersBucket)
+		key := answersKey(project, alias)
+		if b.Get(key) == nil {
+			return nil
+		}
+		if err := b.Delete(key); err != nil {
+			return err
+		}
+		return writeAudit(tx, auditEntry{Action: "forget", Target: alias, Detail: project})
+	})
+	if err != nil {
+		return fmt.Errorf("forgetting answers: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+var approvalsBucket = []byte("approvals")
+
+// The states of an approval request.
+const (
+	approvalPending  = "pending"
+	approvalApproved = "approved"
+	approvalDenied   = "denied"
+	// approvalUsed is an approved request whose run has started.
+	approvalUsed = "used"
+)
+
+// approval is a request to run an alias that needs approval. Runs of such
+// an alias submit a request and stop; once another user approves it, the
+// same run by the same user goes ahead, once.
+type approval struct {
+	ID    uint64    `json:"id"`
+	Alias string    `json:"alias"`
+	Args  []string  `json:"args,omitempty"`
+	User  string    `json:"user"`
+	Time  time.Time `json:"time"`
+	// Modified is when the alias was last changed at the time of the
+	// request. Changing the alias voids the approval.
+	Modified time.Time `json:"modified"`
+	Status   string    `json:"status"`
+	Approver string    `json:"approver,omitempty"`
+	Decided  time.Time `json:"decided"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// errApprovalPending is returned for runs that wait for approval.
+var errApprovalPending = errors.New("approval pending")
+
+// pendingError reports that a run waits for the approval request p.
+type pendingError struct {
+	p *approval
+}
+
+func (e *pendingError) Error() string {
+	return fmt.Sprintf("%s needs approval before it runs; request %d is pending (cmdex approvals approve %d)", e.p.Alias, e.p.ID, e.p.ID)
+}
+
+func (e *pendingError) Unwrap() error { return errApprovalPending }
+
+// needsApproval reports whether runs of a must be approved first.
+func (a *Alias) needsApproval() bool {
+	return a.Access != nil && len(a.Access.Approve) > 0
+}
+
+// canApprove reports whether u may approve runs of a.
+func (u *apiUser) canApprove(a *Alias) bool {
+	return u.admin || u.matches(a.Access.Approve)
+}
+
+func putApproval(tx *bolt.Tx, p *approval) error {
+	v, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	key := historyKey(p.ID)
+	if v, err = encodeValue(string(key), v); err != nil {
+		return err
+	}
+	return tx.Bucket(approvalsBucket).Put(key, v)
+}
+
+func getApproval(tx *bolt.Tx, id uint64) (*approval, error) {
+	key := historyKey(id)
+	v := tx.Bucket(approvalsBucket).Get(key)
+	if v == nil {
+		return nil, usageError(fmt.Errorf("no approval request %d", id))
+	}
+	v, err := decodeValue(string(key), v)
+	if err != nil {
+		return nil, err
+	}
+	p := &approval{}
+	if err := json.Unmarshal(v, p); err != nil {
+		return nil, fmt.Errorf("reading approval request %d: %w", id, err)
+	}
+	return p, nil
+}
+
+// forEachApproval calls fn for the approval requests, newest first.
+func forEachApproval(tx *bolt.Tx, fn func(p *approval) error) error {
+	c := tx.Bucket(approvalsBucket).Cursor()
+	for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+		p, err := getApproval(tx, binary.BigEndian.Uint64(k))
+		if err != nil {
+			return err
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requireApproval lets user run a, which needs approval, with args if an
+// approved request for exactly that run exists, using the request up.
+// Otherwise it submits a request, unless one is already pending, and
+// returns a *pendingError. detail goes into the audit log.
+func requireApproval(a *Alias, args []string, user, detail string) error {
+	var pending *approval
+	err := db.Update(func(tx *bolt.Tx) error {
+		var err error
+		pending, err = claimApproval(tx, a, args, user, detail)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("checking approval: %w", err)
+	}
+	if pending != nil {
+		return newError(exitApprovalPending, "approval_pending", &pendingError{pending})
+	}
+	return nil
+}
+
+// claimApproval does the work of requireApproval in tx, returning the
+// pending request if the run can't go ahead.
+func claimApproval(tx *bolt.Tx, a *Alias, args []string, user, detail string) (*approval, error) {
+	var found *approval
+	errFound := errors.New("found")
+	err := forEachApproval(tx, func(p *approval) error {
+		if p.Alias == a.ref() && p.User == user && quoteArgs(p.Args) == quoteArgs(args) && p.Modified.Equal(a.Modified) &&
+			(p.Status == approvalApproved || p.Status == approvalPending) {
+			found = p
+			return errFound
+		}
+		return nil
+	})
+	if err != nil && err != errFound {
+		return nil, err
+	}
+	if found != nil && found.Status == approvalApproved {
+		found.Status = approvalUsed
+		if err := putApproval(tx, found); err != nil {
+			return nil, err
+		}
+		return nil, writeAudit(tx, auditEntry{Action: "approval-use", Target: a.ref(), User: user, Detail: fmt.Sprintf("request %d approved by %s; %s", found.ID, found.Approver, detail)})
+	}
+	if found == nil {
+		id, err := tx.Bucket(approvalsBucket).NextSequence()
+		if err != nil {
+			return nil, err
+		}
+		found = &approval{ID: id, Alias: a.ref(), Args: args, User: user, Time: time.Now(), Modified: a.Modified, Status: approvalPending}
+		if err := putApproval(tx, found); err != nil {
+			return nil, err
+		}
+		// The arguments can hold secrets, and the audit log isn't
+		// encrypted at rest; they stay with the request alone.
+		request := fmt.Sprintf("request %d", id)
+		if len(args) > 0 {
+			request += " with arguments"
+		}
+		if err := writeAudit(tx, auditEntry{Action: "approval-request", Target: a.ref(), User: user, Detail: request + "; " + detail}); err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// decideApproval approves or denies the pending request id on behalf of u.
+func decideApproval(tx *bolt.Tx, u *apiUser, id uint64, approve bool, reason, detail string) (*approval, error) {
+	p, err := getApproval(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if p.Status != approvalPending {
+		return nil, usageError(fmt.Errorf("request %d is %s, not pending", id, p.Status))
+	}
+	a, err := getVariantOrLayered(tx, p.Alias)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case !a.needsApproval():
+		return nil, usageError(fmt.Errorf("%s no longer needs approval", p.Alias))
+	case u.name == p.User:
+		return nil, fmt.Errorf("%w: %s can't decide on their own request", errForbidden, u.name)
+	case !u.canApprove(a):
+		return nil, fmt.Errorf("%w: %s may not approve runs of %s", errForbidden, u.name, p.Alias)
+	}
+	action := "approve"
+	p.Status = approvalApproved
+	if !approve {
+		action, p.Status = "deny", approvalDenied
+	}
+	p.Approver, p.Decided, p.Reason = u.name, time.Now(), reason
+	if err := putApproval(tx, p); err != nil {
+		return nil, err
+	}
+	if reason != "" {
+		detail = reason + "; " + detail
+	}
+	return p, writeAudit(tx, auditEntry{Action: action, Target: p.Alias, User: u.name, Detail: fmt.Sprintf("request %d by %s; %s", id, p.User, detail)})
+}
+
+// parseApprovalID parses the id of an approval request.
+func parseApprovalID(s string) (uint64, error) {
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, usageError(fmt.Errorf("invalid approval request id %q", s))
+	}
+	return id, nil
+}
+
+func approvalsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "approvals",
+		Short: "List, approve and deny requests to run protected aliases",
+		Long: `Aliases whose access: section lists approvers only run once someone
+approves the run:
+
+  access:
+    approve: [alice, group:ops]
+
+Running such an alias, from the command line or through cmdex serve,
+submits an approval request and stops. Once one of the approvers (or an
+administrator of the daemon) other than the requester approves it, the same
+run by the same user, with the same arguments, goes ahead, once. Changing
+the alias voids its approvals. Every request and decision is recorded in
+the audit log.
+
+From the command line, approvers are matched by their OS user name; group
+entries only apply to users of the daemon, which serves the same requests at
+/approvals.`,
+		Example: "  cmdex approvals list\n  cmdex approvals approve 12\n  cmdex approvals deny 13 --reason 'not during the freeze'",
+	}
+	var all bool
+	list := &cobra.Command{
+		Use:         "list",
+		Short:       "List pending approval requests",
+		Annotations: readDB,
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var t table
+			t.color(1, "alias")
+			err := db.View(func(tx *bolt.Tx) error {
+				return forEachApproval(tx, func(p *approval) error {
+					if all || p.Status == approvalPending {
+						decided := ""
+						if p.Approver != "" {
+							decided = p.Approver + " " + formatTime(p.Decided)
+						}
+						t.add(fmt.Sprint(p.ID), p.Alias, quoteArgs(p.Args), p.User, formatTime(p.Time), p.Status, decided)
+					}
+					return nil
+				})
+			})
+			if err != nil {
+				return fmt.Errorf("listing approval requests: %w", err)
+			}
+			width, _, _ := terminalSize()
+			printLines(t.lines(width))
+			return nil
+		},
+	}
+	list.Flags().BoolVarP(&all, "all", "a", false, "Include decided and used requests")
+	cmd.AddCommand(list)
+	for _, approve := range []bool{true, false} {
+		approve := approve
+		var reason string
+		sub := &cobra.Command{
+			Use:   "approve <id>",
+			Short: "Approve a pending request",
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				id, err := parseApprovalID(args[0])
+				if err != nil {
+					return err
+				}
+				var p *approval
+				err = db.Update(func(tx *bolt.Tx) error {
+					p, err = decideApproval(tx, &apiUser{name: currentUser()}, id, approve, reason, "cli")
+					return err
+				})
+				if err != nil {
+					return fmt.Errorf("deciding on request %d: %w", id, err)
+				}
+				fmt.Printf("Request %d by %s to run %s is %s\n", p.ID, p.User, paint("alias", p.Alias), p.Status)
+				return nil
+			},
+		}
+		if !approve {
+			sub.Use, sub.Short = "deny <id>", "Deny a pending request"
+		}
+		sub.Flags().StringVar(&reason, "reason", "", "Why the request is approved or denied, for the audit log")
+		cmd.AddCommand(sub)
+	}
+	return cmd
+}
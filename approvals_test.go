@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestApproveLayeredAlias(t *testing.T) {
+	dir := t.TempDir()
+	layer := "aliases:\n  deploy:\n    command: echo deploy\n    access:\n      approve: [alice]\n"
+	if err := os.WriteFile(filepath.Join(dir, "ops.yaml"), []byte(layer), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CMDEX_SYSTEM_ALIASES", dir)
+	t.Setenv("CMDEX_DB", filepath.Join(dir, "cmdex.db"))
+	if err := openStore(false); err != nil {
+		t.Fatal(err)
+	}
+	defer closeDB()
+
+	a := layered("deploy")
+	if a == nil {
+		t.Fatal("deploy is not in the system layer")
+	}
+	var pending *approval
+	err := db.Update(func(tx *bolt.Tx) error {
+		var err error
+		pending, err = claimApproval(tx, a, nil, "bob", "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending == nil || pending.Status != approvalPending {
+		t.Fatalf("claimApproval = %+v, want a pending request", pending)
+	}
+
+	alice := &apiUser{name: "alice"}
+	err = db.Update(func(tx *bolt.Tx) error {
+		p, err := decideApproval(tx, alice, pending.ID, true, "", "test")
+		if err == nil && p.Status != approvalApproved {
+			t.Errorf("request is %s, want %s", p.Status, approvalApproved)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("approving request %d: %v", pending.ID, err)
+	}
+
+	// The approved request lets bob's run go ahead once.
+	err = db.Update(func(tx *bolt.Tx) error {
+		var err error
+		pending, err = claimApproval(tx, a, nil, "bob", "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending != nil {
+		t.Errorf("claimApproval after approval = %+v, want nil", pending)
+	}
+}
+
+func TestApprovalArgsLeftOutOfAudit(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CMDEX_SYSTEM_ALIASES", dir)
+	t.Setenv("CMDEX_DB", filepath.Join(dir, "cmdex.db"))
+	if err := openStore(false); err != nil {
+		t.Fatal(err)
+	}
+	defer closeDB()
+
+	a := &Alias{Name: "login", Command: "login {{1}}"}
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := claimApproval(tx, a, []string{"hunter22secret"}, "bob", "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(auditBucket).ForEach(func(k, v []byte) error {
+			if strings.Contains(string(v), "hunter22secret") {
+				t.Errorf("audit entry holds the arguments: %s", v)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
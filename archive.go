@@ -0,0 +1,521 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// archive is the file format written by export and read by import.
+type archive struct {
+	Version  int         `json:"version"`
+	Exported time.Time   `json:"exported"`
+	Aliases  []aliasJSON `json:"aliases"`
+}
+
+// aliases returns the aliases in the archive.
+func (ar *archive) aliases() []*Alias {
+	aliases := make([]*Alias, len(ar.Aliases))
+	for i, a := range ar.Aliases {
+		aliases[i] = a.Alias
+	}
+	return aliases
+}
+
+// ageHeader starts every binary age file.
+const ageHeader = "age-encryption.org/v1"
+
+func exportCmd() *cobra.Command {
+	var (
+		output     string
+		encrypt    bool
+		recipients []string
+		sel        aliasSelection
+		format     string
+	)
+	cmd := &cobra.Command{
+		Use:   "export [alias...]",
+		Short: "Write aliases to an archive file",
+		Long: `export writes the given aliases, or all of them, as a JSON archive that import
+reads back.
+
+Aliases are selected by name or glob pattern, as arguments or with --alias,
+where * matches across namespaces too (k8s* selects k8s/get and k8s); by
+namespace with --namespace (k8s selects k8s/deploy and k8s:logs); and by
+tag with --tag. Each selector may be repeated, selecting the aliases that
+match any of its values; different selectors narrow each other down, so
+--namespace k8s --tag prod exports the aliases of the k8s namespace tagged
+prod.
+
+With --encrypt the archive is encrypted with age using a passphrase (asked
+for, or taken from CMDEX_PASSPHRASE). With --recipient it is encrypted to
+age public keys instead, and import decrypts it with the matching identity
+file. Encrypted archives written to a terminal are ASCII-armored.
+
+With --format markdown or html, export instead writes a document of the
+aliases grouped by tag, for publishing the catalog on a wiki. With --format
+vscode-tasks it writes a .vscode/tasks.json with a task running each alias,
+whose placeholders VS Code asks for when the task starts.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !contains([]string{"json", "markdown", "html", "vscode-tasks"}, format) {
+				return usageError(fmt.Errorf("invalid --format %q (use json, markdown, html or vscode-tasks)", format))
+			}
+			sel.names = append(sel.names, args...)
+			if err := sel.validate(); err != nil {
+				return err
+			}
+			ar := archive{Version: 1, Exported: time.Now(), Aliases: []aliasJSON{}}
+			err := db.View(func(tx *bolt.Tx) error {
+				for _, name := range sel.plainNames() {
+					if _, err := getAlias(tx, name); err != nil {
+						return fmt.Errorf("%s: %w", name, err)
+					}
+				}
+				return forEachAlias(tx, func(a *Alias) error {
+					if sel.matches(a) {
+						ar.Aliases = append(ar.Aliases, aliasJSON{a.Name, a})
+					}
+					return nil
+				})
+			})
+			if err != nil {
+				return fmt.Errorf("exporting: %w", err)
+			}
+			var data []byte
+			switch format {
+			case "markdown":
+				data = renderMarkdown(ar.aliases())
+			case "html":
+				data, err = renderHTML(ar.aliases())
+			case "vscode-tasks":
+				data, err = renderVSCodeTasks(ar.aliases())
+			default:
+				data, err = json.MarshalIndent(ar, "", "  ")
+			}
+			if err != nil {
+				return err
+			}
+
+			out := os.Stdout
+			if output != "" && output != "-" {
+				if out, err = os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600); err != nil {
+					return fmt.Errorf("exporting: %w", err)
+				}
+				defer out.Close()
+			}
+			if encrypt || len(recipients) > 0 {
+				if data, err = encryptArchive(data, recipients, isTerminal(out)); err != nil {
+					return err
+				}
+			} else if format == "json" || format == "vscode-tasks" {
+				data = append(data, '\n')
+			}
+			if _, err := out.Write(data); err != nil {
+				return fmt.Errorf("exporting: %w", err)
+			}
+			if out == os.Stdout {
+				return nil
+			}
+			if err := out.Close(); err != nil {
+				return fmt.Errorf("exporting: %w", err)
+			}
+			fmt.Fprintf(os.Stderr, "Exported %d aliases to %s\n", len(ar.Aliases), output)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Write the archive to this file instead of stdout")
+	cmd.Flags().BoolVar(&encrypt, "encrypt", false, "Encrypt the archive with a passphrase")
+	cmd.Flags().StringArrayVarP(&recipients, "recipient", "r", nil, "Encrypt the archive to this age public key (repeatable)")
+	sel.addFlags(cmd, "export")
+	cmd.Flags().StringVar(&format, "format", "json", "Output format: json (an archive for import), markdown, html or vscode-tasks")
+	return cmd
+}
+
+func importCmd() *cobra.Command {
+	var (
+		identity string
+		force    bool
+		merge    bool
+		sel      aliasSelection
+	)
+	cmd := &cobra.Command{
+		Use:   "import [file]",
+		Short: "Read aliases from an archive written by export",
+		Long: `import adds the aliases of an export archive, read from file or stdin. Aliases
+that already exist are skipped unless --force is given, which overwrites
+them, or --merge.
+
+With --merge, import merges an updated archive, such as a new version of a
+team bundle, with the local changes to its aliases, field by field. import
+remembers the version of each alias it last brought in: a field changed
+only in the archive since then takes the archive's value, one changed only
+locally keeps the local value, and one changed on both sides to different
+values is a conflict. Conflicts are shown with both values to keep the
+local one, keep the remote one or write a new one in $EDITOR; without a
+terminal the local value is kept, with a warning. Aliases imported before
+import remembered them have no common version, so every field that differs
+is a conflict. cmdex diff shows what an import would change beforehand.
+
+--alias, --namespace and --tag import only the aliases of the archive they
+select, as they select those export writes.
+
+Encrypted archives are detected automatically and decrypted with the
+passphrase (asked for, or taken from CMDEX_PASSPHRASE), or with the age
+identity file given with --identity.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if force && merge {
+				return usageError(fmt.Errorf("--force and --merge can't be combined"))
+			}
+			if err := sel.validate(); err != nil {
+				return err
+			}
+			var (
+				data []byte
+				err  error
+			)
+			source := "stdin"
+			if len(args) == 0 || args[0] == "-" {
+				data, err = io.ReadAll(os.Stdin)
+			} else {
+				data, err = os.ReadFile(args[0])
+				if abs, err := filepath.Abs(args[0]); err == nil {
+					source = abs
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("reading archive: %w", err)
+			}
+			ar, err := readArchive(data, identity)
+			if err != nil {
+				return err
+			}
+			in := make(map[string]bool)
+			for _, entry := range ar.Aliases {
+				in[aliasKey(entry.Name)] = true
+			}
+			for _, name := range sel.plainNames() {
+				if !in[aliasKey(name)] {
+					return fmt.Errorf("%s: not in the archive", name)
+				}
+			}
+
+			// Conflicts are resolved before the store is written to, as the
+			// prompts would otherwise hold its lock.
+			var merges map[string]*aliasMerge
+			if merge {
+				resolve := keepLocal
+				if interactive() {
+					resolve = askConflict
+				}
+				var selected []*Alias
+				for _, entry := range ar.Aliases {
+					entry.Alias.Name = entry.Name
+					if sel.matches(entry.Alias) {
+						selected = append(selected, entry.Alias)
+					}
+				}
+				if merges, err = planMerges(selected, resolve); err != nil {
+					return fmt.Errorf("importing: %w", err)
+				}
+			}
+
+			var added, skipped, merged, conflicts int
+			err = db.Update(func(tx *bolt.Tx) error {
+				for _, entry := range ar.Aliases {
+					a := entry.Alias
+					a.Name = entry.Name
+					if !sel.matches(a) {
+						continue
+					}
+					audit := auditEntry{Action: "import", Target: a.Name}
+					existing, err := getAlias(tx, a.Name)
+					if m, planned := merges[aliasKey(a.Name)]; merge && (planned || err == nil) {
+						if !planned || err != nil || fieldText(existing) != fieldText(m.local) {
+							return fmt.Errorf("alias %s changed during the merge; import again", a.Name)
+						}
+						conflicts += m.conflicts
+						if err := putBase(tx, a); err != nil {
+							return err
+						}
+						if fieldText(m.merged) == fieldText(existing) {
+							continue
+						}
+						// Runs may have been recorded since the merge began.
+						a = m.merged
+						a.Created, a.Uses, a.LastUsed, a.Provenance = existing.Created, existing.Uses, existing.LastUsed, existing.Provenance
+						a.Modified = time.Now()
+						audit.Detail = "merged"
+						merged++
+					} else if err == nil && !force {
+						skipped++
+						fmt.Fprintf(os.Stderr, "Skipping existing alias %s\n", a.Name)
+						continue
+					} else {
+						if a.Created.IsZero() {
+							a.Created = time.Now()
+						}
+						if err := putBase(tx, a); err != nil {
+							return err
+						}
+						// What the archive says of the alias's origin is
+						// only as trustworthy as the archive.
+						a.Provenance = newProvenance("import", source)
+						added++
+					}
+					if err := policy.checkAlias(a); err != nil {
+						return err
+					}
+					if err := putAlias(tx, a); err != nil {
+						return err
+					}
+					if err := writeAudit(tx, audit); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("importing: %w", err)
+			}
+			fmt.Printf("Imported %d aliases", added)
+			if merged > 0 {
+				fmt.Printf(", merged changes into %d", merged)
+			}
+			if conflicts > 0 {
+				fmt.Printf(" (%d conflicting fields)", conflicts)
+			}
+			if skipped > 0 {
+				fmt.Printf(", skipped %d existing (use --merge to merge their changes, or --force to overwrite)", skipped)
+			}
+			fmt.Println()
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&identity, "identity", "i", "", "Decrypt with this age identity file instead of a passphrase")
+	cmd.Flags().BoolVar(&force, "force", false, "Overwrite aliases that already exist")
+	cmd.Flags().BoolVar(&merge, "merge", false, "Merge the changes to aliases that already exist with the local ones")
+	sel.addFlags(cmd, "import")
+	return cmd
+}
+
+// readArchive decrypts and decodes the export archive data, checking its
+// aliases.
+func readArchive(data []byte, identity string) (*archive, error) {
+	data, err := decryptArchive(data, identity)
+	if err != nil {
+		return nil, err
+	}
+	var ar archive
+	if err := json.Unmarshal(data, &ar); err != nil {
+		return nil, fmt.Errorf("reading archive: %w", err)
+	}
+	if ar.Version != 1 {
+		return nil, fmt.Errorf("unsupported archive version %d", ar.Version)
+	}
+	for _, entry := range ar.Aliases {
+		if entry.Alias == nil || entry.Name == "" {
+			return nil, fmt.Errorf("reading archive: alias without a name")
+		}
+		if err := entry.validate(); err != nil {
+			return nil, fmt.Errorf("reading archive: %s: %w", entry.Name, err)
+		}
+	}
+	return &ar, nil
+}
+
+// hasAnyTag reports whether a carries one of tags.
+func hasAnyTag(a *Alias, tags []string) bool {
+	for _, t := range a.Tags {
+		if contains(tags, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// aliasSelection selects the aliases export writes and import reads: by
+// name or pattern, by namespace and by tag. An alias is selected when it
+// matches one of the values of every selector given.
+type aliasSelection struct {
+	names, namespaces, tags []string
+}
+
+// addFlags adds the selectors to cmd, whose verb, export or import, the
+// flag descriptions use.
+func (sel *aliasSelection) addFlags(cmd *cobra.Command, verb string) {
+	cmd.Flags().StringArrayVar(&sel.names, "alias", nil, "Only "+verb+" the aliases of this name or glob pattern, whose * also matches across /, so k8s* selects k8s/get (repeatable)")
+	cmd.Flags().StringArrayVar(&sel.namespaces, "namespace", nil, "Only "+verb+" the aliases in this namespace, such as k8s (repeatable)")
+	cmd.Flags().StringSliceVarP(&sel.tags, "tag", "t", nil, "Only "+verb+" aliases with one of these tags")
+}
+
+func (sel *aliasSelection) validate() error {
+	for _, pattern := range sel.names {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return usageError(fmt.Errorf("invalid alias pattern %q", pattern))
+		}
+	}
+	for _, ns := range sel.namespaces {
+		if strings.Trim(ns, namespaceSeparators) == "" {
+			return usageError(fmt.Errorf("invalid namespace %q", ns))
+		}
+	}
+	return nil
+}
+
+// plainNames returns the names selected that aren't patterns, which must
+// exist.
+func (sel *aliasSelection) plainNames() []string {
+	var names []string
+	for _, name := range sel.names {
+		if !strings.ContainsAny(name, "*?[\\") {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// matchAliasPattern reports whether name matches the glob pattern. Unlike
+// path.Match, * and ? match / too, which separates namespaces as : and .
+// do.
+func matchAliasPattern(pattern, name string) bool {
+	slashless := func(s string) string { return strings.ReplaceAll(aliasKey(s), "/", "\x00") }
+	ok, _ := path.Match(slashless(pattern), slashless(name))
+	return ok
+}
+
+func (sel *aliasSelection) matches(a *Alias) bool {
+	if len(sel.tags) > 0 && !hasAnyTag(a, sel.tags) {
+		return false
+	}
+	if len(sel.names) > 0 {
+		found := false
+		for _, pattern := range sel.names {
+			if matchAliasPattern(pattern, a.Name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(sel.namespaces) > 0 {
+		found := false
+		for _, ns := range sel.namespaces {
+			if inNamespace(a.Name, ns) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// encryptArchive encrypts data to the age recipients, or with a passphrase
+// when there are none.
+func encryptArchive(data []byte, recipients []string, armored bool) ([]byte, error) {
+	var rs []age.Recipient
+	for _, r := range recipients {
+		rcpt, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return nil, usageError(fmt.Errorf("invalid recipient %q: %w", r, err))
+		}
+		rs = append(rs, rcpt)
+	}
+	if len(rs) == 0 {
+		pass, err := askPassphrase("Passphrase", true)
+		if err != nil {
+			return nil, err
+		}
+		r, err := age.NewScryptRecipient(pass)
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, r)
+	}
+
+	var buf bytes.Buffer
+	var dst io.Writer = &buf
+	var aw io.WriteCloser
+	if armored {
+		aw = armor.NewWriter(&buf)
+		dst = aw
+	}
+	w, err := age.Encrypt(dst, rs...)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting archive: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("encrypting archive: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("encrypting archive: %w", err)
+	}
+	if aw != nil {
+		if err := aw.Close(); err != nil {
+			return nil, fmt.Errorf("encrypting archive: %w", err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// decryptArchive returns data decrypted if it is an age file, and
+// unchanged otherwise.
+func decryptArchive(data []byte, identityFile string) ([]byte, error) {
+	var src io.Reader
+	switch {
+	case bytes.HasPrefix(data, []byte(armor.Header)):
+		src = armor.NewReader(bytes.NewReader(data))
+	case bytes.HasPrefix(data, []byte(ageHeader)):
+		src = bytes.NewReader(data)
+	default:
+		return data, nil
+	}
+
+	var ids []age.Identity
+	if identityFile != "" {
+		f, err := os.Open(identityFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading identity: %w", err)
+		}
+		defer f.Close()
+		if ids, err = age.ParseIdentities(bufio.NewReader(f)); err != nil {
+			return nil, fmt.Errorf("reading identity %s: %w", identityFile, err)
+		}
+	} else {
+		pass, err := askPassphrase("Passphrase", false)
+		if err != nil {
+			return nil, err
+		}
+		id, err := age.NewScryptIdentity(pass)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	r, err := age.Decrypt(src, ids...)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting archive: %w", err)
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting archive: %w", err)
+	}
+	return plain, nil
+}
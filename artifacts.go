@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// artifactsRoot returns the directory that holds the artifacts of every
+// run: history.artifacts in the config, or cmdex/artifacts in the user's
+// data directory.
+func artifactsRoot() (string, error) {
+	if dir := currentConfig().History.Artifacts; dir != "" {
+		return expandHome(dir), nil
+	}
+	return dataDir("artifacts")
+}
+
+// dataDir returns the directory name in cmdex's part of the user's data
+// directory.
+func dataDir(name string) (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "cmdex", name), nil
+	}
+	if goruntime.GOOS != "windows" && goruntime.GOOS != "darwin" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, ".local", "share", "cmdex", name), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "cmdex", name), nil
+}
+
+// collectArtifacts copies the files matching the alias's artifact patterns
+// into a new directory for this run, kept in s.artifacts for the history.
+// Artifacts are collected whether the run succeeded or not; a failure to
+// collect them is reported but doesn't fail the run.
+func (s *sequence) collectArtifacts(start time.Time) {
+	dir := s.dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	var files []string
+	for _, pattern := range s.alias.Artifacts {
+		expanded, err := expandTemplate(pattern, s.data)
+		if err != nil {
+			printWarning("Warning: artifact %s: %v", pattern, err)
+			continue
+		}
+		expanded = expandHome(expanded)
+		if !filepath.IsAbs(expanded) {
+			expanded = filepath.Join(dir, expanded)
+		}
+		matches, err := filepath.Glob(expanded)
+		if err != nil {
+			printWarning("Warning: artifact %s: %v", pattern, err)
+			continue
+		}
+		if len(matches) == 0 {
+			printWarning("Warning: artifact %s matched no files", pattern)
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return
+	}
+
+	root, err := artifactsRoot()
+	if err != nil {
+		printWarning("Warning: collecting artifacts: %v", err)
+		return
+	}
+	name := s.alias.ref()
+	if name == "" {
+		name = "exec"
+	}
+	into, err := reserveDir(root, fmt.Sprintf("%s-%s", strings.ReplaceAll(name, string(filepath.Separator), "_"), start.Format("20060102-150405")))
+	if err != nil {
+		printWarning("Warning: collecting artifacts: %v", err)
+		return
+	}
+	n := 0
+	for _, file := range files {
+		// Files under the working directory keep their place in it;
+		// others go in by their base name.
+		rel, err := filepath.Rel(dir, file)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			rel = filepath.Base(file)
+		}
+		copied, err := copyTree(file, filepath.Join(into, rel))
+		n += copied
+		if err != nil {
+			printWarning("Warning: artifact %s: %v", file, err)
+		}
+	}
+	if n > 0 {
+		s.artifacts = into
+		s.trace(verbosityVerbose, "%d artifacts saved in %s", n, into)
+	}
+}
+
+// reserveDir creates a new directory in root named name, or name-2, name-3
+// and so on if runs started in the same second took the name already.
+func reserveDir(root, name string) (string, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return "", err
+	}
+	for i := 1; ; i++ {
+		dir := filepath.Join(root, name)
+		if i > 1 {
+			dir += "-" + strconv.Itoa(i)
+		}
+		err := os.Mkdir(dir, 0755)
+		if err == nil {
+			return dir, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+	}
+}
+
+// checkArtifacts reports whether the artifact patterns are valid globs.
+func checkArtifacts(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid artifact pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// copyTree copies the file or directory tree from to to, returning how many
+// files it copied.
+func copyTree(from, to string) (int, error) {
+	n := 0
+	err := filepath.WalkDir(from, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(to, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if err := copyFile(path, target); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func historyArtifactsCmd() *cobra.Command {
+	var dirOnly bool
+	cmd := &cobra.Command{
+		Use:   "artifacts <run>",
+		Short: "Show where the artifacts of a run were saved",
+		Long: `artifacts shows the directory holding the files a run saved as artifacts,
+and the files in it. Aliases declare their artifacts with save --artifact
+or artifacts: in a spec: paths or glob patterns, relative to the working
+directory, that are copied after every run. They are kept under
+history.artifacts in the config, by default cmdex/artifacts in the user's
+data directory.`,
+		Example:     "  cmdex save report --artifact 'out/*.html' 'make report'\n  cmdex history artifacts 42\n  cd \"$(cmdex history artifacts --dir 42)\"",
+		Annotations: readDB,
+		Args:        cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var e *historyEntry
+			err := db.View(func(tx *bolt.Tx) error {
+				var err error
+				e, err = resolveRun(tx, args[0])
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("reading history: %w", err)
+			}
+			if e.Artifacts == "" {
+				return fmt.Errorf("run %s saved no artifacts", args[0])
+			}
+			if dirOnly {
+				fmt.Println(e.Artifacts)
+				return nil
+			}
+			var t table
+			err = filepath.WalkDir(e.Artifacts, func(path string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				rel, _ := filepath.Rel(e.Artifacts, path)
+				t.add("  "+rel, strconv.FormatInt(info.Size(), 10), formatTime(info.ModTime()))
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("listing artifacts: %w", err)
+			}
+			fmt.Println(e.Artifacts)
+			printLines(t.lines(0))
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&dirOnly, "dir", false, "Only print the directory, for use in scripts")
+	return cmd
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+var auditBucket = []byte("audit")
+
+// auditEntry records who changed or ran what, from where and when. Entries
+// are keyed by a sequence number and never rewritten or deleted.
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"`
+	Host   string    `json:"host"`
+	Action string    `json:"action"`
+	// Target is the alias or variable acted on.
+	Target string `json:"target,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// currentUser returns the name of the OS user running cmdex.
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// writeAudit appends e to the audit log, filling in the time, user and host
+// unless the caller already did.
+func writeAudit(tx *bolt.Tx, e auditEntry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.User == "" {
+		e.User = currentUser()
+	}
+	if e.Host == "" {
+		e.Host, _ = os.Hostname()
+	}
+	b := tx.Bucket(auditBucket)
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return b.Put(key, v)
+}
+
+// parseSince parses a --since value: a duration such as 36h or 7d, or a
+// date in YYYY-MM-DD or RFC 3339 form.
+func parseSince(s string) (time.Time, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (use e.g. 7d, 12h or 2024-01-31)", s)
+}
+
+func auditCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Inspect the audit log of changes and runs",
+	}
+	var (
+		since   string
+		target  string
+		asJSON  bool
+		limit   int
+		actions []string
+	)
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List audit log entries, oldest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var from time.Time
+			if since != "" {
+				var err error
+				if from, err = parseSince(since); err != nil {
+					return usageError(err)
+				}
+			}
+			var entries []auditEntry
+			err := db.View(func(tx *bolt.Tx) error {
+				return tx.Bucket(auditBucket).ForEach(func(k, v []byte) error {
+					var e auditEntry
+					if err := json.Unmarshal(v, &e); err != nil {
+						return err
+					}
+					if e.Time.Before(from) || target != "" && e.Target != target {
+						return nil
+					}
+					if len(actions) > 0 && !contains(actions, e.Action) {
+						return nil
+					}
+					entries = append(entries, e)
+					return nil
+				})
+			})
+			if err != nil {
+				return fmt.Errorf("reading audit log: %w", err)
+			}
+			if limit > 0 && len(entries) > limit {
+				entries = entries[len(entries)-limit:]
+			}
+
+			if asJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if entries == nil {
+					entries = []auditEntry{}
+				}
+				return enc.Encode(entries)
+			}
+			var t table
+			t.color(3, "alias")
+			for _, e := range entries {
+				t.add(e.Time.Local().Format("2006-01-02 15:04:05"), e.User+"@"+e.Host, e.Action, e.Target, e.Detail)
+			}
+			printLines(t.lines(0))
+			return nil
+		},
+	}
+	list.Flags().StringVar(&since, "since", "", "Only show entries newer than this (e.g. 7d, 12h, 2024-01-31)")
+	list.Flags().StringVar(&target, "alias", "", "Only show entries for this alias or variable")
+	list.Flags().StringSliceVar(&actions, "action", nil, "Only show these actions (save, edit, run, var-set, var-unset)")
+	list.Flags().IntVar(&limit, "limit", 0, "Show at most this many of the newest entries")
+	list.Flags().BoolVar(&asJSON, "json", false, "Print the entries as JSON")
+	cmd.AddCommand(list)
+	return cmd
+}
+
+// contains reports whether list holds s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// batchResult is the outcome of one alias in a tagged run.
+type batchResult struct {
+	alias    string
+	err      error
+	duration time.Duration
+	output   bytes.Buffer
+}
+
+// runTagged runs every alias tagged tag, one after another or, with
+// parallel, all at once. Every alias runs even if others fail; the run fails
+// if any of them did.
+func runTagged(ctx context.Context, tag string, parallel bool, opts runOptions) error {
+	var names []string
+	err := db.View(func(tx *bolt.Tx) error {
+		for _, name := range taggedNames(tx, tag) {
+			a, err := getAlias(tx, name)
+			if err != nil {
+				return err
+			}
+			// A deprecated alias forwards to its replacement, which runs
+			// under its own name if it carries the tag too.
+			if a.Deprecated == nil {
+				names = append(names, a.Name)
+			}
+		}
+		// Aliases from layers carry their tags themselves, not in the
+		// index.
+		for _, a := range layeredAliases(tx) {
+			if contains(a.Tags, tag) && a.Deprecated == nil {
+				names = append(names, a.Name)
+			}
+		}
+		sort.Strings(names)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("listing aliases: %w", err)
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no aliases tagged %s", tag)
+	}
+
+	// Arguments and confirmations are settled up front, so that prompts
+	// don't interleave with running commands.
+	results := make([]*batchResult, len(names))
+	seqs := make([]*sequence, len(names))
+	for i, name := range names {
+		results[i] = &batchResult{alias: name}
+		seqs[i], results[i].err = prepareRun(name, nil, opts)
+	}
+	closeDB()
+
+	run := func(i int) {
+		r, s := results[i], seqs[i]
+		ctx := ctx
+		if opts.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+			defer cancel()
+		}
+		start := time.Now()
+		r.err = s.execute(ctx)
+		r.duration = time.Since(start)
+	}
+	if parallel {
+		var (
+			wg sync.WaitGroup
+			mu sync.Mutex
+		)
+		for i := range names {
+			if seqs[i] == nil {
+				printBatchResult(results[i])
+				continue
+			}
+			seqs[i].stdout = &results[i].output
+			seqs[i].stderr = &results[i].output
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				run(i)
+				mu.Lock()
+				defer mu.Unlock()
+				os.Stdout.Write(results[i].output.Bytes())
+				printBatchResult(results[i])
+			}(i)
+		}
+		wg.Wait()
+	} else {
+		for i := range names {
+			if seqs[i] != nil {
+				banner(os.Stderr, verbosityNormal, "==> "+names[i])
+				run(i)
+			}
+			printBatchResult(results[i])
+		}
+	}
+
+	failed := 0
+	for i, r := range results {
+		if seqs[i] != nil {
+			recordHistory(seqs[i].historyEntry(time.Now().Add(-r.duration), r.duration, r.err))
+		}
+		if r.err != nil {
+			failed++
+		}
+	}
+	if verbosity >= verbosityNormal {
+		fmt.Fprintf(os.Stderr, "%d of %d aliases tagged %s succeeded\n", len(results)-failed, len(results), tag)
+	}
+	if failed > 0 {
+		return newError(exitChildFailed, "child_failed", fmt.Errorf("%d of %d aliases failed", failed, len(results)))
+	}
+	return nil
+}
+
+// printBatchResult writes the status line of one alias in a tagged run.
+func printBatchResult(r *batchResult) {
+	if r.err != nil {
+		fmt.Fprintln(os.Stderr, paintFor(os.Stderr, "error", "FAIL")+" "+paintFor(os.Stderr, "alias", r.alias)+": "+r.err.Error())
+		return
+	}
+	if verbosity >= verbosityNormal {
+		fmt.Fprintf(os.Stderr, "ok   %s (%s)\n", paintFor(os.Stderr, "alias", r.alias), r.duration.Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// blobsBucket holds script bodies too large to keep inline in their alias
+// record, keyed by the SHA-256 of the body. Aliases with identical scripts
+// share one blob.
+var blobsBucket = []byte("blobs")
+
+// blobMinSize is the size from which script bodies are stored as blobs.
+const blobMinSize = 1024
+
+// blob is a stored script body and the number of aliases referring to it.
+type blob struct {
+	Refs int    `json:"refs"`
+	Body string `json:"body"`
+}
+
+func blobKey(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+func getBlob(tx *bolt.Tx, key string) (*blob, error) {
+	v := tx.Bucket(blobsBucket).Get([]byte(key))
+	if v == nil {
+		return nil, fmt.Errorf("script blob %s is missing", key)
+	}
+	v, err := decodeValue(key, v)
+	if err != nil {
+		return nil, err
+	}
+	b := &blob{}
+	if err := json.Unmarshal(v, b); err != nil {
+		return nil, fmt.Errorf("reading script blob %s: %w", key, err)
+	}
+	return b, nil
+}
+
+func putBlob(tx *bolt.Tx, key string, b *blob) error {
+	v, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	if v, err = encodeValue(key, v); err != nil {
+		return err
+	}
+	return tx.Bucket(blobsBucket).Put([]byte(key), v)
+}
+
+// retainBlob stores body as a blob, or adds a reference to the blob that
+// already holds it, and returns its key.
+func retainBlob(tx *bolt.Tx, body string) (string, error) {
+	key := blobKey(body)
+	b := &blob{Body: body}
+	if tx.Bucket(blobsBucket).Get([]byte(key)) != nil {
+		var err error
+		if b, err = getBlob(tx, key); err != nil {
+			return "", err
+		}
+	}
+	b.Refs++
+	return key, putBlob(tx, key, b)
+}
+
+// releaseBlob drops a reference to the blob key, deleting the blob with its
+// last reference.
+func releaseBlob(tx *bolt.Tx, key string) error {
+	b, err := getBlob(tx, key)
+	if err != nil {
+		return err
+	}
+	if b.Refs--; b.Refs <= 0 {
+		return tx.Bucket(blobsBucket).Delete([]byte(key))
+	}
+	return putBlob(tx, key, b)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bookkeeping such as usage counters and the run history isn't needed by
+// the run itself, so rather than committing it on its own before and after
+// the command, paying an fsync each time, it's queued and written in one
+// transaction as cmdex exits.
+var (
+	pendingMu     sync.Mutex
+	pendingWrites []func(tx *bolt.Tx) error
+)
+
+// deferWrite queues fn to run in the transaction flushWrites commits.
+func deferWrite(fn func(tx *bolt.Tx) error) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	pendingWrites = append(pendingWrites, fn)
+}
+
+// flushWrites commits the queued writes, opening the database again if the
+// run closed it or opened it read-only. Failures are reported but don't
+// fail the command, nor keep the other writes from being committed: a write
+// that fails rolls the transaction back, and the others are written again
+// without it. The writes for an alias removed since they were queued are
+// dropped the same way, only quietly, so that nothing they wrote before
+// finding it gone is kept.
+func flushWrites() {
+	pendingMu.Lock()
+	writes := pendingWrites
+	pendingWrites = nil
+	pendingMu.Unlock()
+	if len(writes) == 0 {
+		return
+	}
+	if err := writableDB(); err != nil {
+		printError("Error recording usage and history: %v", err)
+		return
+	}
+	for len(writes) > 0 {
+		failed := -1
+		// Not db.Batch: there's nothing to coalesce with, and it would
+		// wait out its batch delay before committing.
+		err := db.Update(func(tx *bolt.Tx) error {
+			for i, fn := range writes {
+				if err := fn(tx); err != nil {
+					failed = i
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errAliasNotFound) {
+			printError("Error recording usage and history: %v", err)
+		}
+		if failed < 0 {
+			return
+		}
+		writes = append(writes[:failed:failed], writes[failed+1:]...)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestFlushWritesDropsFailed(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CMDEX_SYSTEM_ALIASES", dir)
+	t.Setenv("CMDEX_DB", filepath.Join(dir, "cmdex.db"))
+	if err := openStore(false); err != nil {
+		t.Fatal(err)
+	}
+	defer closeDB()
+
+	bucket := []byte("flush-test")
+	// Each write puts its key; "fail" and "gone" ones then fail, with
+	// another error or errAliasNotFound, and must leave nothing behind.
+	write := func(key, outcome string) func(tx *bolt.Tx) error {
+		return func(tx *bolt.Tx) error {
+			if err := tx.Bucket(bucket).Put([]byte(key), []byte(outcome)); err != nil {
+				return err
+			}
+			switch outcome {
+			case "fail":
+				return errors.New("disk full")
+			case "gone":
+				return errAliasNotFound
+			}
+			return nil
+		}
+	}
+	tests := []struct {
+		name   string
+		writes [][2]string
+		want   []string
+	}{
+		{"all written", [][2]string{{"a", "ok"}, {"b", "ok"}, {"c", "ok"}}, []string{"a", "b", "c"}},
+		{"first fails", [][2]string{{"a", "fail"}, {"b", "ok"}}, []string{"b"}},
+		{"middle fails", [][2]string{{"a", "ok"}, {"b", "fail"}, {"c", "ok"}}, []string{"a", "c"}},
+		{"last gone", [][2]string{{"a", "ok"}, {"b", "gone"}}, []string{"a"}},
+		{"several fail", [][2]string{{"a", "fail"}, {"b", "ok"}, {"c", "gone"}, {"d", "ok"}}, []string{"b", "d"}},
+		{"all fail", [][2]string{{"a", "fail"}, {"b", "gone"}}, nil},
+	}
+	for _, tt := range tests {
+		err := db.Update(func(tx *bolt.Tx) error {
+			tx.DeleteBucket(bucket)
+			_, err := tx.CreateBucket(bucket)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range tt.writes {
+			deferWrite(write(w[0], w[1]))
+		}
+		flushWrites()
+		if len(pendingWrites) > 0 {
+			t.Errorf("%s: %d writes still queued", tt.name, len(pendingWrites))
+			pendingWrites = nil
+		}
+		var got []string
+		err = db.View(func(tx *bolt.Tx) error {
+			return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+				got = append(got, string(k))
+				return nil
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: written %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import "fmt"
+
+// nameClash returns what else the alias name would shadow as a command, a
+// program on PATH or a shell builtin, or "" if nothing.
+func nameClash(name string) string {
+	if shellBuiltins[name] {
+		return "the shell builtin " + name
+	}
+	dir, _ := shimDir()
+	if other := otherCommand(name, dir); other != "" {
+		return other
+	}
+	return ""
+}
+
+// checkNameClash warns about a new alias named like a program or a shell
+// builtin, or refuses it if forbid_shadowing is set in the config.
+func checkNameClash(name string) error {
+	other := nameClash(name)
+	switch {
+	case other == "":
+		return nil
+	case currentConfig().ForbidShadowing:
+		return fmt.Errorf("alias %s would shadow %s, and forbid_shadowing is set in the config", name, other)
+	}
+	printWarning("Warning: alias %s has the name of %s; its shim or shell alias would shadow it", name, other)
+	return nil
+}
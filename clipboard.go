@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// clipboardTool is an external program used to talk to the system clipboard.
+type clipboardTool struct {
+	name string
+	args []string
+}
+
+// clipboardCopyTools returns the candidate programs for writing to the
+// clipboard on the current platform, in order of preference.
+func clipboardCopyTools() []clipboardTool {
+	switch runtime.GOOS {
+	case "darwin":
+		return []clipboardTool{{"pbcopy", nil}}
+	case "windows":
+		return []clipboardTool{{"clip", nil}}
+	}
+	var tools []clipboardTool
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		tools = append(tools, clipboardTool{"wl-copy", nil})
+	}
+	return append(tools,
+		clipboardTool{"xclip", []string{"-selection", "clipboard"}},
+		clipboardTool{"xsel", []string{"--clipboard", "--input"}},
+	)
+}
+
+// clipboardPasteTools returns the candidate programs for reading from the
+// clipboard on the current platform, in order of preference.
+func clipboardPasteTools() []clipboardTool {
+	switch runtime.GOOS {
+	case "darwin":
+		return []clipboardTool{{"pbpaste", nil}}
+	case "windows":
+		return []clipboardTool{{"powershell", []string{"-NoProfile", "-Command", "Get-Clipboard"}}}
+	}
+	var tools []clipboardTool
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		tools = append(tools, clipboardTool{"wl-paste", []string{"--no-newline"}})
+	}
+	return append(tools,
+		clipboardTool{"xclip", []string{"-selection", "clipboard", "-o"}},
+		clipboardTool{"xsel", []string{"--clipboard", "--output"}},
+	)
+}
+
+// findClipboardTool returns the first tool that is available on PATH.
+func findClipboardTool(tools []clipboardTool) (clipboardTool, error) {
+	var names []string
+	for _, t := range tools {
+		if _, err := exec.LookPath(t.name); err == nil {
+			return t, nil
+		}
+		names = append(names, t.name)
+	}
+	return clipboardTool{}, fmt.Errorf("no clipboard utility found (tried %s)", strings.Join(names, ", "))
+}
+
+// copyToClipboard places text on the system clipboard.
+func copyToClipboard(text string) error {
+	tool, err := findClipboardTool(clipboardCopyTools())
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(tool.name, tool.args...)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// readClipboard returns the current text content of the system clipboard.
+func readClipboard() (string, error) {
+	tool, err := findClipboardTool(clipboardPasteTools())
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	cmd := exec.Command(tool.name, tool.args...)
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	// Utilities such as powershell append a trailing newline.
+	return strings.TrimRight(out.String(), "\r\n"), nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// noColor is set by the --no-color flag.
+var noColor bool
+
+// defaultTheme maps output roles to colors. Entries can be overridden in the
+// theme section of the config file.
+var defaultTheme = map[string]string{
+	"alias":   "cyan",
+	"error":   "red",
+	"step":    "bold blue",
+	"tag":     "magenta",
+	"added":   "green",
+	"removed": "red",
+	"match":   "bold yellow",
+	"skipped": "dim",
+	"reload":  "dim",
+}
+
+var colorCodes = map[string]string{
+	"bold":      "1",
+	"dim":       "2",
+	"underline": "4",
+	"black":     "30",
+	"red":       "31",
+	"green":     "32",
+	"yellow":    "33",
+	"blue":      "34",
+	"magenta":   "35",
+	"cyan":      "36",
+	"white":     "37",
+	"gray":      "90",
+	"grey":      "90",
+
+	"bright-red":     "91",
+	"bright-green":   "92",
+	"bright-yellow":  "93",
+	"bright-blue":    "94",
+	"bright-magenta": "95",
+	"bright-cyan":    "96",
+	"bright-white":   "97",
+}
+
+// colorEnabled reports whether f should receive ANSI colors: only terminals
+// do, and never when NO_COLOR is set or --no-color was given.
+func colorEnabled(f *os.File) bool {
+	if noColor {
+		return false
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	return isTerminal(f)
+}
+
+// ansiSequence converts a color spec such as "bold red" into an SGR escape
+// sequence. Numeric parts (e.g. "38;5;208") are passed through unchanged.
+func ansiSequence(spec string) string {
+	var codes []string
+	for _, part := range strings.Fields(spec) {
+		if code, ok := colorCodes[strings.ToLower(part)]; ok {
+			codes = append(codes, code)
+		} else if strings.Trim(part, "0123456789;") == "" {
+			codes = append(codes, part)
+		}
+	}
+	if len(codes) == 0 {
+		return ""
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m"
+}
+
+// themeColor returns the color spec configured for role.
+func themeColor(role string) string {
+	if spec, ok := currentConfig().Theme[role]; ok {
+		return spec
+	}
+	return defaultTheme[role]
+}
+
+// paintFor colors s with the theme color for role if f supports colors.
+func paintFor(f *os.File, role, s string) string {
+	if s == "" || role == "" || !colorEnabled(f) {
+		return s
+	}
+	seq := ansiSequence(themeColor(role))
+	if seq == "" {
+		return s
+	}
+	return seq + s + "\x1b[0m"
+}
+
+// paint colors s for output on stdout.
+func paint(role, s string) string {
+	return paintFor(os.Stdout, role, s)
+}
+
+// printError prints a non-fatal error message to stderr in the theme's
+// error color, translating format.
+func printError(format string, args ...interface{}) {
+	fmt.Fprintln(os.Stderr, paintFor(os.Stderr, "error", fmt.Sprintf(tr(format), args...)))
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// completeAliasArgs completes an alias name as the first argument and,
+// after it, the values of its placeholders: the allowed choices, or else
+// the default, with the placeholder's name and description shown as help.
+func completeAliasArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if db == nil {
+		if err := openDB(); err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		defer closeDB()
+	}
+
+	if len(args) == 0 {
+		var names []string
+		db.View(func(tx *bolt.Tx) error {
+			for _, a := range layeredAliases(tx) {
+				if strings.HasPrefix(a.Name, toComplete) && a.Deprecated == nil {
+					names = append(names, a.Name+"\t"+completionHint(a))
+				}
+			}
+			return forEachAlias(tx, func(a *Alias) error {
+				if strings.HasPrefix(a.Name, toComplete) && a.Deprecated == nil {
+					names = append(names, a.Name+"\t"+completionHint(a))
+				}
+				return nil
+			})
+		})
+		return names, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	a, err := loadAlias(args[0])
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	i := len(args) - 1
+	if i >= placeholderCount(a.allCommands()) && i >= len(a.Placeholders) {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var ph Placeholder
+	if i < len(a.Placeholders) {
+		ph = a.Placeholders[i]
+	}
+
+	var comps []string
+	help := fmt.Sprintf("$%d", i+1)
+	if ph.Name != "" {
+		help += " " + ph.Name
+	}
+	if ph.Description != "" {
+		help += ": " + ph.Description
+	}
+	comps = cobra.AppendActiveHelp(comps, help)
+	switch {
+	case len(ph.Choices) > 0:
+		for _, c := range ph.Choices {
+			if strings.HasPrefix(c, toComplete) {
+				comps = append(comps, c)
+			}
+		}
+		return comps, cobra.ShellCompDirectiveNoFileComp
+	case ph.Default != "" && strings.HasPrefix(ph.Default, toComplete):
+		comps = append(comps, ph.Default+"\tdefault")
+		return comps, cobra.ShellCompDirectiveNoFileComp
+	}
+	// Free-form values may well be file names.
+	return comps, cobra.ShellCompDirectiveDefault
+}
+
+// completionHint is the description shown next to an alias name.
+func completionHint(a *Alias) string {
+	if a.Description != "" {
+		return a.Description
+	}
+	return a.summary()
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds user settings read from the cmdex config file.
+type Config struct {
+	// Theme maps output roles (alias, error, ...) to color names.
+	Theme   map[string]string `yaml:"theme"`
+	Serve   ServeConfig       `yaml:"serve"`
+	Lint    LintConfig        `yaml:"lint"`
+	History HistoryConfig     `yaml:"history"`
+	Tracing TracingConfig     `yaml:"tracing"`
+	Notify  NotifyConfig      `yaml:"notify"`
+	// Windows are safety windows, by name, that aliases share with
+	// window:.
+	Windows map[string]WindowConfig `yaml:"windows"`
+	// Layers are files and directories of read-only aliases, beneath the
+	// store; see aliasLayer.
+	Layers []string `yaml:"layers"`
+	// EnvAllow replaces the variables, by name or pattern, that commands
+	// run with a cleared environment see; see defaultEnvAllow.
+	EnvAllow []string `yaml:"env_allow"`
+	// ForbidShadowing refuses new aliases, and shims, named like a program
+	// on PATH or a shell builtin, which are otherwise only warned about.
+	ForbidShadowing bool `yaml:"forbid_shadowing"`
+	// Shims configures the commands cmdex shims sync writes for aliases.
+	Shims ShimsConfig `yaml:"shims"`
+}
+
+// ShimsConfig configures alias shims.
+type ShimsConfig struct {
+	// Dir is the directory the shims are written to, which belongs on
+	// PATH; it defaults to cmdex/shims in the user's data directory.
+	Dir string `yaml:"dir"`
+}
+
+// HistoryConfig adjusts what the run history records and keeps.
+type HistoryConfig struct {
+	// Snapshot records the environment and working directory of every
+	// run, as run --snapshot does.
+	Snapshot bool `yaml:"snapshot"`
+	// Capture records the output of every run, as run --capture does.
+	Capture bool `yaml:"capture"`
+	// Artifacts is the directory that receives the artifacts of runs.
+	Artifacts string `yaml:"artifacts"`
+	// Redact lists more variables, by name or pattern such as *_PAT, whose
+	// values are redacted from recorded output, besides those named like
+	// secrets; see sensitiveName.
+	Redact []string `yaml:"redact"`
+	// MaxEntries keeps this many of the latest runs of every alias.
+	MaxEntries int `yaml:"max_entries"`
+	// MaxAge drops runs older than this, such as 90d.
+	MaxAge string `yaml:"max_age"`
+	// MaxSize drops the oldest runs once the history, with its
+	// artifacts, is larger than this, such as 50M.
+	MaxSize string `yaml:"max_size"`
+}
+
+// LintConfig adjusts the rules of cmdex lint.
+type LintConfig struct {
+	// Rules maps rule ids to a severity: off, info, warning or error.
+	Rules map[string]string `yaml:"rules"`
+}
+
+// ServeConfig configures the cmdex daemon.
+type ServeConfig struct {
+	// Users maps user names to their credentials and groups.
+	Users map[string]ServeUser `yaml:"users"`
+	// Hooks maps webhook names, served at /hooks/<name>, to the alias
+	// they run.
+	Hooks map[string]ServeHook `yaml:"hooks"`
+}
+
+// ServeHook is a webhook of the daemon.
+type ServeHook struct {
+	// Alias is the alias to run; it defaults to the hook's name.
+	Alias string `yaml:"alias"`
+	// Secret is the HMAC-SHA256 key requests are signed with.
+	Secret string `yaml:"secret"`
+	// Args are payload fields, as dot-separated paths, passed as $1, $2,
+	// ... By default the fields named like the alias's placeholders are.
+	Args []string `yaml:"args"`
+	// Yes confirms aliases that ask for confirmation.
+	Yes     bool   `yaml:"yes"`
+	Timeout string `yaml:"timeout"`
+	// Async answers right away and runs the alias in the background.
+	Async bool `yaml:"async"`
+}
+
+// ServeUser is a client of the daemon, identified by its bearer token.
+// Administrators bypass per-alias access rules.
+type ServeUser struct {
+	Token  string   `yaml:"token"`
+	Groups []string `yaml:"groups"`
+	Admin  bool     `yaml:"admin"`
+}
+
+var (
+	cfg Config
+	// cfgMu guards cfg in the daemon, which swaps in the reloaded config
+	// while requests read it.
+	cfgMu sync.RWMutex
+)
+
+// currentConfig returns a copy of cfg. Code the daemon can reach reads the
+// config through it, as a reload may swap cfg out meanwhile; the reload
+// replaces the whole config, so the copy's maps and slices stay as they
+// were.
+func currentConfig() Config {
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	return cfg
+}
+
+// configPath returns the location of the config file, honouring
+// CMDEX_CONFIG when set.
+func configPath() string {
+	if p := os.Getenv("CMDEX_CONFIG"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "cmdex.yaml"
+	}
+	return filepath.Join(dir, "cmdex", "config.yaml")
+}
+
+// loadConfig reads the config file into cfg. A missing file is not an error.
+func loadConfig() error {
+	c, err := readConfig()
+	if err != nil {
+		return err
+	}
+	cfg = c
+	return nil
+}
+
+// readConfig reads the config file, which is empty if it doesn't exist.
+func readConfig() (Config, error) {
+	var c Config
+	data, err := os.ReadFile(configPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	return c, yaml.Unmarshal(data, &c)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// cooldownsBucket holds when the aliases from layers, which keep no usage
+// of their own, last started a run, by name, for their cooldowns.
+var cooldownsBucket = []byte("cooldowns")
+
+// checkCooldown parses the cooldown of an alias, such as 5m.
+func checkCooldown(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid cooldown %q (use a duration such as 30s or 5m)", s)
+	}
+	return d, nil
+}
+
+// cooldownError refuses a run of an alias within its cooldown.
+type cooldownError struct {
+	alias    string
+	lastUsed time.Time
+	cooldown time.Duration
+}
+
+func (e *cooldownError) Error() string {
+	ago := time.Since(e.lastUsed)
+	return fmt.Sprintf("%s last ran %s ago, at %s, and has a cooldown of %s; it can run again in %s",
+		e.alias, ago.Round(time.Second), formatTime(e.lastUsed), e.cooldown, (e.cooldown - ago).Round(time.Second))
+}
+
+// cooldownUpdate is useUpdate for aliases with a cooldown: it refuses the
+// run if the alias started another within its cooldown, unless force is
+// set. The use is recorded in the same transaction, so that of two runs
+// started together only one gets through.
+func cooldownUpdate(e auditEntry, force bool) func(tx *bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		a, err := getAlias(tx, e.Target)
+		fromLayer := false
+		if err == errAliasNotFound {
+			if a = layered(e.Target); a != nil {
+				fromLayer, err = true, nil
+				if v := tx.Bucket(cooldownsBucket).Get([]byte(aliasKey(a.Name))); v != nil {
+					if a.LastUsed, err = time.Parse(time.RFC3339Nano, string(v)); err != nil {
+						return fmt.Errorf("reading the last run of %s: %w", a.Name, err)
+					}
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+		cooldown, err := checkCooldown(a.Cooldown)
+		if err != nil {
+			return err
+		}
+		if since := time.Since(a.LastUsed); since < cooldown {
+			if !force {
+				return newError(exitCooldown, "cooldown", &cooldownError{a.Name, a.LastUsed, cooldown})
+			}
+			forced := fmt.Sprintf("forced %s into the %s cooldown", since.Round(time.Second), cooldown)
+			if e.Detail != "" {
+				forced = e.Detail + "; " + forced
+			}
+			e.Detail = forced
+		}
+		if fromLayer {
+			now := time.Now().UTC().Format(time.RFC3339Nano)
+			if err := tx.Bucket(cooldownsBucket).Put([]byte(aliasKey(a.Name)), []byte(now)); err != nil {
+				return err
+			}
+		}
+		return useUpdate(e)(tx)
+	}
+}
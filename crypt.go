@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/scrypt"
+)
+
+var (
+	metaBucket    = []byte("meta")
+	encryptionKey = []byte("encryption")
+)
+
+// sealedPrefix marks encrypted values. It can't start a JSON record or a
+// legacy command string.
+var sealedPrefix = []byte("\x00enc1")
+
+// storeKey is the AES key of an encrypted database, nil when the database
+// is stored in plain text.
+var storeKey []byte
+
+// encryptionMeta describes how the key of an encrypted database is
+// obtained. It's stored in the meta bucket.
+type encryptionMeta struct {
+	// KDF is "scrypt" for a passphrase-derived key or "keyring" for a
+	// random key kept in the OS keyring under Account.
+	KDF     string `json:"kdf"`
+	Salt    []byte `json:"salt,omitempty"`
+	Account string `json:"account,omitempty"`
+	// Check is a sealed known value that tells a wrong key from a right one.
+	Check []byte `json:"check"`
+}
+
+var checkValue = []byte("cmdex")
+
+// deriveKey derives an AES-256 key from a passphrase.
+func deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+}
+
+// seal encrypts v with key, binding it to the record name so that values
+// can't be swapped between records.
+func seal(key, name, v []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(append([]byte(nil), sealedPrefix...), nonce...)
+	return gcm.Seal(out, nonce, v, name), nil
+}
+
+// unseal reverses seal.
+func unseal(key, name, v []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	v = v[len(sealedPrefix):]
+	if len(v) < gcm.NonceSize() {
+		return nil, fmt.Errorf("corrupt encrypted value")
+	}
+	return gcm.Open(nil, v[:gcm.NonceSize()], v[gcm.NonceSize():], name)
+}
+
+// encodeValue prepares a record value for writing, encrypting it when the
+// database is encrypted.
+func encodeValue(name string, v []byte) ([]byte, error) {
+	if storeKey == nil {
+		return v, nil
+	}
+	return seal(storeKey, []byte(name), v)
+}
+
+// decodeValue returns the plain text of a stored record value.
+func decodeValue(name string, v []byte) ([]byte, error) {
+	if !bytes.HasPrefix(v, sealedPrefix) {
+		return v, nil
+	}
+	if storeKey == nil {
+		return nil, fmt.Errorf("record %s is encrypted but the database has no key", name)
+	}
+	plain, err := unseal(storeKey, []byte(name), v)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s: %w", name, err)
+	}
+	return plain, nil
+}
+
+// readEncryptionMeta returns the encryption settings of the database, or
+// nil if it is not encrypted.
+func readEncryptionMeta(tx *bolt.Tx) (*encryptionMeta, error) {
+	v := tx.Bucket(metaBucket).Get(encryptionKey)
+	if v == nil {
+		return nil, nil
+	}
+	m := &encryptionMeta{}
+	if err := json.Unmarshal(v, m); err != nil {
+		return nil, fmt.Errorf("reading encryption settings: %w", err)
+	}
+	return m, nil
+}
+
+// unlockStore loads the key of an encrypted database, asking for the
+// passphrase if needed. The key is kept for the life of the process.
+func unlockStore() error {
+	if storeKey != nil {
+		return nil
+	}
+	var m *encryptionMeta
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		m, err = readEncryptionMeta(tx)
+		return err
+	})
+	if err != nil || m == nil {
+		return err
+	}
+	key, err := m.key()
+	if err != nil {
+		return err
+	}
+	if _, err := unseal(key, encryptionKey, m.Check); err != nil {
+		return fmt.Errorf("wrong passphrase for the encrypted database")
+	}
+	storeKey = key
+	return nil
+}
+
+// key obtains the database key described by m.
+func (m *encryptionMeta) key() ([]byte, error) {
+	switch m.KDF {
+	case "scrypt":
+		pass, err := askPassphrase("Database passphrase", false)
+		if err != nil {
+			return nil, err
+		}
+		return deriveKey(pass, m.Salt)
+	case "keyring":
+		secret, err := keyringGet(m.Account)
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(secret)
+	}
+	return nil, fmt.Errorf("unknown key derivation %q", m.KDF)
+}
+
+// recordBuckets are the buckets whose values are encrypted. The audit log
+// stays readable without the key.
+var recordBuckets = [][]byte{commandsBucket, varsBucket, historyBucket, answersBucket, blobsBucket, approvalsBucket, outputBucket, basesBucket}
+
+// rewriteRecords re-encodes every value of the record buckets, reading
+// with the current storeKey and writing with key.
+func rewriteRecords(tx *bolt.Tx, key []byte) error {
+	for _, name := range recordBuckets {
+		b := tx.Bucket(name)
+		values := make(map[string][]byte)
+		err := b.ForEach(func(k, v []byte) error {
+			plain, err := decodeValue(string(k), v)
+			if err != nil {
+				return err
+			}
+			values[string(k)] = plain
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for k, v := range values {
+			if key != nil {
+				if v, err = seal(key, []byte(k), v); err != nil {
+					return err
+				}
+			}
+			if err := b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+	}
+	// Tag index keys depend on the encryption key, and the output is only
+	// indexed without one, so the indexes are rebuilt once the new key is
+	// in use.
+	return tx.Bucket(metaBucket).Delete(indexesKey)
+}
+
+// compactDB rewrites the database into a fresh file, so that freed pages
+// holding plain-text copies of re-encrypted records are dropped.
+func compactDB() error {
+	path := db.Path()
+	tmp := path + ".compact"
+	dst, err := bolt.Open(tmp, 0600, nil)
+	if err != nil {
+		return err
+	}
+	if err := bolt.Compact(dst, db, 0); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	closeDB()
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return openDB()
+}
+
+func dbCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "db",
+		Short: "Manage the alias database",
+	}
+	var useKeyring bool
+	encrypt := &cobra.Command{
+		Use:   "encrypt",
+		Short: "Encrypt the commands and variables stored in the database",
+		Long: `encrypt switches the database to encrypted storage: every alias and variable
+is encrypted with AES-GCM before it is written. The key is derived from a
+passphrase (asked for, or taken from CMDEX_PASSPHRASE), or with --keyring
+generated at random and kept in the OS keyring. The passphrase is needed
+every time cmdex opens the database. The audit log is not encrypted.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if storeKey != nil {
+				return fmt.Errorf("the database is already encrypted")
+			}
+			m := &encryptionMeta{KDF: "scrypt"}
+			var key []byte
+			if useKeyring {
+				key = make([]byte, 32)
+				id := make([]byte, 8)
+				if _, err := rand.Read(key); err != nil {
+					return err
+				}
+				if _, err := rand.Read(id); err != nil {
+					return err
+				}
+				m.KDF, m.Account = "keyring", "db-"+hex.EncodeToString(id)
+				if err := keyringSet(m.Account, base64.StdEncoding.EncodeToString(key)); err != nil {
+					return err
+				}
+			} else {
+				pass, err := askPassphrase("Database passphrase", true)
+				if err != nil {
+					return err
+				}
+				m.Salt = make([]byte, 16)
+				if _, err := rand.Read(m.Salt); err != nil {
+					return err
+				}
+				if key, err = deriveKey(pass, m.Salt); err != nil {
+					return err
+				}
+			}
+			var err error
+			if m.Check, err = seal(key, encryptionKey, checkValue); err != nil {
+				return err
+			}
+			err = db.Update(func(tx *bolt.Tx) error {
+				if err := rewriteRecords(tx, key); err != nil {
+					return err
+				}
+				v, err := json.Marshal(m)
+				if err != nil {
+					return err
+				}
+				if err := tx.Bucket(metaBucket).Put(encryptionKey, v); err != nil {
+					return err
+				}
+				return writeAudit(tx, auditEntry{Action: "db-encrypt", Detail: m.KDF})
+			})
+			if err != nil {
+				return fmt.Errorf("encrypting database: %w", err)
+			}
+			storeKey = key
+			if err := compactDB(); err != nil {
+				return fmt.Errorf("compacting database: %w", err)
+			}
+			fmt.Println("Database encrypted")
+			return nil
+		},
+	}
+	encrypt.Flags().BoolVar(&useKeyring, "keyring", false, "Keep a random key in the OS keyring instead of using a passphrase")
+	cmd.AddCommand(encrypt)
+	cmd.AddCommand(&cobra.Command{
+		Use:   "decrypt",
+		Short: "Store the database in plain text again",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if storeKey == nil {
+				return fmt.Errorf("the database is not encrypted")
+			}
+			err := db.Update(func(tx *bolt.Tx) error {
+				if err := rewriteRecords(tx, nil); err != nil {
+					return err
+				}
+				if err := tx.Bucket(metaBucket).Delete(encryptionKey); err != nil {
+					return err
+				}
+				return writeAudit(tx, auditEntry{Action: "db-decrypt"})
+			})
+			if err != nil {
+				return fmt.Errorf("decrypting database: %w", err)
+			}
+			storeKey = nil
+			if err := ensureIndexes(); err != nil {
+				return fmt.Errorf("rebuilding indexes: %w", err)
+			}
+			fmt.Println("Database decrypted")
+			return nil
+		},
+	})
+	cmd.AddCommand(dbMigrateCmd(), dbRecoverCmd())
+	return cmd
+}
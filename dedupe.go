@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// duplicateGroup is a set of aliases whose bodies are the same or nearly so.
+type duplicateGroup struct {
+	aliases []*Alias
+	// similarity is the lowest pairwise similarity in the group, 1 for
+	// identical bodies.
+	similarity float64
+}
+
+func dedupeCmd() *cobra.Command {
+	var threshold float64
+	cmd := &cobra.Command{
+		Use:   "dedupe",
+		Short: "Find aliases with identical or similar commands",
+		Long: `dedupe compares the commands of all aliases, word by word, and lists the
+groups that are identical or at least --threshold similar.
+
+On a terminal each group can be merged: the alias you keep takes over the
+tags, description and usage counts of the others, which are removed.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if threshold <= 0 || threshold > 1 {
+				return usageError(fmt.Errorf("invalid --threshold %v (use a value above 0 and up to 1)", threshold))
+			}
+			var aliases []*Alias
+			err := db.View(func(tx *bolt.Tx) error {
+				return forEachAlias(tx, func(a *Alias) error {
+					aliases = append(aliases, a)
+					return nil
+				})
+			})
+			if err != nil {
+				return fmt.Errorf("listing commands: %w", err)
+			}
+			groups := findDuplicates(aliases, threshold)
+			if len(groups) == 0 {
+				fmt.Println("No duplicates found")
+				return nil
+			}
+			for i, g := range groups {
+				label := "identical"
+				if g.similarity < 1 {
+					label = fmt.Sprintf("%.0f%% similar", g.similarity*100)
+				}
+				fmt.Printf("\nGroup %d of %d (%s):\n", i+1, len(groups), label)
+				var t table
+				t.color(1, "alias")
+				for j, a := range g.aliases {
+					t.add(fmt.Sprintf("  %d.", j+1), a.Name, fmt.Sprintf("%d uses", a.Uses), a.summary())
+				}
+				printLines(t.lines(0))
+				if !interactive() {
+					continue
+				}
+				keep, quit, err := pickKeeper(len(g.aliases))
+				if err != nil {
+					return err
+				}
+				if quit {
+					return nil
+				}
+				if keep < 0 {
+					continue
+				}
+				if err := mergeAliases(g.aliases, keep); err != nil {
+					return fmt.Errorf("merging aliases: %w", err)
+				}
+				fmt.Printf("Kept %s\n", paint("alias", g.aliases[keep].Name))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().Float64Var(&threshold, "threshold", 0.8, "Minimum similarity (0-1) for aliases to count as duplicates")
+	return cmd
+}
+
+// pickKeeper asks which alias of a group of n to keep. It returns -1 when
+// the group is skipped.
+func pickKeeper(n int) (keep int, quit bool, err error) {
+	for {
+		fmt.Printf("Keep [1-%d] and merge the rest, [n]ext, [q]uit? ", n)
+		key, err := readKey()
+		fmt.Println(string(key))
+		if err != nil {
+			return 0, false, err
+		}
+		switch {
+		case key == 'q' || key == 3 || key == 4:
+			return 0, true, nil
+		case key == 'n':
+			return -1, false, nil
+		case key >= '1' && int(key-'0') <= n:
+			return int(key - '1'), false, nil
+		}
+	}
+}
+
+// mergeAliases folds the other aliases of group into group[keep] and removes
+// them.
+func mergeAliases(group []*Alias, keep int) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		kept, err := getAlias(tx, group[keep].Name)
+		if err != nil {
+			return err
+		}
+		var removed []string
+		for i, other := range group {
+			if i == keep {
+				continue
+			}
+			for _, tag := range other.Tags {
+				if !contains(kept.Tags, tag) {
+					kept.Tags = append(kept.Tags, tag)
+				}
+			}
+			if kept.Description == "" {
+				kept.Description = other.Description
+			}
+			kept.Uses += other.Uses
+			if other.LastUsed.After(kept.LastUsed) {
+				kept.LastUsed = other.LastUsed
+			}
+			if err := deleteAlias(tx, other.Name); err != nil {
+				return err
+			}
+			removed = append(removed, other.Name)
+		}
+		kept.Modified = time.Now()
+		if err := putAlias(tx, kept); err != nil {
+			return err
+		}
+		return writeAudit(tx, auditEntry{Action: "merge", Target: kept.Name, Detail: "removed " + strings.Join(removed, ", ")})
+	})
+}
+
+// findDuplicates groups aliases whose bodies are at least threshold
+// similar. Groups are transitive: if a is like b and b like c, all three
+// end up together.
+func findDuplicates(aliases []*Alias, threshold float64) []duplicateGroup {
+	words := make([][]string, len(aliases))
+	for i, a := range aliases {
+		words[i] = strings.Fields(a.Script + "\n" + a.allCommands())
+	}
+	parent := make([]int, len(aliases))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	lowest := make(map[int]float64)
+	for i := range aliases {
+		for j := i + 1; j < len(aliases); j++ {
+			sim := similarity(words[i], words[j])
+			if sim < threshold {
+				continue
+			}
+			ri, rj := find(i), find(j)
+			low := sim
+			for _, r := range []int{ri, rj} {
+				if v, ok := lowest[r]; ok && v < low {
+					low = v
+				}
+			}
+			parent[rj] = ri
+			delete(lowest, rj)
+			lowest[ri] = low
+		}
+	}
+
+	members := make(map[int][]*Alias)
+	for i, a := range aliases {
+		r := find(i)
+		members[r] = append(members[r], a)
+	}
+	var groups []duplicateGroup
+	for r, group := range members {
+		if len(group) > 1 {
+			groups = append(groups, duplicateGroup{aliases: group, similarity: lowest[r]})
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].similarity != groups[j].similarity {
+			return groups[i].similarity > groups[j].similarity
+		}
+		return groups[i].aliases[0].Name < groups[j].aliases[0].Name
+	})
+	return groups
+}
+
+// similarity compares two word lists by edit distance, from 0 (nothing in
+// common) to 1 (identical).
+func similarity(a, b []string) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	longest := len(a)
+	if len(b) > longest {
+		longest = len(b)
+	}
+	return 1 - float64(prev[len(b)])/float64(longest)
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
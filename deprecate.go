@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Deprecation marks an alias as replaced by another one.
+type Deprecation struct {
+	// Use names the alias that runs instead.
+	Use string `json:"use" yaml:"use"`
+	// Strict refuses to run the old name instead of forwarding.
+	Strict bool `json:"strict,omitempty" yaml:"strict,omitempty"`
+}
+
+// maxRedirects bounds the chain of deprecated aliases followed in one run.
+const maxRedirects = 10
+
+// followDeprecation returns the alias that actually runs for a, following
+// deprecation redirects and warning about each one.
+func followDeprecation(a *Alias) (*Alias, error) {
+	seen := map[string]bool{a.Name: true}
+	for a.Deprecated != nil {
+		d := a.Deprecated
+		if d.Strict {
+			return nil, fmt.Errorf("alias %s is deprecated, use %s instead", a.Name, d.Use)
+		}
+		if seen[d.Use] || len(seen) > maxRedirects {
+			return nil, fmt.Errorf("alias %s: deprecation redirects loop", a.Name)
+		}
+		printWarning("Warning: alias %s is deprecated, running %s instead", a.Name, d.Use)
+		next, err := loadAlias(d.Use)
+		if err != nil {
+			return nil, fmt.Errorf("alias %s redirects to %s: %w", a.Name, d.Use, err)
+		}
+		seen[next.Name] = true
+		a = next
+	}
+	return a, nil
+}
+
+func deprecateCmd() *cobra.Command {
+	var (
+		use    string
+		strict bool
+		undo   bool
+	)
+	cmd := &cobra.Command{
+		Use:   "deprecate <old> --use <new>",
+		Short: "Forward an old alias name to its replacement",
+		Long: `deprecate marks an alias as replaced. Running the old name prints a warning and
+runs the new alias instead, or with --strict fails and names the new alias.
+The old name doesn't need to exist: renamed aliases can leave a redirect
+behind. --undo removes the mark again.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			old := args[0]
+			if !undo && use == "" {
+				return usageError(fmt.Errorf("--use is required"))
+			}
+			if use == old {
+				return usageError(fmt.Errorf("an alias can't redirect to itself"))
+			}
+			err := db.Update(func(tx *bolt.Tx) error {
+				now := time.Now()
+				a, err := getAlias(tx, old)
+				switch {
+				case err == errAliasNotFound && !undo:
+					a = &Alias{Name: old, Created: now, Provenance: newProvenance("deprecate", "")}
+				case err != nil:
+					return err
+				}
+				e := auditEntry{Action: "deprecate", Target: old, Detail: "use " + use}
+				if undo {
+					if a.Deprecated == nil {
+						return fmt.Errorf("alias %s is not deprecated", old)
+					}
+					a.Deprecated = nil
+					e.Detail = "undo"
+					// A bare redirect has nothing left once undone.
+					if a.body() == "" && len(a.Steps) == 0 && len(a.Platforms) == 0 {
+						if err := deleteAlias(tx, old); err != nil {
+							return err
+						}
+						return writeAudit(tx, e)
+					}
+				} else {
+					target, err := getAlias(tx, use)
+					for steps := 0; err == nil && target.Deprecated != nil && steps < maxRedirects; steps++ {
+						if target.Deprecated.Use == old {
+							return fmt.Errorf("%s already forwards to %s", use, old)
+						}
+						target, err = getAlias(tx, target.Deprecated.Use)
+					}
+					if err != nil {
+						return fmt.Errorf("%s: %w", use, err)
+					}
+					a.Deprecated = &Deprecation{Use: use, Strict: strict}
+				}
+				a.Modified = now
+				if err := putAlias(tx, a); err != nil {
+					return err
+				}
+				return writeAudit(tx, e)
+			})
+			if err != nil {
+				return fmt.Errorf("deprecating alias: %w", err)
+			}
+			if undo {
+				fmt.Printf("Alias %s is no longer deprecated\n", paint("alias", old))
+			} else {
+				fmt.Printf("Alias %s now forwards to %s\n", paint("alias", old), paint("alias", use))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&use, "use", "", "The alias that replaces the old one")
+	cmd.Flags().BoolVar(&strict, "strict", false, "Fail instead of forwarding to the new alias")
+	cmd.Flags().BoolVar(&undo, "undo", false, "Remove the deprecation")
+	return cmd
+}
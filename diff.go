@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/yaml.v3"
+)
+
+// boltMagic is the number in the meta page that starts every bolt
+// database file, 16 bytes in.
+const boltMagic = 0xED0CDAED
+
+func diffCmd() *cobra.Command {
+	var (
+		identity string
+		nameOnly bool
+	)
+	cmd := &cobra.Command{
+		Use:   "diff <export-file|database|url>",
+		Short: "Compare the aliases of the store with those of an archive, file, database or URL",
+		Long: `diff compares the aliases of the store with those of another source and shows
+which aliases the source adds, which it lacks and which it has changed,
+with a colored diff of each changed alias as a layer file would write it.
+Run it before importing an update of a team bundle to see what the import
+would bring.
+
+The source is one of:
+
+  - an archive written by cmdex export, encrypted or not (decrypted with the
+    passphrase or the age identity of --identity, as import does), or - for
+    stdin
+  - a YAML file of aliases in the format of the layers
+  - another cmdex database file, such as the cmdex.db of another project
+    or a backup; encrypted databases must share the key of the store
+  - an http:// or https:// URL serving an archive or a YAML file
+
+Only the store is compared; aliases the layers define don't count. When
+and how often aliases were used isn't compared either.`,
+		Example:     "  cmdex diff team-bundle.json\n  cmdex diff --name-only https://example.com/aliases.yaml\n  cmdex diff ../other-project/cmdex.db",
+		Annotations: readDB,
+		Args:        cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			theirs, err := readAliasSource(cmd, args[0], identity)
+			if err != nil {
+				return err
+			}
+			ours := make(map[string]*Alias)
+			err = db.View(func(tx *bolt.Tx) error {
+				return forEachAlias(tx, func(a *Alias) error {
+					ours[aliasKey(a.Name)] = a
+					return nil
+				})
+			})
+			if err != nil {
+				return fmt.Errorf("reading aliases: %w", err)
+			}
+			printLines(diffAliases(ours, theirs, args[0], nameOnly))
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&identity, "identity", "i", "", "Decrypt an encrypted archive with this age identity file instead of a passphrase")
+	cmd.Flags().BoolVar(&nameOnly, "name-only", false, "Only list the names of the aliases that differ")
+	return cmd
+}
+
+// readAliasSource reads the aliases of source, a file, database or URL as
+// diff takes them, by normalized name.
+func readAliasSource(cmd *cobra.Command, source, identity string) (map[string]*Alias, error) {
+	var (
+		data []byte
+		err  error
+	)
+	switch {
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		data, err = fetchSource(cmd, source)
+	case source == "-":
+		data, err = io.ReadAll(os.Stdin)
+	default:
+		if isBoltFile(source) {
+			return readDatabaseAliases(source)
+		}
+		data, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", source, err)
+	}
+	aliases := make(map[string]*Alias)
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, ageHeader) || strings.HasPrefix(trimmed, "-----BEGIN AGE") {
+		ar, err := readArchive(data, identity)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range ar.Aliases {
+			entry.Alias.Name = entry.Name
+			aliases[aliasKey(entry.Name)] = entry.Alias
+		}
+		return aliases, nil
+	}
+	l, err := parseLayer("diff", source, data)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", source, err)
+	}
+	for key, a := range l.aliases {
+		aliases[key] = a
+	}
+	return aliases, nil
+}
+
+// fetchSource downloads the archive or alias file at url.
+func fetchSource(cmd *cobra.Command, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(cmd.Context(), http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download returned %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// isBoltFile reports whether the file at path is a bolt database.
+func isBoltFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, 20)
+	if _, err := io.ReadFull(f, head); err != nil {
+		return false
+	}
+	return binary.LittleEndian.Uint32(head[16:]) == boltMagic
+}
+
+// readDatabaseAliases reads the aliases stored in the cmdex database at
+// path, which may be the store itself.
+func readDatabaseAliases(path string) (map[string]*Alias, error) {
+	other, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, damaged(path, err))
+	}
+	defer other.Close()
+	aliases := make(map[string]*Alias)
+	err = guarded(func() error {
+		return other.View(func(tx *bolt.Tx) error {
+			if tx.Bucket(commandsBucket) == nil {
+				return fmt.Errorf("not a cmdex database")
+			}
+			return forEachAlias(tx, func(a *Alias) error {
+				aliases[aliasKey(a.Name)] = a
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, damaged(path, err))
+	}
+	return aliases, nil
+}
+
+// aliasSpec renders a as a layer file would define it, without what the
+// store records of its use.
+func aliasSpec(a *Alias) []string {
+	data, err := yaml.Marshal(a)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+}
+
+// diffAliases describes how the aliases of source, theirs, differ from
+// those of the store, ours.
+func diffAliases(ours, theirs map[string]*Alias, source string, nameOnly bool) []string {
+	names := make(map[string]bool)
+	for k := range ours {
+		names[k] = true
+	}
+	for k := range theirs {
+		names[k] = true
+	}
+	keys := make([]string, 0, len(names))
+	for k := range names {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if abs, err := filepath.Abs(source); err == nil && !strings.Contains(source, "://") && source != "-" {
+		source = abs
+	}
+	lines := []string{paint("removed", "--- store "+dbPath()), paint("added", "+++ "+source)}
+	var added, removed, changed int
+	for _, k := range keys {
+		a, inOurs := ours[k]
+		b, inTheirs := theirs[k]
+		switch {
+		case !inOurs:
+			added++
+			lines = append(lines, paint("added", "+ "+b.Name))
+			if !nameOnly {
+				for _, line := range aliasSpec(b) {
+					lines = append(lines, paint("added", "    + "+line))
+				}
+			}
+		case !inTheirs:
+			removed++
+			lines = append(lines, paint("removed", "- "+a.Name))
+			if !nameOnly {
+				for _, line := range aliasSpec(a) {
+					lines = append(lines, paint("removed", "    - "+line))
+				}
+			}
+		default:
+			x, y := aliasSpec(a), aliasSpec(b)
+			if strings.Join(x, "\n") == strings.Join(y, "\n") {
+				continue
+			}
+			changed++
+			lines = append(lines, "~ "+paint("alias", a.Name))
+			if !nameOnly {
+				lines = append(lines, diffLines(x, y, "    ")...)
+			}
+		}
+	}
+	if added+removed+changed == 0 {
+		return append(lines, "No differences")
+	}
+	return append(lines, fmt.Sprintf("%d added, %d removed, %d changed", added, removed, changed))
+}
+
+// diffLines returns the lines of a line diff from x to y: those both keep
+// plain, those only x has marked -, those only y has marked +, each after
+// indent.
+func diffLines(x, y []string, indent string) []string {
+	// common[i][j] is the length of the longest common subsequence of
+	// x[i:] and y[j:].
+	common := make([][]int, len(x)+1)
+	for i := range common {
+		common[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+	var lines []string
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			lines = append(lines, indent+"  "+x[i])
+			i++
+			j++
+		case j == len(y) || i < len(x) && common[i+1][j] >= common[i][j+1]:
+			lines = append(lines, paint("removed", indent+"- "+x[i]))
+			i++
+		default:
+			lines = append(lines, paint("added", indent+"+ "+y[j]))
+			j++
+		}
+	}
+	return lines
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+)
+
+// docGroup is one section of the alias documentation: the aliases sharing
+// a tag.
+type docGroup struct {
+	Title   string
+	Aliases []*Alias
+}
+
+// docGroups sorts aliases into one group per tag, in tag order, followed by
+// the untagged ones. An alias with several tags is listed under each.
+func docGroups(aliases []*Alias) []docGroup {
+	byTag := make(map[string][]*Alias)
+	var untagged []*Alias
+	for _, a := range aliases {
+		if len(a.Tags) == 0 {
+			untagged = append(untagged, a)
+		}
+		for _, t := range a.Tags {
+			byTag[t] = append(byTag[t], a)
+		}
+	}
+	tags := make([]string, 0, len(byTag))
+	for t := range byTag {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	var groups []docGroup
+	for _, t := range tags {
+		groups = append(groups, docGroup{t, byTag[t]})
+	}
+	if len(untagged) > 0 {
+		title := "Other"
+		if len(groups) == 0 {
+			title = "Aliases"
+		}
+		groups = append(groups, docGroup{title, untagged})
+	}
+	return groups
+}
+
+// docCommands returns the command lines documented for a: its steps, its
+// script or its command and platform variants.
+func docCommands(a *Alias) []string {
+	switch {
+	case a.Script != "":
+		return []string{a.Script}
+	case len(a.Steps) > 0:
+		var lines []string
+		for _, step := range a.Steps {
+			lines = append(lines, step.text())
+		}
+		return []string{strings.Join(lines, "\n")}
+	}
+	var commands []string
+	if a.Command != "" {
+		commands = append(commands, a.Command)
+	}
+	for _, key := range a.platformKeys() {
+		commands = append(commands, "# "+key+"\n"+a.Platforms[key])
+	}
+	return commands
+}
+
+// docPlaceholder is a placeholder row in the documentation.
+type docPlaceholder struct {
+	Arg, Name, Description, Default, Choices string
+}
+
+func docPlaceholders(a *Alias) []docPlaceholder {
+	var rows []docPlaceholder
+	for i := 0; i < placeholderCount(a.allCommands()); i++ {
+		row := docPlaceholder{Arg: fmt.Sprintf("$%d", i+1)}
+		if i < len(a.Placeholders) {
+			ph := a.Placeholders[i]
+			row.Name, row.Description, row.Default = ph.Name, ph.Description, ph.Default
+			row.Choices = strings.Join(ph.Choices, ", ")
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// renderMarkdown documents aliases as a Markdown catalog.
+func renderMarkdown(aliases []*Alias) []byte {
+	var b bytes.Buffer
+	cell := func(s string) string {
+		return strings.ReplaceAll(strings.ReplaceAll(s, "|", `\|`), "\n", " ")
+	}
+	b.WriteString("# Command catalog\n")
+	for _, g := range docGroups(aliases) {
+		fmt.Fprintf(&b, "\n## %s\n", g.Title)
+		for _, a := range g.Aliases {
+			fmt.Fprintf(&b, "\n### `%s`\n", a.Name)
+			if a.Description != "" {
+				b.WriteString("\n" + a.Description + "\n")
+			}
+			if d := a.Deprecated; d != nil {
+				fmt.Fprintf(&b, "\n> **Deprecated:** use `%s` instead.\n", d.Use)
+			}
+			for _, c := range docCommands(a) {
+				fmt.Fprintf(&b, "\n```sh\n%s\n```\n", c)
+			}
+			if rows := docPlaceholders(a); len(rows) > 0 {
+				b.WriteString("\n| Argument | Name | Description | Default | Allowed values |\n")
+				b.WriteString("|---|---|---|---|---|\n")
+				for _, r := range rows {
+					fmt.Fprintf(&b, "| `%s` | %s | %s | %s | %s |\n", r.Arg, cell(r.Name), cell(r.Description), cell(r.Default), cell(r.Choices))
+				}
+			}
+			if len(a.Examples) > 0 {
+				b.WriteString("\nExamples:\n\n")
+				for _, ex := range a.Examples {
+					fmt.Fprintf(&b, "- `%s`\n", ex)
+				}
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+var docTemplate = template.Must(template.New("doc").Funcs(template.FuncMap{
+	"commands":     docCommands,
+	"placeholders": docPlaceholders,
+	"anchor": func(group, name string) string {
+		return strings.ReplaceAll(group+"-"+name, " ", "-")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Command catalog</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: auto; padding: 1em; }
+pre { background: #f4f4f4; padding: .5em; overflow-x: auto; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: .2em .5em; text-align: left; }
+.deprecated { color: #a00; }
+</style>
+</head>
+<body>
+<h1>Command catalog</h1>
+<ul>
+{{- range .}}
+<li><a href="#{{.Title}}">{{.Title}}</a></li>
+{{- end}}
+</ul>
+{{- range $g := .}}
+<h2 id="{{$g.Title}}">{{$g.Title}}</h2>
+{{- range $g.Aliases}}
+<h3 id="{{anchor $g.Title .Name}}"><code>{{.Name}}</code></h3>
+{{- if .Description}}
+<p>{{.Description}}</p>
+{{- end}}
+{{- with .Deprecated}}
+<p class="deprecated"><strong>Deprecated:</strong> use <code>{{.Use}}</code> instead.</p>
+{{- end}}
+{{- range commands .}}
+<pre><code>{{.}}</code></pre>
+{{- end}}
+{{- with placeholders .}}
+<table>
+<tr><th>Argument</th><th>Name</th><th>Description</th><th>Default</th><th>Allowed values</th></tr>
+{{- range .}}
+<tr><td><code>{{.Arg}}</code></td><td>{{.Name}}</td><td>{{.Description}}</td><td>{{.Default}}</td><td>{{.Choices}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- with .Examples}}
+<p>Examples:</p>
+<ul>
+{{- range .}}
+<li><code>{{.}}</code></li>
+{{- end}}
+</ul>
+{{- end}}
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// renderHTML documents aliases as a standalone HTML page.
+func renderHTML(aliases []*Alias) ([]byte, error) {
+	var b bytes.Buffer
+	if err := docTemplate.Execute(&b, docGroups(aliases)); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// eachValues parses the value of run --each: comma-separated values, or
+// with @file the lines of file, skipping blank lines and # comments.
+func eachValues(spec string) ([]string, error) {
+	var values []string
+	if path, ok := strings.CutPrefix(spec, "@"); ok {
+		data, err := os.ReadFile(expandHome(path))
+		if err != nil {
+			return nil, fmt.Errorf("reading --each values: %w", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				values = append(values, line)
+			}
+		}
+	} else {
+		values = splitList(spec)
+	}
+	if len(values) == 0 {
+		return nil, usageError(fmt.Errorf("--each names no values"))
+	}
+	return values, nil
+}
+
+// runEach runs alias once for every value, one after another, with the
+// value appended to args; a host list, say, runs the alias against each
+// host. It stops at the first failure. With canary, the first value is a
+// canary: once it has run, the user sees how it went and decides whether
+// the others run.
+func runEach(ctx context.Context, alias string, args, values []string, canary bool, opts runOptions) error {
+	if canary && !interactive() {
+		return usageError(fmt.Errorf("--canary asks before running the remaining values and needs a terminal"))
+	}
+	// Arguments and confirmations are settled up front; an alias that
+	// asks for confirmation asks once, and one with a cooldown counts the
+	// values as a single run.
+	seqs := make([]*sequence, len(values))
+	for i, value := range values {
+		s, err := prepareRun(alias, append(append([]string(nil), args...), value), opts)
+		if err != nil {
+			return err
+		}
+		seqs[i] = s
+		opts.yes, opts.force = true, true
+	}
+	closeDB()
+
+	for i, s := range seqs {
+		banner(os.Stderr, verbosityNormal, "==> "+s.alias.ref()+" "+values[i])
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("%d of %d values not run: %w", len(values)-i, len(values), err)
+		}
+		runCtx, cancel := ctx, context.CancelFunc(func() {})
+		if opts.timeout > 0 {
+			runCtx, cancel = context.WithTimeout(ctx, opts.timeout)
+		}
+		start := time.Now()
+		err := s.execute(runCtx)
+		d := time.Since(start)
+		cancel()
+		recordHistory(s.historyEntry(start, d, err))
+		if err != nil {
+			return fmt.Errorf("%s %s failed, %d of %d values not run: %w", s.alias.ref(), values[i], len(values)-i-1, len(values), err)
+		}
+		if canary && i == 0 && len(seqs) > 1 {
+			fmt.Fprintln(os.Stderr, paintFor(os.Stderr, "step", trf("Canary %s succeeded in %s", values[0], d.Round(time.Millisecond))))
+			ok, err := confirm(trf("Continue with the remaining %d values?", len(seqs)-1), false)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("stopped after the canary %s", values[0])
+			}
+		}
+	}
+	if verbosity >= verbosityNormal && len(seqs) > 1 {
+		fmt.Fprintf(os.Stderr, "%s ran for all %d values\n", alias, len(seqs))
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPConfig is the mail server that sends email notifications.
+type SMTPConfig struct {
+	Host string `yaml:"host"`
+	// Port is 587 by default, or 465 with TLS.
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	// Password may instead be given in CMDEX_SMTP_PASSWORD, to keep it out
+	// of the config file.
+	Password string `yaml:"password"`
+	// From is the sender, the username (or cmdex@<hostname>) by default.
+	From string `yaml:"from"`
+	// TLS connects with TLS from the start instead of upgrading with
+	// STARTTLS when the server offers it.
+	TLS bool `yaml:"tls"`
+}
+
+// maxAttachment caps the output attached to emails.
+const maxAttachment = 4 << 20
+
+func (c SMTPConfig) port() int {
+	switch {
+	case c.Port != 0:
+		return c.Port
+	case c.TLS:
+		return 465
+	}
+	return 587
+}
+
+func (c SMTPConfig) from() string {
+	switch {
+	case c.From != "":
+		return c.From
+	case strings.Contains(c.Username, "@"):
+		return c.Username
+	}
+	host, _ := os.Hostname()
+	return "cmdex@" + host
+}
+
+// sendEmail mails n to the recipients of t, with output attached.
+func sendEmail(t NotifyTarget, n notification, output []byte) error {
+	c := currentConfig().Notify.SMTP
+	if c.Host == "" {
+		return fmt.Errorf("no mail server; set notify.smtp.host in the config")
+	}
+	msg, err := n.email(c.from(), t.To, output)
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(c.Host, strconv.Itoa(c.port()))
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	if c.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: c.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok && !c.TLS {
+		if err := client.StartTLS(&tls.Config{ServerName: c.Host}); err != nil {
+			return err
+		}
+	}
+	if c.Username != "" {
+		password := os.Getenv("CMDEX_SMTP_PASSWORD")
+		if password == "" {
+			password = c.Password
+		}
+		if err := client.Auth(smtp.PlainAuth("", c.Username, password, c.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(c.from()); err != nil {
+		return err
+	}
+	for _, to := range t.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("%s: %w", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// email renders n as a message with the output of the run attached.
+func (n notification) email(from string, to []string, output []byte) ([]byte, error) {
+	status := "succeeded"
+	if !n.OK {
+		status = fmt.Sprintf("failed (exit code %d)", n.ExitCode)
+	}
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", fmt.Sprintf("[cmdex] %s %s on %s", n.Alias, status, n.Host)))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+	body, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}, "Content-Transfer-Encoding": {"8bit"}})
+	if err != nil {
+		return nil, err
+	}
+	text := n.summary() + "\n"
+	if len(n.Output) > 0 {
+		text += "\nLast lines of output:\n\n  " + strings.Join(n.Output, "\n  ") + "\n"
+	}
+	fmt.Fprint(body, strings.ReplaceAll(text, "\n", "\r\n"))
+	if len(output) > 0 {
+		name := strings.Map(func(r rune) rune {
+			if r == '/' || r == ' ' || r == ':' {
+				return '_'
+			}
+			return r
+		}, n.Alias) + "-" + n.Time.Format("20060102-150405") + ".log"
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"text/plain; charset=utf-8"},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": name})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(output)
+		for len(encoded) > 76 {
+			fmt.Fprintf(part, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(part, "%s\r\n", encoded)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
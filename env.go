@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultEnvAllow are the variables of the caller's environment commands
+// run with a cleared environment still see, unless env_allow in the config
+// replaces them.
+var defaultEnvAllow = []string{"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "LANG", "LC_*", "TZ", "TMPDIR",
+	"SystemRoot", "ComSpec", "PATHEXT", "TEMP", "TMP", "USERPROFILE"}
+
+// envAllowed reports whether the variable name matches one of patterns:
+// names or globs such as AWS_*.
+func envAllowed(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEnv checks the variables an alias sets and the patterns of the
+// variables it lets through.
+func checkEnv(env map[string]string, allow []string) error {
+	for name := range env {
+		if name == "" || strings.ContainsAny(name, "= \t\n") {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+	}
+	for _, pattern := range allow {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("invalid environment variable pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// parseEnv parses NAME=VALUE assignments, as given to save --env.
+func parseEnv(assignments []string) (map[string]string, error) {
+	if len(assignments) == 0 {
+		return nil, nil
+	}
+	env := make(map[string]string)
+	for _, kv := range assignments {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --env %q (use NAME=VALUE)", kv)
+		}
+		env[name] = value
+	}
+	return env, nil
+}
+
+// setEnvironment prepares the environment and umask of the commands of s,
+// clearing the environment if clear or the alias says to, with allow
+// passing more variables through.
+func (s *sequence) setEnvironment(clear bool, allow []string) error {
+	if err := checkEnv(nil, allow); err != nil {
+		return usageError(err)
+	}
+	s.envCleared = s.sandbox == nil && (clear || s.alias.EnvClear)
+	var err error
+	if s.env, err = s.childEnv(s.envCleared, allow); err != nil {
+		return err
+	}
+	if s.alias.Umask != "" {
+		if s.umask, err = parseUmask(s.alias.Umask); err != nil {
+			return fmt.Errorf("alias %s: %w", s.alias.Name, err)
+		}
+	}
+	return nil
+}
+
+// childEnv returns the environment the commands of s run with, nil for
+// cmdex's own. A sandbox, or a cleared environment, passes only the allowed
+// variables through; the alias's locale, time zone and env are set on top
+// either way.
+func (s *sequence) childEnv(clear bool, allow []string) ([]string, error) {
+	var env []string
+	switch {
+	case s.sandbox != nil:
+		env = s.sandbox.env()
+	case clear:
+		patterns := defaultEnvAllow
+		if allowed := currentConfig().EnvAllow; allowed != nil {
+			patterns = allowed
+		}
+		patterns = append(append(append([]string(nil), patterns...), s.alias.EnvAllow...), allow...)
+		env = []string{}
+		for _, kv := range os.Environ() {
+			if name, _, _ := strings.Cut(kv, "="); envAllowed(name, patterns) {
+				env = append(env, kv)
+			}
+		}
+	}
+	a := s.alias
+	if len(a.Env) == 0 && a.Locale == "" && a.TZ == "" {
+		return env, nil
+	}
+	if env == nil {
+		env = os.Environ()
+	}
+	if a.Locale != "" {
+		env = setEnv(setEnv(env, "LANG", a.Locale), "LC_ALL", a.Locale)
+	}
+	if a.TZ != "" {
+		env = setEnv(env, "TZ", a.TZ)
+	}
+	names := make([]string, 0, len(a.Env))
+	for name := range a.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, err := s.render(a.Env[name])
+		if err != nil {
+			return nil, fmt.Errorf("env %s: %w", name, err)
+		}
+		env = setEnv(env, name, value)
+	}
+	return env, nil
+}
+
+// setEnv sets the variable name in env to value, replacing any value it
+// has.
+func setEnv(env []string, name, value string) []string {
+	out := env[:0:0]
+	for _, kv := range env {
+		if n, _, _ := strings.Cut(kv, "="); n != name {
+			out = append(out, kv)
+		}
+	}
+	return append(out, name+"="+value)
+}
+
+// localePattern matches locale names such as C, POSIX, en_US.UTF-8 and
+// de_DE@euro.
+var localePattern = regexp.MustCompile(`^(C|POSIX|[a-zA-Z]{2,8}(_[a-zA-Z0-9]{2,8})?)(\.[a-zA-Z0-9-]+)?(@[a-zA-Z0-9]+)?$`)
+
+// checkLocale checks a's umask, locale and time zone.
+func (a *Alias) checkLocale() error {
+	if a.Umask != "" {
+		if _, err := parseUmask(a.Umask); err != nil {
+			return err
+		}
+	}
+	if a.Locale != "" && !localePattern.MatchString(a.Locale) {
+		return fmt.Errorf("invalid locale %q (use e.g. C.UTF-8 or en_US.UTF-8)", a.Locale)
+	}
+	if a.TZ != "" {
+		if _, err := time.LoadLocation(a.TZ); err != nil {
+			return fmt.Errorf("invalid time zone %q (use e.g. UTC or Europe/Berlin)", a.TZ)
+		}
+	}
+	return nil
+}
+
+// parseUmask parses an octal umask such as 022 or 0077.
+func parseUmask(s string) (int, error) {
+	mask, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mask > 0o777 {
+		return 0, fmt.Errorf("invalid umask %q (use octal, e.g. 022)", s)
+	}
+	return int(mask), nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Exit codes are part of the CLI contract: wrappers rely on them, so existing
+// values must never be renumbered.
+const (
+	exitOK                 = 0
+	exitError              = 1  // unclassified failure
+	exitUsage              = 2  // invalid arguments or flags
+	exitNotFound           = 3  // alias not found
+	exitDBLocked           = 4  // database held by another process
+	exitPlaceholderMissing = 5  // command needs more arguments
+	exitChildFailed        = 6  // the executed command failed
+	exitTimeout            = 7  // the executed command ran out of time
+	exitRuntimeMissing     = 8  // the alias's interpreter is not installed
+	exitApprovalPending    = 9  // the run waits for approval
+	exitCooldown           = 10 // the alias ran within its cooldown
+	exitAliasLocked        = 11 // the alias is already running
+	exitPolicy             = 12 // the administrator's policy forbids it
+	exitCanceled           = 13 // the run was canceled, by SIGTERM or its client
+	exitDBCorrupt          = 14 // the database file is damaged
+)
+
+// errorFormat is set by the --error-format flag: text or json.
+var errorFormat = "text"
+
+// cliError attaches a stable exit code and kind to an error.
+type cliError struct {
+	code int
+	kind string
+	err  error
+	// childExit is the exit status of a failed child process, if any.
+	childExit int
+}
+
+func (e *cliError) Error() string { return e.err.Error() }
+func (e *cliError) Unwrap() error { return e.err }
+
+func newError(code int, kind string, err error) *cliError {
+	return &cliError{code: code, kind: kind, err: err}
+}
+
+// usageError marks err as caused by invalid command-line input.
+func usageError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return newError(exitUsage, "usage", err)
+}
+
+// classify maps err onto its exit code and kind.
+func classify(err error) *cliError {
+	var ce *cliError
+	if errors.As(err, &ce) {
+		// Keep the full wrapped message while reporting the inner kind.
+		return &cliError{code: ce.code, kind: ce.kind, err: err, childExit: ce.childExit}
+	}
+	var corrupt *corruptDBError
+	switch {
+	case errors.As(err, &corrupt):
+		return &cliError{code: exitDBCorrupt, kind: "db_corrupt", err: err}
+	case errors.Is(err, errAliasNotFound):
+		return &cliError{code: exitNotFound, kind: "not_found", err: err}
+	case errors.Is(err, context.Canceled):
+		return &cliError{code: exitCanceled, kind: "canceled", err: err}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &cliError{code: exitTimeout, kind: "timeout", err: err}
+	case errors.Is(err, bolt.ErrTimeout):
+		return &cliError{code: exitDBLocked, kind: "db_locked", err: fmt.Errorf("database is locked by another cmdex process")}
+	}
+	return &cliError{code: exitError, kind: "error", err: err}
+}
+
+// reportError writes err to stderr in the selected format and returns the
+// exit code the process should terminate with.
+func reportError(cmd *cobra.Command, err error) int {
+	ce := classify(err)
+	if errorFormat == "json" {
+		payload := struct {
+			Error     string `json:"error"`
+			Kind      string `json:"kind"`
+			Code      int    `json:"code"`
+			ChildExit int    `json:"child_exit,omitempty"`
+		}{ce.Error(), ce.kind, ce.code, ce.childExit}
+		json.NewEncoder(os.Stderr).Encode(payload)
+		return ce.code
+	}
+	fmt.Fprintln(os.Stderr, paintFor(os.Stderr, "error", trf("Error: %s", ce.Error())))
+	if ce.code == exitUsage && cmd != nil {
+		fmt.Fprintln(os.Stderr, trf("Run '%s --help' for usage.", cmd.CommandPath()))
+	}
+	return ce.code
+}
+
+// markUsageErrors makes argument validation failures of cmd and all its
+// subcommands report as usage errors.
+func markUsageErrors(cmd *cobra.Command) {
+	if args := cmd.Args; args != nil {
+		cmd.Args = func(cmd *cobra.Command, a []string) error {
+			return usageError(args(cmd, a))
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		markUsageErrors(sub)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runEvent is one line of the run --events jsonl stream.
+type runEvent struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	// Step is the number of the step the event concerns, from 1.
+	Step     int      `json:"step,omitempty"`
+	RunID    string   `json:"run_id,omitempty"`
+	Alias    string   `json:"alias,omitempty"`
+	Args     []string `json:"args,omitempty"`
+	Steps    int      `json:"steps,omitempty"`
+	Name     string   `json:"name,omitempty"`
+	Kind     string   `json:"kind,omitempty"`
+	Stream   string   `json:"stream,omitempty"`
+	Data     string   `json:"data,omitempty"`
+	ExitCode *int     `json:"exit_code,omitempty"`
+	Duration *float64 `json:"duration,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// eventLog writes the events of a run as JSON lines. Commands write their
+// output from separate goroutines, so writes are serialised.
+type eventLog struct {
+	mu   sync.Mutex
+	w    io.Writer
+	c    io.Closer
+	step int
+	// toStdout is set when the events take the place of the output on
+	// stdout.
+	toStdout bool
+}
+
+// openEvents opens the destination of run --events-to: - or empty for
+// stdout, fd:N for an inherited file descriptor, or a file path.
+func openEvents(format, dest string) (*eventLog, error) {
+	if format != "jsonl" {
+		return nil, usageError(fmt.Errorf("unknown --events format %q (use jsonl)", format))
+	}
+	switch {
+	case dest == "" || dest == "-":
+		return &eventLog{w: os.Stdout, toStdout: true}, nil
+	case strings.HasPrefix(dest, "fd:"):
+		fd, err := strconv.Atoi(strings.TrimPrefix(dest, "fd:"))
+		if err != nil || fd < 0 {
+			return nil, usageError(fmt.Errorf("invalid --events-to %q (use fd:N)", dest))
+		}
+		f := os.NewFile(uintptr(fd), dest)
+		if f == nil {
+			return nil, fmt.Errorf("--events-to %s: no such file descriptor", dest)
+		}
+		if _, err := f.Stat(); err != nil {
+			return nil, fmt.Errorf("--events-to %s: %w", dest, err)
+		}
+		return &eventLog{w: f, c: f}, nil
+	}
+	f, err := os.Create(expandHome(dest))
+	if err != nil {
+		return nil, fmt.Errorf("opening --events-to file: %w", err)
+	}
+	return &eventLog{w: f, c: f}, nil
+}
+
+// emit writes e. A destination that stops accepting events, like a wrapper
+// that went away, doesn't stop the run.
+func (l *eventLog) emit(e runEvent) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Step == 0 && e.Event == "output" {
+		e.Step = l.step
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	l.w.Write(append(line, '\n'))
+}
+
+// close closes the destination, unless it's stdout.
+func (l *eventLog) close() error {
+	if l == nil || l.c == nil {
+		return nil
+	}
+	return l.c.Close()
+}
+
+// stepStarted reports the start of step i, from 0, of the sequence.
+func (l *eventLog) stepStarted(i int, step Step) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.step = i + 1
+	l.mu.Unlock()
+	l.emit(runEvent{Event: "step_started", Step: i + 1, Name: step.label(), Kind: step.kind()})
+}
+
+// finished reports the end of a step, or of the run when step is 0, with
+// the exit code the history records.
+func (l *eventLog) finished(event string, step int, d time.Duration, err error) {
+	if l == nil {
+		return
+	}
+	code, secs := exitCodeOf(err), d.Seconds()
+	e := runEvent{Event: event, Step: step, ExitCode: &code, Duration: &secs}
+	if err != nil {
+		e.Error, e.Kind = err.Error(), classify(err).kind
+	}
+	l.emit(e)
+}
+
+// output returns a writer that reports what is written to it as output
+// events of stream, passing it on to next unless the events take the
+// place of stdout.
+func (l *eventLog) output(stream string, next io.Writer) io.Writer {
+	if l.toStdout && stream == "stdout" {
+		next = io.Discard
+	}
+	return &eventOutput{log: l, stream: stream, next: next}
+}
+
+type eventOutput struct {
+	log    *eventLog
+	stream string
+	next   io.Writer
+}
+
+func (w *eventOutput) Write(p []byte) (int, error) {
+	w.log.emit(runEvent{Event: "output", Stream: w.stream, Data: string(p)})
+	return w.next.Write(p)
+}
+
+// kind names the type of the step in events.
+func (s Step) kind() string {
+	switch {
+	case s.HTTP != nil:
+		return "http"
+	case s.WriteFile != nil:
+		return "write_file"
+	case s.Prompt != nil:
+		return "prompt"
+	case s.WaitFor != nil:
+		return "wait_for"
+	}
+	return "run"
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// printExamples lists the example invocations of a, numbered for
+// examples --run.
+func printExamples(a *Alias) {
+	if len(a.Examples) == 0 {
+		return
+	}
+	fmt.Println("Examples:")
+	for i, ex := range a.Examples {
+		fmt.Printf("  %d. %s\n", i+1, ex)
+	}
+}
+
+func examplesCmd() *cobra.Command {
+	var run int
+	cmd := &cobra.Command{
+		Use:   "examples <alias>",
+		Short: "List or run the example invocations of an alias",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := loadAlias(args[0])
+			if err != nil {
+				return fmt.Errorf("retrieving command: %w", err)
+			}
+			if len(a.Examples) == 0 {
+				return fmt.Errorf("alias %s has no examples; add them with save --example", args[0])
+			}
+			if run == 0 {
+				printExamples(a)
+				return nil
+			}
+			if run < 0 || run > len(a.Examples) {
+				return usageError(fmt.Errorf("no example %d (alias %s has %d)", run, args[0], len(a.Examples)))
+			}
+			example := a.Examples[run-1]
+			exArgs, err := splitArgs(example)
+			if err != nil {
+				return fmt.Errorf("example %d: %w", run, err)
+			}
+			if len(exArgs) > 0 && exArgs[0] == "cmdex" {
+				exArgs = exArgs[1:]
+			}
+			if len(exArgs) == 0 {
+				return fmt.Errorf("example %d is empty", run)
+			}
+			banner(cmd.ErrOrStderr(), verbosityNormal, "==> "+example)
+			// The example runs as a fresh invocation, which opens the
+			// database itself.
+			closeDB()
+			root := newRootCmd()
+			root.SetArgs(exArgs)
+			_, err = root.ExecuteC()
+			return err
+		},
+	}
+	cmd.Flags().IntVar(&run, "run", 0, "Run example `N` instead of listing the examples")
+	return cmd
+}
+
+// helpCmd replaces cobra's help command so that "help <alias>" describes a
+// saved alias as well as "help <command>" a subcommand.
+func helpCmd(root *cobra.Command) *cobra.Command {
+	return &cobra.Command{
+		Use:         "help [command | alias]",
+		Short:       "Help about any command or alias",
+		Annotations: noDB,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			target, rest, err := root.Find(args)
+			if err != nil || target == root && len(rest) > 0 {
+				if len(args) == 1 {
+					if err := openDB(); err == nil {
+						defer closeDB()
+						if a, err := loadAlias(args[0]); err == nil {
+							printAlias(a)
+							return nil
+						}
+					}
+				}
+				return usageError(fmt.Errorf("unknown command or alias %s", strconv.Quote(args[0])))
+			}
+			return target.Help()
+		},
+	}
+}
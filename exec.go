@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func execCmd() *cobra.Command {
+	var opts runOptions
+	cmd := &cobra.Command{
+		Use:   "exec -- <command> [args...]",
+		Short: "Run a command once without saving it",
+		Long: `exec runs a command the way run runs an alias's: under --timeout,
+--sandbox and the resource limits, and recorded in the history, where rerun
+and history find it as (exec). The arguments are passed on exactly as given:
+{{...}} templates and $N placeholders are left alone. The command isn't
+saved, but when it succeeds on a terminal exec offers to save it as an
+alias.`,
+		Example:     "  cmdex exec -- rsync -a build/ prod:/srv\n  cmdex exec --timeout 5m -- make test\n  cmdex exec -- awk '{print $1}' access.log",
+		Annotations: readDB,
+		Args:        cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := execCommand(cmd.Context(), args, opts); err != nil {
+				return err
+			}
+			if opts.events != "" {
+				return nil
+			}
+			return offerSave(strings.Join(args, " "))
+		},
+	}
+	cmd.Flags().BoolVarP(&opts.yes, "yes", "y", false, "Don't ask for confirmation of commands the policy wants confirmed")
+	cmd.Flags().DurationVar(&opts.timeout, "timeout", 0, "Kill the command if it runs longer than this (e.g. 30s, 5m)")
+	cmd.Flags().BoolVar(&opts.sandbox, "sandbox", false, "Run with a clean environment and, where bubblewrap or sandbox-exec is available, a read-only file system outside the working directory")
+	cmd.Flags().BoolVar(&opts.noNet, "no-net", false, "Run sandboxed without network access (implies --sandbox)")
+	cmd.Flags().BoolVar(&opts.snapshot, "snapshot", false, "Record the environment and working directory in the history, for cmdex history diff")
+	cmd.Flags().StringVar(&opts.tee, "tee", "", "Also write the output to this file; on a terminal the command still sees a terminal")
+	cmd.Flags().BoolVar(&opts.capture, "capture", false, "Record the output in the history, for cmdex history search")
+	cmd.Flags().BoolVar(&opts.pty, "pty", false, "Run the command on a pseudo-terminal, for interactive programs like ssh or vim (Linux only)")
+	eventFlags(cmd, &opts)
+	envFlags(cmd, &opts)
+	limitFlags(cmd, &opts.limits)
+	return cmd
+}
+
+// execCommand runs argv like the command of an alias, without saving it.
+// The history records it quoted, for rerun to split again.
+func execCommand(ctx context.Context, argv []string, opts runOptions) error {
+	command := quoteArgs(argv)
+	a := &Alias{Command: command}
+	if err := policy.checkCommand(command); err != nil {
+		return err
+	}
+	if needsConfirmation(a) && !opts.yes {
+		if !interactive() {
+			return errorf("the policy requires confirmation of %s; pass --yes to run it non-interactively", command)
+		}
+		ok, err := confirm(trf("Run %s?", command), false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("aborted")
+		}
+	}
+	s := startSequence(a, nil, &templateData{steps: make(map[string]string)})
+	s.argv = argv
+	if err := s.configure(opts); err != nil {
+		return err
+	}
+	closeDB()
+	return runSequence(ctx, s, "exec", opts)
+}
+
+// offerSave offers, on a terminal, to save the command exec ran as an
+// alias. Aliases split their command into words, so the arguments are
+// saved joined by spaces.
+func offerSave(command string) error {
+	if !interactive() || verbosity < verbosityNormal {
+		return nil
+	}
+	save, err := confirm("Save this command as an alias?", false)
+	if err != nil || !save {
+		return err
+	}
+	if err := writableDB(); err != nil {
+		return err
+	}
+	name, err := askAliasName()
+	if err != nil {
+		return err
+	}
+	a := &Alias{Name: name, Command: command}
+	// The run was confirmed if the policy asked for it, and so is the
+	// alias.
+	a.Confirm = needsConfirmation(a)
+	return saveNewAlias(a, "exec")
+}
+
+// rerunExec runs the command of the cmdex exec run e again, with
+// editCommand after letting the user change it.
+func rerunExec(ctx context.Context, e *historyEntry, editCommand bool, opts runOptions) error {
+	command := e.Command
+	if editCommand {
+		if !interactive() {
+			return fmt.Errorf("--edit-args needs a terminal")
+		}
+		var err error
+		if command, err = ask("Command", command); err != nil {
+			return err
+		}
+	}
+	argv, err := splitArgs(command)
+	if err != nil {
+		return usageError(fmt.Errorf("command %q: %w", command, err))
+	}
+	if len(argv) == 0 {
+		return usageError(errors.New("empty command"))
+	}
+	banner(os.Stderr, verbosityNormal, "==> "+command)
+	return execCommand(ctx, argv, opts)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Conditions such as `os == "darwin" && exit_code != 0` are small boolean
+// expressions over strings. The grammar is:
+//
+//	or      = and { "||" and }
+//	and     = not { "&&" not }
+//	not     = "!" not | compare
+//	compare = operand [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" ) operand ]
+//	operand = "(" or ")" | string | number | identifier
+//
+// Identifiers are resolved by the caller. Ordering comparisons are numeric
+// when both sides are numbers and lexical otherwise.
+
+type exprToken struct {
+	kind  byte // 's' string, 'n' number, 'i' identifier, 'o' operator
+	value string
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+	lookup func(name string) (string, bool)
+}
+
+// evalCondition evaluates expr, resolving identifiers with lookup.
+func evalCondition(expr string, lookup func(name string) (string, bool)) (bool, error) {
+	tokens, err := lexExpr(expr)
+	if err != nil {
+		return false, err
+	}
+	p := &exprParser{tokens: tokens, lookup: lookup}
+	v, err := p.or()
+	if err != nil {
+		return false, err
+	}
+	if p.pos < len(p.tokens) {
+		return false, fmt.Errorf("unexpected %q", p.tokens[p.pos].value)
+	}
+	return truthy(v), nil
+}
+
+func lexExpr(s string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, exprToken{'s', s[i+1 : i+1+end]})
+			i += end + 2
+		case strings.HasPrefix(s[i:], "==") || strings.HasPrefix(s[i:], "!=") ||
+			strings.HasPrefix(s[i:], "<=") || strings.HasPrefix(s[i:], ">=") ||
+			strings.HasPrefix(s[i:], "&&") || strings.HasPrefix(s[i:], "||"):
+			tokens = append(tokens, exprToken{'o', s[i : i+2]})
+			i += 2
+		case strings.ContainsRune("<>!()", rune(c)):
+			tokens = append(tokens, exprToken{'o', string(c)})
+			i++
+		case c >= '0' && c <= '9' || c == '-':
+			j := i + 1
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, exprToken{'n', s[i:j]})
+			i = j
+		case c == '$' || c == '_' || unicode.IsLetter(rune(c)):
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || s[j] == '.' || s[j] == '-' ||
+				unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			tokens = append(tokens, exprToken{'i', s[i:j]})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+func (p *exprParser) peek(op string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == 'o' && p.tokens[p.pos].value == op
+}
+
+func (p *exprParser) or() (string, error) {
+	left, err := p.and()
+	for err == nil && p.peek("||") {
+		p.pos++
+		var right string
+		right, err = p.and()
+		left = boolString(truthy(left) || truthy(right))
+	}
+	return left, err
+}
+
+func (p *exprParser) and() (string, error) {
+	left, err := p.not()
+	for err == nil && p.peek("&&") {
+		p.pos++
+		var right string
+		right, err = p.not()
+		left = boolString(truthy(left) && truthy(right))
+	}
+	return left, err
+}
+
+func (p *exprParser) not() (string, error) {
+	if p.peek("!") {
+		p.pos++
+		v, err := p.not()
+		return boolString(!truthy(v)), err
+	}
+	return p.compare()
+}
+
+func (p *exprParser) compare() (string, error) {
+	left, err := p.operand()
+	if err != nil || p.pos >= len(p.tokens) || p.tokens[p.pos].kind != 'o' {
+		return left, err
+	}
+	op := p.tokens[p.pos].value
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return left, nil
+	}
+	p.pos++
+	right, err := p.operand()
+	if err != nil {
+		return "", err
+	}
+	cmp := strings.Compare(left, right)
+	if l, lerr := strconv.ParseFloat(left, 64); lerr == nil {
+		if r, rerr := strconv.ParseFloat(right, 64); rerr == nil {
+			switch {
+			case l < r:
+				cmp = -1
+			case l > r:
+				cmp = 1
+			default:
+				cmp = 0
+			}
+		}
+	}
+	switch op {
+	case "==":
+		return boolString(cmp == 0), nil
+	case "!=":
+		return boolString(cmp != 0), nil
+	case "<":
+		return boolString(cmp < 0), nil
+	case "<=":
+		return boolString(cmp <= 0), nil
+	case ">":
+		return boolString(cmp > 0), nil
+	}
+	return boolString(cmp >= 0), nil
+}
+
+func (p *exprParser) operand() (string, error) {
+	if p.pos >= len(p.tokens) {
+		return "", fmt.Errorf("unexpected end of expression")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	switch t.kind {
+	case 's', 'n':
+		return t.value, nil
+	case 'i':
+		switch t.value {
+		case "true", "false":
+			return t.value, nil
+		}
+		v, ok := p.lookup(t.value)
+		if !ok {
+			return "", fmt.Errorf("unknown name %q", t.value)
+		}
+		return v, nil
+	}
+	if t.value == "(" {
+		v, err := p.or()
+		if err != nil {
+			return "", err
+		}
+		if !p.peek(")") {
+			return "", fmt.Errorf("missing )")
+		}
+		p.pos++
+		return v, nil
+	}
+	return "", fmt.Errorf("unexpected %q", t.value)
+}
+
+// truthy reports whether a value counts as true: anything but "", "0" and
+// "false".
+func truthy(v string) bool {
+	return v != "" && v != "0" && v != "false"
+}
+
+func boolString(b bool) string {
+	return strconv.FormatBool(b)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// An output filter rewrites what an alias prints on stdout before it's
+// shown. The filter is either one of the built-ins
+//
+//	grep [-v] [-i] <regexp>   keep (or with -v drop) matching lines
+//	head <n>                  keep the first n lines
+//	tail <n>                  keep the last n lines
+//	column                    align whitespace-separated columns
+//
+// or any other command line, such as "jq .items[].name", which reads the
+// output on its stdin.
+
+// checkFilter reports whether spec is a usable output filter.
+func checkFilter(spec string) error {
+	_, err := newFilter(context.Background(), spec, io.Discard, io.Discard, nil)
+	return err
+}
+
+// newFilter returns a writer that filters what's written to it into out.
+// Close must be called to flush the filter and wait for it; it reports
+// the filter's failure. External filters are built by command, so they run
+// where, with the environment and in the sandbox the alias does, write
+// their errors to errOut and don't start until the first write.
+func newFilter(ctx context.Context, spec string, out, errOut io.Writer, command func(ctx context.Context, argv []string, binds ...string) (*exec.Cmd, error)) (io.WriteCloser, error) {
+	argv, err := splitArgs(spec)
+	if err != nil {
+		return nil, fmt.Errorf("output filter: %w", err)
+	}
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("output filter is empty")
+	}
+	switch name, args := argv[0], argv[1:]; name {
+	case "grep":
+		invert, fold := false, false
+		for len(args) > 1 && strings.HasPrefix(args[0], "-") {
+			switch args[0] {
+			case "-v":
+				invert = true
+			case "-i":
+				fold = true
+			default:
+				return nil, fmt.Errorf("output filter grep: unknown option %s (only -v and -i are built in)", args[0])
+			}
+			args = args[1:]
+		}
+		if len(args) != 1 {
+			return nil, fmt.Errorf("output filter grep: want one pattern")
+		}
+		pattern := args[0]
+		if fold {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("output filter grep: %w", err)
+		}
+		return &lineFilter{out: out, line: func(w io.Writer, line string) {
+			if re.MatchString(line) != invert {
+				io.WriteString(w, line+"\n")
+			}
+		}}, nil
+	case "head", "tail":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("output filter %s: want a line count", name)
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("output filter %s: invalid line count %q", name, args[0])
+		}
+		if name == "head" {
+			seen := 0
+			return &lineFilter{out: out, line: func(w io.Writer, line string) {
+				// Later lines are read and dropped so the command isn't
+				// killed by a closed pipe.
+				if seen++; seen <= n {
+					io.WriteString(w, line+"\n")
+				}
+			}}, nil
+		}
+		var last []string
+		return &lineFilter{out: out,
+			line: func(w io.Writer, line string) {
+				if last = append(last, line); len(last) > n {
+					last = last[1:]
+				}
+			},
+			flush: func(w io.Writer) {
+				for _, line := range last {
+					io.WriteString(w, line+"\n")
+				}
+			},
+		}, nil
+	case "column":
+		if len(args) > 0 {
+			return nil, fmt.Errorf("output filter column takes no arguments")
+		}
+		var t table
+		return &lineFilter{out: out,
+			line: func(w io.Writer, line string) { t.add(strings.Fields(line)...) },
+			flush: func(w io.Writer) {
+				for _, line := range t.lines(0) {
+					io.WriteString(w, line+"\n")
+				}
+			},
+		}, nil
+	}
+	return &commandFilter{ctx: ctx, spec: spec, argv: argv, out: out, errOut: errOut, command: command}, nil
+}
+
+// lineFilter applies a built-in filter line by line.
+type lineFilter struct {
+	out  io.Writer
+	buf  []byte
+	line func(w io.Writer, line string)
+	// flush, if set, writes what the filter held back once the output
+	// ends.
+	flush func(w io.Writer)
+}
+
+func (f *lineFilter) Write(p []byte) (int, error) {
+	f.buf = append(f.buf, p...)
+	for {
+		i := bytes.IndexByte(f.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		f.line(f.out, strings.TrimSuffix(string(f.buf[:i]), "\r"))
+		f.buf = f.buf[i+1:]
+	}
+}
+
+func (f *lineFilter) Close() error {
+	if len(f.buf) > 0 {
+		f.line(f.out, string(f.buf))
+		f.buf = nil
+	}
+	if f.flush != nil {
+		f.flush(f.out)
+	}
+	return nil
+}
+
+// commandFilter pipes the output through an external command.
+type commandFilter struct {
+	ctx  context.Context
+	spec string
+	argv []string
+	out  io.Writer
+	// errOut receives the filter's own errors: the sequence's stderr.
+	errOut  io.Writer
+	command func(ctx context.Context, argv []string, binds ...string) (*exec.Cmd, error)
+	cmd     *exec.Cmd
+	in      io.WriteCloser
+}
+
+func (f *commandFilter) start() error {
+	if err := policy.checkCommand(f.spec); err != nil {
+		return err
+	}
+	var err error
+	if f.cmd, err = f.command(f.ctx, f.argv); err != nil {
+		return fmt.Errorf("output filter: %w", err)
+	}
+	// The filter reads the alias's output, not its stdin or terminal.
+	f.cmd.Stdin, f.cmd.SysProcAttr = nil, nil
+	f.cmd.Stdout = f.out
+	f.cmd.Stderr = f.errOut
+	if f.in, err = f.cmd.StdinPipe(); err != nil {
+		return err
+	}
+	if err := f.cmd.Start(); err != nil {
+		return fmt.Errorf("output filter: %w", err)
+	}
+	return nil
+}
+
+func (f *commandFilter) Write(p []byte) (int, error) {
+	if f.cmd == nil {
+		if err := f.start(); err != nil {
+			return 0, err
+		}
+	}
+	// A filter that stops reading early, like head, shouldn't fail the
+	// command writing to it.
+	f.in.Write(p)
+	return len(p), nil
+}
+
+func (f *commandFilter) Close() error {
+	if f.cmd == nil {
+		// Filters such as jq expect input even when there was none.
+		if err := f.start(); err != nil {
+			return err
+		}
+	}
+	f.in.Close()
+	if err := f.cmd.Wait(); err != nil {
+		return fmt.Errorf("output filter %s: %w", f.argv[0], err)
+	}
+	return nil
+}
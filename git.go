@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// gitRoot returns the root of the git work tree enclosing the working
+// directory: the nearest directory with a .git entry, which is a file in
+// worktrees and submodules.
+func gitRoot() (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for dir := wd; ; {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("%s is not inside a git repository", wd)
+		}
+		dir = parent
+	}
+}
+
+// workDir returns the directory a runs in, empty for the caller's. With
+// GitRoot, that's the root of the enclosing git repository, or a's Dir
+// taken relative to it.
+func (a *Alias) workDir() (string, error) {
+	dir := expandHome(a.Dir)
+	if !a.GitRoot || filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	root, err := gitRoot()
+	if err != nil {
+		return "", fmt.Errorf("alias %s runs at the git root: %w", a.Name, err)
+	}
+	return filepath.Join(root, dir), nil
+}
+
+// gitOutput runs git with args in dir and returns its trimmed output.
+func gitOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitValue returns the value of the template {{gitBranch}}, {{gitSha}} or
+// {{gitDirty}} for the checkout in dir.
+func gitValue(dir, name string) (string, error) {
+	switch name {
+	case "gitBranch":
+		return gitOutput(dir, "rev-parse", "--abbrev-ref", "HEAD")
+	case "gitSha":
+		return gitOutput(dir, "rev-parse", "HEAD")
+	}
+	status, err := gitOutput(dir, "status", "--porcelain")
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatBool(status != ""), nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// grepAliases returns the aliases matching every word of query, ignoring
+// case, in their names, descriptions, tags or commands. Aliases matching by
+// name come first, then the most used.
+func grepAliases(tx *bolt.Tx, query string) ([]*Alias, error) {
+	words := strings.Fields(strings.ToLower(query))
+	type match struct {
+		alias  *Alias
+		byName bool
+	}
+	var matches []match
+	q := aliasQuery{sortBy: "usage"}
+	_, err := q.each(tx, func(a *Alias) error {
+		name := strings.ToLower(a.Name)
+		text := strings.ToLower(strings.Join([]string{a.Name, a.Description, strings.Join(a.Tags, " "), a.Script, a.allCommands()}, "\n"))
+		byName := true
+		for _, w := range words {
+			if !strings.Contains(text, w) {
+				return nil
+			}
+			byName = byName && strings.Contains(name, w)
+		}
+		matches = append(matches, match{a, byName})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].byName && !matches[j].byName })
+	aliases := make([]*Alias, len(matches))
+	for i, m := range matches {
+		aliases[i] = m.alias
+	}
+	return aliases, nil
+}
+
+// pickAlias asks which of n listed aliases to run. It returns -1 when the
+// user picks none.
+func pickAlias(n int) (int, error) {
+	for {
+		answer, err := ask(trf("Run which alias? [1-%d, Enter for none]", n), "")
+		if err != nil {
+			return -1, err
+		}
+		if answer == "" || answer == "q" {
+			return -1, nil
+		}
+		if i, err := strconv.Atoi(answer); err == nil && i >= 1 && i <= n {
+			return i - 1, nil
+		}
+	}
+}
+
+func grepRunCmd() *cobra.Command {
+	var (
+		first bool
+		limit int
+		opts  runOptions
+	)
+	cmd := &cobra.Command{
+		Use:   "grep-run <text>... [-- args...]",
+		Short: "Find aliases by their text and pick one to run",
+		Long: `grep-run lists the aliases whose name, description, tags or commands
+contain every word of the text, ignoring case, and runs the one picked from
+the list. Aliases matching by name are listed first, then the most used.
+Arguments after -- are passed to the alias picked.
+
+With a single match grep-run asks before running it; with --first it runs
+the best match without asking, as it must where nobody can answer.`,
+		Example:           "  cmdex grep-run port-forward\n  cmdex grep-run logs prod -- api",
+		Annotations:       readDB,
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: cobra.NoFileCompletions,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			words, runArgs := args, []string(nil)
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				words, runArgs = args[:dash], args[dash:]
+			}
+			query := strings.Join(words, " ")
+			if strings.TrimSpace(query) == "" {
+				return usageError(fmt.Errorf("nothing to search for"))
+			}
+			var aliases []*Alias
+			err := db.View(func(tx *bolt.Tx) error {
+				var err error
+				aliases, err = grepAliases(tx, query)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("searching aliases: %w", err)
+			}
+			if len(aliases) == 0 {
+				return fmt.Errorf("no alias matches %q", query)
+			}
+			pick := 0
+			if !first {
+				shown := aliases
+				if limit > 0 && len(shown) > limit {
+					shown = shown[:limit]
+				}
+				var t table
+				t.color(1, "alias")
+				for i, a := range shown {
+					t.add(fmt.Sprintf("%d.", i+1), a.Name, a.summary())
+				}
+				width := 0
+				if w, _, ok := terminalSize(); ok {
+					width = w
+				}
+				printLines(t.lines(width))
+				if len(shown) < len(aliases) {
+					fmt.Printf("(%d more; refine the search or raise --limit)\n", len(aliases)-len(shown))
+				}
+				if !interactive() && len(aliases) == 1 {
+					return fmt.Errorf("only %s matches %q; run it with cmdex run, or pass --first", aliases[0].Name, query)
+				}
+				if !interactive() {
+					return fmt.Errorf("%d aliases match %q; run one with cmdex run, or pass --first", len(aliases), query)
+				}
+				if len(shown) == 1 {
+					ok, err := confirm(trf("Run %s?", shown[0].Name), true)
+					if err != nil || !ok {
+						return err
+					}
+				} else if pick, err = pickAlias(len(shown)); err != nil || pick < 0 {
+					return err
+				}
+			}
+			return runCommand(cmd.Context(), aliases[pick].Name, runArgs, opts)
+		},
+	}
+	cmd.Flags().BoolVar(&first, "first", false, "Run the best match without listing the matches or asking")
+	cmd.Flags().IntVar(&limit, "limit", 20, "List at most this many matches to pick from")
+	cmd.Flags().BoolVarP(&opts.yes, "yes", "y", false, "Don't ask for confirmation before running")
+	cmd.Flags().DurationVar(&opts.timeout, "timeout", 0, "Kill the command if it runs longer than this (e.g. 30s, 5m)")
+	return cmd
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"cmdex/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer serves the daemon's API over gRPC. It shares authentication,
+// access rules and the run machinery with the REST API.
+type grpcServer struct {
+	api.UnimplementedCmdexServer
+	srv *server
+}
+
+// serveGRPC listens on addr and serves the gRPC API until it fails.
+func (srv *server) serveGRPC(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	gs := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, h grpc.UnaryHandler) (interface{}, error) {
+			ctx, err := srv.grpcAuth(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return h(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(s interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+			ctx, err := srv.grpcAuth(ss.Context())
+			if err != nil {
+				return err
+			}
+			return h(s, &authStream{ss, ctx})
+		}),
+	)
+	api.RegisterCmdexServer(gs, &grpcServer{srv: srv})
+	return gs.Serve(lis)
+}
+
+// grpcAuth authenticates the bearer token in the "authorization" metadata
+// of a call and attaches the client to its context.
+func (srv *server) grpcAuth(ctx context.Context) (context.Context, error) {
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			token = strings.TrimPrefix(v[0], "Bearer ")
+		}
+	}
+	u := srv.authenticate(token)
+	if u == nil {
+		return nil, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return context.WithValue(ctx, userKey{}, u), nil
+}
+
+// authStream is a server stream with an authenticated context.
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context { return s.ctx }
+
+// contextUser returns the client authenticated for a call.
+func contextUser(ctx context.Context) *apiUser {
+	return ctx.Value(userKey{}).(*apiUser)
+}
+
+// remoteAddr identifies the client of a call in the audit log.
+func remoteAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return "grpc " + p.Addr.String()
+	}
+	return "grpc"
+}
+
+// grpcError maps err onto a gRPC status, as writeError does onto HTTP
+// statuses.
+func grpcError(err error) error {
+	ce := classify(err)
+	code := codes.Internal
+	switch {
+	case errors.Is(err, errAliasNotFound):
+		code = codes.NotFound
+	case errors.Is(err, errForbidden):
+		code = codes.PermissionDenied
+	case ce.code == exitUsage || ce.code == exitPlaceholderMissing:
+		code = codes.InvalidArgument
+	case ce.code == exitDBLocked:
+		code = codes.Unavailable
+	case ce.code == exitApprovalPending:
+		code = codes.FailedPrecondition
+	case ce.code == exitCooldown:
+		code = codes.ResourceExhausted
+	case ce.code == exitAliasLocked:
+		code = codes.Aborted
+	case ce.code == exitCanceled:
+		code = codes.Canceled
+	}
+	return status.Error(code, ce.Error())
+}
+
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// toProto converts a to its API message.
+func toProto(a *Alias) *api.Alias {
+	record, _ := json.Marshal(aliasJSON{a.Name, a})
+	pa := &api.Alias{
+		Name:        a.Name,
+		Description: a.Description,
+		Command:     a.Command,
+		Script:      a.Script,
+		Runtime:     a.Runtime,
+		Platforms:   a.Platforms,
+		Tags:        a.Tags,
+		Examples:    a.Examples,
+		Dir:         a.Dir,
+		Confirm:     a.Confirm,
+		Created:     timestamp(a.Created),
+		Modified:    timestamp(a.Modified),
+		Uses:        int64(a.Uses),
+		LastUsed:    timestamp(a.LastUsed),
+		RecordJson:  string(record),
+	}
+	if a.Deprecated != nil {
+		pa.DeprecatedUse = a.Deprecated.Use
+	}
+	for _, ph := range a.Placeholders {
+		pa.Placeholders = append(pa.Placeholders, &api.Placeholder{
+			Name: ph.Name, Description: ph.Description, Default: ph.Default, Choices: ph.Choices,
+		})
+	}
+	return pa
+}
+
+// fromProto converts an API message to an alias, preferring its complete
+// JSON record when it has one.
+func fromProto(pa *api.Alias) (*Alias, error) {
+	if pa.RecordJson != "" {
+		body := aliasJSON{Alias: &Alias{}}
+		dec := json.NewDecoder(strings.NewReader(pa.RecordJson))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&body); err != nil {
+			return nil, usageError(err)
+		}
+		return body.Alias, nil
+	}
+	a := &Alias{
+		Description: pa.Description,
+		Command:     pa.Command,
+		Script:      pa.Script,
+		Runtime:     pa.Runtime,
+		Platforms:   pa.Platforms,
+		Tags:        pa.Tags,
+		Examples:    pa.Examples,
+		Dir:         pa.Dir,
+		Confirm:     pa.Confirm,
+	}
+	if pa.DeprecatedUse != "" {
+		a.Deprecated = &Deprecation{Use: pa.DeprecatedUse}
+	}
+	for _, ph := range pa.Placeholders {
+		a.Placeholders = append(a.Placeholders, Placeholder{
+			Name: ph.Name, Description: ph.Description, Default: ph.Default, Choices: ph.Choices,
+		})
+	}
+	return a, nil
+}
+
+// aliasQueryOf returns the listing a request asks for.
+func aliasQueryOf(req *api.ListAliasesRequest) aliasQuery {
+	q := aliasQuery{sortBy: req.Sort, reverse: req.Reverse, tag: req.Tag, after: req.After, limit: int(req.Limit)}
+	if q.sortBy == "" {
+		q.sortBy = "name"
+	}
+	return q
+}
+
+func (g *grpcServer) ListAliases(ctx context.Context, req *api.ListAliasesRequest) (*api.ListAliasesResponse, error) {
+	if req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "negative limit")
+	}
+	q := aliasQueryOf(req)
+	resp := &api.ListAliasesResponse{}
+	more, err := g.srv.store.each(ctx, q, func(a *Alias) error {
+		resp.Aliases = append(resp.Aliases, toProto(a))
+		return nil
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if more {
+		resp.NextAfter = resp.Aliases[len(resp.Aliases)-1].Name
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) StreamAliases(req *api.ListAliasesRequest, stream api.Cmdex_StreamAliasesServer) error {
+	if req.Limit < 0 {
+		return status.Error(codes.InvalidArgument, "negative limit")
+	}
+	q := aliasQueryOf(req)
+	var sendErr error
+	_, err := g.srv.store.each(stream.Context(), q, func(a *Alias) error {
+		if sendErr = stream.Send(toProto(a)); sendErr != nil {
+			return sendErr
+		}
+		return nil
+	})
+	if sendErr != nil {
+		return sendErr
+	}
+	if err != nil {
+		return grpcError(err)
+	}
+	return nil
+}
+
+func (g *grpcServer) GetAlias(ctx context.Context, req *api.GetAliasRequest) (*api.Alias, error) {
+	a, err := g.srv.store.get(ctx, req.Name)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProto(a), nil
+}
+
+func (g *grpcServer) PutAlias(ctx context.Context, req *api.PutAliasRequest) (*api.Alias, error) {
+	if req.Alias == nil || req.Alias.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "alias name missing")
+	}
+	a, err := fromProto(req.Alias)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if err := a.validate(); err != nil {
+		return nil, grpcError(usageError(err))
+	}
+	if err := g.srv.storeAlias(ctx, contextUser(ctx), req.Alias.Name, a, remoteAddr(ctx)); err != nil {
+		return nil, grpcError(err)
+	}
+	return toProto(a), nil
+}
+
+func (g *grpcServer) RunAlias(req *api.RunAliasRequest, stream api.Cmdex_RunAliasServer) error {
+	ctx := stream.Context()
+	s, err := g.srv.prepareRun(ctx, req.Name, runSpec{
+		args: func(*Alias) ([]string, error) { return req.Args, nil },
+		yes:  req.Yes,
+		user: contextUser(ctx),
+	}, remoteAddr(ctx))
+	if err != nil {
+		return grpcError(err)
+	}
+	out := &streamWriter{stream: stream}
+	s.stdout, s.stderr = out, out
+	resp := g.srv.execute(ctx, s, time.Duration(req.TimeoutSeconds*float64(time.Second)))
+	if out.err != nil {
+		return out.err
+	}
+	return stream.Send(&api.RunEvent{Event: &api.RunEvent_Result{Result: &api.RunResult{
+		Alias:           resp.Alias,
+		ExitCode:        int32(resp.ExitCode),
+		DurationSeconds: resp.Duration,
+		Error:           resp.Error,
+		Kind:            resp.Kind,
+	}}})
+}
+
+// streamWriter sends what a run writes as output events. Steps write to
+// stdout and stderr from separate goroutines, so sends are serialised.
+type streamWriter struct {
+	mu     sync.Mutex
+	stream api.Cmdex_RunAliasServer
+	err    error
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	chunk := append([]byte(nil), p...)
+	if w.err = w.stream.Send(&api.RunEvent{Event: &api.RunEvent_Output{Output: chunk}}); w.err != nil {
+		return 0, w.err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	goruntime "runtime"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Guards are conditions an alias checks before it runs, refusing to run
+// where it would do damage.
+type Guards struct {
+	// RequireCleanWorktree refuses to run from a checkout with
+	// uncommitted changes.
+	RequireCleanWorktree bool `json:"require_clean_worktree,omitempty" yaml:"require_clean_worktree,omitempty"`
+	// RequireBranch is a pattern, such as main or release/*, the checked
+	// out branch must match.
+	RequireBranch string `json:"require_branch,omitempty" yaml:"require_branch,omitempty"`
+	// RequireKubeContext and ForbidKubeContext are patterns, such as
+	// prod-*, the current kubectl context must or must not match.
+	RequireKubeContext string `json:"require_kube_context,omitempty" yaml:"require_kube_context,omitempty"`
+	ForbidKubeContext  string `json:"forbid_kube_context,omitempty" yaml:"forbid_kube_context,omitempty"`
+	// RequireAWSProfile and RequireGCPProject are patterns the active AWS
+	// profile and gcloud project must match.
+	RequireAWSProfile string `json:"require_aws_profile,omitempty" yaml:"require_aws_profile,omitempty"`
+	RequireGCPProject string `json:"require_gcp_project,omitempty" yaml:"require_gcp_project,omitempty"`
+	// CloudMismatch is what happens when the cloud identity doesn't
+	// match: "refuse" (the default) or "confirm", which asks whether to
+	// run anyway and refuses where nobody can answer.
+	CloudMismatch string `json:"cloud_mismatch,omitempty" yaml:"cloud_mismatch,omitempty"`
+	// Deny lists the times, in local time, the alias must not run at,
+	// such as "Fri 16:00-Mon 08:00"; Allow lists the only times it may.
+	// See parseWindow.
+	Deny  []string `json:"deny,omitempty" yaml:"deny,omitempty"`
+	Allow []string `json:"allow,omitempty" yaml:"allow,omitempty"`
+	// Window names a safety window from windows in the config, whose
+	// times apply as well.
+	Window string `json:"window,omitempty" yaml:"window,omitempty"`
+	// OutsideWindow is what happens on a run outside the times above:
+	// "refuse" (the default) or "confirm", which asks twice whether to
+	// run anyway and refuses where nobody can answer. It overrides the
+	// outside: of the window.
+	OutsideWindow string `json:"outside_window,omitempty" yaml:"outside_window,omitempty"`
+}
+
+// String describes the guards, such as "clean worktree, branch main".
+func (g Guards) String() string {
+	var parts []string
+	if g.RequireCleanWorktree {
+		parts = append(parts, "clean worktree")
+	}
+	if g.RequireBranch != "" {
+		parts = append(parts, "branch "+g.RequireBranch)
+	}
+	if g.RequireKubeContext != "" {
+		parts = append(parts, "kube context "+g.RequireKubeContext)
+	}
+	if g.ForbidKubeContext != "" {
+		parts = append(parts, "no kube context "+g.ForbidKubeContext)
+	}
+	if g.RequireAWSProfile != "" {
+		parts = append(parts, "AWS profile "+g.RequireAWSProfile)
+	}
+	if g.RequireGCPProject != "" {
+		parts = append(parts, "gcloud project "+g.RequireGCPProject)
+	}
+	if g.CloudMismatch == "confirm" && (g.RequireAWSProfile != "" || g.RequireGCPProject != "") {
+		parts[len(parts)-1] += " (or confirm)"
+	}
+	if len(g.Deny) > 0 {
+		parts = append(parts, "not "+strings.Join(g.Deny, ", "))
+	}
+	if len(g.Allow) > 0 {
+		parts = append(parts, "only "+strings.Join(g.Allow, ", "))
+	}
+	if g.Window != "" {
+		parts = append(parts, "window "+g.Window)
+	}
+	if g.OutsideWindow == "confirm" && (len(g.Deny) > 0 || len(g.Allow) > 0 || g.Window != "") {
+		parts[len(parts)-1] += " (or confirm twice)"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// validate checks the patterns of the guards.
+func (g Guards) validate() error {
+	for _, pattern := range []string{g.RequireBranch, g.RequireKubeContext, g.ForbidKubeContext, g.RequireAWSProfile, g.RequireGCPProject} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	switch g.CloudMismatch {
+	case "", "refuse", "confirm":
+	default:
+		return fmt.Errorf("invalid cloud mismatch action %q (use refuse or confirm)", g.CloudMismatch)
+	}
+	if _, err := parseWindows(g.Deny); err != nil {
+		return err
+	}
+	if _, err := parseWindows(g.Allow); err != nil {
+		return err
+	}
+	return checkOutsideWindow(g.OutsideWindow)
+}
+
+// checkGuards refuses to run s if one of its alias's guards fails.
+func (s *sequence) checkGuards() error {
+	g := s.alias.Guards
+	if g.RequireBranch != "" {
+		branch, err := s.data.git("gitBranch")
+		if err != nil {
+			return fmt.Errorf("alias %s requires branch %s: %w", s.alias.Name, g.RequireBranch, err)
+		}
+		if ok, _ := path.Match(g.RequireBranch, branch); !ok {
+			return fmt.Errorf("alias %s requires branch %s, but %s is checked out", s.alias.Name, g.RequireBranch, branch)
+		}
+	}
+	if g.RequireCleanWorktree {
+		dirty, err := s.data.git("gitDirty")
+		if err != nil {
+			return fmt.Errorf("alias %s requires a clean worktree: %w", s.alias.Name, err)
+		}
+		if dirty == "true" {
+			return fmt.Errorf("alias %s requires a clean worktree; commit or stash the changes first", s.alias.Name)
+		}
+	}
+	if g.RequireKubeContext != "" || g.ForbidKubeContext != "" {
+		context, err := kubeContext()
+		if err != nil {
+			return fmt.Errorf("alias %s checks the kube context: %w", s.alias.Name, err)
+		}
+		if ok, _ := path.Match(g.RequireKubeContext, context); g.RequireKubeContext != "" && !ok {
+			if context == "" {
+				return fmt.Errorf("alias %s requires kube context %s, but no context is selected", s.alias.Name, g.RequireKubeContext)
+			}
+			return fmt.Errorf("alias %s requires kube context %s, but the current context is %s (kubectl config use-context)", s.alias.Name, g.RequireKubeContext, context)
+		}
+		if ok, _ := path.Match(g.ForbidKubeContext, context); g.ForbidKubeContext != "" && ok {
+			return fmt.Errorf("alias %s must not run against kube context %s (kubectl config use-context)", s.alias.Name, context)
+		}
+	}
+	if g.RequireAWSProfile != "" {
+		profile := awsProfile()
+		if ok, _ := path.Match(g.RequireAWSProfile, profile); !ok || os.Getenv("AWS_ACCESS_KEY_ID") != "" {
+			active := "the AWS profile is " + profile
+			if os.Getenv("AWS_ACCESS_KEY_ID") != "" {
+				active = "AWS credentials are set in the environment"
+			}
+			if err := s.cloudMismatch(fmt.Sprintf("alias %s expects AWS profile %s, but %s", s.alias.Name, g.RequireAWSProfile, active)); err != nil {
+				return err
+			}
+		}
+	}
+	if g.RequireGCPProject != "" {
+		project, err := gcpProject()
+		if err != nil {
+			return fmt.Errorf("alias %s checks the gcloud project: %w", s.alias.Name, err)
+		}
+		if ok, _ := path.Match(g.RequireGCPProject, project); !ok {
+			active := "the gcloud project is " + project
+			if project == "" {
+				active = "no gcloud project is set"
+			}
+			if err := s.cloudMismatch(fmt.Sprintf("alias %s expects gcloud project %s, but %s", s.alias.Name, g.RequireGCPProject, active)); err != nil {
+				return err
+			}
+		}
+	}
+	return s.checkWindow()
+}
+
+// cloudMismatch refuses to run s over a cloud identity that doesn't match,
+// described by problem, or asks the user to go ahead anyway if the alias
+// says to.
+func (s *sequence) cloudMismatch(problem string) error {
+	if s.alias.CloudMismatch != "confirm" || !interactive() {
+		return errors.New(problem)
+	}
+	ok, err := confirm(trf("%s. Run it anyway?", problem), false)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("aborted")
+	}
+	return nil
+}
+
+// awsProfile returns the AWS profile the AWS CLI and SDKs use.
+func awsProfile() string {
+	for _, name := range []string{"AWS_PROFILE", "AWS_DEFAULT_PROFILE"} {
+		if p := os.Getenv(name); p != "" {
+			return p
+		}
+	}
+	return "default"
+}
+
+// gcpProject returns the project gcloud uses: $CLOUDSDK_CORE_PROJECT, or
+// the core/project property of the active gcloud configuration.
+func gcpProject() (string, error) {
+	if p := os.Getenv("CLOUDSDK_CORE_PROJECT"); p != "" {
+		return p, nil
+	}
+	dir := os.Getenv("CLOUDSDK_CONFIG")
+	switch {
+	case dir != "":
+	case goruntime.GOOS == "windows":
+		dir = filepath.Join(os.Getenv("APPDATA"), "gcloud")
+	default:
+		dir = expandHome("~/.config/gcloud")
+	}
+	name := os.Getenv("CLOUDSDK_ACTIVE_CONFIG_NAME")
+	if name == "" {
+		data, err := os.ReadFile(filepath.Join(dir, "active_config"))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		if name = strings.TrimSpace(string(data)); name == "" {
+			name = "default"
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "configurations", "config_"+name))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var section string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok && section == "core" && strings.TrimSpace(key) == "project" {
+			return strings.TrimSpace(value), nil
+		}
+	}
+	return "", nil
+}
+
+// kubeContext returns the current context of kubectl: that of the first
+// file listed in $KUBECONFIG that sets one, or of ~/.kube/config. It's
+// empty if none is selected.
+func kubeContext() (string, error) {
+	paths := filepath.SplitList(os.Getenv("KUBECONFIG"))
+	if len(paths) == 0 {
+		paths = []string{"~/.kube/config"}
+	}
+	for _, p := range paths {
+		data, err := os.ReadFile(expandHome(p))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		var config struct {
+			CurrentContext string `yaml:"current-context"`
+		}
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return "", fmt.Errorf("reading %s: %w", p, err)
+		}
+		if config.CurrentContext != "" {
+			return config.CurrentContext, nil
+		}
+	}
+	return "", nil
+}
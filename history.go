@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+var historyBucket = []byte("history")
+
+// historyEntry records one run of an alias from the command line.
+type historyEntry struct {
+	ID uint64 `json:"-"`
+	// RunID is the run's ULID, which other records refer to it by.
+	RunID    string        `json:"run_id,omitempty"`
+	Alias    string        `json:"alias"`
+	Args     []string      `json:"args,omitempty"`
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+	ExitCode int           `json:"exit_code"`
+	Host     string        `json:"host,omitempty"`
+	User     string        `json:"user,omitempty"`
+	// Dir is the working directory of the run; Env, its environment, is
+	// recorded when snapshots are on.
+	Dir string            `json:"dir,omitempty"`
+	Env map[string]string `json:"env,omitempty"`
+	// Sandboxed runs had a clean environment.
+	Sandboxed bool `json:"sandboxed,omitempty"`
+	// EnvCleared runs had only the allowed variables of the caller's
+	// environment.
+	EnvCleared bool `json:"env_cleared,omitempty"`
+	// Vars are the names of the variables the run's templates used.
+	Vars []string `json:"vars,omitempty"`
+	// Steps records every step of the run.
+	Steps []stepRecord `json:"steps,omitempty"`
+	// Log is the file the run's output was teed to.
+	Log string `json:"log,omitempty"`
+	// output is the output recorded of the run, kept in the output
+	// bucket.
+	output []byte
+	// Command is the command of a run of cmdex exec, which has no alias.
+	Command string `json:"command,omitempty"`
+	// Artifacts is the directory holding the files the run saved.
+	Artifacts string `json:"artifacts,omitempty"`
+}
+
+// label names what ran: the alias, or (exec) for cmdex exec.
+func (e *historyEntry) label() string {
+	if e.Command != "" {
+		return "(exec)"
+	}
+	return e.Alias
+}
+
+// argsText shows the arguments of the run, or the command exec ran.
+func (e *historyEntry) argsText() string {
+	if e.Command != "" {
+		return e.Command
+	}
+	return quoteArgs(e.Args)
+}
+
+// exitCodeOf returns the exit status a run ending in err is reported with:
+// the child's own status if it failed, otherwise cmdex's exit code.
+func exitCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	ce := classify(err)
+	if ce.childExit != 0 {
+		return ce.childExit
+	}
+	return ce.code
+}
+
+func historyKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+func decodeHistory(k, v []byte) (*historyEntry, error) {
+	v, err := decodeValue(string(k), v)
+	if err != nil {
+		return nil, err
+	}
+	e := &historyEntry{ID: binary.BigEndian.Uint64(k)}
+	if err := json.Unmarshal(v, e); err != nil {
+		return nil, fmt.Errorf("reading history entry %d: %w", e.ID, err)
+	}
+	return e, nil
+}
+
+// recordHistory queues e for appending to the run history when cmdex
+// exits, pruning the history to the configured retention.
+func recordHistory(e historyEntry) {
+	deferWrite(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		key := historyKey(id)
+		if v, err = encodeValue(string(key), v); err != nil {
+			return err
+		}
+		if err := b.Put(key, v); err != nil {
+			return err
+		}
+		if len(e.output) > 0 {
+			if err := putOutput(tx, key, e.output); err != nil {
+				return err
+			}
+		}
+		return enforceRetention(tx)
+	})
+}
+
+// forEachHistory calls fn for history entries, newest first, until fn
+// returns false.
+func forEachHistory(tx *bolt.Tx, fn func(e *historyEntry) bool) error {
+	c := tx.Bucket(historyBucket).Cursor()
+	for k, v := c.Last(); k != nil; k, v = c.Prev() {
+		e, err := decodeHistory(k, v)
+		if err != nil {
+			return err
+		}
+		if !fn(e) {
+			break
+		}
+	}
+	return nil
+}
+
+// quoteArgs renders args so that splitArgs reads them back unchanged.
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\"'\\") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// historyColumns are the values history --output csv can write.
+var historyColumns = map[string]func(e *historyEntry) string{
+	"id":        func(e *historyEntry) string { return fmt.Sprint(e.ID) },
+	"run_id":    func(e *historyEntry) string { return e.RunID },
+	"time":      func(e *historyEntry) string { return formatTime(e.Time) },
+	"alias":     func(e *historyEntry) string { return e.label() },
+	"exit_code": func(e *historyEntry) string { return strconv.Itoa(e.ExitCode) },
+	"duration":  func(e *historyEntry) string { return strconv.FormatFloat(e.Duration.Seconds(), 'f', 3, 64) },
+	"args":      func(e *historyEntry) string { return e.argsText() },
+	"dir":       func(e *historyEntry) string { return e.Dir },
+	"artifacts": func(e *historyEntry) string { return e.Artifacts },
+	"log":       func(e *historyEntry) string { return e.Log },
+}
+
+var historyColumnNames = []string{"id", "run_id", "time", "alias", "exit_code", "duration", "args", "dir", "artifacts", "log"}
+
+func historyCmd() *cobra.Command {
+	var (
+		limit   int
+		alias   string
+		output  string
+		columns []string
+	)
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "List recent alias runs, newest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			columns, err := checkOutput(output, nil, columns, historyColumnNames, historyColumnNames[:7])
+			if err != nil {
+				return err
+			}
+			var (
+				t    table
+				rows [][]string
+			)
+			t.color(3, "alias")
+			n := 0
+			err = db.View(func(tx *bolt.Tx) error {
+				return forEachHistory(tx, func(e *historyEntry) bool {
+					if alias != "" && e.label() != alias {
+						return true
+					}
+					n++
+					if output != "table" {
+						var row []string
+						for _, c := range columns {
+							row = append(row, historyColumns[c](e))
+						}
+						rows = append(rows, row)
+						return limit <= 0 || n < limit
+					}
+					status := fmt.Sprintf("exit %d", e.ExitCode)
+					t.add(fmt.Sprint(e.ID), e.RunID, e.Time.Local().Format("2006-01-02 15:04:05"), e.label(),
+						status, e.Duration.Round(time.Millisecond).String(), e.argsText())
+					return limit <= 0 || n < limit
+				})
+			})
+			if err != nil {
+				return fmt.Errorf("reading history: %w", err)
+			}
+			if output != "table" {
+				return writeDelimited(output, columns, rows)
+			}
+			printLines(t.lines(0))
+			return nil
+		},
+	}
+	cmd.Flags().IntVarP(&limit, "limit", "n", 20, "Show at most this many runs (0 for all)")
+	cmd.Flags().StringVar(&alias, "alias", "", "Only show runs of this alias, or (exec) for those of cmdex exec")
+	cmd.Flags().StringVarP(&output, "output", "o", "table", "Output format: table, csv or tsv")
+	cmd.Flags().StringSliceVar(&columns, "columns", nil, "Columns for csv and tsv output: "+strings.Join(historyColumnNames, ", "))
+	cmd.AddCommand(historyReportCmd())
+	cmd.AddCommand(historyDiffCmd())
+	cmd.AddCommand(historyArtifactsCmd())
+	cmd.AddCommand(historyShowCmd())
+	cmd.AddCommand(historySearchCmd())
+	cmd.AddCommand(historyPruneCmd())
+	cmd.AddCommand(historyExportCmd(), historyImportCmd())
+	return cmd
+}
+
+func rerunCmd() *cobra.Command {
+	var (
+		editArgs bool
+		opts     runOptions
+	)
+	cmd := &cobra.Command{
+		Use:         "rerun",
+		Annotations: readDB,
+		Short:       "Run the most recent alias invocation again",
+		Long: `rerun repeats the last alias run recorded in the history with the same
+arguments. With --edit-args the arguments can be changed first.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var last *historyEntry
+			err := db.View(func(tx *bolt.Tx) error {
+				return forEachHistory(tx, func(e *historyEntry) bool {
+					last = e
+					return false
+				})
+			})
+			if err != nil {
+				return fmt.Errorf("reading history: %w", err)
+			}
+			if last == nil {
+				return fmt.Errorf("no runs recorded yet")
+			}
+			if last.Command != "" {
+				return rerunExec(cmd.Context(), last, editArgs, opts)
+			}
+			runArgs := last.Args
+			if editArgs {
+				if !interactive() {
+					return fmt.Errorf("--edit-args needs a terminal")
+				}
+				answer, err := ask(trf("Arguments for %s", paint("alias", last.Alias)), quoteArgs(last.Args))
+				if err != nil {
+					return err
+				}
+				if runArgs, err = splitArgs(answer); err != nil {
+					return usageError(err)
+				}
+			}
+			banner(cmd.ErrOrStderr(), verbosityNormal, "==> "+strings.TrimSpace(last.Alias+" "+quoteArgs(runArgs)))
+			return runCommand(cmd.Context(), last.Alias, runArgs, opts)
+		},
+	}
+	cmd.Flags().BoolVar(&editArgs, "edit-args", false, "Change the arguments before running")
+	cmd.Flags().BoolVarP(&opts.yes, "yes", "y", false, "Don't ask for confirmation before running")
+	return cmd
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// historyRecord is a run as history export writes it: the history entry
+// with its number and, if asked for, its recorded output.
+type historyRecord struct {
+	ID uint64 `json:"id"`
+	*historyEntry
+	Output *string `json:"output,omitempty"`
+}
+
+// historyIdentity tells runs apart across machines, for history import to
+// skip those it already has. Runs recorded before run IDs existed go by
+// their time and alias.
+func historyIdentity(e *historyEntry) string {
+	if e.RunID != "" {
+		return e.RunID
+	}
+	return e.Time.UTC().Format(time.RFC3339Nano) + " " + e.Host + " " + e.label()
+}
+
+func historyExportCmd() *cobra.Command {
+	var (
+		format     string
+		output     string
+		alias      string
+		since      string
+		withOutput bool
+	)
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write the run history as JSON Lines",
+		Long: `export writes every run of the history, oldest first, as one JSON object per
+line: the run's number (id), run ID, alias, arguments, time, duration in
+nanoseconds, exit code, steps and the rest of what cmdex history show
+prints. The runs are streamed, so the history can be of any size. With
+--with-output each run also carries the output recorded of it.
+
+Feed the lines to your own analytics, or move the history to another
+machine with cmdex history import, alongside the aliases of cmdex export.`,
+		Example:     "  cmdex history export > runs.jsonl\n  cmdex history export --alias deploy --since 30d | jq -r '[.time, .exit_code] | @tsv'",
+		Annotations: readDB,
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "jsonl" {
+				return usageError(fmt.Errorf("invalid --format %q (use jsonl)", format))
+			}
+			var after time.Time
+			if since != "" {
+				var err error
+				if after, err = parseSince(since); err != nil {
+					return usageError(fmt.Errorf("--since: %w", err))
+				}
+			}
+			out := os.Stdout
+			if output != "" && output != "-" {
+				var err error
+				if out, err = os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600); err != nil {
+					return fmt.Errorf("exporting history: %w", err)
+				}
+				defer out.Close()
+			}
+			w := bufio.NewWriter(out)
+			enc := json.NewEncoder(w)
+			n := 0
+			err := db.View(func(tx *bolt.Tx) error {
+				c := tx.Bucket(historyBucket).Cursor()
+				for k, v := c.First(); k != nil; k, v = c.Next() {
+					e, err := decodeHistory(k, v)
+					if err != nil {
+						return err
+					}
+					if alias != "" && e.label() != alias || e.Time.Before(after) {
+						continue
+					}
+					r := historyRecord{ID: e.ID, historyEntry: e}
+					if withOutput {
+						data, err := getOutput(tx, k)
+						if err != nil {
+							return fmt.Errorf("reading the output of run %d: %w", e.ID, err)
+						}
+						if data != nil {
+							text := string(data)
+							r.Output = &text
+						}
+					}
+					if err := enc.Encode(r); err != nil {
+						return err
+					}
+					n++
+				}
+				return nil
+			})
+			if err == nil {
+				err = w.Flush()
+			}
+			if err != nil {
+				return fmt.Errorf("exporting history: %w", err)
+			}
+			if out != os.Stdout {
+				fmt.Fprintf(os.Stderr, "Exported %d runs to %s\n", n, output)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "jsonl", "Output format: jsonl")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Write to this file instead of stdout")
+	cmd.Flags().StringVar(&alias, "alias", "", "Only export runs of this alias, or (exec) for those of cmdex exec")
+	cmd.Flags().StringVar(&since, "since", "", "Only export runs since this duration ago (36h, 7d) or date")
+	cmd.Flags().BoolVar(&withOutput, "with-output", false, "Include the output recorded of each run")
+	return cmd
+}
+
+func historyImportCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "import [file]",
+		Short: "Add the runs of a history export to the history",
+		Long: `import reads the JSON Lines of cmdex history export, from file or stdin, and
+appends the runs to the history. Runs it already has, by run ID, are
+skipped, so importing the same export twice adds nothing. Imported runs get
+new numbers after those of the existing runs; the history's retention
+settings apply once they are in.`,
+		Example: "  ssh laptop cmdex history export | cmdex history import\n  cmdex history import runs.jsonl",
+		Args:    cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			in := os.Stdin
+			if len(args) == 1 && args[0] != "-" {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return fmt.Errorf("reading history export: %w", err)
+				}
+				defer f.Close()
+				in = f
+			}
+			var added, skipped int
+			err := db.Update(func(tx *bolt.Tx) error {
+				have := make(map[string]bool)
+				err := forEachHistory(tx, func(e *historyEntry) bool {
+					have[historyIdentity(e)] = true
+					return true
+				})
+				if err != nil {
+					return err
+				}
+				b := tx.Bucket(historyBucket)
+				dec := json.NewDecoder(bufio.NewReader(in))
+				for line := 1; ; line++ {
+					r := historyRecord{historyEntry: &historyEntry{}}
+					if err := dec.Decode(&r); errors.Is(err, io.EOF) {
+						break
+					} else if err != nil {
+						return fmt.Errorf("record %d: %w", line, err)
+					}
+					e := r.historyEntry
+					if e.Alias == "" && e.Command == "" || e.Time.IsZero() {
+						return fmt.Errorf("record %d: not a run of a history export", line)
+					}
+					id := historyIdentity(e)
+					if have[id] {
+						skipped++
+						continue
+					}
+					have[id] = true
+					seq, err := b.NextSequence()
+					if err != nil {
+						return err
+					}
+					v, err := json.Marshal(e)
+					if err != nil {
+						return err
+					}
+					key := historyKey(seq)
+					if v, err = encodeValue(string(key), v); err != nil {
+						return err
+					}
+					if err := b.Put(key, v); err != nil {
+						return err
+					}
+					if r.Output != nil && *r.Output != "" {
+						if err := putOutput(tx, key, []byte(*r.Output)); err != nil {
+							return err
+						}
+					}
+					added++
+				}
+				if added == 0 {
+					return nil
+				}
+				if err := enforceRetention(tx); err != nil {
+					return err
+				}
+				return writeAudit(tx, auditEntry{Action: "history-import", Detail: fmt.Sprintf("%d runs", added)})
+			})
+			if err != nil {
+				return fmt.Errorf("importing history: %w", err)
+			}
+			fmt.Printf("Imported %d runs, skipped %d already in the history\n", added, skipped)
+			return nil
+		},
+	}
+}
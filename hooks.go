@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxHookPayload bounds the size of webhook request bodies.
+const maxHookPayload = 1 << 20
+
+// checkHooks validates the webhooks configured in c.
+func checkHooks(c Config) error {
+	for name, h := range c.Serve.Hooks {
+		if h.Secret == "" {
+			return fmt.Errorf("hook %s has no secret", name)
+		}
+		if h.Timeout != "" {
+			if _, err := time.ParseDuration(h.Timeout); err != nil {
+				return fmt.Errorf("hook %s: invalid timeout: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validSignature checks the HMAC-SHA256 signature of body, sent as
+// "sha256=<hex>" in X-Hub-Signature-256 (as GitHub and Gitea do) or
+// X-Cmdex-Signature.
+func validSignature(r *http.Request, secret string, body []byte) bool {
+	sig := r.Header.Get("X-Hub-Signature-256")
+	if sig == "" {
+		sig = r.Header.Get("X-Cmdex-Signature")
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(sig, "sha256="))
+	if err != nil || len(got) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// payloadField looks up a dot-separated path in a decoded JSON payload and
+// formats the value found as an argument.
+func payloadField(payload interface{}, path string) (string, bool) {
+	v := payload
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = node[key]; !ok {
+				return "", false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", false
+			}
+			v = node[i]
+		default:
+			return "", false
+		}
+	}
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	b, _ := json.Marshal(v)
+	return string(b), true
+}
+
+// hookArgs maps payload fields to the arguments of a: the fields listed by
+// the hook or, without a list, the fields named like a's placeholders.
+// Placeholders without a field are left to their defaults.
+func hookArgs(h ServeHook, payload interface{}, a *Alias) ([]string, error) {
+	if len(h.Args) > 0 {
+		args := make([]string, len(h.Args))
+		for i, path := range h.Args {
+			v, ok := payloadField(payload, path)
+			if !ok {
+				return nil, usageError(fmt.Errorf("payload has no field %s", path))
+			}
+			args[i] = v
+		}
+		return args, nil
+	}
+	var args []string
+	for _, ph := range a.Placeholders {
+		v, ok := payloadField(payload, ph.Name)
+		if ph.Name == "" || !ok {
+			break
+		}
+		args = append(args, v)
+	}
+	return args, nil
+}
+
+func (srv *server) handleHook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/hooks/")
+	// The config may be reloaded meanwhile.
+	h, ok := currentConfig().Serve.Hooks[name]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxHookPayload+1))
+	if err != nil {
+		writeError(w, usageError(fmt.Errorf("reading payload: %w", err)))
+		return
+	}
+	if len(body) > maxHookPayload {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": "payload too large"})
+		return
+	}
+	if !validSignature(r, h.Secret, body) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid signature"})
+		return
+	}
+	var payload interface{}
+	if len(bytes.TrimSpace(body)) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&payload); err != nil {
+			writeError(w, usageError(fmt.Errorf("invalid payload: %w", err)))
+			return
+		}
+	}
+	timeout, _ := time.ParseDuration(h.Timeout)
+	alias := h.Alias
+	if alias == "" {
+		alias = name
+	}
+	srv.run(w, r, alias, runSpec{
+		args:    func(a *Alias) ([]string, error) { return hookArgs(h, payload, a) },
+		yes:     h.Yes,
+		timeout: timeout,
+		// Hooks are set up by whoever controls the config file.
+		user:  &apiUser{name: "hook:" + name, admin: true},
+		async: h.Async,
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// HTTPStep is a built-in HTTP request, so aliases can call webhooks and
+// health endpoints without depending on curl.
+type HTTPStep struct {
+	Method  string            `json:"method,omitempty" yaml:"method,omitempty"`
+	URL     string            `json:"url" yaml:"url"`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Body    string            `json:"body,omitempty" yaml:"body,omitempty"`
+	// Status is the expected response status; any 2xx is accepted if unset.
+	Status int `json:"status,omitempty" yaml:"status,omitempty"`
+}
+
+func (h *HTTPStep) method() string {
+	if h.Method == "" {
+		return http.MethodGet
+	}
+	return strings.ToUpper(h.Method)
+}
+
+// runHTTP performs an HTTP step, writing the response body to stdout. An
+// unexpected status fails the step like a failing command, with exit code 1.
+func (s *sequence) runHTTP(ctx context.Context, h *HTTPStep, stdout io.Writer) error {
+	url, err := s.render(h.URL)
+	if err != nil {
+		return err
+	}
+	body, err := s.render(h.Body)
+	if err != nil {
+		return err
+	}
+	s.trace(verbosityVerbose, "%s %s", h.method(), url)
+	req, err := http.NewRequestWithContext(ctx, h.method(), url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range h.Headers {
+		if v, err = s.render(v); err != nil {
+			return err
+		}
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return childError(ctx, err)
+		}
+		return stepFailed(fmt.Errorf("%s %s: %w", req.Method, url, err))
+	}
+	defer resp.Body.Close()
+	s.httpStatus = resp.StatusCode
+	if _, err := io.Copy(stdout, resp.Body); err != nil {
+		return stepFailed(fmt.Errorf("reading response: %w", err))
+	}
+
+	ok := resp.StatusCode >= 200 && resp.StatusCode < 300
+	if h.Status != 0 {
+		ok = resp.StatusCode == h.Status
+	}
+	if !ok {
+		return stepFailed(fmt.Errorf("%s %s returned %s", req.Method, url, resp.Status))
+	}
+	return nil
+}
+
+// stepFailed reports a failed built-in step the same way as a command that
+// exited with status 1.
+func stepFailed(err error) error {
+	ce := newError(exitChildFailed, "child_failed", err)
+	ce.childExit = 1
+	return ce
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Messages are written in English in the source and translated gettext
+// style: the English text, fmt verbs included, is the message id, looked
+// up in the catalog of the user's locale. A catalog is a YAML file
+// <locale>.yaml mapping ids to translations, such as
+//
+//	"Save this alias?": "Diesen Alias speichern?"
+//	"Alias %s exists. Overwrite it?": "Alias %s existiert. Überschreiben?"
+//
+// Catalogs ship in the binary, and packagers and users can add or override
+// them in the directories of localeDirs. Messages without a translation
+// are shown in English.
+
+//go:embed locale/*.yaml
+var builtinLocales embed.FS
+
+var (
+	catalogOnce sync.Once
+	catalog     map[string]string
+)
+
+// userLocale returns the locale messages are shown in, such as "pt_BR",
+// taken from LC_ALL, LC_MESSAGES or LANG. It's empty for English and the
+// C locale.
+func userLocale() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		v := os.Getenv(name)
+		if v == "" {
+			continue
+		}
+		v, _, _ = strings.Cut(v, ".")
+		v, _, _ = strings.Cut(v, "@")
+		if v == "C" || v == "POSIX" || v == "en" || strings.HasPrefix(v, "en_") {
+			return ""
+		}
+		return v
+	}
+	return ""
+}
+
+// localeDirs are searched for catalogs after the built-in ones, later
+// directories overriding earlier ones.
+func localeDirs() []string {
+	dirs := []string{"/usr/share/cmdex/locale", filepath.Join(filepath.Dir(configPath()), "locale")}
+	if dir := os.Getenv("CMDEX_LOCALE_DIR"); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// loadCatalog reads the catalogs of locale: those of its language, such
+// as pt, then those of the locale itself, such as pt_BR. Unreadable
+// catalogs are skipped.
+func loadCatalog(locale string) map[string]string {
+	names := []string{locale}
+	if lang, _, ok := strings.Cut(locale, "_"); ok {
+		names = []string{lang, locale}
+	}
+	c := make(map[string]string)
+	merge := func(data []byte) {
+		var m map[string]string
+		if yaml.Unmarshal(data, &m) == nil {
+			for id, s := range m {
+				c[id] = s
+			}
+		}
+	}
+	for _, name := range names {
+		if data, err := builtinLocales.ReadFile("locale/" + name + ".yaml"); err == nil {
+			merge(data)
+		}
+		for _, dir := range localeDirs() {
+			if data, err := os.ReadFile(filepath.Join(dir, name+".yaml")); err == nil {
+				merge(data)
+			}
+		}
+	}
+	return c
+}
+
+// tr returns the translation of msg for the user's locale.
+func tr(msg string) string {
+	catalogOnce.Do(func() {
+		if locale := userLocale(); locale != "" {
+			catalog = loadCatalog(locale)
+		}
+	})
+	if s, ok := catalog[msg]; ok && s != "" {
+		return s
+	}
+	return msg
+}
+
+// trf formats the translation of format.
+func trf(format string, args ...interface{}) string {
+	return fmt.Sprintf(tr(format), args...)
+}
+
+// errorf is fmt.Errorf with a translated format.
+func errorf(format string, args ...interface{}) error {
+	return fmt.Errorf(tr(format), args...)
+}
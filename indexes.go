@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// The index buckets let listings filter by tag and sort by modification
+// time or usage without decoding every alias. Their keys end in the alias
+// name and their values are empty; putAlias and deleteAlias keep them in
+// step with the commands bucket.
+var (
+	// idxTagsBucket is keyed by tag, NUL, alias.
+	idxTagsBucket = []byte("idx_tags")
+	// idxMtimeBucket is keyed by the modification time, then the alias.
+	idxMtimeBucket = []byte("idx_mtime")
+	// idxUsageBucket is keyed by the use count, the last-use time, then the
+	// alias.
+	idxUsageBucket = []byte("idx_usage")
+
+	indexBuckets = [][]byte{idxTagsBucket, idxMtimeBucket, idxUsageBucket}
+)
+
+// indexesKey marks in the meta bucket that the indexes are complete.
+// Databases from before the indexes, or whose key changed, lack it and
+// get their indexes rebuilt.
+var indexesKey = []byte("indexes")
+
+// tagIndexKey returns the prefix of tag's entries. In an encrypted
+// database tags are only kept as keyed hashes, since index keys can't be
+// sealed.
+func tagIndexKey(tag string) []byte {
+	if storeKey != nil {
+		mac := hmac.New(sha256.New, storeKey)
+		mac.Write([]byte("tag\x00" + tag))
+		tag = hex.EncodeToString(mac.Sum(nil)[:16])
+	}
+	return []byte(tag + "\x00")
+}
+
+// timeKey encodes t so that keys sort by time, with the zero time first.
+func timeKey(t time.Time) []byte {
+	b := make([]byte, 8)
+	if !t.IsZero() {
+		binary.BigEndian.PutUint64(b, uint64(t.UnixNano()))
+	}
+	return b
+}
+
+// indexKeys returns the index entries of a, by bucket.
+func indexKeys(a *Alias) map[string][][]byte {
+	keys := make(map[string][][]byte)
+	for _, tag := range a.Tags {
+		keys[string(idxTagsBucket)] = append(keys[string(idxTagsBucket)], append(tagIndexKey(tag), a.Name...))
+	}
+	mtime := append(timeKey(a.Modified), a.Name...)
+	keys[string(idxMtimeBucket)] = [][]byte{mtime}
+	usage := make([]byte, 8, 16+len(a.Name))
+	binary.BigEndian.PutUint64(usage, uint64(a.Uses))
+	usage = append(append(usage, timeKey(a.LastUsed)...), a.Name...)
+	keys[string(idxUsageBucket)] = [][]byte{usage}
+	return keys
+}
+
+// updateIndexes replaces the entries of old, if any, by those of a, or
+// just removes them when a is nil.
+func updateIndexes(tx *bolt.Tx, old, a *Alias) error {
+	if old != nil {
+		for name, keys := range indexKeys(old) {
+			b := tx.Bucket([]byte(name))
+			for _, k := range keys {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if a != nil {
+		for name, keys := range indexKeys(a) {
+			b := tx.Bucket([]byte(name))
+			for _, k := range keys {
+				if err := b.Put(k, nil); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// storedAlias decodes the stored record of name as it is, without loading
+// its script blob, or returns nil if there is none.
+func storedAlias(tx *bolt.Tx, name string) (*Alias, error) {
+	name = lookupKey(tx, name)
+	v := tx.Bucket(commandsBucket).Get([]byte(name))
+	if v == nil {
+		return nil, nil
+	}
+	v, err := decodeValue(name, v)
+	if err != nil {
+		return nil, err
+	}
+	a := &Alias{Name: name}
+	// Records predating JSON values have no tags or blob.
+	if bytes.HasPrefix(v, []byte("{")) {
+		json.Unmarshal(v, a)
+	}
+	return a, nil
+}
+
+// rebuildIndexes recreates the index buckets from the commands bucket, and
+// the output index from the recorded output.
+func rebuildIndexes(tx *bolt.Tx) error {
+	for _, name := range indexBuckets {
+		if tx.Bucket(name) != nil {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.CreateBucket(name); err != nil {
+			return err
+		}
+	}
+	err := tx.Bucket(commandsBucket).ForEach(func(k, v []byte) error {
+		a, err := storedAlias(tx, string(k))
+		if err != nil {
+			return err
+		}
+		return updateIndexes(tx, nil, a)
+	})
+	if err != nil {
+		return err
+	}
+	if err := rebuildOutputIndex(tx); err != nil {
+		return err
+	}
+	return tx.Bucket(metaBucket).Put(indexesKey, []byte("1"))
+}
+
+// indexesBuilt reports whether the indexes are complete.
+func indexesBuilt(tx *bolt.Tx) bool {
+	return tx.Bucket(metaBucket).Get(indexesKey) != nil
+}
+
+// taggedNames returns the aliases tagged tag, in name order.
+func taggedNames(tx *bolt.Tx, tag string) []string {
+	prefix := tagIndexKey(tag)
+	var names []string
+	c := tx.Bucket(idxTagsBucket).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		names = append(names, string(k[len(prefix):]))
+	}
+	return names
+}
+
+// indexedOrder returns the aliases in the order of list --sort key, most
+// recent or most used first, or nil if the key has no index.
+func indexedOrder(tx *bolt.Tx, key string) []string {
+	var (
+		bucket []byte
+		skip   int
+	)
+	switch key {
+	case "modified":
+		bucket, skip = idxMtimeBucket, 8
+	case "usage":
+		bucket, skip = idxUsageBucket, 16
+	default:
+		return nil
+	}
+	names := []string{}
+	c := tx.Bucket(bucket).Cursor()
+	for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+		names = append(names, string(k[skip:]))
+	}
+	return names
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// keyringService names the entries cmdex keeps in the OS keyring.
+const keyringService = "cmdex"
+
+// keyringGet reads the secret stored for account from the OS keyring:
+// the login keychain on macOS and the Secret Service (via secret-tool)
+// elsewhere.
+func keyringGet(account string) (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", account, "-w")
+	case "windows":
+		return "", fmt.Errorf("the OS keyring is not supported on windows")
+	default:
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", account)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", keyringError("reading", err, stderr.Bytes())
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// keyringSet stores secret for account in the OS keyring.
+func keyringSet(account, secret string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "add-generic-password", "-U", "-s", keyringService, "-a", account, "-w", secret)
+	case "windows":
+		return fmt.Errorf("the OS keyring is not supported on windows")
+	default:
+		cmd = exec.Command("secret-tool", "store", "--label=cmdex database key", "service", keyringService, "account", account)
+		cmd.Stdin = strings.NewReader(secret)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return keyringError("writing", err, out)
+	}
+	return nil
+}
+
+// keyringError describes a failed keyring tool, including what it printed.
+func keyringError(action string, err error, output []byte) error {
+	if msg := strings.TrimSpace(string(output)); msg != "" {
+		return fmt.Errorf("%s keyring: %v: %s", action, err, msg)
+	}
+	return fmt.Errorf("%s keyring: %w", action, err)
+}
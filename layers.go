@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"sort"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/yaml.v3"
+)
+
+// aliasLayer is a read-only file of aliases beneath the user's store, such
+// as one installed system-wide by an administrator or vendored into a
+// repository. Layers are read from, lowest precedence first:
+//
+//   - system: the files in /etc/cmdex/aliases.d (%ProgramData%\cmdex\aliases.d
+//     on Windows), in name order
+//   - config: the files and directories listed under layers in the config,
+//     in order
+//   - project: .cmdex/aliases.yaml and .cmdex/aliases.d in the project the
+//     current directory belongs to
+//
+// An alias in a higher layer shadows one of the same name below it, and the
+// store shadows every layer.
+type aliasLayer struct {
+	// kind is system, config or project.
+	kind    string
+	path    string
+	aliases map[string]*Alias
+}
+
+func (l *aliasLayer) String() string {
+	return l.kind + " layer " + l.path
+}
+
+// layerFile is the format of layer files: aliases by name, each written as
+// a spec.
+type layerFile struct {
+	Aliases map[string]*Alias `yaml:"aliases"`
+}
+
+var (
+	layersOnce sync.Once
+	// layers are the loaded layers, lowest precedence first.
+	layers []*aliasLayer
+)
+
+// systemLayerDir returns the directory of the system layers, honouring
+// CMDEX_SYSTEM_ALIASES when set.
+func systemLayerDir() string {
+	if dir := os.Getenv("CMDEX_SYSTEM_ALIASES"); dir != "" {
+		return dir
+	}
+	if goruntime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "cmdex", "aliases.d")
+	}
+	return "/etc/cmdex/aliases.d"
+}
+
+// layerFiles returns the YAML files in dir, in name order.
+func layerFiles(dir string) []string {
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// layerSource is a file that is a layer if it exists.
+type layerSource struct {
+	kind, path string
+}
+
+// layerSources returns the files the layers are read from, lowest
+// precedence first, and the directories whose YAML files are layers.
+func layerSources() (files []layerSource, dirs []string) {
+	system := systemLayerDir()
+	dirs = append(dirs, system)
+	for _, path := range layerFiles(system) {
+		files = append(files, layerSource{"system", path})
+	}
+	for _, path := range currentConfig().Layers {
+		path = expandHome(path)
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			dirs = append(dirs, path)
+			for _, file := range layerFiles(path) {
+				files = append(files, layerSource{"config", file})
+			}
+			continue
+		}
+		files = append(files, layerSource{"config", path})
+	}
+	if project := projectDir(); project != "" {
+		files = append(files, layerSource{"project", filepath.Join(project, ".cmdex", "aliases.yaml")})
+		dir := filepath.Join(project, ".cmdex", "aliases.d")
+		dirs = append(dirs, dir)
+		for _, path := range layerFiles(dir) {
+			files = append(files, layerSource{"project", path})
+		}
+	}
+	return files, dirs
+}
+
+// loadLayers reads the layers once. A layer file that can't be read is
+// reported and left out.
+func loadLayers() []*aliasLayer {
+	layersOnce.Do(func() {
+		layers = readLayers(nil, func(src layerSource, err error) {
+			printWarning("Warning: %s layer %s: %v", src.kind, src.path, err)
+		})
+	})
+	return layers
+}
+
+// readLayers reads the layer files, calling failed for those that can't
+// be read. Those are left out, or kept as they are in previous.
+func readLayers(previous []*aliasLayer, failed func(src layerSource, err error)) []*aliasLayer {
+	var read []*aliasLayer
+	files, _ := layerSources()
+	for _, src := range files {
+		l, err := readLayer(src.kind, src.path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			failed(src, err)
+			for _, old := range previous {
+				if old.path == src.path {
+					read = append(read, old)
+				}
+			}
+			continue
+		}
+		read = append(read, l)
+	}
+	return read
+}
+
+// readLayer reads the layer file at path.
+func readLayer(kind, path string) (*aliasLayer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseLayer(kind, path, data)
+}
+
+// parseLayer decodes data, the layer file at path.
+func parseLayer(kind, path string, data []byte) (*aliasLayer, error) {
+	var f layerFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	l := &aliasLayer{kind: kind, path: path, aliases: make(map[string]*Alias)}
+	for name, a := range f.Aliases {
+		if a == nil {
+			return nil, fmt.Errorf("alias %s: defines no command, script or steps", name)
+		}
+		if err := a.validate(); err != nil {
+			return nil, fmt.Errorf("alias %s: %w", name, err)
+		}
+		a.Name, a.layer = name, l
+		l.aliases[aliasKey(name)] = a
+	}
+	return l, nil
+}
+
+// layered returns the alias name resolves to in the layers, or nil if no
+// layer has it.
+func layered(name string) *Alias {
+	ls := loadLayers()
+	for i := len(ls) - 1; i >= 0; i-- {
+		if a, ok := ls[i].aliases[aliasKey(name)]; ok {
+			c := *a
+			return &c
+		}
+	}
+	return nil
+}
+
+// layersWith returns the layers that define name, highest precedence
+// first.
+func layersWith(name string) []*aliasLayer {
+	var found []*aliasLayer
+	ls := loadLayers()
+	for i := len(ls) - 1; i >= 0; i-- {
+		if _, ok := ls[i].aliases[aliasKey(name)]; ok {
+			found = append(found, ls[i])
+		}
+	}
+	return found
+}
+
+// layeredAliases returns the aliases of the layers the store doesn't
+// shadow, by name.
+func layeredAliases(tx *bolt.Tx) map[string]*Alias {
+	all := make(map[string]*Alias)
+	for _, l := range loadLayers() {
+		for key, a := range l.aliases {
+			if tx.Bucket(commandsBucket).Get([]byte(key)) == nil {
+				all[key] = a
+			}
+		}
+	}
+	return all
+}
+
+// getVariantOrLayered is getVariant falling back to the layers for aliases
+// not in the store.
+func getVariantOrLayered(tx *bolt.Tx, ref string) (*Alias, error) {
+	a, err := getVariant(tx, ref)
+	if !errors.Is(err, errAliasNotFound) {
+		return a, err
+	}
+	if a := layered(ref); a != nil {
+		return a, nil
+	}
+	name, variant := splitVariant(ref)
+	if a := layered(name); a != nil && variant != "" {
+		return a.withVariant(variant)
+	}
+	return nil, err
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits caps the resources of the processes an alias runs.
+type Limits struct {
+	// MaxMem is the largest address space, such as 512M or 2G.
+	MaxMem string `json:"max_mem,omitempty" yaml:"max_mem,omitempty"`
+	// MaxCPU is the CPU time allowed, such as 30s or 5m.
+	MaxCPU   string `json:"max_cpu,omitempty" yaml:"max_cpu,omitempty"`
+	MaxFiles int    `json:"max_files,omitempty" yaml:"max_files,omitempty"`
+	// Nice lowers the scheduling priority, from 0 to 19.
+	Nice int `json:"nice,omitempty" yaml:"nice,omitempty"`
+}
+
+// empty reports whether l limits nothing.
+func (l *Limits) empty() bool {
+	return l == nil || *l == Limits{}
+}
+
+// merge returns l with the limits set in override replacing its own.
+func (l *Limits) merge(override Limits) *Limits {
+	var m Limits
+	if l != nil {
+		m = *l
+	}
+	if override.MaxMem != "" {
+		m.MaxMem = override.MaxMem
+	}
+	if override.MaxCPU != "" {
+		m.MaxCPU = override.MaxCPU
+	}
+	if override.MaxFiles != 0 {
+		m.MaxFiles = override.MaxFiles
+	}
+	if override.Nice != 0 {
+		m.Nice = override.Nice
+	}
+	return &m
+}
+
+// validate checks that every limit can be parsed.
+func (l *Limits) validate() error {
+	if l == nil {
+		return nil
+	}
+	if _, err := l.memBytes(); err != nil {
+		return err
+	}
+	if _, err := l.cpuSeconds(); err != nil {
+		return err
+	}
+	if l.MaxFiles < 0 {
+		return fmt.Errorf("invalid max files %d", l.MaxFiles)
+	}
+	if l.Nice < 0 || l.Nice > 19 {
+		return fmt.Errorf("invalid nice %d (use 0 to 19)", l.Nice)
+	}
+	return nil
+}
+
+// memBytes returns MaxMem in bytes, 0 when unset.
+func (l *Limits) memBytes() (uint64, error) {
+	if l.MaxMem == "" {
+		return 0, nil
+	}
+	n, err := parseBytes(l.MaxMem)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory limit %q (use e.g. 512M or 2G)", l.MaxMem)
+	}
+	return n, nil
+}
+
+// parseBytes parses a size such as 512M or 2G into bytes.
+func parseBytes(size string) (uint64, error) {
+	s := strings.ToUpper(strings.TrimSuffix(strings.ToUpper(size), "B"))
+	mult := uint64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			mult, s = 1<<10, s[:n-1]
+		case 'M':
+			mult, s = 1<<20, s[:n-1]
+		case 'G':
+			mult, s = 1<<30, s[:n-1]
+		case 'T':
+			mult, s = 1<<40, s[:n-1]
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid size %q (use e.g. 512M or 2G)", size)
+	}
+	return n * mult, nil
+}
+
+// cpuSeconds returns MaxCPU in whole seconds, 0 when unset.
+func (l *Limits) cpuSeconds() (uint64, error) {
+	if l.MaxCPU == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(l.MaxCPU)
+	if err != nil || d < time.Second {
+		return 0, fmt.Errorf("invalid CPU limit %q (use e.g. 30s or 5m)", l.MaxCPU)
+	}
+	return uint64(d / time.Second), nil
+}
+
+// String renders l for show.
+func (l *Limits) String() string {
+	var parts []string
+	if l.MaxMem != "" {
+		parts = append(parts, "memory "+l.MaxMem)
+	}
+	if l.MaxCPU != "" {
+		parts = append(parts, "cpu "+l.MaxCPU)
+	}
+	if l.MaxFiles != 0 {
+		parts = append(parts, fmt.Sprintf("%d files", l.MaxFiles))
+	}
+	if l.Nice != 0 {
+		parts = append(parts, fmt.Sprintf("nice %d", l.Nice))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// applyLimits sets the limits on the running process pid. Processes it
+// starts afterwards inherit them.
+func applyLimits(pid int, l *Limits) error {
+	mem, err := l.memBytes()
+	if err != nil {
+		return err
+	}
+	cpu, err := l.cpuSeconds()
+	if err != nil {
+		return err
+	}
+	set := func(resource int, v uint64) error {
+		if v == 0 {
+			return nil
+		}
+		return unix.Prlimit(pid, resource, &unix.Rlimit{Cur: v, Max: v}, nil)
+	}
+	if err := set(unix.RLIMIT_AS, mem); err != nil {
+		return err
+	}
+	if err := set(unix.RLIMIT_CPU, cpu); err != nil {
+		return err
+	}
+	if err := set(unix.RLIMIT_NOFILE, uint64(l.MaxFiles)); err != nil {
+		return err
+	}
+	if l.Nice != 0 {
+		return unix.Setpriority(unix.PRIO_PROCESS, pid, l.Nice)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// applyLimits is only implemented on Linux.
+func applyLimits(pid int, l *Limits) error {
+	return fmt.Errorf("resource limits are only supported on Linux")
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Lint severities, from least to most serious. "off" disables a rule.
+var severities = map[string]int{"off": -1, "info": 0, "warning": 1, "error": 2}
+
+// lintFinding is one problem found in an alias.
+type lintFinding struct {
+	rule     string
+	severity string
+	message  string
+	// fix repairs the problem in a, if it can be fixed automatically.
+	fix func(tx *bolt.Tx, a *Alias) error
+	// suggestion, when set, is what to give cmdex save instead of the
+	// alias's command to fix the problem.
+	suggestion string
+}
+
+// lintRule checks aliases for one kind of problem.
+type lintRule struct {
+	id       string
+	severity string
+	summary  string
+	check    func(a *Alias) []lintFinding
+}
+
+var lintRules = []lintRule{
+	{"hardcoded-secret", "error", "passwords, tokens and keys written into the command (fix: move them to a variable)", lintSecrets},
+	{"user-path", "warning", "absolute paths into a user's home directory (fix: use ~ in the directory)", lintUserPaths},
+	{"unpinned-image", "warning", "container images without a tag or digest, or tagged latest", lintImages},
+	{"destructive-no-confirm", "warning", "destructive commands that run without confirmation (fix: enable confirm)", lintDestructive},
+	{"missing-binary", "warning", "programs the alias runs that aren't on PATH, as after an uninstall or a version switch", lintMissingBinaries},
+	{"quoting", "warning", "quotes, variables, globs and shell operators in commands, which run without a shell (fix: unquote them or run them with sh)", lintQuoting},
+	{"missing-description", "info", "aliases without a description", lintDescription},
+}
+
+// lintText returns every piece of an alias that can run or be sent.
+func lintText(a *Alias) []string {
+	texts := []string{a.Command, a.Script}
+	for _, key := range a.platformKeys() {
+		texts = append(texts, a.Platforms[key])
+	}
+	for _, step := range a.Steps {
+		texts = append(texts, step.templates()...)
+	}
+	return texts
+}
+
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(?:password|passwd|secret|token|api[_-]?key|access[_-]?key)[=:]\s*['"]?([^\s'"{}]{6,})`),
+	regexp.MustCompile(`(?i)bearer\s+([A-Za-z0-9._~+/=-]{20,})`),
+	regexp.MustCompile(`\b(AKIA[0-9A-Z]{16})\b`),
+	regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,})\b`),
+	regexp.MustCompile(`\b(xox[abprs]-[A-Za-z0-9-]{10,})\b`),
+}
+
+func lintSecrets(a *Alias) []lintFinding {
+	var findings []lintFinding
+	seen := make(map[string]bool)
+	for _, text := range lintText(a) {
+		for _, re := range secretPatterns {
+			for _, m := range re.FindAllStringSubmatch(text, -1) {
+				secret := m[1]
+				if seen[secret] {
+					continue
+				}
+				seen[secret] = true
+				findings = append(findings, lintFinding{
+					message: fmt.Sprintf("looks like a hardcoded secret: %s", maskSecret(secret)),
+					fix: func(tx *bolt.Tx, a *Alias) error {
+						name := secretVarName(tx, a.Name)
+						if err := putVar(tx, name, secret); err != nil {
+							return err
+						}
+						replaceText(a, secret, fmt.Sprintf(`{{var "%s"}}`, name))
+						return nil
+					},
+				})
+			}
+		}
+	}
+	return findings
+}
+
+// maskSecret shows just enough of a secret to recognise it.
+func maskSecret(s string) string {
+	if len(s) <= 8 {
+		return strings.Repeat("*", len(s))
+	}
+	return s[:4] + strings.Repeat("*", len(s)-4)
+}
+
+// secretVarName picks an unused variable name for a secret of alias.
+func secretVarName(tx *bolt.Tx, alias string) string {
+	base := strings.ToUpper(regexp.MustCompile(`[^A-Za-z0-9]+`).ReplaceAllString(alias, "_")) + "_SECRET"
+	name := base
+	for i := 2; ; i++ {
+		if _, ok, _ := getVar(tx, name); !ok {
+			return name
+		}
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+}
+
+// replaceText replaces old with new everywhere in the alias body.
+func replaceText(a *Alias, old, new string) {
+	a.Command = strings.ReplaceAll(a.Command, old, new)
+	a.Script = strings.ReplaceAll(a.Script, old, new)
+	for key, v := range a.Platforms {
+		a.Platforms[key] = strings.ReplaceAll(v, old, new)
+	}
+	for i := range a.Steps {
+		step := &a.Steps[i]
+		step.Run = strings.ReplaceAll(step.Run, old, new)
+		if h := step.HTTP; h != nil {
+			h.URL = strings.ReplaceAll(h.URL, old, new)
+			h.Body = strings.ReplaceAll(h.Body, old, new)
+			for k, v := range h.Headers {
+				h.Headers[k] = strings.ReplaceAll(v, old, new)
+			}
+		}
+		if w := step.WriteFile; w != nil {
+			w.Path = strings.ReplaceAll(w.Path, old, new)
+			w.Content = strings.ReplaceAll(w.Content, old, new)
+		}
+		if w := step.WaitFor; w != nil {
+			w.Run = strings.ReplaceAll(w.Run, old, new)
+			w.TCP = strings.ReplaceAll(w.TCP, old, new)
+			w.HTTP = strings.ReplaceAll(w.HTTP, old, new)
+		}
+	}
+}
+
+var userPathPattern = regexp.MustCompile(`(?:/home|/Users)/[^/\s"']+`)
+
+func lintUserPaths(a *Alias) []lintFinding {
+	var findings []lintFinding
+	if home := userPathPattern.FindString(a.Dir); home != "" {
+		findings = append(findings, lintFinding{
+			message: fmt.Sprintf("directory %s is specific to one user", a.Dir),
+			fix: func(tx *bolt.Tx, a *Alias) error {
+				a.Dir = "~" + strings.TrimPrefix(a.Dir, home)
+				return nil
+			},
+		})
+	}
+	for _, text := range lintText(a) {
+		for _, path := range userPathPattern.FindAllString(text, -1) {
+			findings = append(findings, lintFinding{message: fmt.Sprintf("command uses the user-specific path %s", path)})
+		}
+	}
+	return findings
+}
+
+// containerValueFlags are docker/podman run flags that take a separate value.
+var containerValueFlags = map[string]bool{
+	"-e": true, "--env": true, "-v": true, "--volume": true, "-p": true, "--publish": true,
+	"--name": true, "-w": true, "--workdir": true, "-u": true, "--user": true, "--network": true,
+	"--entrypoint": true, "--mount": true, "--platform": true, "-l": true, "--label": true,
+	"--env-file": true, "-h": true, "--hostname": true,
+}
+
+func lintImages(a *Alias) []lintFinding {
+	var findings []lintFinding
+	for _, text := range lintText(a) {
+		for _, line := range strings.Split(text, "\n") {
+			words := strings.Fields(line)
+			for i := 0; i+1 < len(words); i++ {
+				if words[i] != "docker" && words[i] != "podman" {
+					continue
+				}
+				switch words[i+1] {
+				case "run", "pull", "create":
+				default:
+					continue
+				}
+				image := ""
+				for j := i + 2; j < len(words); j++ {
+					w := words[j]
+					if strings.HasPrefix(w, "-") {
+						if containerValueFlags[w] {
+							j++
+						}
+						continue
+					}
+					image = w
+					break
+				}
+				if image == "" || strings.Contains(image, "{{") || strings.Contains(image, "$") || strings.Contains(image, "@sha256:") {
+					continue
+				}
+				name := image[strings.LastIndex(image, "/")+1:]
+				_, tag, tagged := strings.Cut(name, ":")
+				if !tagged || tag == "latest" {
+					findings = append(findings, lintFinding{message: fmt.Sprintf("image %s is not pinned to a version", image)})
+				}
+			}
+		}
+	}
+	return findings
+}
+
+var destructivePattern = regexp.MustCompile(`(?i)\brm\s+-\w*(rf|fr)\w*\b|\bmkfs\b|\bdd\s+if=|\bdrop\s+(table|database)\b|\bkubectl\s+delete\b|\bterraform\s+destroy\b|\bgit\s+push\s+.*--force\b`)
+
+func lintDestructive(a *Alias) []lintFinding {
+	if a.Confirm {
+		return nil
+	}
+	for _, text := range lintText(a) {
+		if m := destructivePattern.FindString(text); m != "" {
+			return []lintFinding{{
+				message: fmt.Sprintf("runs %q without asking for confirmation", m),
+				fix: func(tx *bolt.Tx, a *Alias) error {
+					a.Confirm = true
+					return nil
+				},
+			}}
+		}
+	}
+	return nil
+}
+
+func lintDescription(a *Alias) []lintFinding {
+	if a.Description != "" || a.Deprecated != nil {
+		return nil
+	}
+	return []lintFinding{{message: "has no description"}}
+}
+
+// lintAlias runs every enabled rule against a, with severities taken from
+// the lint section of the config file where set.
+func lintAlias(a *Alias) []lintFinding {
+	var findings []lintFinding
+	for _, rule := range lintRules {
+		severity := rule.severity
+		if s, ok := cfg.Lint.Rules[rule.id]; ok {
+			severity = s
+		}
+		if severity == "off" {
+			continue
+		}
+		for _, f := range rule.check(a) {
+			f.rule, f.severity = rule.id, severity
+			findings = append(findings, f)
+		}
+	}
+	return findings
+}
+
+func lintCmd() *cobra.Command {
+	var (
+		fix       bool
+		listRules bool
+		minimum   string
+	)
+	cmd := &cobra.Command{
+		Use:   "lint [alias...]",
+		Short: "Check aliases for common problems",
+		Long: `lint checks the given aliases, or all of them, against a set of rules and
+reports what it finds. Rule severities can be changed, or rules turned off,
+in the config file:
+
+  lint:
+    rules:
+      missing-description: off
+      unpinned-image: error
+
+--fix repairs what can be repaired automatically. lint exits with an error
+when it finds problems of severity error.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if listRules {
+				var t table
+				for _, rule := range lintRules {
+					severity := rule.severity
+					if s, ok := cfg.Lint.Rules[rule.id]; ok {
+						severity = s
+					}
+					t.add(rule.id, severity, rule.summary)
+				}
+				printLines(t.lines(0))
+				return nil
+			}
+			if _, ok := severities[minimum]; !ok || minimum == "off" {
+				return usageError(fmt.Errorf("invalid --severity %q (use info, warning or error)", minimum))
+			}
+			for id, s := range cfg.Lint.Rules {
+				if _, ok := severities[s]; !ok {
+					return fmt.Errorf("config: lint rule %s: invalid severity %q", id, s)
+				}
+			}
+
+			var aliases []*Alias
+			err := db.View(func(tx *bolt.Tx) error {
+				if len(args) > 0 {
+					for _, name := range args {
+						a, err := getAlias(tx, name)
+						if err != nil {
+							return fmt.Errorf("%s: %w", name, err)
+						}
+						aliases = append(aliases, a)
+					}
+					return nil
+				}
+				return forEachAlias(tx, func(a *Alias) error {
+					aliases = append(aliases, a)
+					return nil
+				})
+			})
+			if err != nil {
+				return fmt.Errorf("linting: %w", err)
+			}
+
+			var (
+				t                 table
+				errorCount, fixed int
+				fixable           = make(map[string][]lintFinding)
+				fixableAliases    []string
+			)
+			t.color(0, "alias")
+			for _, a := range aliases {
+				for _, f := range lintAlias(a) {
+					if severities[f.severity] < severities[minimum] {
+						continue
+					}
+					if f.severity == "error" {
+						errorCount++
+					}
+					note := ""
+					if f.fix != nil {
+						note = " (fixable)"
+						if len(fixable[a.Name]) == 0 {
+							fixableAliases = append(fixableAliases, a.Name)
+						}
+						fixable[a.Name] = append(fixable[a.Name], f)
+					}
+					t.add(a.Name, f.severity, f.rule, f.message+note)
+				}
+			}
+			printLines(t.lines(0))
+
+			if fix && len(fixable) > 0 {
+				sort.Strings(fixableAliases)
+				err := db.Update(func(tx *bolt.Tx) error {
+					for _, name := range fixableAliases {
+						a, err := getAlias(tx, name)
+						if err != nil {
+							return err
+						}
+						for _, f := range fixable[name] {
+							if err := f.fix(tx, a); err != nil {
+								return fmt.Errorf("%s: %s: %w", name, f.rule, err)
+							}
+							fixed++
+							if f.severity == "error" {
+								errorCount--
+							}
+						}
+						a.Modified = time.Now()
+						if err := putAlias(tx, a); err != nil {
+							return err
+						}
+						if err := writeAudit(tx, auditEntry{Action: "edit", Target: name, Detail: "lint --fix"}); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+				if err != nil {
+					return fmt.Errorf("fixing: %w", err)
+				}
+				fmt.Printf("Fixed %d problems\n", fixed)
+			}
+			if errorCount > 0 {
+				return fmt.Errorf("lint found %d problems of severity error", errorCount)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&fix, "fix", false, "Repair the problems that can be fixed automatically")
+	cmd.Flags().BoolVar(&listRules, "rules", false, "List the rules and their severities")
+	cmd.Flags().StringVar(&minimum, "severity", "info", "Only report problems at least this severe")
+	return cmd
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	bolt "go.etcd.io/bbolt"
+)
+
+// aliasLess orders aliases for each supported list --sort key. Time and
+// usage orderings put the most recent or most used alias first.
+var aliasLess = map[string]func(a, b *Alias) bool{
+	"name":     func(a, b *Alias) bool { return a.Name < b.Name },
+	"created":  func(a, b *Alias) bool { return a.Created.After(b.Created) },
+	"modified": func(a, b *Alias) bool { return a.Modified.After(b.Modified) },
+	"usage": func(a, b *Alias) bool {
+		if a.Uses != b.Uses {
+			return a.Uses > b.Uses
+		}
+		return a.LastUsed.After(b.LastUsed)
+	},
+}
+
+// aliasColumns are the values list --output csv can write.
+var aliasColumns = map[string]func(a *Alias) string{
+	"name":        func(a *Alias) string { return a.Name },
+	"tags":        func(a *Alias) string { return strings.Join(a.Tags, ",") },
+	"description": func(a *Alias) string { return a.Description },
+	"command":     func(a *Alias) string { return a.body() },
+	"created":     func(a *Alias) string { return formatTime(a.Created) },
+	"modified":    func(a *Alias) string { return formatTime(a.Modified) },
+	"uses":        func(a *Alias) string { return strconv.Itoa(a.Uses) },
+	"last_used":   func(a *Alias) string { return formatTime(a.LastUsed) },
+	"owner":       func(a *Alias) string { return a.Owner },
+	"team":        func(a *Alias) string { return a.Team },
+}
+
+var aliasColumnNames = []string{"name", "tags", "description", "command", "created", "modified", "uses", "last_used", "owner", "team"}
+
+// aliasQuery selects and orders aliases for list and the daemon's listing
+// endpoints.
+type aliasQuery struct {
+	// sortBy is a key of aliasLess.
+	sortBy  string
+	reverse bool
+	tag     string
+	// filter keeps aliases whose name or command contains it.
+	filter string
+	// after is a pagination cursor: the listing resumes after this alias.
+	after string
+	limit int
+}
+
+// each calls fn for the aliases q selects, in order. Where the order comes
+// from the store's key order or an index, aliases are read one at a time
+// and only as far as the limit. more reports whether the limit cut the
+// listing short.
+func (q *aliasQuery) each(tx *bolt.Tx, fn func(a *Alias) error) (more bool, err error) {
+	less, ok := aliasLess[q.sortBy]
+	if !ok {
+		return false, usageError(fmt.Errorf("unknown sort key %q (use name, created, modified or usage)", q.sortBy))
+	}
+	var tagged map[string]bool
+	if q.tag != "" {
+		tagged = make(map[string]bool)
+		for _, name := range taggedNames(tx, q.tag) {
+			tagged[name] = true
+		}
+	}
+	// Aliases from layers the store doesn't shadow are listed too; those
+	// have no usage or times, so they sort last except by name.
+	layered := layeredAliases(tx)
+	for name, a := range layered {
+		if tagged != nil && contains(a.Tags, q.tag) {
+			tagged[name] = true
+		}
+	}
+	after, nameFilter := aliasKey(q.after), aliasKey(q.filter)
+	keep := func(a *Alias) bool {
+		return (tagged == nil || tagged[a.Name]) &&
+			(q.filter == "" || strings.Contains(a.Name, nameFilter) || strings.Contains(a.Script+a.allCommands(), q.filter))
+	}
+
+	// Collect the names in order without decoding the aliases, unless
+	// the order has no index.
+	var names []string
+	switch order := indexedOrder(tx, q.sortBy); {
+	case order != nil:
+		names = order
+	case q.sortBy == "name":
+		c := tx.Bucket(commandsBucket).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			names = append(names, string(k))
+		}
+	default:
+		var aliases []*Alias
+		err := forEachAlias(tx, func(a *Alias) error {
+			if keep(a) {
+				aliases = append(aliases, a)
+			}
+			return nil
+		})
+		if err != nil {
+			return false, err
+		}
+		sort.SliceStable(aliases, func(i, j int) bool { return less(aliases[i], aliases[j]) })
+		for _, a := range aliases {
+			names = append(names, a.Name)
+		}
+	}
+	if len(layered) > 0 {
+		var extra []string
+		for name := range layered {
+			extra = append(extra, name)
+		}
+		sort.Strings(extra)
+		names = append(names, extra...)
+		if q.sortBy == "name" {
+			sort.Strings(names)
+		}
+	}
+	if q.reverse {
+		for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+			names[i], names[j] = names[j], names[i]
+		}
+	}
+	if q.after != "" {
+		i := -1
+		for j, name := range names {
+			if name == after {
+				i = j
+				break
+			}
+		}
+		if i < 0 && q.sortBy == "name" {
+			// The cursor alias may have been removed since; resume where
+			// it would have been.
+			i = sort.Search(len(names), func(j int) bool {
+				if q.reverse {
+					return names[j] < after
+				}
+				return names[j] > after
+			}) - 1
+		} else if i < 0 {
+			return false, usageError(fmt.Errorf("no alias %s to list after", q.after))
+		}
+		names = names[i+1:]
+	}
+
+	n := 0
+	for _, name := range names {
+		if tagged != nil && !tagged[name] {
+			continue
+		}
+		a, ok := layered[name]
+		if !ok {
+			var err error
+			if a, err = getAlias(tx, name); err != nil {
+				return false, err
+			}
+		}
+		if !keep(a) {
+			continue
+		}
+		if q.limit > 0 && n == q.limit {
+			return true, nil
+		}
+		n++
+		if err := fn(a); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// formatTime formats t for machine-readable output, leaving unset times
+// empty.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func listCmd() *cobra.Command {
+	var (
+		output  string
+		columns []string
+		full    bool
+		sortBy  string
+		reverse bool
+		filter  string
+		tag     string
+		after   string
+		limit   int
+		tree    bool
+		depth   int
+	)
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List all saved aliases and their associated commands",
+		Long: `list prints the saved aliases and their commands, sorted and filtered by
+the flags below, as a table or in the format --output names.
+
+With --tree, aliases are grouped by the namespaces in their names, which
+are separated by /, : or ., as in k8s/prod/deploy or docker:build. Each
+namespace shows how many aliases it holds; --depth N collapses the
+namespaces below the Nth level into those counts, and implies --tree.`,
+		Annotations: readDB,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			columns, err := checkOutput(output, []string{"json", "launcher-json"}, columns, aliasColumnNames, aliasColumnNames[:8])
+			if err != nil {
+				return err
+			}
+			if (tree || depth != 0) && output != "table" {
+				return usageError(fmt.Errorf("--tree and --depth only apply to table output"))
+			}
+			if depth < 0 {
+				return usageError(fmt.Errorf("invalid --depth %d", depth))
+			}
+			q := aliasQuery{sortBy: sortBy, reverse: reverse, tag: tag, filter: filter, after: after, limit: limit}
+
+			// Delimited output is written as the aliases are read.
+			if output == "csv" || output == "tsv" {
+				w := csv.NewWriter(os.Stdout)
+				if output == "tsv" {
+					w.Comma = '\t'
+				}
+				w.Write(columns)
+				err := db.View(func(tx *bolt.Tx) error {
+					_, err := q.each(tx, func(a *Alias) error {
+						row := make([]string, len(columns))
+						for i, c := range columns {
+							row[i] = aliasColumns[c](a)
+						}
+						return w.Write(row)
+					})
+					return err
+				})
+				w.Flush()
+				if err != nil {
+					return fmt.Errorf("listing commands: %w", err)
+				}
+				return w.Error()
+			}
+
+			var aliases []*Alias
+			err = db.View(func(tx *bolt.Tx) error {
+				_, err := q.each(tx, func(a *Alias) error {
+					aliases = append(aliases, a)
+					return nil
+				})
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("listing commands: %w", err)
+			}
+
+			switch output {
+			case "json":
+				items := make([]aliasJSON, len(aliases))
+				for i, a := range aliases {
+					items[i] = aliasJSON{Name: a.Name, Alias: a}
+				}
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(items)
+			case "launcher-json":
+				return writeLauncherItems(aliases)
+			}
+			width := 0
+			if w, _, ok := terminalSize(); ok && !full {
+				width = w
+			}
+			if tree || depth > 0 {
+				printLines(treeLines(buildTree(aliases), depth, width))
+				return nil
+			}
+			// Only spend columns on tags and descriptions if any are set.
+			var withTags, withDesc bool
+			for _, a := range aliases {
+				withTags = withTags || len(a.Tags) > 0
+				withDesc = withDesc || a.Description != ""
+			}
+			var t table
+			t.color(0, "alias")
+			if withTags {
+				t.color(1, "tag")
+			}
+			for _, a := range aliases {
+				row := []string{a.Name}
+				if withTags {
+					row = append(row, strings.Join(a.Tags, ","))
+				}
+				if withDesc {
+					row = append(row, a.Description)
+				}
+				t.add(append(row, a.summary())...)
+			}
+			printLines(t.lines(width))
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&full, "full", false, "Do not truncate long commands to the terminal width")
+	cmd.Flags().StringVar(&sortBy, "sort", "name", "Sort by name, created, modified or usage")
+	cmd.Flags().BoolVar(&reverse, "reverse", false, "Reverse the sort order")
+	cmd.Flags().StringVar(&filter, "filter", "", "Only list aliases whose name or command contains this substring")
+	cmd.Flags().StringVarP(&tag, "tag", "t", "", "Only list aliases with this tag")
+	cmd.Flags().IntVar(&limit, "limit", 0, "Show at most this many aliases")
+	cmd.Flags().StringVar(&after, "after", "", "Start the listing after this alias, to page through it with --limit")
+	cmd.Flags().BoolVar(&tree, "tree", false, "Show the aliases as a tree of their namespaces")
+	cmd.Flags().IntVar(&depth, "depth", 0, "With --tree, collapse namespaces below this many levels")
+	cmd.Flags().StringVarP(&output, "output", "o", "table", "Output format: table, csv, tsv, json or launcher-json (an Alfred/Raycast script filter)")
+	cmd.Flags().StringSliceVar(&columns, "columns", nil, "Columns for csv and tsv output: "+strings.Join(aliasColumnNames, ", "))
+	// Launchers are set up with --format, as export is.
+	cmd.Flags().SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		if name == "format" {
+			name = "output"
+		}
+		return pflag.NormalizedName(name)
+	})
+	return cmd
+}
+
+// launcherItem is an entry of an Alfred script filter, which Raycast and
+// similar launchers read as well.
+type launcherItem struct {
+	UID          string            `json:"uid"`
+	Title        string            `json:"title"`
+	Subtitle     string            `json:"subtitle"`
+	Arg          string            `json:"arg"`
+	Autocomplete string            `json:"autocomplete"`
+	Match        string            `json:"match,omitempty"`
+	Text         map[string]string `json:"text,omitempty"`
+}
+
+// writeLauncherItems writes aliases as a script filter. Selecting an item
+// passes the alias name on, for the launcher to run with cmdex run
+// --launcher.
+func writeLauncherItems(aliases []*Alias) error {
+	items := make([]launcherItem, 0, len(aliases))
+	for _, a := range aliases {
+		subtitle := a.Description
+		if subtitle == "" {
+			subtitle = a.summary()
+		}
+		items = append(items, launcherItem{
+			UID:          a.Name,
+			Title:        a.Name,
+			Subtitle:     subtitle,
+			Arg:          a.Name,
+			Autocomplete: a.Name,
+			Match:        strings.Join(strings.Fields(a.Name+" "+a.Description+" "+strings.Join(a.Tags, " ")), " "),
+			Text:         map[string]string{"copy": a.body(), "largetype": a.body()},
+		})
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]interface{}{"items": items})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockPath returns the lock file of the alias name. Locks are per user:
+// they keep one user's runs of an alias from interleaving.
+func lockPath(name string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "cmdex", "locks", url.PathEscape(name)+".lock"), nil
+}
+
+// lockedError refuses a run of an alias that is already running.
+type lockedError struct {
+	alias string
+	// pid and since describe the run holding the lock, if known.
+	pid   int
+	since time.Time
+}
+
+func (e *lockedError) Error() string {
+	if e.pid == 0 {
+		return fmt.Sprintf("%s is already running", e.alias)
+	}
+	return fmt.Sprintf("%s is already running (pid %d, since %s)", e.alias, e.pid, formatTime(e.since))
+}
+
+// lockHolder reads the pid and start time the holder of f wrote into it.
+func lockHolder(f *os.File) (int, time.Time) {
+	buf := make([]byte, 64)
+	n, _ := f.ReadAt(buf, 0)
+	pid, since, _ := strings.Cut(strings.TrimSpace(string(buf[:n])), " ")
+	p, _ := strconv.Atoi(pid)
+	t, _ := time.Parse(time.RFC3339, since)
+	return p, t
+}
+
+// lockAlias takes the lock of the alias name, for aliases that run one at
+// a time, and returns the function releasing it. If another process holds
+// the lock, lockAlias fails, or with wait waits until ctx is done for the
+// lock to be released.
+func lockAlias(ctx context.Context, name string, wait bool) (func(), error) {
+	path, err := lockPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("locking %s: %w", name, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("locking %s: %w", name, err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("locking %s: %w", name, err)
+	}
+	for waiting := false; ; {
+		ok, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("locking %s: %w", name, err)
+		}
+		if ok {
+			break
+		}
+		pid, since := lockHolder(f)
+		if !wait {
+			f.Close()
+			return nil, newError(exitAliasLocked, "locked", &lockedError{name, pid, since})
+		}
+		if !waiting {
+			banner(os.Stderr, verbosityNormal, fmt.Sprintf("Waiting for %s (pid %d) to finish", name, pid))
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, childError(ctx, ctx.Err())
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	f.Truncate(0)
+	f.WriteAt([]byte(fmt.Sprintf("%d %s\n", os.Getpid(), time.Now().Format(time.RFC3339))), 0)
+	return func() {
+		f.Truncate(0)
+		unlock(f)
+		f.Close()
+	}, nil
+}
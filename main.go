@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"os"
-	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	bolt "go.etcd.io/bbolt"
@@ -13,158 +18,502 @@ import (
 
 var db *bolt.DB
 
+// noDB annotates commands that must not open the alias database.
+var noDB = map[string]string{"db": "none"}
+
+// readDB marks commands that only read the database, apart from
+// bookkeeping queued with deferWrite and writes preceded by writableDB.
+var readDB = map[string]string{"db": "read"}
+
 func main() {
-	var err error
-	db, err = bolt.Open("cmdex.db", 0600, nil)
-	if err != nil {
-		log.Fatal(err)
+	// SIGTERM cancels what runs, which stops the commands and records the
+	// runs as canceled. Ctrl-C reaches the commands of the terminal's
+	// process group directly and is left to them.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	cmd, err := executeGuarded(ctx, newRootCmd())
+	stop()
+	// A damaged database is left alone once it failed.
+	var corrupt *corruptDBError
+	if !errors.As(err, &corrupt) {
+		flushWrites()
+		closeDB()
 	}
-	defer db.Close()
-
-	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte("commands"))
-		return err
-	})
 	if err != nil {
-		log.Fatal(err)
+		os.Exit(reportError(cmd, err))
 	}
+}
 
+// newRootCmd builds the cmdex command tree.
+func newRootCmd() *cobra.Command {
 	var rootCmd = &cobra.Command{
 		Use:   "cmdex",
 		Short: "A CLI tool to store and execute custom commands",
-		Long:  `cmdex allows users to store and execute custom commands or multi-step command sequences using short, memorable aliases.`,
-		Run: func(cmd *cobra.Command, args []string) {
+		// Bare "cmdex <alias>" runs the alias.
+		Annotations: readDB,
+		Long:        `cmdex allows users to store and execute custom commands or multi-step command sequences using short, memorable aliases.`,
+		Args:        cobra.ArbitraryArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if errorFormat != "text" && errorFormat != "json" {
+				return usageError(fmt.Errorf("invalid --error-format %q (use text or json)", errorFormat))
+			}
+			if err := setVerbosity(); err != nil {
+				return err
+			}
+			if err := loadConfig(); err != nil {
+				printError("Error reading config file: %v", err)
+			}
+			loadPolicy()
+			var err error
+			switch cmd.Annotations["db"] {
+			case "none":
+				return nil
+			case "read":
+				err = openReadOnlyDB()
+			default:
+				err = openDB()
+			}
+			if err != nil {
+				if err = offerRecovery(err); err == nil {
+					err = openStore(cmd.Annotations["db"] == "read")
+				}
+			}
+			if err == nil && cmd.Annotations["db"] != "migrate" {
+				warnOutdatedSchema()
+			}
+			return err
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) > 0 {
-				runCommand(args[0], args[1:])
-			} else {
-				cmd.Help()
+				return runCommand(cmd.Context(), args[0], args[1:], runOptions{})
 			}
+			return cmd.Help()
 		},
+		ValidArgsFunction: completeAliasArgs,
+		SilenceErrors:     true,
+		SilenceUsage:      true,
 	}
 
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "Disable colored output")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Print only the output of the commands run, and errors")
+	rootCmd.PersistentFlags().CountVarP(&verbose, "verbose", "v", "Also print the expanded commands and timings (-vv: working directories, sandboxing and limits too)")
+	rootCmd.PersistentFlags().StringVar(&errorFormat, "error-format", "text", "Format of error reports on stderr: text or json")
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return usageError(err)
+	})
+	rootCmd.SetHelpCommand(helpCmd(rootCmd))
+
+	rootCmd.AddCommand(newCmd())
 	rootCmd.AddCommand(saveCmd())
 	rootCmd.AddCommand(listCmd())
 	rootCmd.AddCommand(editCmd())
 	rootCmd.AddCommand(runCmd())
+	rootCmd.AddCommand(execCmd())
+	rootCmd.AddCommand(grepRunCmd())
+	rootCmd.AddCommand(showCmd())
+	rootCmd.AddCommand(whichCmd())
+	rootCmd.AddCommand(exportCmd())
+	rootCmd.AddCommand(importCmd())
+	rootCmd.AddCommand(diffCmd())
+	rootCmd.AddCommand(varCmd())
+	rootCmd.AddCommand(serveCmd())
+	rootCmd.AddCommand(scheduleCmd())
+	rootCmd.AddCommand(historyCmd())
+	rootCmd.AddCommand(rerunCmd())
+	rootCmd.AddCommand(suggestCmd())
+	rootCmd.AddCommand(dedupeCmd())
+	rootCmd.AddCommand(tagCmd())
+	rootCmd.AddCommand(variantsCmd())
+	rootCmd.AddCommand(approvalsCmd())
+	rootCmd.AddCommand(deprecateCmd())
+	rootCmd.AddCommand(lintCmd())
+	rootCmd.AddCommand(doctorCmd())
+	rootCmd.AddCommand(policyCmd())
+	rootCmd.AddCommand(examplesCmd())
+	rootCmd.AddCommand(auditCmd())
+	rootCmd.AddCommand(dbCmd())
+	rootCmd.AddCommand(shellCmd())
+	rootCmd.AddCommand(shimsCmd())
+	rootCmd.AddCommand(versionCmd())
+	rootCmd.AddCommand(selfUpdateCmd())
+	markUsageErrors(rootCmd)
+	return rootCmd
+}
+
+// storeBuckets are the buckets of the database.
+var storeBuckets = [][]byte{commandsBucket, varsBucket, auditBucket, metaBucket, historyBucket, answersBucket, blobsBucket, idxTagsBucket, idxMtimeBucket, idxUsageBucket, approvalsBucket, outputBucket, idxOutputBucket, basesBucket, cooldownsBucket}
+
+// dbReadOnly reports whether db was opened with openReadOnlyDB.
+var dbReadOnly bool
+
+// openDB opens the alias database and makes sure its buckets exist.
+func openDB() error {
+	return openStore(false)
+}
+
+// openReadOnlyDB opens the database for reading. Read-only handles share
+// the file lock, so concurrent runs don't wait for each other. A database
+// that doesn't exist yet or lacks buckets is opened normally instead, to
+// create them.
+func openReadOnlyDB() error {
+	return openStore(true)
+}
 
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+// dbPath returns the database file: the one CMDEX_DB names, or cmdex.db in
+// the current directory.
+func dbPath() string {
+	if p := os.Getenv("CMDEX_DB"); p != "" {
+		return p
 	}
+	return "cmdex.db"
 }
 
-func saveCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "save <alias> <command>",
-		Short: "Save a command set with an alias",
-		Args:  cobra.MinimumNArgs(2),
-		Run: func(cmd *cobra.Command, args []string) {
-			alias := args[0]
-			command := strings.Join(args[1:], " ")
-			err := db.Update(func(tx *bolt.Tx) error {
-				b := tx.Bucket([]byte("commands"))
-				return b.Put([]byte(alias), []byte(command))
-			})
-			if err != nil {
-				fmt.Printf("Error saving command: %v\n", err)
-			} else {
-				fmt.Printf("Command saved with alias: %s\n", alias)
+func openStore(readOnly bool) error {
+	// The freelist is rebuilt on open instead of being written on every
+	// commit; the database is small enough for that to be cheap.
+	if _, err := os.Stat(dbPath()); readOnly && errors.Is(err, os.ErrNotExist) {
+		return openStore(false)
+	}
+	opts := &bolt.Options{Timeout: time.Second, NoFreelistSync: true, ReadOnly: readOnly}
+	// Opening for writing scans the pages for the freelist, which panics
+	// on damaged ones.
+	err := guarded(func() error {
+		var err error
+		db, err = bolt.Open(dbPath(), 0600, opts)
+		return err
+	})
+	if err != nil {
+		db = nil
+		return damaged(dbPath(), err)
+	}
+	var missing [][]byte
+	err = guarded(func() error {
+		return db.View(func(tx *bolt.Tx) error {
+			for _, name := range storeBuckets {
+				if tx.Bucket(name) == nil {
+					missing = append(missing, name)
+				}
 			}
-		},
+			return nil
+		})
+	})
+	if err != nil {
+		closeDB()
+		return damaged(dbPath(), err)
+	}
+	// Only create buckets when some are missing: even an empty write
+	// transaction costs an fsync.
+	if len(missing) > 0 {
+		if readOnly {
+			closeDB()
+			return openStore(false)
+		}
+		err = db.Update(func(tx *bolt.Tx) error {
+			// A new database starts at the current schema version.
+			fresh := tx.Bucket(commandsBucket) == nil
+			for _, name := range missing {
+				if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			}
+			if fresh {
+				return setSchemaVersion(tx, schemaLatest)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	dbReadOnly = readOnly
+	if err := checkSchema(); err != nil {
+		return err
 	}
+	if err := unlockStore(); err != nil {
+		return err
+	}
+	return ensureIndexes()
 }
 
-func listCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "list",
-		Short: "List all saved aliases and their associated commands",
-		Run: func(cmd *cobra.Command, args []string) {
-			err := db.View(func(tx *bolt.Tx) error {
-				b := tx.Bucket([]byte("commands"))
-				return b.ForEach(func(k, v []byte) error {
-					fmt.Printf("%s: %s\n", k, v)
-					return nil
-				})
-			})
-			if err != nil {
-				fmt.Printf("Error listing commands: %v\n", err)
-			}
-		},
+// ensureIndexes builds the indexes if they are incomplete, reopening a
+// read-only database for writing to do so.
+func ensureIndexes() error {
+	var built bool
+	db.View(func(tx *bolt.Tx) error {
+		built = indexesBuilt(tx)
+		return nil
+	})
+	if built {
+		return nil
+	}
+	if dbReadOnly {
+		closeDB()
+		return openDB()
+	}
+	return db.Update(rebuildIndexes)
+}
+
+// writableDB reopens the database for writing if it's closed or was opened
+// read-only.
+func writableDB() error {
+	if db != nil && !dbReadOnly {
+		return nil
+	}
+	closeDB()
+	return openDB()
+}
+
+// closeDB releases the database so that other cmdex processes can use it.
+func closeDB() {
+	if db != nil {
+		db.Close()
+		db = nil
+		dbReadOnly = false
 	}
 }
 
 func editCmd() *cobra.Command {
 	return &cobra.Command{
-		Use:   "edit <alias> <new_command>",
+		Use:   "edit <alias> [new_command]",
 		Short: "Edit an existing command set",
-		Args:  cobra.MinimumNArgs(2),
-		Run: func(cmd *cobra.Command, args []string) {
+		Long:  `edit replaces the command of an alias. Without a new command the current command or script is opened in $EDITOR.`,
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
 			alias := args[0]
-			newCommand := strings.Join(args[1:], " ")
+			var text string
+			if len(args) == 1 {
+				a, err := loadAlias(alias)
+				if err != nil {
+					return fmt.Errorf("editing command: %w", err)
+				}
+				if text, err = editText(a.body()); err != nil {
+					return err
+				}
+				if strings.TrimSpace(text) == "" {
+					return fmt.Errorf("empty command, alias left unchanged")
+				}
+			} else {
+				text = strings.Join(args[1:], " ")
+			}
 			err := db.Update(func(tx *bolt.Tx) error {
-				b := tx.Bucket([]byte("commands"))
-				if b.Get([]byte(alias)) == nil {
-					return fmt.Errorf("alias not found")
+				// Editing an alias from a layer saves the edited copy in
+				// the store, where it shadows the layer.
+				a, err := getVariantOrLayered(tx, alias)
+				if err != nil {
+					return err
+				}
+				setBody(a, text)
+				a.Modified = time.Now()
+				if a.Created.IsZero() {
+					a.Created = a.Modified
 				}
-				return b.Put([]byte(alias), []byte(newCommand))
+				if a.Variant != "" {
+					// Write the edited body back to its variant.
+					variant, v := a.Variant, a.variantBody()
+					if a, err = getVariantOrLayered(tx, a.Name); err != nil {
+						return err
+					}
+					a.Variants[variant], a.Modified = v, v.Modified
+				}
+				if a.layer != nil {
+					a.Provenance = newProvenance("layer", a.layer.path)
+				}
+				if err := policy.checkAlias(a); err != nil {
+					return err
+				}
+				if err := putAlias(tx, a); err != nil {
+					return err
+				}
+				return writeAudit(tx, auditEntry{Action: "edit", Target: alias})
 			})
 			if err != nil {
-				fmt.Printf("Error editing command: %v\n", err)
-			} else {
-				fmt.Printf("Command updated for alias: %s\n", alias)
+				return fmt.Errorf("editing command: %w", err)
 			}
+			fmt.Printf("Command updated for alias: %s\n", paint("alias", alias))
+			return nil
 		},
 	}
 }
 
-func runCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "run <alias> [args...]",
-		Short: "Run a saved command set",
-		Args:  cobra.MinimumNArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
-			runCommand(args[0], args[1:])
+func showCmd() *cobra.Command {
+	var copyOut bool
+	cmd := &cobra.Command{
+		Use:         "show <alias>",
+		Short:       "Show the command saved under an alias",
+		Annotations: readDB,
+		Args:        cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := loadAlias(args[0])
+			if err != nil {
+				return fmt.Errorf("retrieving command: %w", err)
+			}
+			if copyOut {
+				if err := copyToClipboard(a.body()); err != nil {
+					return fmt.Errorf("copying to clipboard: %w", err)
+				}
+				fmt.Printf("Command for alias %s copied to clipboard\n", paint("alias", args[0]))
+				return nil
+			}
+			printAlias(a)
+			return nil
 		},
 	}
+	cmd.Flags().BoolVar(&copyOut, "copy", false, "Copy the command to the clipboard")
+	return cmd
 }
 
-func runCommand(alias string, args []string) {
-	var command string
-	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("commands"))
-		v := b.Get([]byte(alias))
-		if v == nil {
-			return fmt.Errorf("alias not found")
+// printAlias prints the details of a as labelled fields, omitting empty ones.
+func printAlias(a *Alias) {
+	field := func(label, value string) {
+		if value != "" {
+			fmt.Printf("%-13s %s\n", label+":", value)
 		}
-		command = string(v)
-		return nil
-	})
-	if err != nil {
-		fmt.Printf("Error retrieving command: %v\n", err)
-		return
 	}
-
-	// Replace placeholders with arguments
-	for i, arg := range args {
-		placeholder := fmt.Sprintf("$%d", i+1)
-		command = strings.ReplaceAll(command, placeholder, arg)
+	field("Alias", paint("alias", a.ref()))
+	if a.layer != nil {
+		field("Layer", a.layer.String()+" (read-only)")
 	}
-
-	// Split the command into parts
-	cmdParts := strings.Fields(command)
-	if len(cmdParts) == 0 {
-		fmt.Println("Empty command")
-		return
+	field("Description", a.Description)
+	field("Owner", a.Owner)
+	field("Team", a.Team)
+	if d := a.Deprecated; d != nil {
+		mode := "forwards to"
+		if d.Strict {
+			mode = "replaced by"
+		}
+		field("Deprecated", mode+" "+paint("alias", d.Use))
 	}
-
-	// Create the command
-	cmd := exec.Command(cmdParts[0], cmdParts[1:]...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	// Run the command
-	err = cmd.Run()
-	if err != nil {
-		fmt.Printf("Error executing command: %v\n", err)
+	field("Command", a.Command)
+	for _, key := range a.platformKeys() {
+		field("  "+key, a.Platforms[key])
 	}
-}
\ No newline at end of file
+	if len(a.Steps) > 0 {
+		fmt.Println("Steps:")
+		for i, step := range a.Steps {
+			text := step.text()
+			line := fmt.Sprintf("  %d. %s", i+1, text)
+			if step.Name != "" {
+				line = fmt.Sprintf("  %d. %s: %s", i+1, step.Name, text)
+			}
+			if step.When != "" {
+				line += fmt.Sprintf("  (when %s)", step.When)
+			}
+			if step.Register != "" {
+				line += fmt.Sprintf("  -> steps.%s", step.Register)
+			}
+			fmt.Println(line)
+		}
+	}
+	field("Stdin file", a.StdinFile)
+	if a.Stdin != "" {
+		fmt.Println("Stdin:")
+		for _, line := range strings.Split(strings.TrimRight(a.Stdin, "\n"), "\n") {
+			fmt.Println("  " + line)
+		}
+	}
+	if a.Script != "" {
+		fmt.Println("Script:")
+		for _, line := range strings.Split(a.Script, "\n") {
+			fmt.Println("  " + line)
+		}
+	}
+	field("Runtime", a.Runtime)
+	field("Variants", strings.Join(a.variantNames(), ", "))
+	field("Tags", paint("tag", strings.Join(a.Tags, ", ")))
+	field("Filter", a.Filter)
+	field("Artifacts", strings.Join(a.Artifacts, ", "))
+	field("Notify", strings.Join(a.Notify, ", "))
+	if a.GitRoot {
+		field("Directory", filepath.Join("<git root>", a.Dir))
+	} else {
+		field("Directory", a.Dir)
+	}
+	if len(a.Env) > 0 {
+		var names []string
+		for name := range a.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		field("Env", strings.Join(names, ", "))
+	}
+	field("Umask", a.Umask)
+	field("Locale", a.Locale)
+	field("Time zone", a.TZ)
+	switch {
+	case a.EnvClear && len(a.EnvAllow) > 0:
+		field("Environment", "cleared, also passing "+strings.Join(a.EnvAllow, ", "))
+	case a.EnvClear:
+		field("Environment", "cleared")
+	}
+	if a.Shim {
+		field("Shim", "yes (cmdex shims sync)")
+	}
+	if a.Confirm {
+		field("Confirm", "yes")
+	}
+	field("Cooldown", a.Cooldown)
+	field("Guards", a.Guards.String())
+	if a.Lock {
+		field("Lock", "one run at a time")
+	}
+	field("Schedule", a.Schedule)
+	if !a.Limits.empty() {
+		field("Limits", a.Limits.String())
+	}
+	if a.Access != nil {
+		fmt.Println("Access:")
+		if a.Access.ReadOnly {
+			fmt.Println("  read-only")
+		}
+		if len(a.Access.Run) > 0 {
+			fmt.Println("  run:     " + strings.Join(a.Access.Run, ", "))
+		}
+		if len(a.Access.Edit) > 0 {
+			fmt.Println("  edit:    " + strings.Join(a.Access.Edit, ", "))
+		}
+		if len(a.Access.Approve) > 0 {
+			fmt.Println("  approve: " + strings.Join(a.Access.Approve, ", "))
+		}
+	}
+	if n := placeholderCount(a.allCommands()); n > 0 {
+		fmt.Println("Placeholders:")
+		for i := 0; i < n; i++ {
+			line := fmt.Sprintf("  $%d", i+1)
+			if i < len(a.Placeholders) {
+				ph := a.Placeholders[i]
+				if ph.Name != "" {
+					line += " " + ph.Name
+				}
+				if ph.Description != "" {
+					line += " - " + ph.Description
+				}
+				if ph.Default != "" {
+					line += fmt.Sprintf(" (default: %s)", ph.Default)
+				}
+				if len(ph.Choices) > 0 {
+					line += fmt.Sprintf(" [%s]", strings.Join(ph.Choices, "|"))
+				}
+			}
+			fmt.Println(line)
+		}
+	}
+	if axes, err := a.matrixAxes(a.Matrix); err == nil && len(axes) > 0 {
+		fmt.Println("Matrix:")
+		for _, axis := range axes {
+			fmt.Printf("  %s: %s\n", axis.name, strings.Join(axis.values, ", "))
+		}
+	}
+	printExamples(a)
+	if !a.Created.IsZero() {
+		field("Created", a.Created.Format(time.RFC3339))
+	}
+	if a.Provenance != nil {
+		field("Provenance", a.Provenance.String())
+	}
+	if !a.Modified.IsZero() {
+		field("Modified", a.Modified.Format(time.RFC3339))
+	}
+	field("Uses", fmt.Sprint(a.Uses))
+	if !a.LastUsed.IsZero() {
+		field("Last used", a.LastUsed.Format(time.RFC3339))
+	}
+}
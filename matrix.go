@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// matrixAxis is one parameter of a matrix: the placeholder it fills, by
+// index, and the values it takes.
+type matrixAxis struct {
+	name   string
+	index  int
+	values []string
+}
+
+// placeholderIndex resolves name, a placeholder name or $N, to the index
+// of the placeholder.
+func (a *Alias) placeholderIndex(name string) (int, error) {
+	if n, err := strconv.Atoi(strings.TrimPrefix(name, "$")); err == nil && strings.HasPrefix(name, "$") && n >= 1 {
+		return n - 1, nil
+	}
+	for i, ph := range a.Placeholders {
+		if ph.Name == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("matrix parameter %s is not a placeholder of %s", name, a.ref())
+}
+
+// matrixAxes returns the axes of matrix in the order of the placeholders
+// they fill.
+func (a *Alias) matrixAxes(matrix map[string][]string) ([]matrixAxis, error) {
+	var axes []matrixAxis
+	used := make(map[int]string)
+	for name, values := range matrix {
+		i, err := a.placeholderIndex(name)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := used[i]; ok {
+			return nil, fmt.Errorf("matrix parameters %s and %s both fill $%d", other, name, i+1)
+		}
+		used[i] = name
+		if len(values) == 0 {
+			return nil, fmt.Errorf("matrix parameter %s has no values", name)
+		}
+		axes = append(axes, matrixAxis{name: name, index: i, values: values})
+	}
+	sort.Slice(axes, func(i, j int) bool { return axes[i].index < axes[j].index })
+	return axes, nil
+}
+
+// parseAxes parses the run --axis values, name=v1,v2 each, into a matrix.
+func parseAxes(specs []string) (map[string][]string, error) {
+	matrix := make(map[string][]string)
+	for _, spec := range specs {
+		name, values, ok := strings.Cut(spec, "=")
+		if !ok || name == "" {
+			return nil, usageError(fmt.Errorf("invalid --axis %q (use name=value1,value2)", spec))
+		}
+		matrix[name] = splitList(values)
+	}
+	return matrix, nil
+}
+
+// combinations returns every combination of the axes' values, the first
+// axis changing slowest.
+func combinations(axes []matrixAxis) [][]string {
+	combos := [][]string{nil}
+	for _, axis := range axes {
+		var next [][]string
+		for _, combo := range combos {
+			for _, v := range axis.values {
+				next = append(next, append(append([]string(nil), combo...), v))
+			}
+		}
+		combos = next
+	}
+	return combos
+}
+
+// matrixArgs places the values of one combination at the positions of
+// their placeholders, filling the positions in between with args in order,
+// or else the placeholders' defaults. Arguments left over follow.
+func (a *Alias) matrixArgs(axes []matrixAxis, combo, args []string) ([]string, error) {
+	fixed := make(map[int]string)
+	last := 0
+	for i, axis := range axes {
+		fixed[axis.index] = combo[i]
+		if axis.index > last {
+			last = axis.index
+		}
+	}
+	var out []string
+	rest := args
+	for i := 0; i <= last; i++ {
+		switch v, ok := fixed[i]; {
+		case ok:
+			out = append(out, v)
+		case len(rest) > 0:
+			out, rest = append(out, rest[0]), rest[1:]
+		case i < len(a.Placeholders) && a.Placeholders[i].Default != "":
+			out = append(out, a.Placeholders[i].Default)
+		default:
+			return nil, usageError(fmt.Errorf("no value for $%d, which comes before the matrix parameters; pass it as an argument", i+1))
+		}
+	}
+	return append(out, rest...), nil
+}
+
+// runMatrix runs alias for every combination of its matrix, with overrides
+// from run --axis replacing or adding axes, at most parallel at a time.
+// Every combination runs even if others fail; a grid of the results
+// follows.
+func runMatrix(ctx context.Context, alias string, args, overrides []string, parallel int, opts runOptions) error {
+	if parallel < 1 {
+		return usageError(fmt.Errorf("--max-parallel must be at least 1"))
+	}
+	a, err := loadAlias(alias)
+	if err != nil {
+		return fmt.Errorf("retrieving command: %w", err)
+	}
+	if a, err = followDeprecation(a); err != nil {
+		return err
+	}
+	extra, err := parseAxes(overrides)
+	if err != nil {
+		return err
+	}
+	matrix := make(map[string][]string)
+	for name, values := range a.Matrix {
+		matrix[name] = values
+	}
+	for name, values := range extra {
+		matrix[name] = values
+	}
+	if len(matrix) == 0 {
+		return usageError(fmt.Errorf("%s declares no matrix; add matrix: to its spec or pass --axis name=value1,value2", a.ref()))
+	}
+	axes, err := a.matrixAxes(matrix)
+	if err != nil {
+		return usageError(err)
+	}
+
+	// As with --each, arguments and confirmations are settled up front.
+	combos := combinations(axes)
+	seqs := make([]*sequence, len(combos))
+	labels := make([]string, len(combos))
+	for i, combo := range combos {
+		var parts []string
+		for j, axis := range axes {
+			parts = append(parts, axis.name+"="+combo[j])
+		}
+		labels[i] = strings.Join(parts, " ")
+		combArgs, err := a.matrixArgs(axes, combo, args)
+		if err != nil {
+			return err
+		}
+		if seqs[i], err = prepareRun(alias, combArgs, opts); err != nil {
+			return fmt.Errorf("%s: %w", labels[i], err)
+		}
+		opts.yes, opts.force = true, true
+	}
+	closeDB()
+
+	results := make([]*batchResult, len(combos))
+	run := func(i int) {
+		r, s := results[i], seqs[i]
+		ctx := ctx
+		if opts.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+			defer cancel()
+		}
+		start := time.Now()
+		r.err = s.execute(ctx)
+		r.duration = time.Since(start)
+		recordHistory(s.historyEntry(start, r.duration, r.err))
+	}
+	if parallel == 1 {
+		for i := range combos {
+			results[i] = &batchResult{alias: a.ref() + " " + labels[i]}
+			banner(os.Stderr, verbosityNormal, "==> "+results[i].alias)
+			run(i)
+			printBatchResult(results[i])
+		}
+	} else {
+		var (
+			wg  sync.WaitGroup
+			mu  sync.Mutex
+			sem = make(chan struct{}, parallel)
+		)
+		for i := range combos {
+			results[i] = &batchResult{alias: a.ref() + " " + labels[i]}
+			seqs[i].stdout = &results[i].output
+			seqs[i].stderr = &results[i].output
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				sem <- struct{}{}
+				run(i)
+				<-sem
+				mu.Lock()
+				defer mu.Unlock()
+				banner(os.Stderr, verbosityNormal, "==> "+results[i].alias)
+				os.Stdout.Write(results[i].output.Bytes())
+				printBatchResult(results[i])
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+		}
+	}
+	if verbosity >= verbosityNormal {
+		fmt.Fprintln(os.Stderr)
+		for _, line := range matrixGrid(axes, combos, results) {
+			fmt.Fprintln(os.Stderr, line)
+		}
+		fmt.Fprintf(os.Stderr, "%d of %d combinations succeeded\n", len(results)-failed, len(results))
+	}
+	if failed > 0 {
+		return newError(exitChildFailed, "child_failed", fmt.Errorf("%d of %d combinations failed", failed, len(results)))
+	}
+	return nil
+}
+
+// matrixGrid lays out the results of a matrix run: for two axes, a grid
+// with the first axis down and the second across; otherwise one row per
+// combination.
+func matrixGrid(axes []matrixAxis, combos [][]string, results []*batchResult) []string {
+	status := func(r *batchResult) string {
+		if r.err != nil {
+			return fmt.Sprintf("FAIL (exit %d)", exitCodeOf(r.err))
+		}
+		return "ok " + r.duration.Round(time.Millisecond).String()
+	}
+	var t table
+	if len(axes) == 2 {
+		header := append([]string{axes[0].name + " \\ " + axes[1].name}, axes[1].values...)
+		t.add(header...)
+		for i, v := range axes[0].values {
+			row := []string{v}
+			for j := range axes[1].values {
+				row = append(row, status(results[i*len(axes[1].values)+j]))
+			}
+			t.add(row...)
+		}
+		return t.lines(0)
+	}
+	var header []string
+	for _, axis := range axes {
+		header = append(header, axis.name)
+	}
+	t.add(append(header, "result")...)
+	for i, combo := range combos {
+		t.add(append(append([]string(nil), combo...), status(results[i]))...)
+	}
+	return t.lines(0)
+}
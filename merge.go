@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/yaml.v3"
+)
+
+// basesBucket holds the aliases as import last brought them in, by name:
+// the common ancestor of the local alias and the archive's when import
+// merges the next version.
+var basesBucket = []byte("bases")
+
+// getBase returns the alias name as last imported, or nil if it never was.
+func getBase(tx *bolt.Tx, name string) (*Alias, error) {
+	key := aliasKey(name)
+	v := tx.Bucket(basesBucket).Get([]byte(key))
+	if v == nil {
+		return nil, nil
+	}
+	v, err := decodeValue(key, v)
+	if err != nil {
+		return nil, err
+	}
+	a := &Alias{Name: key}
+	if err := json.Unmarshal(v, a); err != nil {
+		return nil, fmt.Errorf("reading the imported version of %s: %w", name, err)
+	}
+	return a, nil
+}
+
+// putBase records a as the version of the alias import brought in.
+func putBase(tx *bolt.Tx, a *Alias) error {
+	key := aliasKey(a.Name)
+	v, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	if v, err = encodeValue(key, v); err != nil {
+		return err
+	}
+	return tx.Bucket(basesBucket).Put([]byte(key), v)
+}
+
+// specFields splits a, as a layer file would define it, into its fields.
+// Unset fields are left out.
+func specFields(a *Alias) (map[string]interface{}, error) {
+	data, err := yaml.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// fieldText renders the value of a field for comparing and showing it;
+// unset fields are empty.
+func fieldText(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimRight(string(data), "\n")
+}
+
+// fieldConflict is a field of an alias that changed both locally and in
+// the archive, to different values. Unset values are nil.
+type fieldConflict struct {
+	alias, field  string
+	local, remote interface{}
+}
+
+// mergeAlias merges remote, the alias of an archive, into local, the one
+// in the store, field by field: a field changed only on one side since
+// base, the version last imported, takes that side's value. Fields changed
+// on both sides are conflicts, whose values resolve picks. Without a base
+// every field that differs is a conflict. The merged alias keeps what the
+// store records of local's use.
+func mergeAlias(base, local, remote *Alias, resolve func(c fieldConflict) (interface{}, error)) (*Alias, int, error) {
+	var b map[string]interface{}
+	if base != nil {
+		var err error
+		if b, err = specFields(base); err != nil {
+			return nil, 0, err
+		}
+	}
+	l, err := specFields(local)
+	if err != nil {
+		return nil, 0, err
+	}
+	r, err := specFields(remote)
+	if err != nil {
+		return nil, 0, err
+	}
+	names := make(map[string]bool)
+	for _, m := range []map[string]interface{}{b, l, r} {
+		for name := range m {
+			names[name] = true
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	merged := make(map[string]interface{})
+	conflicts := 0
+	for _, name := range sorted {
+		x, y := fieldText(l[name]), fieldText(r[name])
+		v := l[name]
+		switch {
+		case x == y:
+		case base != nil && x == fieldText(b[name]):
+			v = r[name]
+		case base != nil && y == fieldText(b[name]):
+		default:
+			conflicts++
+			if v, err = resolve(fieldConflict{alias: local.Name, field: name, local: l[name], remote: r[name]}); err != nil {
+				return nil, conflicts, err
+			}
+		}
+		if v != nil {
+			merged[name] = v
+		}
+	}
+
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, conflicts, err
+	}
+	a := &Alias{}
+	if err := yaml.Unmarshal(data, a); err != nil {
+		return nil, conflicts, fmt.Errorf("alias %s: %w", local.Name, err)
+	}
+	if err := a.validate(); err != nil {
+		return nil, conflicts, fmt.Errorf("alias %s: %w", local.Name, err)
+	}
+	a.Name, a.Created, a.Uses, a.LastUsed, a.Provenance = local.Name, local.Created, local.Uses, local.LastUsed, local.Provenance
+	a.Modified = local.Modified
+	return a, conflicts, nil
+}
+
+// aliasMerge is the merge of the alias of an archive into local, the alias
+// of the store it was merged with.
+type aliasMerge struct {
+	local, merged *Alias
+	conflicts     int
+}
+
+// planMerges merges the aliases of an archive, remote, into those of the
+// store that have the same names, by normalized name. The store is read in
+// one transaction and closed while resolve runs, so that the conflicts can
+// take as long to resolve as they need without locking out other cmdex
+// processes.
+func planMerges(remote []*Alias, resolve func(c fieldConflict) (interface{}, error)) (map[string]*aliasMerge, error) {
+	type pending struct {
+		local, remote, base *Alias
+	}
+	var todo []pending
+	err := db.View(func(tx *bolt.Tx) error {
+		for _, r := range remote {
+			local, err := getAlias(tx, r.Name)
+			if errors.Is(err, errAliasNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			base, err := getBase(tx, r.Name)
+			if err != nil {
+				return err
+			}
+			todo = append(todo, pending{local, r, base})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	closeDB()
+	merges := make(map[string]*aliasMerge)
+	for _, p := range todo {
+		merged, n, err := mergeAlias(p.base, p.local, p.remote, resolve)
+		if err != nil {
+			return nil, err
+		}
+		merges[aliasKey(p.remote.Name)] = &aliasMerge{local: p.local, merged: merged, conflicts: n}
+	}
+	return merges, openDB()
+}
+
+// showConflictValue prints the value of a conflicting field under label.
+func showConflictValue(label string, v interface{}) {
+	text := fieldText(v)
+	if v == nil {
+		text = "(unset)"
+	}
+	lines := strings.Split(text, "\n")
+	fmt.Printf("  %-8s%s\n", label+":", lines[0])
+	for _, line := range lines[1:] {
+		fmt.Printf("          %s\n", line)
+	}
+}
+
+// askConflict asks the user to keep the local or the remote value of the
+// conflicting field, or to write one in their editor.
+func askConflict(c fieldConflict) (interface{}, error) {
+	fmt.Printf("%s: %s changed both here and in the archive\n", paint("alias", c.alias), c.field)
+	showConflictValue("local", c.local)
+	showConflictValue("remote", c.remote)
+	for {
+		answer, err := ask("Keep [l]ocal, keep [r]emote or [e]dit", "")
+		if err != nil {
+			return nil, err
+		}
+		switch strings.ToLower(answer) {
+		case "l", "local":
+			return c.local, nil
+		case "r", "remote":
+			return c.remote, nil
+		case "e", "edit":
+			if v, err := editConflict(c); err != nil {
+				printWarning("Warning: %v", err)
+			} else {
+				return v, nil
+			}
+		}
+	}
+}
+
+// editConflict opens the editor on the local value of the conflicting
+// field, with the remote one below it in comments, and returns the value
+// saved. Deleting the field unsets it.
+func editConflict(c fieldConflict) (interface{}, error) {
+	var text strings.Builder
+	text.WriteString("# " + c.alias + ": the local " + c.field + "; the remote one is below\n")
+	if c.local != nil {
+		local, _ := yaml.Marshal(map[string]interface{}{c.field: c.local})
+		text.Write(local)
+	}
+	remote, _ := yaml.Marshal(map[string]interface{}{c.field: c.remote})
+	if c.remote == nil {
+		remote = []byte("(unset)\n")
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(remote), "\n"), "\n") {
+		text.WriteString("# " + line + "\n")
+	}
+	edited, err := editText(text.String())
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	if err := yaml.Unmarshal([]byte(edited), &fields); err != nil {
+		return nil, fmt.Errorf("reading the edited %s: %w", c.field, err)
+	}
+	for name := range fields {
+		if name != c.field {
+			return nil, fmt.Errorf("the edit may only set %s, not %s", c.field, name)
+		}
+	}
+	return fields[c.field], nil
+}
+
+// keepLocal resolves the conflicts of a merge nobody is there to ask
+// about in favour of the store.
+func keepLocal(c fieldConflict) (interface{}, error) {
+	printWarning("Warning: alias %s: %s changed both here and in the archive; kept the local value (import from a terminal to choose)", c.alias, c.field)
+	return c.local, nil
+}
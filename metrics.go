@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the run duration
+// histogram.
+var durationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900}
+
+// histogram is a cumulative Prometheus-style histogram.
+type histogram struct {
+	counts []uint64 // per bucket, cumulative
+	count  uint64
+	sum    float64
+}
+
+// runMetrics collects per-alias run statistics for the /metrics endpoint.
+type runMetrics struct {
+	mu        sync.Mutex
+	started   time.Time
+	runs      map[string]uint64
+	failures  map[string]uint64
+	durations map[string]*histogram
+}
+
+func newRunMetrics() *runMetrics {
+	return &runMetrics{
+		started:   time.Now(),
+		runs:      make(map[string]uint64),
+		failures:  make(map[string]uint64),
+		durations: make(map[string]*histogram),
+	}
+}
+
+// observe records one finished run of alias.
+func (m *runMetrics) observe(alias string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs[alias]++
+	if err != nil {
+		m.failures[alias]++
+	}
+	h := m.durations[alias]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		m.durations[alias] = h
+	}
+	secs := d.Seconds()
+	for i, le := range durationBuckets {
+		if secs <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += secs
+}
+
+// write renders the metrics in the Prometheus text exposition format.
+func (m *runMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	aliases := make([]string, 0, len(m.runs))
+	for alias := range m.runs {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	fmt.Fprintln(w, "# HELP cmdex_runs_total Alias runs started through the daemon.")
+	fmt.Fprintln(w, "# TYPE cmdex_runs_total counter")
+	for _, alias := range aliases {
+		fmt.Fprintf(w, "cmdex_runs_total{alias=%s} %d\n", promLabel(alias), m.runs[alias])
+	}
+	fmt.Fprintln(w, "# HELP cmdex_run_failures_total Alias runs that failed.")
+	fmt.Fprintln(w, "# TYPE cmdex_run_failures_total counter")
+	for _, alias := range aliases {
+		fmt.Fprintf(w, "cmdex_run_failures_total{alias=%s} %d\n", promLabel(alias), m.failures[alias])
+	}
+	fmt.Fprintln(w, "# HELP cmdex_run_duration_seconds Duration of alias runs.")
+	fmt.Fprintln(w, "# TYPE cmdex_run_duration_seconds histogram")
+	for _, alias := range aliases {
+		h, label := m.durations[alias], promLabel(alias)
+		for i, le := range durationBuckets {
+			fmt.Fprintf(w, "cmdex_run_duration_seconds_bucket{alias=%s,le=\"%g\"} %d\n", label, le, h.counts[i])
+		}
+		fmt.Fprintf(w, "cmdex_run_duration_seconds_bucket{alias=%s,le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(w, "cmdex_run_duration_seconds_sum{alias=%s} %g\n", label, h.sum)
+		fmt.Fprintf(w, "cmdex_run_duration_seconds_count{alias=%s} %d\n", label, h.count)
+	}
+	fmt.Fprintln(w, "# HELP cmdex_uptime_seconds Seconds since the daemon started.")
+	fmt.Fprintln(w, "# TYPE cmdex_uptime_seconds gauge")
+	fmt.Fprintf(w, "cmdex_uptime_seconds %g\n", time.Since(m.started).Seconds())
+}
+
+// promLabel quotes a label value for the exposition format.
+func promLabel(v string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(v) + `"`
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// schemaKey holds the schema version of the database in the meta bucket.
+// Databases from before versioning have none and are at version 0.
+var schemaKey = []byte("schema")
+
+// A migration upgrades the database by one schema version.
+type migration struct {
+	description string
+	// apply upgrades the records in tx and returns how many it changed.
+	apply func(tx *bolt.Tx) (int, error)
+}
+
+// migrations takes the database from schema version i to i+1 with
+// migrations[i]. New migrations are only ever appended. The indexes aren't
+// part of the schema: they're rebuilt whenever they're incomplete.
+var migrations = []migration{
+	{"Store aliases saved as bare commands as JSON records", migrateBareRecords},
+	{"Move large scripts into shared blobs", migrateScriptBlobs},
+	{"Store alias names in Unicode normalization form C", migrateAliasKeys},
+}
+
+// schemaLatest is the schema version this cmdex writes.
+var schemaLatest = len(migrations)
+
+func schemaVersion(tx *bolt.Tx) int {
+	v := tx.Bucket(metaBucket).Get(schemaKey)
+	if len(v) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(v))
+}
+
+func setSchemaVersion(tx *bolt.Tx, version int) error {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(version))
+	return tx.Bucket(metaBucket).Put(schemaKey, v)
+}
+
+// checkSchema refuses databases written by a newer cmdex, whose records
+// this one may misread or damage.
+func checkSchema() error {
+	var version int
+	db.View(func(tx *bolt.Tx) error {
+		version = schemaVersion(tx)
+		return nil
+	})
+	if version > schemaLatest {
+		return fmt.Errorf("the database has schema version %d, newer than this cmdex supports (%d); upgrade cmdex", version, schemaLatest)
+	}
+	return nil
+}
+
+// warnOutdatedSchema points out pending migrations. Older records still
+// work, so this is only a warning.
+func warnOutdatedSchema() {
+	var version int
+	db.View(func(tx *bolt.Tx) error {
+		version = schemaVersion(tx)
+		return nil
+	})
+	if version < schemaLatest {
+		printWarning("Warning: the database has schema version %d of %d; run cmdex db migrate", version, schemaLatest)
+	}
+}
+
+// migrateBareRecords rewrites aliases stored as a plain command string,
+// from before aliases had options, as JSON records.
+func migrateBareRecords(tx *bolt.Tx) (int, error) {
+	var bare []string
+	err := tx.Bucket(commandsBucket).ForEach(func(k, v []byte) error {
+		plain, err := decodeValue(string(k), v)
+		if err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(plain, []byte("{")) {
+			bare = append(bare, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range bare {
+		a, err := getAlias(tx, name)
+		if err != nil {
+			return 0, err
+		}
+		if err := putAlias(tx, a); err != nil {
+			return 0, err
+		}
+	}
+	return len(bare), nil
+}
+
+// migrateScriptBlobs moves scripts saved inline before blobs existed
+// into the blobs bucket.
+func migrateScriptBlobs(tx *bolt.Tx) (int, error) {
+	var large []string
+	err := tx.Bucket(commandsBucket).ForEach(func(k, v []byte) error {
+		a, err := storedAlias(tx, string(k))
+		if err != nil {
+			return err
+		}
+		if len(a.Script) >= blobMinSize {
+			large = append(large, a.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range large {
+		a, err := getAlias(tx, name)
+		if err != nil {
+			return 0, err
+		}
+		if err := putAlias(tx, a); err != nil {
+			return 0, err
+		}
+	}
+	return len(large), nil
+}
+
+// migrateAliasKeys moves aliases saved under a name that isn't in NFC to
+// the normalized key, which lookups try first.
+func migrateAliasKeys(tx *bolt.Tx) (int, error) {
+	c := tx.Bucket(commandsBucket)
+	var names []string
+	err := c.ForEach(func(k, v []byte) error {
+		if name := string(k); aliasKey(name) != name {
+			if c.Get([]byte(aliasKey(name))) != nil {
+				return fmt.Errorf("aliases %q and %q only differ in their Unicode normalization; rename one of them first", name, aliasKey(name))
+			}
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range names {
+		a, err := getAlias(tx, name)
+		if err != nil {
+			return 0, err
+		}
+		if err := putAlias(tx, a); err != nil {
+			return 0, err
+		}
+	}
+	return len(names), nil
+}
+
+// errDryRun rolls back the transaction of db migrate --dry-run.
+var errDryRun = errors.New("dry run")
+
+// backupDB copies the database next to itself and returns the copy's path.
+func backupDB(version int) (string, error) {
+	path := fmt.Sprintf("%s.v%d-%s.bak", db.Path(), version, time.Now().Format("20060102-150405"))
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	})
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+func dbMigrateCmd() *cobra.Command {
+	var dryRun, noBackup bool
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Upgrade the database to the current schema version",
+		Long: `migrate applies the migrations between the database's schema version and
+the one this cmdex writes, all in one transaction: either every migration
+is applied or none is. Before migrating, the database is copied to
+cmdex.db.v<version>-<time>.bak unless --no-backup is given. With --dry-run
+the migrations are run and rolled back, to show what they would change.`,
+		Example:     "  cmdex db migrate --dry-run\n  cmdex db migrate",
+		Annotations: map[string]string{"db": "migrate"},
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var from int
+			db.View(func(tx *bolt.Tx) error {
+				from = schemaVersion(tx)
+				return nil
+			})
+			if from >= schemaLatest {
+				fmt.Printf("The database is at schema version %d; nothing to migrate\n", from)
+				return nil
+			}
+			if !dryRun && !noBackup {
+				path, err := backupDB(from)
+				if err != nil {
+					return fmt.Errorf("backing up the database: %w", err)
+				}
+				fmt.Printf("Backed up the database to %s\n", path)
+			}
+			var t table
+			err := db.Update(func(tx *bolt.Tx) error {
+				for version := from; version < schemaLatest; version++ {
+					m := migrations[version]
+					n, err := m.apply(tx)
+					if err != nil {
+						return fmt.Errorf("migration %d (%s): %w", version+1, m.description, err)
+					}
+					t.add(fmt.Sprint(version+1), m.description, fmt.Sprintf("%d changed", n))
+				}
+				if dryRun {
+					return errDryRun
+				}
+				if err := setSchemaVersion(tx, schemaLatest); err != nil {
+					return err
+				}
+				return writeAudit(tx, auditEntry{Action: "db-migrate", Detail: fmt.Sprintf("schema version %d -> %d", from, schemaLatest)})
+			})
+			if err != nil && err != errDryRun {
+				return fmt.Errorf("migrating database: %w", err)
+			}
+			printLines(t.lines(0))
+			if dryRun {
+				fmt.Printf("Would migrate the database from schema version %d to %d\n", from, schemaLatest)
+				return nil
+			}
+			fmt.Printf("Migrated the database from schema version %d to %d\n", from, schemaLatest)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Show the pending migrations and what they would change without applying them")
+	cmd.Flags().BoolVar(&noBackup, "no-backup", false, "Don't copy the database before migrating")
+	return cmd
+}
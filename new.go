@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "new",
+		Short: "Create an alias interactively",
+		Long:  `new walks through creating an alias step by step: its name, command, description, tags, placeholders, working directory and whether it needs confirmation before running.`,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !interactive() {
+				return fmt.Errorf("new needs an interactive terminal; use save instead")
+			}
+			a, err := runWizard()
+			if err != nil {
+				return err
+			}
+			if a == nil {
+				fmt.Println("Nothing saved")
+				return nil
+			}
+			return saveNewAlias(a, "new")
+		},
+	}
+}
+
+// saveNewAlias saves a, which replaces any alias of the same name while
+// keeping its usage statistics. detail tells in the audit log how a was
+// made.
+func saveNewAlias(a *Alias, detail string) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		now := time.Now()
+		if old, err := getAlias(tx, a.Name); err == nil {
+			a.Created, a.Uses, a.LastUsed, a.Provenance = old.Created, old.Uses, old.LastUsed, old.Provenance
+		} else {
+			if err := checkNameClash(a.Name); err != nil {
+				return err
+			}
+			a.Created, a.Provenance = now, newProvenance(detail, "")
+		}
+		a.Modified = now
+		if err := policy.checkAlias(a); err != nil {
+			return err
+		}
+		if err := putAlias(tx, a); err != nil {
+			return err
+		}
+		return writeAudit(tx, auditEntry{Action: "save", Target: a.Name, Detail: detail})
+	})
+	if err != nil {
+		return fmt.Errorf("saving command: %w", err)
+	}
+	fmt.Println(trf("Command saved with alias: %s", paint("alias", a.Name)))
+	return nil
+}
+
+// askAliasName prompts for the name of a new alias, confirming before
+// reusing the name of an existing one.
+func askAliasName() (string, error) {
+	for {
+		name, err := ask("Alias name", "")
+		if err != nil {
+			return "", err
+		}
+		if strings.ContainsAny(name, " \t") {
+			fmt.Println(tr("Alias names can't contain whitespace"))
+			continue
+		}
+		if name == "" {
+			continue
+		}
+		if _, err := loadAlias(name); err == nil {
+			overwrite, err := confirm(trf("Alias %s exists. Overwrite it?", name), false)
+			if err != nil {
+				return "", err
+			}
+			if !overwrite {
+				continue
+			}
+		}
+		return name, nil
+	}
+}
+
+// runWizard prompts for every field of a new alias. It returns nil when the
+// user decides not to save.
+func runWizard() (*Alias, error) {
+	name, err := askAliasName()
+	if err != nil {
+		return nil, err
+	}
+	a := &Alias{Name: name}
+
+	for a.body() == "" {
+		command, err := ask("Command (empty to open $EDITOR)", "")
+		if err != nil {
+			return nil, err
+		}
+		if command == "" {
+			if command, err = editText(""); err != nil {
+				return nil, err
+			}
+		}
+		setBody(a, command)
+	}
+
+	if a.Description, err = ask("Description", ""); err != nil {
+		return nil, err
+	}
+	tags, err := ask("Tags (comma-separated)", "")
+	if err != nil {
+		return nil, err
+	}
+	a.Tags = splitList(tags)
+
+	for i := 0; i < placeholderCount(a.allCommands()); i++ {
+		fmt.Println(trf("Placeholder $%d", i+1))
+		var ph Placeholder
+		if ph.Name, err = ask("  Name", ""); err != nil {
+			return nil, err
+		}
+		if ph.Description, err = ask("  Description", ""); err != nil {
+			return nil, err
+		}
+		if ph.Default, err = ask("  Default value", ""); err != nil {
+			return nil, err
+		}
+		choices, err := ask("  Allowed values (comma-separated, empty for any)", "")
+		if err != nil {
+			return nil, err
+		}
+		ph.Choices = splitList(choices)
+		a.Placeholders = append(a.Placeholders, ph)
+	}
+
+	if a.Dir, err = ask("Working directory (empty for current)", ""); err != nil {
+		return nil, err
+	}
+	if a.Confirm, err = confirm("Ask for confirmation before running?", false); err != nil {
+		return nil, err
+	}
+
+	fmt.Println()
+	printAlias(a)
+	fmt.Println()
+	save, err := confirm("Save this alias?", true)
+	if err != nil || !save {
+		return nil, err
+	}
+	return a, nil
+}
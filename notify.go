@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NotifyConfig sets up where cmdex reports finished runs.
+type NotifyConfig struct {
+	// Targets are the notification targets, by name.
+	Targets map[string]NotifyTarget `yaml:"targets"`
+	// Default names the targets of aliases that don't name their own.
+	Default []string `yaml:"default"`
+	// Scheduled names targets that are also told of the runs started by
+	// cmdex schedule.
+	Scheduled []string `yaml:"scheduled"`
+	// SMTP is the mail server of email targets.
+	SMTP SMTPConfig `yaml:"smtp"`
+}
+
+// NotifyTarget is a Slack or Discord incoming webhook, any URL that takes
+// a JSON description of the run, or email recipients.
+type NotifyTarget struct {
+	// Type is slack, discord, webhook or email; it's guessed from URL if
+	// unset.
+	Type string `yaml:"type"`
+	URL  string `yaml:"url"`
+	// To are the recipients of an email target, which get the output of
+	// the run attached.
+	To []string `yaml:"to"`
+	// On is failure (the default), success or always.
+	On string `yaml:"on"`
+	// Lines is how many of the last lines of output are included, 20 by
+	// default; -1 leaves the output out.
+	Lines int `yaml:"lines"`
+}
+
+// notifyNone, as an alias's only target, turns off the default targets.
+const notifyNone = "none"
+
+// kind returns the type of t.
+func (t NotifyTarget) kind() string {
+	if t.Type != "" {
+		return t.Type
+	}
+	if len(t.To) > 0 {
+		return "email"
+	}
+	u, err := url.Parse(t.URL)
+	switch {
+	case err != nil:
+	case u.Host == "hooks.slack.com":
+		return "slack"
+	case (u.Host == "discord.com" || u.Host == "discordapp.com") && strings.HasPrefix(u.Path, "/api/webhooks/"):
+		return "discord"
+	}
+	return "webhook"
+}
+
+// wants reports whether t is notified of a run that succeeded or not.
+func (t NotifyTarget) wants(ok bool) bool {
+	switch t.On {
+	case "always":
+		return true
+	case "success":
+		return ok
+	}
+	return !ok
+}
+
+// lines returns how many lines of output t includes.
+func (t NotifyTarget) lines() int {
+	if t.Lines == 0 {
+		return 20
+	}
+	return t.Lines
+}
+
+func (t NotifyTarget) validate() error {
+	switch t.Type {
+	case "", "slack", "discord", "webhook", "email":
+	default:
+		return fmt.Errorf("unknown notification type %q (use slack, discord, webhook or email)", t.Type)
+	}
+	if t.kind() == "email" {
+		if len(t.To) == 0 {
+			return fmt.Errorf("email notification without recipients; list them under to:")
+		}
+		if currentConfig().Notify.SMTP.Host == "" {
+			return fmt.Errorf("email notification without a mail server; set notify.smtp.host in the config")
+		}
+	} else if u, err := url.Parse(t.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid notification url %q", t.URL)
+	}
+	switch t.On {
+	case "", "failure", "success", "always":
+	default:
+		return fmt.Errorf("invalid notification on: %q (use failure, success or always)", t.On)
+	}
+	return nil
+}
+
+// checkNotify reports whether every entry of an alias's notify list names
+// a configured target or is a webhook URL.
+func checkNotify(entries []string) error {
+	for _, entry := range entries {
+		if entry == notifyNone {
+			if len(entries) > 1 {
+				return fmt.Errorf("notify: %s can't be combined with other targets", notifyNone)
+			}
+			continue
+		}
+		t, err := notifyTarget(entry)
+		if err != nil {
+			return err
+		}
+		if err := t.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyTarget resolves an entry of a notify list: a configured target's
+// name, or a URL or mailto:address notified on failure.
+func notifyTarget(entry string) (NotifyTarget, error) {
+	if t, ok := currentConfig().Notify.Targets[entry]; ok {
+		return t, nil
+	}
+	if strings.HasPrefix(entry, "http://") || strings.HasPrefix(entry, "https://") {
+		return NotifyTarget{URL: entry}, nil
+	}
+	if to := strings.TrimPrefix(entry, "mailto:"); to != entry {
+		return NotifyTarget{Type: "email", To: splitList(to)}, nil
+	}
+	return NotifyTarget{}, fmt.Errorf("unknown notification target %q (configure it under notify.targets or give a URL or mailto:address)", entry)
+}
+
+// notifyEntries returns the notify list of runs of a: its own, or the
+// default one, and notify.scheduled for the runs cmdex schedule starts.
+func (a *Alias) notifyEntries(scheduled bool) []string {
+	c := currentConfig()
+	entries := a.Notify
+	if len(entries) == 0 {
+		entries = c.Notify.Default
+	}
+	if scheduled {
+		entries = append(append([]string(nil), entries...), c.Notify.Scheduled...)
+	}
+	return entries
+}
+
+// notifyTargets returns the targets to notify of runs of a; see
+// notifyEntries.
+func (a *Alias) notifyTargets(scheduled bool) []NotifyTarget {
+	entries := a.notifyEntries(scheduled)
+	var targets []NotifyTarget
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if entry == notifyNone {
+			return nil
+		}
+		if seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		t, err := notifyTarget(entry)
+		if err != nil {
+			printWarning("Warning: %v", err)
+			continue
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// outputTail keeps the last lines written to it, and all of the output
+// up to limit if full is set.
+type outputTail struct {
+	mu      sync.Mutex
+	max     int
+	lines   []string
+	partial string
+	full    *bytes.Buffer
+	// limit caps full, in bytes.
+	limit int
+	cut   bool
+	// redactor, when set, takes the output first and passes it on with
+	// the secrets redacted.
+	redactor *redactWriter
+}
+
+func (t *outputTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.full != nil {
+		if room := t.limit - t.full.Len(); len(p) > room {
+			t.full.Write(p[:room])
+			t.cut = true
+		} else {
+			t.full.Write(p)
+		}
+	}
+	text := t.partial + string(p)
+	parts := strings.Split(text, "\n")
+	t.partial = parts[len(parts)-1]
+	t.lines = append(t.lines, parts[:len(parts)-1]...)
+	if len(t.lines) > t.max {
+		t.lines = t.lines[len(t.lines)-t.max:]
+	}
+	return len(p), nil
+}
+
+// last returns up to n of the last lines.
+func (t *outputTail) last(n int) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lines := t.lines
+	if t.partial != "" {
+		lines = append(lines[:len(lines):len(lines)], t.partial)
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// output returns all of the output kept, noting where it was cut short.
+func (t *outputTail) output() []byte {
+	if t == nil || t.full == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := t.full.Bytes()
+	if t.cut {
+		out = append(out[:len(out):len(out)], fmt.Sprintf("\n[output cut at %s]\n", formatBytes(uint64(t.limit)))...)
+	}
+	return out
+}
+
+// tailOutput starts keeping the output of the sequence the targets need:
+// its last lines, and all of it for email, with the secrets redacted.
+// Output going straight to a terminal is left alone, so that the commands
+// still see one; it is then missing from the notifications.
+func (s *sequence) tailOutput(targets []NotifyTarget) *outputTail {
+	t := &outputTail{}
+	for _, target := range targets {
+		if lines := target.lines(); lines > t.max {
+			t.max = lines
+		}
+		if target.kind() == "email" && t.full == nil {
+			t.full, t.limit = new(bytes.Buffer), maxAttachment
+		}
+	}
+	if t.max <= 0 && t.full == nil {
+		return nil
+	}
+	var kept io.Writer = t
+	if len(s.secrets) > 0 {
+		t.redactor = newRedactWriter(t, s.secrets)
+		kept = t.redactor
+	}
+	for _, w := range []*io.Writer{&s.stdout, &s.stderr} {
+		if f, ok := (*w).(*os.File); ok && isTerminal(f) {
+			continue
+		}
+		*w = io.MultiWriter(*w, kept)
+	}
+	return t
+}
+
+// notification is the JSON body sent to webhook targets.
+type notification struct {
+	RunID    string    `json:"run_id"`
+	Alias    string    `json:"alias"`
+	Owner    string    `json:"owner,omitempty"`
+	Team     string    `json:"team,omitempty"`
+	Host     string    `json:"host"`
+	User     string    `json:"user"`
+	Time     time.Time `json:"time"`
+	OK       bool      `json:"ok"`
+	ExitCode int       `json:"exit_code"`
+	Duration float64   `json:"duration"`
+	Error    string    `json:"error,omitempty"`
+	Output   []string  `json:"output,omitempty"`
+}
+
+// notify reports the run of s that started at start and ended with err
+// to targets. Failures to notify are reported but don't fail the run.
+func (s *sequence) notify(targets []NotifyTarget, tail *outputTail, start time.Time, err error) {
+	if tail != nil && tail.redactor != nil {
+		tail.redactor.flush()
+	}
+	host, _ := os.Hostname()
+	n := notification{RunID: s.runID, Alias: s.alias.ref(), Owner: s.alias.Owner, Team: s.alias.Team, Host: host, User: currentUser(), Time: start, OK: err == nil,
+		ExitCode: exitCodeOf(err), Duration: time.Since(start).Seconds()}
+	if n.Alias == "" {
+		n.Alias = "exec: " + s.alias.Command
+	}
+	if err != nil {
+		n.Error = err.Error()
+	}
+	for _, t := range targets {
+		if !t.wants(n.OK) {
+			continue
+		}
+		msg := n
+		if lines := t.lines(); lines > 0 && tail != nil {
+			msg.Output = tail.last(lines)
+		}
+		if t.kind() == "email" {
+			if err := sendEmail(t, msg, tail.output()); err != nil {
+				printWarning("Warning: notifying %s: %v", strings.Join(t.To, ", "), err)
+			}
+			continue
+		}
+		if err := postNotification(t, msg); err != nil {
+			printWarning("Warning: notifying %s: %v", t.URL, err)
+		}
+	}
+}
+
+// postNotification sends n to t in the format of its type.
+func postNotification(t NotifyTarget, n notification) error {
+	var body interface{} = n
+	switch t.kind() {
+	case "slack":
+		body = map[string]string{"text": n.text(3000)}
+	case "discord":
+		// Discord refuses messages longer than 2000 characters.
+		body = map[string]string{"content": n.text(2000)}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", t.URL, resp.Status)
+	}
+	return nil
+}
+
+// summary describes the outcome of the run in a line, followed by the
+// error if it failed.
+func (n notification) summary() string {
+	status := "succeeded"
+	if !n.OK {
+		status = fmt.Sprintf("failed with exit code %d", n.ExitCode)
+	}
+	head := fmt.Sprintf("%s %s on %s (%s, %s)", n.Alias, status, n.Host, n.User, (time.Duration(n.Duration * float64(time.Second))).Round(time.Millisecond))
+	if n.Error != "" && !n.OK {
+		head += "\n" + n.Error
+	}
+	if m := (&Alias{Owner: n.Owner, Team: n.Team}).maintainer(); m != "" && !n.OK {
+		head += "\nMaintained by " + m
+	}
+	return head
+}
+
+// text renders n as a chat message of at most max characters, cutting the
+// output from the front if it doesn't fit.
+func (n notification) text(max int) string {
+	head := n.summary()
+	if len(n.Output) == 0 {
+		return head
+	}
+	output := strings.Join(n.Output, "\n")
+	if room := max - len(head) - 10; len(output) > room {
+		if room < 0 {
+			room = 0
+		}
+		output = output[len(output)-room:]
+	}
+	return head + "\n```\n" + output + "\n```"
+}
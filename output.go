@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+	"golang.org/x/term"
+)
+
+// isTerminal reports whether f is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// terminalSize returns the width and height of the terminal attached to
+// stdout. ok is false when stdout is not a terminal.
+func terminalSize() (width, height int, ok bool) {
+	if !isTerminal(os.Stdout) {
+		return 0, 0, false
+	}
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return 0, 0, false
+	}
+	return width, height, true
+}
+
+// displayWidth returns the number of terminal cells s takes up. It counts
+// characters followed by the emoji variation selector, such as ❤️, as
+// wide, which is how terminals draw them.
+func displayWidth(s string) int {
+	w := runewidth.StringWidth(s)
+	prev := 0
+	for _, r := range s {
+		if r == '\uFE0F' && prev == 1 {
+			w++
+		}
+		prev = runewidth.RuneWidth(r)
+	}
+	return w
+}
+
+// table collects rows of cells and renders them as aligned columns.
+type table struct {
+	rows  [][]string
+	roles map[int]string
+}
+
+func (t *table) add(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// color paints every cell of column col with the theme color for role.
+func (t *table) color(col int, role string) {
+	if t.roles == nil {
+		t.roles = make(map[int]string)
+	}
+	t.roles[col] = role
+}
+
+// lines renders the table. When maxWidth is positive the last column is
+// truncated so that no line is wider than maxWidth cells.
+func (t *table) lines(maxWidth int) []string {
+	var widths []int
+	for _, row := range t.rows {
+		for i, cell := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if w := displayWidth(cell); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+
+	lines := make([]string, 0, len(t.rows))
+	for _, row := range t.rows {
+		var b strings.Builder
+		used := 0
+		for i, cell := range row {
+			if i == len(row)-1 {
+				if maxWidth > 0 {
+					cell = runewidth.Truncate(cell, maxWidth-used, "…")
+				}
+				b.WriteString(paint(t.roles[i], cell))
+				break
+			}
+			b.WriteString(paint(t.roles[i], cell))
+			b.WriteString(strings.Repeat(" ", widths[i]-displayWidth(cell)+2))
+			used += widths[i] + 2
+		}
+		lines = append(lines, strings.TrimRight(b.String(), " "))
+	}
+	return lines
+}
+
+// printLines writes lines to stdout, piping them through $PAGER when stdout
+// is a terminal and the output would not fit on one screen.
+func printLines(lines []string) {
+	_, height, ok := terminalSize()
+	if !ok || height <= 0 || len(lines) < height || page(lines) != nil {
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+	}
+}
+
+// page feeds lines to the user's pager, defaulting to less. An error is only
+// returned when the pager could not be started.
+func page(lines []string) error {
+	pager := strings.Fields(os.Getenv("PAGER"))
+	if len(pager) == 0 {
+		pager = []string{"less"}
+	}
+	cmd := exec.Command(pager[0], pager[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if os.Getenv("LESS") == "" {
+		cmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if _, err := io.WriteString(in, line+"\n"); err != nil {
+			// The user quit the pager before reading everything.
+			break
+		}
+	}
+	in.Close()
+	cmd.Wait()
+	return nil
+}
+
+// outputFormats are the values of the --output flag of list and history.
+var outputFormats = []string{"table", "csv", "tsv"}
+
+// checkOutput validates an --output value, which is one of outputFormats or
+// extra, and the --columns selected from the columns available, returning
+// the columns to write.
+func checkOutput(format string, extra, columns, available, defaults []string) ([]string, error) {
+	if !contains(outputFormats, format) && !contains(extra, format) {
+		return nil, usageError(fmt.Errorf("invalid --output %q (use %s)", format, strings.Join(append(outputFormats, extra...), ", ")))
+	}
+	if len(columns) == 0 {
+		return defaults, nil
+	}
+	if format != "csv" && format != "tsv" {
+		return nil, usageError(fmt.Errorf("--columns needs --output csv or tsv"))
+	}
+	for _, c := range columns {
+		if !contains(available, c) {
+			return nil, usageError(fmt.Errorf("unknown column %q (use %s)", c, strings.Join(available, ", ")))
+		}
+	}
+	return columns, nil
+}
+
+// writeDelimited writes a header and rows to stdout as CSV or, for format
+// "tsv", tab-separated values.
+func writeDelimited(format string, header []string, rows [][]string) error {
+	w := csv.NewWriter(os.Stdout)
+	if format == "tsv" {
+		w.Comma = '\t'
+	}
+	w.Write(header)
+	w.WriteAll(rows)
+	return w.Error()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	goruntime "runtime"
+	"sort"
+	"strings"
+)
+
+// knownOSes are the operating systems accepted as platform variant keys.
+var knownOSes = map[string]bool{
+	"darwin": true, "linux": true, "windows": true,
+	"freebsd": true, "openbsd": true, "netbsd": true,
+}
+
+// validPlatform reports whether key names an OS or a "host:<name>" variant.
+func validPlatform(key string) bool {
+	if host, ok := strings.CutPrefix(key, "host:"); ok {
+		return host != ""
+	}
+	return knownOSes[key]
+}
+
+// platform returns the variant key and command a runs on this machine: a
+// variant for the current hostname wins over one for the OS, which wins over
+// the default command.
+func (a *Alias) platform() (key, command string) {
+	if len(a.Platforms) > 0 {
+		if host, err := os.Hostname(); err == nil {
+			if c, ok := a.Platforms["host:"+host]; ok {
+				return "host:" + host, c
+			}
+		}
+		if c, ok := a.Platforms[goruntime.GOOS]; ok {
+			return goruntime.GOOS, c
+		}
+	}
+	return "", a.Command
+}
+
+// command returns the command line a runs on this machine.
+func (a *Alias) command() string {
+	_, c := a.platform()
+	return c
+}
+
+// platformKeys returns the variant keys of a, sorted.
+func (a *Alias) platformKeys() []string {
+	keys := make([]string, 0, len(a.Platforms))
+	for k := range a.Platforms {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// errNoPlatformCommand reports that a has variants, none for this machine.
+func errNoPlatformCommand(a *Alias) error {
+	host, _ := os.Hostname()
+	return fmt.Errorf("alias %s has no command for %s on host %s (variants: %s)",
+		a.Name, goruntime.GOOS, host, strings.Join(a.platformKeys(), ", "))
+}
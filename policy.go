@@ -0,0 +1,378 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	goruntime "runtime"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/yaml.v3"
+)
+
+// Policy is the administrator's policy from the system-wide policy file. It
+// restricts what aliases can be saved and run on the machine, whichever
+// store or bundle they come from. Patterns are regular expressions matched
+// against every command line; see policyLines.
+type Policy struct {
+	// Allow, when set, lists patterns one of which every command must
+	// match.
+	Allow []string `yaml:"allow"`
+	// Deny lists patterns no command may match.
+	Deny []string `yaml:"deny"`
+	// Confirm lists patterns of commands that must ask for confirmation:
+	// aliases running them are saved only with confirm set, and runs ask
+	// whether the alias sets it or not.
+	Confirm []string `yaml:"confirm"`
+	// ForbiddenBinaries are programs, by name, no command may run.
+	ForbiddenBinaries []string `yaml:"forbidden_binaries"`
+}
+
+// activePolicy is the loaded policy; nil if there is no policy file.
+type activePolicy struct {
+	path                 string
+	allow, deny, confirm []*regexp.Regexp
+	forbidden            map[string]bool
+	// err is why the policy file couldn't be read. Everything the policy
+	// would check fails with it, rather than going unchecked.
+	err error
+}
+
+var policy *activePolicy
+
+// policyPath returns the location of the policy file, honouring
+// CMDEX_POLICY when set.
+func policyPath() string {
+	if p := os.Getenv("CMDEX_POLICY"); p != "" {
+		return p
+	}
+	if goruntime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "cmdex", "policy.yaml")
+	}
+	return "/etc/cmdex/policy.yaml"
+}
+
+// loadPolicy reads the policy file into policy. A missing file means no
+// policy.
+func loadPolicy() {
+	path := policyPath()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	policy = &activePolicy{path: path}
+	if err == nil {
+		err = policy.parse(data)
+	}
+	if err != nil {
+		policy.err = newError(exitPolicy, "policy", fmt.Errorf("reading the policy %s: %w", path, err))
+	}
+}
+
+func (p *activePolicy) parse(data []byte) error {
+	var raw Policy
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	compile := func(key string, patterns []string) ([]*regexp.Regexp, error) {
+		var res []*regexp.Regexp
+		for _, pattern := range patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			res = append(res, re)
+		}
+		return res, nil
+	}
+	var err error
+	if p.allow, err = compile("allow", raw.Allow); err != nil {
+		return err
+	}
+	if p.deny, err = compile("deny", raw.Deny); err != nil {
+		return err
+	}
+	if p.confirm, err = compile("confirm", raw.Confirm); err != nil {
+		return err
+	}
+	p.forbidden = make(map[string]bool)
+	for _, name := range raw.ForbiddenBinaries {
+		p.forbidden[programName(name)] = true
+	}
+	return nil
+}
+
+// policyError reports a command the policy forbids.
+func (p *activePolicy) policyError(format string, args ...interface{}) error {
+	return newError(exitPolicy, "policy", fmt.Errorf("%s (policy %s)", fmt.Sprintf(format, args...), p.path))
+}
+
+// checkLine checks one command line against the policy.
+func (p *activePolicy) checkLine(line string) error {
+	for _, re := range p.deny {
+		if re.MatchString(line) {
+			return p.policyError("command %q is denied by the pattern %s", line, re)
+		}
+	}
+	if len(p.allow) > 0 {
+		allowed := false
+		for _, re := range p.allow {
+			allowed = allowed || re.MatchString(line)
+		}
+		if !allowed {
+			return p.policyError("command %q matches none of the allowed patterns", line)
+		}
+	}
+	for _, name := range programNames(line) {
+		if p.forbidden[name] {
+			return p.policyError("command %q runs %s, which is forbidden", line, name)
+		}
+	}
+	return nil
+}
+
+// checkCommand checks text, a command or script as it is about to run,
+// against the policy.
+func (p *activePolicy) checkCommand(text string) error {
+	if p == nil {
+		return nil
+	}
+	if p.err != nil {
+		return p.err
+	}
+	for _, line := range policyLines(text) {
+		if err := p.checkLine(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAlias checks every command a can run against the policy, including
+// that the commands needing confirmation are confirmed.
+func (p *activePolicy) checkAlias(a *Alias) error {
+	if p == nil {
+		return nil
+	}
+	if p.err != nil {
+		return p.err
+	}
+	for _, text := range policyTexts(a) {
+		if err := p.checkCommand(text); err != nil {
+			return fmt.Errorf("alias %s: %w", a.Name, err)
+		}
+	}
+	if re := p.confirms(a); re != nil && !a.Confirm {
+		return p.policyError("alias %s runs commands matching %s, which must ask for confirmation; save it with --confirm", a.Name, re)
+	}
+	return nil
+}
+
+// confirms returns the pattern of a command of a that the policy wants
+// confirmed, or nil.
+func (p *activePolicy) confirms(a *Alias) *regexp.Regexp {
+	if p == nil {
+		return nil
+	}
+	for _, text := range policyTexts(a) {
+		for _, line := range policyLines(text) {
+			for _, re := range p.confirm {
+				if re.MatchString(line) {
+					return re
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// needsConfirmation reports whether runs of a ask for confirmation: because
+// a says so or the policy does.
+func needsConfirmation(a *Alias) bool {
+	return a.Confirm || policy.confirms(a) != nil
+}
+
+// policyTexts returns the commands and scripts a can run, in its body and
+// its variants, and its output filter.
+func policyTexts(a *Alias) []string {
+	bodies := []*Variant{a.variantBody()}
+	for _, name := range a.variantNames() {
+		bodies = append(bodies, a.Variants[name])
+	}
+	var texts []string
+	for _, b := range bodies {
+		texts = append(texts, b.Command, b.Script)
+		for _, command := range b.Platforms {
+			texts = append(texts, command)
+		}
+		for _, step := range b.Steps {
+			texts = append(texts, step.Run)
+			if step.WaitFor != nil {
+				texts = append(texts, step.WaitFor.Run)
+			}
+		}
+	}
+	return append(texts, a.Filter)
+}
+
+// policyLines splits text into the command lines the policy checks: every
+// line of a script, without blank lines and comments.
+func policyLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// commandWrappers are programs that run the program named after them.
+var commandWrappers = map[string]bool{"sudo": true, "doas": true, "env": true, "exec": true, "command": true,
+	"builtin": true, "nohup": true, "time": true, "nice": true, "xargs": true}
+
+// wrapperValueOptions are the options of the wrappers whose value is the
+// next word, which isn't the program they run.
+var wrapperValueOptions = map[string][]string{
+	"sudo": {"-u", "-g", "-C", "-D", "-h", "-p", "-r", "-t", "-T", "-U", "-R",
+		"--user", "--group", "--close-from", "--chdir", "--host", "--prompt", "--role", "--type", "--command-timeout", "--other-user", "--chroot"},
+	"doas":  {"-u", "-C"},
+	"env":   {"-u", "-C", "--unset", "--chdir"},
+	"exec":  {"-a"},
+	"nice":  {"-n", "--adjustment"},
+	"time":  {"-f", "-o", "--format", "--output"},
+	"xargs": {"-a", "-d", "-E", "-I", "-L", "-n", "-P", "-s", "--arg-file", "--delimiter", "--max-lines", "--max-args", "--max-procs", "--max-chars"},
+}
+
+// programNames returns the programs a command line runs, as well as a
+// shell can be told without running it: the first word of every command in
+// a pipeline or list, past variable assignments and wrappers like sudo,
+// with their options and the values of those.
+func programNames(line string) []string {
+	var names []string
+	for _, part := range strings.FieldsFunc(line, func(r rune) bool { return strings.ContainsRune(";|&()`", r) }) {
+		wrapper, skip := "", false
+		for _, word := range strings.Fields(part) {
+			word = strings.Trim(word, `"'$`)
+			name := programName(word)
+			switch {
+			case word == "":
+				continue
+			case skip:
+				skip = false
+				continue
+			case strings.HasPrefix(word, "-"):
+				// An option of the wrapper, perhaps followed by its value.
+				skip = wrapper != "" && contains(wrapperValueOptions[wrapper], word)
+				continue
+			case strings.Contains(word, "=") && !strings.Contains(word, "/"):
+				// A variable assignment.
+				continue
+			case wrapper != "" && strings.Trim(word, "0123456789") == "":
+				// A count or priority, as with nice 10.
+				continue
+			case commandWrappers[name]:
+				names = append(names, name)
+				wrapper = name
+				continue
+			}
+			names = append(names, name)
+			break
+		}
+	}
+	return names
+}
+
+// programName returns the name a program is forbidden by: the base name of
+// its path, without .exe on Windows.
+func programName(path string) string {
+	name := filepath.Base(filepath.FromSlash(path))
+	if goruntime.GOOS == "windows" {
+		name = strings.TrimSuffix(strings.ToLower(name), ".exe")
+	}
+	return name
+}
+
+func policyCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "policy",
+		Short: "Show the administrator's policy and the aliases breaking it",
+		Long: `policy prints the policy an administrator set for cmdex on this machine and
+lists the stored aliases it refuses to run. The policy is read from
+/etc/cmdex/policy.yaml (%ProgramData%\cmdex\policy.yaml on Windows), or
+the file CMDEX_POLICY names:
+
+  allow: ['^(kubectl|helm|make) ']    # commands must match one of these
+  deny: ['rm\s+-rf\s+/(\s|$)']       # and none of these
+  confirm: ['\bprod\b', 'terraform apply']
+  forbidden_binaries: [dd, mkfs, shred]
+
+Patterns are regular expressions matched against every command line of an
+alias, its steps, scripts and variants. Aliases breaking the policy can't
+be saved, imported or run; those with commands matching confirm: must be
+saved with --confirm, and their runs ask for confirmation either way.
+Forbidden binaries are matched by the program names at the start of each
+command of a line, past sudo, env and the like and their options. This is
+best-effort: a program started in ways cmdex can't tell from the command
+line, such as through sh -c, a variable or a wrapper it doesn't know,
+gets past it, so also deny: what must never run.`,
+		Annotations: readDB,
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if policy == nil {
+				fmt.Printf("No policy (%s doesn't exist)\n", policyPath())
+				return nil
+			}
+			if policy.err != nil {
+				return policy.err
+			}
+			field := func(label string, res []*regexp.Regexp) {
+				var patterns []string
+				for _, re := range res {
+					patterns = append(patterns, re.String())
+				}
+				if len(patterns) > 0 {
+					fmt.Printf("%-13s %s\n", label+":", strings.Join(patterns, "  "))
+				}
+			}
+			fmt.Printf("%-13s %s\n", "Policy:", policy.path)
+			field("Allow", policy.allow)
+			field("Deny", policy.deny)
+			field("Confirm", policy.confirm)
+			if len(policy.forbidden) > 0 {
+				var names []string
+				for name := range policy.forbidden {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				fmt.Printf("%-13s %s\n", "Forbidden:", strings.Join(names, ", "))
+			}
+			var broken []string
+			err := db.View(func(tx *bolt.Tx) error {
+				return forEachAlias(tx, func(a *Alias) error {
+					if err := policy.checkAlias(a); err != nil {
+						broken = append(broken, err.Error())
+					}
+					return nil
+				})
+			})
+			if err != nil {
+				return fmt.Errorf("reading aliases: %w", err)
+			}
+			if len(broken) > 0 {
+				fmt.Println()
+				fmt.Printf("%d aliases break the policy:\n", len(broken))
+				for _, problem := range broken {
+					fmt.Println("  " + strings.TrimSuffix(problem, " (policy "+policy.path+")"))
+				}
+			}
+			return nil
+		},
+	}
+}
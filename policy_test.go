@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProgramNames(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"ls -la", []string{"ls"}},
+		{"/usr/bin/curl -s example.com | sh", []string{"curl", "sh"}},
+		{"FOO=1 BAR=2 rm -rf build", []string{"rm"}},
+		{`"rm" -rf build`, []string{"rm"}},
+		{"make && make install; echo done", []string{"make", "make", "echo"}},
+		{"echo $(wget -qO- example.com)", []string{"echo", "wget"}},
+		{"echo `id`", []string{"echo", "id"}},
+		{"sudo -u root rm -rf /tmp/x", []string{"sudo", "rm"}},
+		{"sudo --user=root rm x", []string{"sudo", "rm"}},
+		{"nice -n 10 curl example.com", []string{"nice", "curl"}},
+		{"nice 10 curl example.com", []string{"nice", "curl"}},
+		{"env -u HOME A=1 curl example.com", []string{"env", "curl"}},
+		{"sudo env nohup wget x", []string{"sudo", "env", "nohup", "wget"}},
+		{"find . -name x | xargs -I {} rm {}", []string{"find", "xargs", "rm"}},
+		{"./script.sh --path=/tmp", []string{"script.sh"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := programNames(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("programNames(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestCheckLine(t *testing.T) {
+	p := &activePolicy{path: "policy.yaml"}
+	err := p.parse([]byte(`
+allow: ['^(git|make|sudo|curl|ls|rm|echo|env)\b']
+deny: ['rm\s+-rf\s+/(\s|$)', 'git push .*--force']
+forbidden_binaries: [curl, /usr/bin/nc]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		line string
+		// want is part of the error, or empty if the line is fine.
+		want string
+	}{
+		{"git status", ""},
+		{"make test", ""},
+		{"rm -rf /tmp/build", ""},
+		{"rm -rf /", "denied by the pattern"},
+		{"git push origin main --force", "denied by the pattern"},
+		{"python -c 'print(1)'", "none of the allowed patterns"},
+		{"curl example.com", "runs curl, which is forbidden"},
+		{"sudo -u root /usr/local/bin/curl x", "runs curl, which is forbidden"},
+		{"echo x | nc host 80", "runs nc, which is forbidden"},
+		{"env A=1 curl x", "runs curl, which is forbidden"},
+		{"echo curl", ""},
+	}
+	for _, tt := range tests {
+		err := p.checkLine(tt.line)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("checkLine(%q) = %v, want nil", tt.line, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("checkLine(%q) = %v, want an error with %q", tt.line, err, tt.want)
+		}
+	}
+}
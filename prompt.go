@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// stdin is shared by all prompts so buffered input is not lost between them.
+var stdin = bufio.NewReader(os.Stdin)
+
+// promptsDisabled turns off all interactive prompts, for example in the
+// daemon where nobody is at the terminal.
+var promptsDisabled bool
+
+// interactive reports whether the user can answer prompts.
+func interactive() bool {
+	return !promptsDisabled && isTerminal(os.Stdin)
+}
+
+// ask prints label, translated, and reads one line of input. An empty
+// answer selects def.
+func ask(label, def string) (string, error) {
+	label = tr(label)
+	if def != "" {
+		fmt.Printf("%s [%s]: ", label, def)
+	} else {
+		fmt.Printf("%s: ", label)
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+// confirm asks a yes/no question, returning def on an empty answer. The
+// English answers are understood in every locale.
+func confirm(label string, def bool) (bool, error) {
+	hint := tr("y/N")
+	if def {
+		hint = tr("Y/n")
+	}
+	for {
+		answer, err := ask(fmt.Sprintf("%s [%s]", tr(label), hint), "")
+		if err != nil {
+			return false, err
+		}
+		switch answer = strings.ToLower(answer); answer {
+		case "":
+			return def, nil
+		case "y", "yes", tr("y"), tr("yes"):
+			return true, nil
+		case "n", "no", tr("n"), tr("no"):
+			return false, nil
+		}
+	}
+}
+
+// readKey reads a single key press without waiting for Enter.
+func readKey() (rune, error) {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return 0, err
+	}
+	defer term.Restore(fd, state)
+	r, _, err := stdin.ReadRune()
+	return r, err
+}
+
+// askPassphrase reads a passphrase without echoing it, asking twice when
+// twice is set. CMDEX_PASSPHRASE, when set, is used instead so that scripts
+// can supply it.
+func askPassphrase(label string, twice bool) (string, error) {
+	if p := os.Getenv("CMDEX_PASSPHRASE"); p != "" {
+		return p, nil
+	}
+	if !interactive() {
+		return "", errorf("a passphrase is needed; set CMDEX_PASSPHRASE to run non-interactively")
+	}
+	read := func(label string) (string, error) {
+		fmt.Fprintf(os.Stderr, "%s: ", label)
+		p, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		return string(p), err
+	}
+	label = tr(label)
+	p, err := read(label)
+	if err != nil {
+		return "", err
+	}
+	if p == "" {
+		return "", errorf("empty passphrase")
+	}
+	if twice {
+		again, err := read(trf("Repeat %s", strings.ToLower(label[:1])+label[1:]))
+		if err != nil {
+			return "", err
+		}
+		if again != p {
+			return "", errorf("passphrases don't match")
+		}
+	}
+	return p, nil
+}
+
+// editText opens the user's editor on initial and returns the saved text.
+func editText(initial string) (string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	f, err := os.CreateTemp("", "cmdex-*.sh")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(initial)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	parts := strings.Fields(editor)
+	cmd := exec.Command(parts[0], append(parts[1:], f.Name())...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("running editor: %w", err)
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\n"), nil
+}
+
+// splitList parses a comma-separated answer into trimmed, non-empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
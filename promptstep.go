@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PromptStep is a built-in step that asks the user a question in the middle
+// of a sequence. The answer is registered for the steps after it.
+type PromptStep struct {
+	Message string `json:"message" yaml:"message"`
+	// Choices, when set, are the allowed answers, picked by value or by
+	// number.
+	Choices []string `json:"choices,omitempty" yaml:"choices,omitempty"`
+	// Confirm makes it a yes/no question, answered as true or false. A
+	// confirmation without register: stops the sequence on no.
+	Confirm bool `json:"confirm,omitempty" yaml:"confirm,omitempty"`
+	// Default is the answer to an empty reply, and the answer when nobody
+	// is there to ask.
+	Default string `json:"default,omitempty" yaml:"default,omitempty"`
+}
+
+// validate checks the settings of a prompt step registered as register.
+func (p *PromptStep) validate(register string) error {
+	switch {
+	case p.Message == "":
+		return fmt.Errorf("prompt needs a message")
+	case p.Confirm && len(p.Choices) > 0:
+		return fmt.Errorf("prompt can't both confirm and offer choices")
+	case p.Confirm && p.Default != "":
+		if _, err := strconv.ParseBool(p.Default); err != nil {
+			return fmt.Errorf("the default of a confirm prompt is true or false, not %q", p.Default)
+		}
+	case len(p.Choices) > 0 && p.Default != "" && !contains(p.Choices, p.Default):
+		return fmt.Errorf("prompt default %q is not one of its choices", p.Default)
+	case !p.Confirm && register == "":
+		return fmt.Errorf("prompt needs a register: name for its answer")
+	}
+	return nil
+}
+
+// runPrompt performs a prompt step, returning the answer. A confirmation
+// that isn't registered fails on no.
+func (s *sequence) runPrompt(p *PromptStep, register string) (string, error) {
+	message, err := s.render(p.Message)
+	if err != nil {
+		return "", err
+	}
+	if s.tty != nil {
+		return "", usageError(fmt.Errorf("prompt steps can't run with --pty, which passes the terminal to the commands"))
+	}
+	// Events on stdout leave no room for the question.
+	if !interactive() || (s.events != nil && s.events.toStdout) {
+		if p.Default == "" {
+			return "", fmt.Errorf("%q needs an answer and there is no terminal to ask on; give the prompt a default", message)
+		}
+		s.trace(verbosityVerbose, "%s: %s (default)", message, p.Default)
+		if p.Default == "false" && register == "" {
+			return "", stepFailed(fmt.Errorf("stopped: no to %q", message))
+		}
+		return p.Default, nil
+	}
+
+	switch {
+	case p.Confirm:
+		def, _ := strconv.ParseBool(p.Default)
+		ok, err := confirm(message, def)
+		if err != nil {
+			return "", err
+		}
+		if !ok && register == "" {
+			return "", stepFailed(fmt.Errorf("stopped: no to %q", message))
+		}
+		return strconv.FormatBool(ok), nil
+	case len(p.Choices) > 0:
+		for i, c := range p.Choices {
+			fmt.Printf("  %d) %s\n", i+1, c)
+		}
+		for {
+			answer, err := ask(message, p.Default)
+			if err != nil {
+				return "", err
+			}
+			if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(p.Choices) {
+				return p.Choices[n-1], nil
+			}
+			if contains(p.Choices, answer) {
+				return answer, nil
+			}
+			fmt.Println(trf("Pick one of 1-%d or type a value: %s", len(p.Choices), strings.Join(p.Choices, ", ")))
+		}
+	}
+	for {
+		answer, err := ask(message, p.Default)
+		if err != nil {
+			return "", err
+		}
+		if answer != "" {
+			return answer, nil
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// Provenance records how an alias came into the store, so that a command
+// imported from elsewhere can be told from one saved by hand and judged
+// accordingly.
+type Provenance struct {
+	// Source is how the alias was created: save, new, exec, suggest,
+	// import, api, layer or deprecate.
+	Source string `json:"source"`
+	// From is what it was created from: the archive imported, the history
+	// a suggestion came from, the layer copied or the API client.
+	From string `json:"from,omitempty"`
+	// User, Host and Version are who created it, on which machine, with
+	// which version of cmdex.
+	User    string `json:"user,omitempty"`
+	Host    string `json:"host,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// newProvenance returns the provenance of an alias created now by source
+// from from.
+func newProvenance(source, from string) *Provenance {
+	p := &Provenance{Source: source, From: from, User: currentUser()}
+	p.Host, _ = os.Hostname()
+	p.Version, _, _ = buildInfo()
+	return p
+}
+
+// provenanceSources describe the sources of aliases for cmdex show.
+var provenanceSources = map[string]string{
+	"save":      "saved with cmdex save",
+	"new":       "created with cmdex new",
+	"exec":      "saved after cmdex exec",
+	"suggest":   "saved from a suggestion",
+	"import":    "imported",
+	"api":       "saved through the API",
+	"layer":     "copied from a layer",
+	"deprecate": "created as a redirect by cmdex deprecate",
+}
+
+func (p *Provenance) String() string {
+	s, ok := provenanceSources[p.Source]
+	if !ok {
+		s = p.Source
+	}
+	if p.From != "" {
+		s += " from " + p.From
+	}
+	var by []string
+	if p.User != "" {
+		by = append(by, "by "+p.User)
+	}
+	if p.Host != "" {
+		by = append(by, "on "+p.Host)
+	}
+	if p.Version != "" {
+		by = append(by, "with cmdex "+p.Version)
+	}
+	if len(by) > 0 {
+		s += ", " + strings.Join(by, " ")
+	}
+	return s
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// retention is how much of the history is kept; zero values keep
+// everything.
+type retention struct {
+	// perAlias keeps this many of the latest runs of every alias.
+	perAlias int
+	// before drops the runs older than this.
+	before time.Time
+	// maxSize drops the oldest runs once the history, with its artifacts,
+	// takes up more bytes than this.
+	maxSize uint64
+}
+
+func (r retention) empty() bool {
+	return r.perAlias <= 0 && r.before.IsZero() && r.maxSize == 0
+}
+
+// configuredRetention returns the retention of history.max_entries,
+// history.max_age and history.max_size in the config.
+func configuredRetention() (retention, error) {
+	h := currentConfig().History
+	r := retention{perAlias: h.MaxEntries}
+	if h.MaxAge != "" {
+		before, err := parseSince(h.MaxAge)
+		if err != nil {
+			return r, fmt.Errorf("history.max_age: %w", err)
+		}
+		r.before = before
+	}
+	if h.MaxSize != "" {
+		n, err := parseBytes(h.MaxSize)
+		if err != nil {
+			return r, fmt.Errorf("history.max_size: %w", err)
+		}
+		r.maxSize = n
+	}
+	return r, nil
+}
+
+// pruneHistory deletes the runs r doesn't keep, of alias only if it's set,
+// returning them with the bytes they took up. Their artifacts are removed
+// too unless dryRun is set, which deletes nothing.
+func pruneHistory(tx *bolt.Tx, r retention, alias string, dryRun bool) ([]*historyEntry, uint64, error) {
+	var (
+		pruned []*historyEntry
+		keys   [][]byte
+		total  uint64
+		freed  uint64
+		counts = make(map[string]int)
+	)
+	err := forEachHistoryRecord(tx, func(k, v []byte, e *historyEntry) {
+		size := uint64(len(k) + len(v) + len(tx.Bucket(outputBucket).Get(k)))
+		if e.Artifacts != "" {
+			size += dirSize(e.Artifacts)
+		}
+		total += size
+		counts[e.label()]++
+		if alias != "" && e.label() != alias {
+			return
+		}
+		if (r.perAlias > 0 && counts[e.label()] > r.perAlias) ||
+			(!r.before.IsZero() && e.Time.Before(r.before)) ||
+			(r.maxSize > 0 && total > r.maxSize) {
+			pruned = append(pruned, e)
+			keys = append(keys, append([]byte(nil), k...))
+			freed += size
+			// Pruned runs no longer count towards the size.
+			total -= size
+		}
+	})
+	if err != nil || dryRun {
+		return pruned, freed, err
+	}
+	b := tx.Bucket(historyBucket)
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return nil, 0, err
+		}
+		if err := deleteOutput(tx, k); err != nil {
+			return nil, 0, err
+		}
+	}
+	for _, e := range pruned {
+		if e.Artifacts != "" {
+			if err := os.RemoveAll(e.Artifacts); err != nil {
+				printWarning("Warning: removing artifacts: %v", err)
+			}
+		}
+	}
+	return pruned, freed, nil
+}
+
+// forEachHistoryRecord calls fn for every history entry with its stored
+// key and value, newest first.
+func forEachHistoryRecord(tx *bolt.Tx, fn func(k, v []byte, e *historyEntry)) error {
+	c := tx.Bucket(historyBucket).Cursor()
+	for k, v := c.Last(); k != nil; k, v = c.Prev() {
+		e, err := decodeHistory(k, v)
+		if err != nil {
+			return err
+		}
+		fn(k, v, e)
+	}
+	return nil
+}
+
+// enforceRetention prunes the history to the configured retention after a
+// run is recorded. A bad setting is reported but doesn't fail the run.
+func enforceRetention(tx *bolt.Tx) error {
+	r, err := configuredRetention()
+	if err != nil {
+		printWarning("Warning: %v", err)
+		return nil
+	}
+	if r.empty() {
+		return nil
+	}
+	pruned, _, err := pruneHistory(tx, r, "", false)
+	if err != nil || len(pruned) == 0 {
+		return err
+	}
+	return writeAudit(tx, auditEntry{Action: "history-prune", Detail: fmt.Sprintf("%d runs (retention)", len(pruned))})
+}
+
+// dirSize returns the bytes taken up by the files under dir.
+func dirSize(dir string) uint64 {
+	var n uint64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			n += uint64(info.Size())
+		}
+		return nil
+	})
+	return n
+}
+
+// formatBytes renders n bytes for people, as in 1.5 MiB.
+func formatBytes(n uint64) string {
+	if n < 1<<10 {
+		return fmt.Sprintf("%d B", n)
+	}
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	v, i := float64(n)/(1<<10), 0
+	for v >= 1<<10 && i < len(units)-1 {
+		v, i = v/(1<<10), i+1
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
+
+func historyPruneCmd() *cobra.Command {
+	var (
+		olderThan string
+		keep      int
+		maxSize   string
+		alias     string
+		dryRun    bool
+	)
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Delete old runs from the history",
+		Long: `prune deletes runs from the history, along with their recorded output and
+artifacts, and then compacts the database to give the space back. Without
+flags it applies the retention configured in the config, which is also
+enforced every time a run is recorded:
+
+  history:
+    max_entries: 100   # the latest runs kept of every alias
+    max_age: 90d       # runs older than this are dropped
+    max_size: 50M      # the oldest runs go once the history is larger
+
+The flags prune by the same rules instead, once.`,
+		Example: "  cmdex history prune --older-than 90d\n  cmdex history prune --keep 10 --alias deploy\n  cmdex history prune --max-size 20M --dry-run",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var r retention
+			flags := cmd.Flags()
+			if flags.Changed("older-than") || flags.Changed("keep") || flags.Changed("max-size") {
+				r.perAlias = keep
+				if olderThan != "" {
+					before, err := parseSince(olderThan)
+					if err != nil {
+						return usageError(fmt.Errorf("--older-than: %w", err))
+					}
+					r.before = before
+				}
+				if maxSize != "" {
+					n, err := parseBytes(maxSize)
+					if err != nil {
+						return usageError(fmt.Errorf("--max-size: %w", err))
+					}
+					r.maxSize = n
+				}
+			} else {
+				var err error
+				if r, err = configuredRetention(); err != nil {
+					return err
+				}
+			}
+			if r.empty() {
+				return usageError(fmt.Errorf("nothing to prune by; pass --older-than, --keep or --max-size, or set history.max_entries, max_age or max_size in the config"))
+			}
+
+			var (
+				pruned []*historyEntry
+				freed  uint64
+			)
+			update := db.Update
+			if dryRun {
+				update = db.View
+			}
+			err := update(func(tx *bolt.Tx) error {
+				var err error
+				if pruned, freed, err = pruneHistory(tx, r, alias, dryRun); err != nil || dryRun || len(pruned) == 0 {
+					return err
+				}
+				return writeAudit(tx, auditEntry{Action: "history-prune", Target: alias, Detail: fmt.Sprintf("%d runs", len(pruned))})
+			})
+			if err != nil {
+				return fmt.Errorf("pruning history: %w", err)
+			}
+			if dryRun {
+				for _, e := range pruned {
+					fmt.Printf("%d\t%s\t%s\n", e.ID, e.Time.Local().Format("2006-01-02 15:04:05"), e.label())
+				}
+				fmt.Printf("Would prune %d runs (%s)\n", len(pruned), formatBytes(freed))
+				return nil
+			}
+			if len(pruned) > 0 {
+				if err := compactDB(); err != nil {
+					return fmt.Errorf("compacting database: %w", err)
+				}
+			}
+			fmt.Printf("Pruned %d runs (%s)\n", len(pruned), formatBytes(freed))
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&olderThan, "older-than", "", "Delete the runs older than this (e.g. 90d, 12h or 2024-01-31)")
+	cmd.Flags().IntVar(&keep, "keep", 0, "Keep only this many of the latest runs of every alias")
+	cmd.Flags().StringVar(&maxSize, "max-size", "", "Delete the oldest runs until the history, with its artifacts, fits in this size (e.g. 50M)")
+	cmd.Flags().StringVar(&alias, "alias", "", "Only prune the runs of this alias, or (exec) for those of cmdex exec")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "List the runs that would be deleted without deleting them")
+	return cmd
+}
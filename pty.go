@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/term"
+)
+
+// capture sets up --tee, --capture and --pty for a run, and returns a
+// function that finishes the copying once the sequence has run. The
+// commands run on a pseudo-terminal with --pty, or when their output is
+// teed or recorded from a terminal, so that they still see a terminal;
+// otherwise a teed or recorded stdout and stderr are copied on their way
+// through.
+func (s *sequence) capture(opts runOptions) (func() error, error) {
+	var (
+		out      io.Writer = os.Stdout
+		log      *os.File
+		logged   io.Writer
+		captured io.Writer
+		closeLog = func() error { return nil }
+		// The recorded output goes through redactors, flushed once the
+		// run finishes.
+		redactors []*redactWriter
+		redacted  = func(w io.Writer) io.Writer {
+			if len(s.secrets) == 0 {
+				return w
+			}
+			r := newRedactWriter(w, s.secrets)
+			redactors = append(redactors, r)
+			return r
+		}
+	)
+	if opts.tee != "" {
+		var err error
+		if log, err = os.Create(expandHome(opts.tee)); err != nil {
+			return nil, fmt.Errorf("opening --tee file: %w", err)
+		}
+		logged = redacted(log)
+		out, closeLog = io.MultiWriter(os.Stdout, logged), log.Close
+		if s.log, err = filepath.Abs(log.Name()); err != nil {
+			s.log = log.Name()
+		}
+	}
+	if opts.capture || currentConfig().History.Capture {
+		s.output = &outputTail{full: new(bytes.Buffer), limit: maxCapture}
+		captured = redacted(s.output)
+		out = io.MultiWriter(out, captured)
+	}
+	if len(redactors) > 0 {
+		closeFile := closeLog
+		closeLog = func() error {
+			for _, r := range redactors {
+				if err := r.flush(); err != nil {
+					closeFile()
+					return err
+				}
+			}
+			return closeFile()
+		}
+	}
+	if opts.pty && s.alias.hasStdin() {
+		closeLog()
+		return nil, usageError(fmt.Errorf("--pty: alias %s feeds its own stdin", s.alias.Name))
+	}
+	if opts.pty && s.events != nil {
+		closeLog()
+		return nil, usageError(fmt.Errorf("--pty can't be combined with --events"))
+	}
+	if opts.pty || ((log != nil || s.output != nil) && isTerminal(os.Stdout) && !s.alias.hasStdin() && s.events == nil) {
+		detach, err := s.attachPTY(out)
+		if err == nil {
+			return func() error {
+				detach()
+				return closeLog()
+			}, nil
+		}
+		if opts.pty {
+			closeLog()
+			return nil, fmt.Errorf("--pty: %w", err)
+		}
+		if log == nil {
+			// Recording the output alone isn't worth the commands losing
+			// their terminal.
+			s.output = nil
+		}
+	}
+	if s.events != nil {
+		s.progress = s.stderr
+		s.stdout, s.stderr = s.events.output("stdout", s.stdout), s.events.output("stderr", s.stderr)
+	}
+	if log != nil {
+		s.stdout = io.MultiWriter(s.stdout, logged)
+		s.stderr = io.MultiWriter(s.stderr, logged)
+	}
+	if s.output != nil {
+		s.stdout = io.MultiWriter(s.stdout, captured)
+		s.stderr = io.MultiWriter(s.stderr, captured)
+	}
+	return closeLog, nil
+}
+
+// attachPTY runs the sequence's commands on a new pseudo-terminal whose
+// output is relayed to out. When stdin is a terminal as well, it's put in
+// raw mode and what's typed is relayed to the commands, which get the
+// pseudo-terminal as their controlling terminal: full-screen and
+// line-editing programs like vim, ssh or docker run -it work as usual,
+// Ctrl-C included. The returned function stops the relaying once the
+// commands have exited and restores the terminal.
+func (s *sequence) attachPTY(out io.Writer) (func(), error) {
+	interactive := isTerminal(os.Stdin)
+	master, slave, err := openPTY(interactive)
+	if err != nil {
+		return nil, err
+	}
+	s.stdout, s.stderr = slave, slave
+	done := make(chan struct{})
+	go func() {
+		// Reading ends with an error once every command has exited and
+		// slave is closed.
+		io.Copy(out, master)
+		close(done)
+	}()
+	restore := func() {}
+	if interactive {
+		fd := int(os.Stdin.Fd())
+		if state, err := term.MakeRaw(fd); err == nil {
+			s.tty = slave
+			stopResize, stopInput := watchResize(slave), relayInput(master)
+			restore = func() {
+				stopInput()
+				stopResize()
+				term.Restore(fd, state)
+			}
+		}
+	}
+	return func() {
+		slave.Close()
+		<-done
+		restore()
+		master.Close()
+	}, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY allocates a pseudo-terminal with the size of the terminal on
+// stdout, if there is one. Unless it's interactive, its output is passed
+// through as written, without the translation of "\n" to "\r\n" that a
+// terminal in raw mode would need.
+func openPTY(interactive bool) (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("unlocking pseudo-terminal: %w", err)
+	}
+	n, err := unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("naming pseudo-terminal: %w", err)
+	}
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	if !interactive {
+		sfd := int(slave.Fd())
+		if t, err := unix.IoctlGetTermios(sfd, unix.TCGETS); err == nil {
+			t.Oflag &^= unix.OPOST
+			unix.IoctlSetTermios(sfd, unix.TCSETS, t)
+		}
+	}
+	resizePTY(slave)
+	return master, slave, nil
+}
+
+// resizePTY gives the pseudo-terminal slave the size of the terminal on
+// stdout.
+func resizePTY(slave *os.File) {
+	if ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ); err == nil {
+		unix.IoctlSetWinsize(int(slave.Fd()), unix.TIOCSWINSZ, ws)
+	}
+}
+
+// watchResize keeps the size of slave in step with the terminal until the
+// returned function is called.
+func watchResize(slave *os.File) func() {
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, unix.SIGWINCH)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-winch:
+				resizePTY(slave)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(winch)
+		close(done)
+	}
+}
+
+// ptyAttr makes the command's stdin, the pseudo-terminal, its controlling
+// terminal, in a session of its own.
+func ptyAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+}
+
+// relayInput copies stdin to w until the returned function is called,
+// which leaves any input not yet read on stdin, for the prompts of cmdex
+// shell.
+func relayInput(w io.Writer) func() {
+	var stop [2]int
+	if err := unix.Pipe2(stop[:], unix.O_CLOEXEC); err != nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		in := int(os.Stdin.Fd())
+		fds := []unix.PollFd{{Fd: int32(in), Events: unix.POLLIN}, {Fd: int32(stop[0]), Events: unix.POLLIN}}
+		buf := make([]byte, 4096)
+		for {
+			if _, err := unix.Poll(fds, -1); err != nil {
+				if err == unix.EINTR {
+					continue
+				}
+				return
+			}
+			if fds[1].Revents != 0 {
+				return
+			}
+			n, err := unix.Read(in, buf)
+			if n <= 0 || err != nil {
+				return
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+	return func() {
+		unix.Write(stop[1], []byte{0})
+		<-done
+		unix.Close(stop[0])
+		unix.Close(stop[1])
+	}
+}
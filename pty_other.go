@@ -0,0 +1,23 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// Pseudo-terminals are only implemented on Linux; elsewhere commands
+// always write to pipes.
+
+func openPTY(interactive bool) (master, slave *os.File, err error) {
+	return nil, nil, fmt.Errorf("pseudo-terminals are only supported on Linux")
+}
+
+func watchResize(slave *os.File) func() { return func() {} }
+
+func ptyAttr() *syscall.SysProcAttr { return nil }
+
+func relayInput(w io.Writer) func() { return func() {} }
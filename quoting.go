@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// shellOperators are the words that only a shell gives a meaning to.
+var shellOperators = map[string]bool{
+	"|": true, "||": true, "&&": true, "&": true, ";": true, ">": true, ">>": true, "<": true,
+	"2>": true, "2>&1": true, "&>": true, "<<": true, "<<<": true, "|&": true,
+}
+
+var shellVarPattern = regexp.MustCompile(`\$\{?[A-Za-z_][A-Za-z0-9_]*\}?|\$\(|` + "`")
+
+// quotingAudit is what's wrong with the quoting of one command line, which
+// runs without a shell, split at whitespace.
+type quotingAudit struct {
+	problems []string
+	// fixed is the command with the problems corrected, if dropping its
+	// quotes does; otherwise shell says whether running it with sh would.
+	fixed string
+	shell bool
+}
+
+// auditQuoting checks command for what a shell would interpret and cmdex
+// passes on as it is: quotes, variables, globs and operators.
+func auditQuoting(command string) quotingAudit {
+	var (
+		audit                  quotingAudit
+		quoted, expanded, ops  []string
+		fixed                  []string
+		program                string
+		unbalanced, needsShell bool
+	)
+	// Templates are expanded before the command is split into words.
+	templates := templatePattern.FindAllString(command, -1)
+	masked := templatePattern.ReplaceAllString(command, "\x00")
+	for i, w := range strings.Fields(masked) {
+		n := strings.Count(w, "\x00")
+		own := templates[:n]
+		templates = templates[n:]
+		word, bare := restoreTemplates(w, own), strings.ReplaceAll(w, "\x00", "")
+		if i == 0 {
+			program = programName(word)
+		}
+		switch {
+		case shellOperators[bare] || strings.HasSuffix(bare, ";") && bare != ";" || strings.HasPrefix(bare, ">") || strings.HasPrefix(bare, "2>"):
+			ops = append(ops, word)
+			needsShell = true
+		case shellVarPattern.MatchString(bare):
+			expanded = append(expanded, word)
+			needsShell = true
+		case strings.ContainsAny(bare, "*?[") && !strings.Contains(bare, "://") && bare != "[" && bare != "[[":
+			expanded = append(expanded, word)
+			needsShell = true
+		}
+		if strings.ContainsAny(bare, `"'`) {
+			quoted = append(quoted, word)
+			if unquoted, ok := unquoteWord(w); ok {
+				word = restoreTemplates(unquoted, own)
+			} else {
+				needsShell = true
+			}
+		}
+		fixed = append(fixed, word)
+	}
+	for _, q := range []string{`"`, "'"} {
+		if strings.Count(masked, q)%2 == 1 {
+			unbalanced = true
+			audit.problems = append(audit.problems, fmt.Sprintf("unbalanced %s quote", q))
+		}
+	}
+	if len(quoted) > 0 && !unbalanced {
+		audit.problems = append(audit.problems, fmt.Sprintf("the quotes in %s are passed to %s as they are and don't group words", strings.Join(quoted, " "), program))
+	}
+	if len(expanded) > 0 {
+		audit.problems = append(audit.problems, fmt.Sprintf("%s %s passed to %s as written, never expanded", strings.Join(expanded, " "), isAre(len(expanded)), program))
+	}
+	if len(ops) > 0 {
+		audit.problems = append(audit.problems, fmt.Sprintf("%s %s passed to %s as arguments", strings.Join(ops, " "), isAre(len(ops)), program))
+	}
+	switch {
+	case len(audit.problems) == 0 || unbalanced:
+	case needsShell:
+		audit.shell = true
+	default:
+		audit.fixed = strings.Join(fixed, " ")
+	}
+	return audit
+}
+
+// restoreTemplates puts templates back in place of the NULs of word.
+func restoreTemplates(word string, templates []string) string {
+	for _, t := range templates {
+		word = strings.Replace(word, "\x00", t, 1)
+	}
+	return word
+}
+
+// unquoteWord strips the quotes around word, if they are all it has.
+func unquoteWord(word string) (string, bool) {
+	if len(word) < 2 {
+		return "", false
+	}
+	q, inner := word[0], word[1:len(word)-1]
+	if (q != '"' && q != '\'') || word[len(word)-1] != q || strings.ContainsAny(inner, `"'`) {
+		return "", false
+	}
+	if inner == "" || q == '"' && strings.ContainsAny(inner, "$`\\") {
+		return "", false
+	}
+	return inner, true
+}
+
+func isAre(n int) string {
+	if n == 1 {
+		return "is"
+	}
+	return "are"
+}
+
+// heredocPattern matches the start of a heredoc, capturing its delimiter.
+var heredocPattern = regexp.MustCompile(`<<-?\s*['"]?([A-Za-z_][A-Za-z0-9_]*)['"]?`)
+
+// unbalancedShellQuote returns the line of the shell script body on which
+// a quote opens that never closes, or 0 if all of them do. Comments and
+// heredocs don't count.
+func unbalancedShellQuote(body string) int {
+	var (
+		quote   rune
+		opened  int
+		heredoc string
+		lines   = strings.Split(body, "\n")
+	)
+	for n, line := range lines {
+		if heredoc != "" {
+			if strings.TrimSpace(line) == heredoc {
+				heredoc = ""
+			}
+			continue
+		}
+		if quote == 0 {
+			if m := heredocPattern.FindStringSubmatch(line); m != nil {
+				heredoc = m[1]
+			}
+		}
+		escaped := false
+	chars:
+		for i, r := range line {
+			switch {
+			case escaped:
+				escaped = false
+			case r == '\\' && quote != '\'':
+				escaped = true
+			case quote != 0:
+				if r == quote {
+					quote = 0
+				}
+			case r == '\'' || r == '"':
+				quote, opened = r, n+1
+			case r == '#' && (i == 0 || strings.ContainsRune(" \t;", rune(line[i-1]))):
+				// The rest of the line is a comment.
+				break chars
+			}
+		}
+	}
+	if quote != 0 {
+		return opened
+	}
+	return 0
+}
+
+// lintQuoting flags commands quoted as if a shell ran them, and the
+// unbalanced quotes of shell scripts.
+func lintQuoting(a *Alias) []lintFinding {
+	if a.isScript() {
+		shell := a.Runtime == "sh" || a.Runtime == "bash"
+		if a.Runtime == "" {
+			argv := scriptInterpreter(a.Script)
+			if programName(argv[0]) == "env" && len(argv) > 1 {
+				argv = argv[1:]
+			}
+			shell = shells[programName(argv[0])]
+		}
+		if line := unbalancedShellQuote(a.body()); shell && line > 0 {
+			return []lintFinding{{message: fmt.Sprintf("the quote opened on line %d of the script never closes", line)}}
+		}
+		return nil
+	}
+	var findings []lintFinding
+	check := func(where, command string, fix func(a *Alias, fixed string)) {
+		audit := auditQuoting(command)
+		if len(audit.problems) == 0 {
+			return
+		}
+		f := lintFinding{message: where + strings.Join(audit.problems, "; ") + "; commands run without a shell"}
+		switch fixed := audit.fixed; {
+		case fixed != "":
+			if where == "" {
+				f.suggestion = shQuote(fixed)
+			} else {
+				f.message += " (use " + fixed + ")"
+			}
+			f.fix = func(tx *bolt.Tx, a *Alias) error {
+				fix(a, fixed)
+				return nil
+			}
+		case audit.shell && where == "" && len(a.Steps) == 0 && len(a.Platforms) == 0:
+			f.suggestion = "--runtime sh " + shQuote(command)
+			f.fix = func(tx *bolt.Tx, a *Alias) error {
+				a.Runtime = "sh"
+				return nil
+			}
+		}
+		findings = append(findings, f)
+	}
+	if a.Command != "" {
+		check("", a.Command, func(a *Alias, fixed string) { a.Command = fixed })
+	}
+	for _, key := range a.platformKeys() {
+		key := key
+		check("for "+key+": ", a.Platforms[key], func(a *Alias, fixed string) { a.Platforms[key] = fixed })
+	}
+	for i, step := range a.Steps {
+		i := i
+		if step.kind() == "run" {
+			check(fmt.Sprintf("step %d: ", i+1), step.Run, func(a *Alias, fixed string) { a.Steps[i].Run = fixed })
+		}
+	}
+	return findings
+}
+
+// expandedVars are the variables whose values in a saved command suggest
+// the shell expanded them on the cmdex save command line.
+var expandedVars = []string{"PWD", "HOME", "USER"}
+
+// savedExpansions returns the variables the shell likely expanded in
+// command before cmdex saw it, by the values they left behind.
+func savedExpansions(command string) []string {
+	var names []string
+	for _, name := range expandedVars {
+		value := os.Getenv(name)
+		if len(value) < 3 {
+			continue
+		}
+		for _, word := range strings.Fields(command) {
+			if word == value || (name != "USER" && strings.HasPrefix(word, value+"/")) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// warnQuoting warns about the quoting problems of alias, just saved as a,
+// with what to save instead. fromArgs says its command came from the
+// command line, where the shell may have expanded variables already.
+func warnQuoting(alias string, a *Alias, fromArgs bool) {
+	for _, f := range lintQuoting(a) {
+		printWarning("Warning: alias %s: %s", alias, f.message)
+		if f.suggestion != "" {
+			printWarning("  did you mean: cmdex save %s %s", alias, f.suggestion)
+		}
+	}
+	if !fromArgs {
+		return
+	}
+	for _, name := range savedExpansions(a.body()) {
+		value := os.Getenv(name)
+		printWarning("Warning: alias %s: %s is the value of $%s; if you meant $%s itself, your shell expanded it while saving", alias, value, name, name)
+		printWarning("  did you mean: cmdex save %s --runtime sh %s", alias, shQuote(strings.ReplaceAll(a.body(), value, "$"+name)))
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// corruptDBError reports a database file that can't be read as a bolt
+// database, or not all of it.
+type corruptDBError struct {
+	path string
+	err  error
+}
+
+func (e *corruptDBError) Error() string {
+	return fmt.Sprintf("database %s is damaged: %v", e.path, e.err)
+}
+
+func (e *corruptDBError) Unwrap() error { return e.err }
+
+// errDamagedPage is why reading a page bolt panicked on failed.
+var errDamagedPage = errors.New("damaged page")
+
+// damaged marks the errors of reading path that mean the file is corrupt.
+func damaged(path string, err error) error {
+	switch {
+	case errors.Is(err, errDamagedPage), errors.Is(err, bolt.ErrInvalid), errors.Is(err, bolt.ErrChecksum), errors.Is(err, bolt.ErrVersionMismatch):
+	case err != nil && err.Error() == "file size too small":
+	default:
+		return err
+	}
+	return &corruptDBError{path: path, err: err}
+}
+
+// guarded runs fn, turning the panics with which bolt meets a damaged page
+// into errors.
+func guarded(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", errDamagedPage, r)
+		}
+	}()
+	return fn()
+}
+
+// bucketLabels name the buckets in the recovery report.
+var bucketLabels = map[string]string{
+	"commands":  "aliases",
+	"vars":      "variables",
+	"audit":     "audit log entries",
+	"meta":      "settings",
+	"history":   "history runs",
+	"answers":   "remembered answers",
+	"blobs":     "scripts",
+	"approvals": "approval requests",
+	"output":    "recorded outputs",
+	"bases":     "imported versions",
+	"cooldowns": "cooldowns of layered aliases",
+}
+
+// salvagedBucket is what salvageDB read of one bucket.
+type salvagedBucket struct {
+	name string
+	keys int
+	// err is why the rest of the bucket couldn't be read, if it couldn't.
+	err error
+}
+
+func (b salvagedBucket) label() string {
+	if label := bucketLabels[b.name]; label != "" {
+		return label
+	}
+	return b.name
+}
+
+// salvageDB copies every key of the database at from it can still read to
+// a new database at to, bucket by bucket. A bucket whose pages are damaged
+// is copied up to the first key that can't be read. The indexes are left
+// out, to be rebuilt from the aliases.
+func salvageDB(from, to string) ([]salvagedBucket, error) {
+	// A copy is read, as an open that failed halfway may still hold the
+	// lock of the damaged file.
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return nil, err
+	}
+	copied := to + ".damaged"
+	if err := os.WriteFile(copied, data, 0600); err != nil {
+		return nil, err
+	}
+	defer os.Remove(copied)
+	var src *bolt.DB
+	err = guarded(func() error {
+		var err error
+		src, err = bolt.Open(copied, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	dst, err := bolt.Open(to, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	defer dst.Close()
+
+	skip := map[string]bool{string(idxOutputBucket): true}
+	for _, name := range indexBuckets {
+		skip[string(name)] = true
+	}
+	var report []salvagedBucket
+	err = guarded(func() error {
+		return src.View(func(tx *bolt.Tx) error {
+			var names [][]byte
+			err := guarded(func() error {
+				return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+					names = append(names, append([]byte(nil), name...))
+					return nil
+				})
+			})
+			if err != nil && len(names) == 0 {
+				return err
+			}
+			for _, name := range names {
+				if skip[string(name)] {
+					continue
+				}
+				r := salvagedBucket{name: string(name)}
+				err := dst.Update(func(out *bolt.Tx) error {
+					b, err := out.CreateBucketIfNotExists(name)
+					if err != nil {
+						return err
+					}
+					r.err = guarded(func() error { return copyBucket(tx.Bucket(name), b, &r.keys) })
+					if bytes.Equal(name, metaBucket) {
+						return b.Delete(indexesKey)
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				report = append(report, r)
+			}
+			return nil
+		})
+	})
+	return report, err
+}
+
+// copyBucket copies the keys and nested buckets of src to dst, counting
+// the keys in n. Past a damaged page, it copies what it can read backwards
+// from the last key.
+func copyBucket(src, dst *bolt.Bucket, n *int) error {
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	copyKey := func(k, v []byte) error {
+		if v == nil {
+			sub, err := dst.CreateBucketIfNotExists(k)
+			if err != nil {
+				return err
+			}
+			return copyBucket(src.Bucket(k), sub, n)
+		}
+		if err := dst.Put(k, v); err != nil {
+			return err
+		}
+		*n++
+		return nil
+	}
+	var last []byte
+	err := guarded(func() error {
+		c := src.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			last = k
+			if err := copyKey(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if !errors.Is(err, errDamagedPage) {
+		return err
+	}
+	guarded(func() error {
+		c := src.Cursor()
+		for k, v := c.Last(); k != nil && (last == nil || bytes.Compare(k, last) > 0); k, v = c.Prev() {
+			if err := copyKey(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// checkDB reports whether the database at path is intact: it opens, and
+// every key of every bucket can be read.
+func checkDB(path string) error {
+	d, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		return damaged(path, err)
+	}
+	defer d.Close()
+	var walk func(b *bolt.Bucket) error
+	walk = func(b *bolt.Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return walk(b.Bucket(k))
+			}
+			return nil
+		})
+	}
+	err = guarded(func() error {
+		return d.View(func(tx *bolt.Tx) error {
+			return tx.ForEach(func(_ []byte, b *bolt.Bucket) error { return walk(b) })
+		})
+	})
+	return damaged(path, err)
+}
+
+// recoverDB salvages what can be read of the damaged database at path into
+// a new database in its place, moves the damaged file aside and prints
+// what was recovered.
+func recoverDB(path string) error {
+	stamp := time.Now().Format("20060102-150405")
+	fresh := path + ".recovering"
+	os.Remove(fresh)
+	report, salvageErr := salvageDB(path, fresh)
+	aside := path + ".corrupt-" + stamp
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(aside); os.IsNotExist(err) {
+			break
+		}
+		aside = fmt.Sprintf("%s.corrupt-%s-%d", path, stamp, i)
+	}
+	if err := os.Rename(path, aside); err != nil {
+		os.Remove(fresh)
+		return fmt.Errorf("moving the damaged database aside: %w", err)
+	}
+	fmt.Printf("Moved %s aside to %s\n", path, aside)
+	if salvageErr != nil && len(report) == 0 {
+		os.Remove(fresh)
+		fmt.Printf("Nothing could be read from it (%v); starting over with an empty database\n", salvageErr)
+		if backups, _ := filepath.Glob(path + ".v*.bak"); len(backups) > 0 {
+			sort.Strings(backups)
+			fmt.Printf("The latest backup from cmdex db migrate is %s; copy it to %s to restore it\n", backups[len(backups)-1], path)
+		}
+		return nil
+	}
+	if err := os.Rename(fresh, path); err != nil {
+		return fmt.Errorf("putting the recovered database in place: %w", err)
+	}
+	var t table
+	incomplete := 0
+	for _, b := range report {
+		state := "complete"
+		if b.err != nil {
+			state = fmt.Sprintf("incomplete: %v", b.err)
+			incomplete++
+		}
+		t.add("  "+b.label(), fmt.Sprint(b.keys), state)
+	}
+	fmt.Printf("Recovered into %s:\n", path)
+	printLines(t.lines(0))
+	if salvageErr != nil {
+		fmt.Printf("Reading stopped early: %v\n", salvageErr)
+	} else if incomplete > 0 {
+		fmt.Printf("%d buckets could only be read in part; the rest is lost\n", incomplete)
+	}
+	return nil
+}
+
+// offerRecovery turns an error opening a damaged database into a recovery,
+// if the user agrees to it, and returns err otherwise.
+func offerRecovery(err error) error {
+	var corrupt *corruptDBError
+	if !errors.As(err, &corrupt) {
+		return err
+	}
+	if !interactive() {
+		return fmt.Errorf("%w; run cmdex db recover to salvage what can be read", err)
+	}
+	printWarning("Warning: %v", err)
+	ok, cerr := confirm(trf("Salvage what can be read of %s into a new database?", corrupt.path), true)
+	if cerr != nil || !ok {
+		return fmt.Errorf("%w; run cmdex db recover to salvage what can be read", err)
+	}
+	return recoverDB(corrupt.path)
+}
+
+func dbRecoverCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "recover",
+		Short: "Salvage what can be read of a damaged database",
+		Long: `recover checks the database and, if it is damaged, copies every key that can
+still be read to a new database, moves the damaged file aside as
+cmdex.db.corrupt-<time> and puts the new one in its place. It prints how
+many aliases, variables, history runs and other records were recovered,
+and which could only be read in part. The indexes are rebuilt from the
+recovered aliases.
+
+cmdex offers the same when a command finds the database damaged and a
+terminal is there to ask; scripts get an error pointing here, and exit
+with status 14.`,
+		Annotations: noDB,
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := dbPath()
+			if _, err := os.Stat(path); err != nil {
+				return fmt.Errorf("recovering database: %w", err)
+			}
+			err := checkDB(path)
+			if err == nil {
+				fmt.Printf("The database %s is intact; nothing to recover\n", path)
+				return nil
+			}
+			// Only damage is recovered from: a database that is locked
+			// or can't be opened for another reason is left alone.
+			var corrupt *corruptDBError
+			if !errors.As(err, &corrupt) {
+				return err
+			}
+			printWarning("Warning: %v", err)
+			if err := recoverDB(path); err != nil {
+				return err
+			}
+			if err := openDB(); err != nil {
+				return fmt.Errorf("opening the recovered database: %w", err)
+			}
+			defer closeDB()
+			return db.Update(func(tx *bolt.Tx) error {
+				return writeAudit(tx, auditEntry{Action: "db-recover"})
+			})
+		},
+	}
+}
+
+// executeGuarded runs the command tree of root, reporting the panics with
+// which bolt meets a damaged database as errors.
+func executeGuarded(ctx context.Context, root *cobra.Command) (cmd *cobra.Command, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if !bytes.Contains(debug.Stack(), []byte("go.etcd.io/bbolt")) {
+				panic(r)
+			}
+			err = fmt.Errorf("%w; run cmdex db recover to salvage what can be read", &corruptDBError{path: dbPath(), err: fmt.Errorf("%w: %v", errDamagedPage, r)})
+		}
+	}()
+	return root.ExecuteContextC(ctx)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// minRedacted is the length below which values aren't redacted, as they
+// would turn up all over ordinary output.
+const minRedacted = 6
+
+// redactedMark replaces the secrets in recorded output and commands.
+const redactedMark = "******"
+
+// sensitiveName reports whether the variable name holds a secret: its name
+// looks like one, or matches a pattern of history.redact in the config.
+func sensitiveName(name string) bool {
+	return isSecretVar(name) || envAllowed(name, currentConfig().History.Redact)
+}
+
+// secretValues returns the values to keep out of the output the run of s
+// records: those of its sensitive environment variables and stored
+// variables, longest first.
+func (s *sequence) secretValues() ([]string, error) {
+	seen := make(map[string]bool)
+	var values []string
+	add := func(name, value string) {
+		if !sensitiveName(name) {
+			return
+		}
+		// Multi-line values, like keys, are redacted line by line.
+		for _, line := range strings.Split(value, "\n") {
+			if line = strings.TrimSpace(line); len(line) >= minRedacted && !seen[line] {
+				seen[line] = true
+				values = append(values, line)
+			}
+		}
+	}
+	env := s.env
+	if env == nil {
+		env = os.Environ()
+	}
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		add(name, value)
+	}
+	for name, value := range s.data.vars {
+		add(name, value)
+	}
+	if db != nil {
+		err := db.View(func(tx *bolt.Tx) error {
+			return forEachVar(tx, func(name, value string) error {
+				add(name, value)
+				return nil
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	return values, nil
+}
+
+// holdsSecret reports whether v contains one of the secrets the run of s
+// redacts.
+func (s *sequence) holdsSecret(v string) bool {
+	for _, secret := range s.secrets {
+		if strings.Contains(v, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactWriter replaces secrets in what it passes on to w. It holds back
+// the end of a line that could be the start of a secret until the rest
+// arrives, or flush is called.
+type redactWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	replacer *strings.Replacer
+	longest  int
+	pending  string
+}
+
+func newRedactWriter(w io.Writer, secrets []string) *redactWriter {
+	pairs := make([]string, 0, 2*len(secrets))
+	for _, secret := range secrets {
+		pairs = append(pairs, secret, redactedMark)
+	}
+	return &redactWriter{w: w, replacer: strings.NewReplacer(pairs...), longest: len(secrets[0])}
+}
+
+func (r *redactWriter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	text := r.replacer.Replace(r.pending + string(p))
+	// Secrets have no newlines, so only a line's last bytes can be the
+	// beginning of one.
+	cut := len(text) - (r.longest - 1)
+	if nl := strings.LastIndexByte(text, '\n'); nl+1 > cut {
+		cut = nl + 1
+	}
+	if cut < 0 {
+		cut = 0
+	}
+	r.pending = text[cut:]
+	if _, err := io.WriteString(r.w, text[:cut]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// flush passes on what is held back.
+func (r *redactWriter) flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	text := r.pending
+	r.pending = ""
+	_, err := io.WriteString(r.w, text)
+	return err
+}
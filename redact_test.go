@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		secrets []string
+		writes  []string
+		want    string
+	}{
+		{"whole", []string{"hunter22"}, []string{"pw hunter22\n"}, "pw ******\n"},
+		{"split", []string{"hunter22"}, []string{"pw hunt", "er22 ok\n"}, "pw ****** ok\n"},
+		{"bytes", []string{"hunter22"}, strings.Split("a hunter22 b", ""), "a ****** b"},
+		{"longest first", []string{"abcdefgh", "abcdef"}, []string{"x abcdefgh abcdef\n"}, "x ****** ******\n"},
+		{"prefix at the end", []string{"hunter22"}, []string{"hunt"}, "hunt"},
+		{"prefix before a newline", []string{"hunter22"}, []string{"hunt\n", "er22\n"}, "hunt\ner22\n"},
+		{"no secret", []string{"hunter22"}, []string{"plain ", "output\n"}, "plain output\n"},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		r := newRedactWriter(&out, tt.secrets)
+		for _, w := range tt.writes {
+			if n, err := r.Write([]byte(w)); n != len(w) || err != nil {
+				t.Errorf("%s: Write(%q) = %d, %v", tt.name, w, n, err)
+			}
+			for _, secret := range tt.secrets {
+				if strings.Contains(out.String(), secret) {
+					t.Errorf("%s: %q passed on before the flush", tt.name, out.String())
+				}
+			}
+		}
+		if err := r.flush(); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, out.String(), tt.want)
+		}
+	}
+}
+
+func TestSensitiveName(t *testing.T) {
+	cfgMu.Lock()
+	cfg.History.Redact = []string{"MY_*", "DATABASE_URL"}
+	cfgMu.Unlock()
+	defer func() { cfg = Config{} }()
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"GITHUB_TOKEN", true},
+		{"api_key", true},
+		{"DB_PASSWORD", true},
+		{"AWS_SECRET_ACCESS_KEY", true},
+		{"MY_VALUE", true},
+		{"DATABASE_URL", true},
+		{"DATABASE_URL_2", false},
+		{"HOME", false},
+		{"PATH", false},
+		{"XMY_VALUE", false},
+	}
+	for _, tt := range tests {
+		if got := sensitiveName(tt.name); got != tt.want {
+			t.Errorf("sensitiveName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Layout of the report's SVG charts, in pixels.
+const (
+	timelineWidth = 800
+	timelineRow   = 22
+	timelineLabel = 140
+	sparkWidth    = 160
+	sparkHeight   = 24
+	// sparkRuns is how many of an alias's latest runs its duration trend
+	// shows.
+	sparkRuns = 50
+)
+
+// reportAlias summarises the runs of one alias.
+type reportAlias struct {
+	Name string
+	// Maintainer is who maintains the alias; see Alias.maintainer.
+	Maintainer string
+	Runs       int
+	Failures   int
+	Total      time.Duration
+	Max        time.Duration
+	Last       time.Time
+	// Y is the row of the alias in the timeline.
+	Y int
+	// Trend holds the points of the duration sparkline.
+	Trend string
+	runs  []*historyEntry
+}
+
+func (r *reportAlias) FailureRate() string {
+	return fmt.Sprintf("%.0f%%", 100*float64(r.Failures)/float64(r.Runs))
+}
+
+func (r *reportAlias) Average() time.Duration {
+	return (r.Total / time.Duration(r.Runs)).Round(time.Millisecond)
+}
+
+// reportDot is one run in the timeline.
+type reportDot struct {
+	X, Y   int
+	Failed bool
+	Title  string
+}
+
+type reportData struct {
+	Generated   time.Time
+	From, To    time.Time
+	Runs        int
+	Failures    int
+	Aliases     []*reportAlias
+	Dots        []reportDot
+	Width       int
+	Height      int
+	LabelWidth  int
+	SparkWidth  int
+	SparkHeight int
+}
+
+func (d *reportData) FailureRate() string {
+	if d.Runs == 0 {
+		return "0%"
+	}
+	return fmt.Sprintf("%.0f%%", 100*float64(d.Failures)/float64(d.Runs))
+}
+
+// buildReport aggregates history entries, given oldest first, naming the
+// maintainers of the aliases by their labels.
+func buildReport(entries []*historyEntry, maintainers map[string]string) *reportData {
+	d := &reportData{
+		Generated: time.Now(), Runs: len(entries),
+		Width: timelineWidth, LabelWidth: timelineLabel,
+		SparkWidth: sparkWidth, SparkHeight: sparkHeight,
+	}
+	if len(entries) == 0 {
+		return d
+	}
+	d.From, d.To = entries[0].Time, entries[len(entries)-1].Time
+	byName := make(map[string]*reportAlias)
+	for _, e := range entries {
+		r := byName[e.label()]
+		if r == nil {
+			r = &reportAlias{Name: e.label(), Maintainer: maintainers[e.label()]}
+			byName[e.label()] = r
+			d.Aliases = append(d.Aliases, r)
+		}
+		r.Runs++
+		if e.ExitCode != 0 {
+			r.Failures++
+			d.Failures++
+		}
+		r.Total += e.Duration
+		if e.Duration > r.Max {
+			r.Max = e.Duration
+		}
+		r.Last = e.Time
+		r.runs = append(r.runs, e)
+	}
+	sort.Slice(d.Aliases, func(i, j int) bool { return d.Aliases[i].Name < d.Aliases[j].Name })
+
+	span := d.To.Sub(d.From)
+	plot := timelineWidth - timelineLabel - 10
+	for i, r := range d.Aliases {
+		r.Y = i*timelineRow + timelineRow/2
+		for _, e := range r.runs {
+			x := timelineLabel
+			if span > 0 {
+				x += int(float64(plot) * float64(e.Time.Sub(d.From)) / float64(span))
+			}
+			d.Dots = append(d.Dots, reportDot{
+				X: x, Y: r.Y, Failed: e.ExitCode != 0,
+				Title: fmt.Sprintf("%s %s: exit %d in %s", r.Name, e.Time.Local().Format("2006-01-02 15:04:05"),
+					e.ExitCode, e.Duration.Round(time.Millisecond)),
+			})
+		}
+		r.Trend = sparkline(r.runs, r.Max)
+	}
+	d.Height = len(d.Aliases) * timelineRow
+	return d
+}
+
+// sparkline returns the SVG polyline points charting the durations of the
+// latest runs, scaled to max.
+func sparkline(runs []*historyEntry, max time.Duration) string {
+	if len(runs) > sparkRuns {
+		runs = runs[len(runs)-sparkRuns:]
+	}
+	if len(runs) < 2 || max <= 0 {
+		return ""
+	}
+	points := make([]string, len(runs))
+	for i, e := range runs {
+		x := i * (sparkWidth - 2) / (len(runs) - 1)
+		y := sparkHeight - 2 - int(float64(sparkHeight-4)*float64(e.Duration)/float64(max))
+		points[i] = fmt.Sprintf("%d,%d", x+1, y)
+	}
+	return strings.Join(points, " ")
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"when": func(t time.Time) string { return t.Local().Format("2006-01-02 15:04:05") },
+	"ms":   func(d time.Duration) string { return d.Round(time.Millisecond).String() },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>cmdex run report</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: auto; padding: 1em; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: .2em .5em; text-align: left; }
+td.num { text-align: right; }
+.ok { fill: #2a2; }
+.failed { fill: #d22; }
+.bad { color: #d22; }
+polyline { fill: none; stroke: #36c; stroke-width: 1.5; }
+</style>
+</head>
+<body>
+<h1>cmdex run report</h1>
+<p>Generated {{when .Generated}}.
+{{- if .Runs}} {{.Runs}} runs from {{when .From}} to {{when .To}}, {{.Failures}} failed ({{.FailureRate}}).{{else}} No runs recorded.{{end}}</p>
+{{- if .Runs}}
+<h2>Timeline</h2>
+<svg width="{{.Width}}" height="{{.Height}}" xmlns="http://www.w3.org/2000/svg">
+{{- range .Aliases}}
+<text x="0" y="{{.Y}}" dy=".35em" font-size="12">{{.Name}}</text>
+{{- end}}
+{{- range .Dots}}
+<circle cx="{{.X}}" cy="{{.Y}}" r="4" class="{{if .Failed}}failed{{else}}ok{{end}}"><title>{{.Title}}</title></circle>
+{{- end}}
+</svg>
+<h2>Aliases</h2>
+<table>
+<tr><th>Alias</th><th>Maintainer</th><th>Runs</th><th>Failed</th><th>Failure rate</th><th>Average</th><th>Longest</th><th>Last run</th><th>Duration trend</th></tr>
+{{- range .Aliases}}
+<tr><td>{{.Name}}</td><td>{{.Maintainer}}</td><td class="num">{{.Runs}}</td><td class="num">{{.Failures}}</td><td class="num{{if .Failures}} bad{{end}}">{{.FailureRate}}</td><td class="num">{{ms .Average}}</td><td class="num">{{ms .Max}}</td><td>{{when .Last}}</td>
+<td>{{if .Trend}}<svg width="{{$.SparkWidth}}" height="{{$.SparkHeight}}" xmlns="http://www.w3.org/2000/svg"><polyline points="{{.Trend}}"/></svg>{{end}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`))
+
+func historyReportCmd() *cobra.Command {
+	var (
+		out   string
+		since string
+		alias string
+	)
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Write an HTML report of the run history",
+		Long: `report writes a standalone HTML page summarising the run history: a timeline
+of runs, and per alias the failure rate and a trend of run durations.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var from time.Time
+			if since != "" {
+				var err error
+				if from, err = parseSince(since); err != nil {
+					return usageError(err)
+				}
+			}
+			var entries []*historyEntry
+			maintainers := make(map[string]string)
+			err := db.View(func(tx *bolt.Tx) error {
+				err := forEachHistory(tx, func(e *historyEntry) bool {
+					if e.Time.Before(from) {
+						return false
+					}
+					if alias == "" || e.label() == alias {
+						entries = append(entries, e)
+					}
+					return true
+				})
+				if err != nil {
+					return err
+				}
+				for _, e := range entries {
+					if _, ok := maintainers[e.label()]; !ok && e.Alias != "" {
+						name, _ := splitVariant(e.Alias)
+						if a, err := getAlias(tx, name); err == nil {
+							maintainers[e.label()] = a.maintainer()
+						}
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("reading history: %w", err)
+			}
+			// History is read newest first.
+			for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+				entries[i], entries[j] = entries[j], entries[i]
+			}
+
+			var b bytes.Buffer
+			if err := reportTemplate.Execute(&b, buildReport(entries, maintainers)); err != nil {
+				return err
+			}
+			if out == "" || out == "-" {
+				_, err = os.Stdout.Write(b.Bytes())
+				return err
+			}
+			if err := os.WriteFile(out, b.Bytes(), 0644); err != nil {
+				return fmt.Errorf("writing report: %w", err)
+			}
+			fmt.Fprintf(os.Stderr, "Wrote report of %d runs to %s\n", len(entries), out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&out, "out", "o", "", "Write the report to this file instead of stdout")
+	cmd.Flags().StringVar(&since, "since", "", "Only include runs since this time (e.g. 7d, 36h, 2024-01-31)")
+	cmd.Flags().StringVar(&alias, "alias", "", "Only include runs of this alias")
+	return cmd
+}
@@ -0,0 +1,637 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var placeholderPattern = regexp.MustCompile(`\$(\d+)`)
+
+// runOptions are the per-invocation settings of run.
+type runOptions struct {
+	timeout time.Duration
+	// yes skips the confirmation prompt of aliases that ask for one.
+	yes bool
+	// sandbox confines the command; noNet also cuts its network access.
+	sandbox, noNet bool
+	// limits override the alias's own resource limits.
+	limits Limits
+	// launcher runs without prompts, colors or step headers, for
+	// invocation from desktop launchers.
+	launcher bool
+	// raw skips the alias's output filter.
+	raw bool
+	// snapshot records the environment and working directory in the
+	// history.
+	snapshot bool
+	// remember keeps the placeholder answers given at the prompts for
+	// later runs in the same project; forget drops those kept before.
+	remember, forget bool
+	// tee names a file that receives a copy of the output.
+	tee string
+	// pty runs the commands on a pseudo-terminal, as tee does from a
+	// terminal.
+	pty bool
+	// force runs aliases within their cooldown.
+	force bool
+	// wait waits for the run in progress of an alias that runs one at a
+	// time instead of failing.
+	wait bool
+	// events is the format of the event stream, if any, written to
+	// eventsTo.
+	events, eventsTo string
+	// capture records the output in the history, for history search.
+	capture bool
+	// scheduled marks the runs started by cmdex schedule, which also
+	// notify notify.scheduled.
+	scheduled bool
+	// envClear runs the commands with only the allowed variables of the
+	// environment, those of envAllow included.
+	envClear bool
+	envAllow []string
+}
+
+func runCmd() *cobra.Command {
+	var (
+		copyOnly bool
+		simulate bool
+		tag      string
+		parallel bool
+		each     string
+		canary   bool
+		matrix   bool
+		axes     []string
+		maxPar   int
+		opts     runOptions
+	)
+	cmd := &cobra.Command{
+		Use:         "run <alias> [args...] | run --tag <tag>",
+		Annotations: readDB,
+		Short:       "Run a saved command set",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if tag != "" && len(args) > 0 {
+				return fmt.Errorf("--tag runs every alias with the tag; don't name an alias as well")
+			}
+			if tag != "" {
+				return nil
+			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
+		ValidArgsFunction: completeAliasArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.launcher {
+				// Nobody can answer a prompt from a launcher.
+				promptsDisabled, noColor = true, true
+			}
+			if simulate {
+				if tag != "" || each != "" || matrix || len(axes) > 0 || copyOnly || opts.tee != "" || opts.events != "" {
+					return usageError(fmt.Errorf("--simulate can't be combined with --tag, --each, --matrix, --copy, --tee or --events"))
+				}
+				return simulateRun(cmd.Context(), args[0], args[1:], opts)
+			}
+			if tag != "" {
+				if copyOnly {
+					return usageError(fmt.Errorf("--copy can't be combined with --tag"))
+				}
+				if opts.tee != "" || each != "" || opts.events != "" {
+					return usageError(fmt.Errorf("--tee, --each and --events can't be combined with --tag"))
+				}
+				return runTagged(cmd.Context(), tag, parallel, opts)
+			}
+			if parallel {
+				return usageError(fmt.Errorf("--parallel needs --tag"))
+			}
+			if opts.events != "" && (matrix || len(axes) > 0 || each != "" || copyOnly) {
+				return usageError(fmt.Errorf("--events can't be combined with --matrix, --each or --copy"))
+			}
+			if matrix || len(axes) > 0 {
+				if copyOnly || each != "" || opts.tee != "" || opts.pty {
+					return usageError(fmt.Errorf("--matrix can't be combined with --copy, --each, --tee or --pty"))
+				}
+				return runMatrix(cmd.Context(), args[0], args[1:], axes, maxPar, opts)
+			}
+			if canary && each == "" {
+				return usageError(fmt.Errorf("--canary needs --each"))
+			}
+			if each != "" {
+				if copyOnly || opts.tee != "" || opts.pty {
+					return usageError(fmt.Errorf("--each can't be combined with --copy, --tee or --pty"))
+				}
+				values, err := eachValues(each)
+				if err != nil {
+					return err
+				}
+				return runEach(cmd.Context(), args[0], args[1:], values, canary, opts)
+			}
+			if copyOnly {
+				return copyCommand(args[0], args[1:])
+			}
+			return runCommand(cmd.Context(), args[0], args[1:], opts)
+		},
+	}
+	cmd.Flags().BoolVar(&copyOnly, "copy", false, "Copy the expanded command to the clipboard instead of running it")
+	cmd.Flags().BoolVar(&simulate, "simulate", false, "Show the plan of the run as a tree, with every step expanded and the aliases it runs in turn, without running anything")
+	cmd.Flags().DurationVar(&opts.timeout, "timeout", 0, "Kill the command if it runs longer than this (e.g. 30s, 5m)")
+	cmd.Flags().StringVar(&tag, "tag", "", "Run every alias with this tag instead of a single alias")
+	cmd.Flags().BoolVar(&parallel, "parallel", false, "With --tag, run the aliases at the same time")
+	cmd.Flags().StringVar(&each, "each", "", "Run once for each of these comma-separated values (or the lines of @file), passing the value as the last argument")
+	cmd.Flags().BoolVar(&canary, "canary", false, "With --each, run the first value alone and ask before running the rest")
+	cmd.Flags().BoolVar(&matrix, "matrix", false, "Run once for every combination of the values in the alias's matrix and show a grid of the results")
+	cmd.Flags().StringArrayVar(&axes, "axis", nil, "With --matrix, use these values for a placeholder, as name=value1,value2 (repeatable; implies --matrix)")
+	cmd.Flags().IntVar(&maxPar, "max-parallel", 1, "With --matrix, run up to this many combinations at the same time")
+	cmd.Flags().BoolVarP(&opts.yes, "yes", "y", false, "Don't ask for confirmation before running")
+	cmd.Flags().BoolVar(&opts.wait, "wait", false, "Wait for the run in progress of an alias saved with --lock instead of failing; --timeout includes the wait")
+	cmd.Flags().BoolVar(&opts.force, "force", false, "Run even if the alias ran within its cooldown")
+	cmd.Flags().BoolVar(&opts.sandbox, "sandbox", false, "Run with a clean environment and, where bubblewrap or sandbox-exec is available, a read-only file system outside the working directory")
+	cmd.Flags().BoolVar(&opts.raw, "raw", false, "Show the output without the alias's output filter")
+	cmd.Flags().BoolVar(&opts.remember, "remember", false, "Remember the placeholder values entered at the prompts for later runs in this project")
+	cmd.Flags().BoolVar(&opts.forget, "forget", false, "Forget the placeholder values remembered for this alias in this project")
+	cmd.Flags().BoolVar(&opts.snapshot, "snapshot", false, "Record the environment and working directory in the history, for cmdex history diff")
+	cmd.Flags().StringVar(&opts.tee, "tee", "", "Also write the output, with the values of secret variables redacted, to this file; on a terminal the commands still see a terminal")
+	cmd.Flags().BoolVar(&opts.capture, "capture", false, "Record the output in the history, with the values of secret variables redacted, for cmdex history search")
+	cmd.Flags().BoolVar(&opts.pty, "pty", false, "Run the commands on a pseudo-terminal, for interactive programs like ssh or vim (Linux only)")
+	cmd.Flags().BoolVar(&opts.launcher, "launcher", false, "Run non-interactively with minimal output, for Alfred, Raycast or rofi")
+	cmd.Flags().BoolVar(&opts.noNet, "no-net", false, "Run sandboxed without network access (implies --sandbox)")
+	cmd.Flags().BoolVar(&opts.scheduled, "scheduled", false, "Mark the run as unattended, as cmdex schedule does, also notifying the targets of notify.scheduled")
+	eventFlags(cmd, &opts)
+	envFlags(cmd, &opts)
+	limitFlags(cmd, &opts.limits)
+	return cmd
+}
+
+// envFlags adds the environment flags shared by run and exec.
+func envFlags(cmd *cobra.Command, opts *runOptions) {
+	cmd.Flags().BoolVar(&opts.envClear, "env-clear", false, "Run with only PATH, HOME, LANG and the other variables env_allow in the config lists, plus the alias's env")
+	cmd.Flags().StringArrayVar(&opts.envAllow, "env-allow", nil, "With --env-clear, also pass this variable, or those matching a pattern such as AWS_* (repeatable)")
+}
+
+// eventFlags adds the event stream flags shared by run and exec.
+func eventFlags(cmd *cobra.Command, opts *runOptions) {
+	cmd.Flags().StringVar(&opts.events, "events", "", "Write progress events in this format (jsonl) for wrappers and editors; they include the output")
+	cmd.Flags().StringVar(&opts.eventsTo, "events-to", "-", "Where --events go: - for stdout, in place of the output, fd:N or a file")
+}
+
+// limitFlags adds the resource limit flags shared by save and run.
+func limitFlags(cmd *cobra.Command, l *Limits) {
+	cmd.Flags().StringVar(&l.MaxMem, "max-mem", "", "Limit the memory of the command (e.g. 512M, 1G)")
+	cmd.Flags().StringVar(&l.MaxCPU, "max-cpu", "", "Limit the CPU time of the command (e.g. 30s, 5m)")
+	cmd.Flags().IntVar(&l.MaxFiles, "max-files", 0, "Limit the number of files the command can open")
+	cmd.Flags().IntVar(&l.Nice, "nice", 0, "Run the command at a lower priority (0-19)")
+}
+
+// placeholderCount returns the highest $N referenced by command.
+func placeholderCount(command string) int {
+	max := 0
+	for _, m := range placeholderPattern.FindAllStringSubmatch(command, -1) {
+		if n, _ := strconv.Atoi(m[1]); n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+// resolveArgs fills in the arguments missing from args for the placeholders
+// a's command references, using each placeholder's default or, failing that,
+// asking the user. Remembered answers, by placeholder number, pre-fill the
+// prompts and stand in for them when nobody can answer. The answers the
+// user gave are added to s.answers.
+func (s *sequence) resolveArgs(a *Alias, args []string, remembered map[int]string) ([]string, error) {
+	if err := checkChoices(a, args); err != nil {
+		return nil, err
+	}
+	n := placeholderCount(a.allCommands())
+	if len(args) >= n {
+		return args, nil
+	}
+	resolved := append([]string(nil), args...)
+	for i := len(args); i < n; i++ {
+		var ph Placeholder
+		if i < len(a.Placeholders) {
+			ph = a.Placeholders[i]
+		}
+		if ph.Default != "" {
+			resolved = append(resolved, ph.Default)
+			continue
+		}
+		if !interactive() {
+			if value, ok := remembered[i+1]; ok {
+				resolved = append(resolved, value)
+				continue
+			}
+			// Leave the gap for expandCommand to report.
+			return resolved, nil
+		}
+		label := fmt.Sprintf("$%d", i+1)
+		if ph.Name != "" {
+			label += " " + ph.Name
+		}
+		if ph.Description != "" {
+			label += " (" + ph.Description + ")"
+		}
+		if len(ph.Choices) > 0 {
+			label += " [" + strings.Join(ph.Choices, "|") + "]"
+		}
+		value, err := ask(label, remembered[i+1])
+		if err != nil {
+			return nil, err
+		}
+		if s.answers == nil {
+			s.answers = make(map[int]string)
+		}
+		s.answers[i+1] = value
+		resolved = append(resolved, value)
+	}
+	return resolved, checkChoices(a, resolved)
+}
+
+// checkChoices rejects arguments outside the allowed values of their
+// placeholder.
+func checkChoices(a *Alias, args []string) error {
+	for i, arg := range args {
+		if i >= len(a.Placeholders) {
+			break
+		}
+		ph := a.Placeholders[i]
+		if len(ph.Choices) > 0 && !contains(ph.Choices, arg) {
+			name := fmt.Sprintf("$%d", i+1)
+			if ph.Name != "" {
+				name += " (" + ph.Name + ")"
+			}
+			return usageError(fmt.Errorf("invalid value %q for %s: use one of %s", arg, name, strings.Join(ph.Choices, ", ")))
+		}
+	}
+	return nil
+}
+
+// expansionPattern matches both $N placeholders and {{...}} templates, so
+// a command can be expanded in one pass and substituted values are never
+// themselves expanded.
+var expansionPattern = regexp.MustCompile(`\$(\d+)|\{\{\s*(.*?)\s*\}\}`)
+
+// expandCommand replaces the $1, $2, ... placeholders in command with args
+// and evaluates its templates. It fails if the command references a
+// placeholder with no matching argument.
+func expandCommand(command string, args []string, data *templateData) (string, error) {
+	var (
+		missing  []string
+		firstErr error
+	)
+	command = expansionPattern.ReplaceAllStringFunc(command, func(m string) string {
+		sub := expansionPattern.FindStringSubmatch(m)
+		if sub[1] == "" {
+			value, ok, err := data.eval(sub[2])
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			if !ok {
+				return m
+			}
+			return value
+		}
+		n, _ := strconv.Atoi(sub[1])
+		if n == 0 {
+			return m
+		}
+		if n > len(args) {
+			missing = append(missing, m)
+			return m
+		}
+		return args[n-1]
+	})
+	if firstErr != nil {
+		return "", firstErr
+	}
+	if len(missing) > 0 {
+		return "", newError(exitPlaceholderMissing, "placeholder_missing",
+			fmt.Errorf("no argument given for placeholder %s", strings.Join(missing, ", ")))
+	}
+	return command, nil
+}
+
+// allCommands returns every command line a runs, for placeholder and
+// template scanning.
+func (a *Alias) allCommands() string {
+	var b strings.Builder
+	b.WriteString(a.command())
+	for _, step := range a.Steps {
+		for _, text := range step.templates() {
+			b.WriteString("\n" + text)
+		}
+	}
+	return b.String()
+}
+
+// newSequence prepares a run of a with args, filling in any missing
+// placeholder values and checking that every step expands cleanly.
+// remembered holds answers to offer for the placeholders; see resolveArgs.
+// startSequence returns the sequence running a with args, before anything
+// is checked or expanded.
+func startSequence(a *Alias, args []string, data *templateData) *sequence {
+	return &sequence{alias: a, args: args, data: data, stdout: os.Stdout, stderr: os.Stderr, runID: newRunID(time.Now()), umask: -1}
+}
+
+func newSequence(a *Alias, args []string, remembered map[int]string) (*sequence, error) {
+	data, err := loadTemplateData(a.body() + a.allCommands())
+	if err != nil {
+		return nil, fmt.Errorf("loading variables: %w", err)
+	}
+	data.steps = make(map[string]string)
+	s := startSequence(a, args, data)
+	if s.dir, err = a.workDir(); err != nil {
+		return nil, err
+	}
+	data.dir = s.dir
+	if s.stdin, err = s.stdinPayload(); err != nil {
+		return nil, err
+	}
+	if a.isScript() {
+		return s, nil
+	}
+	if len(a.Steps) == 0 && a.command() == "" && len(a.Platforms) > 0 {
+		return nil, errNoPlatformCommand(a)
+	}
+	if s.args, err = s.resolveArgs(a, args, remembered); err != nil {
+		return nil, err
+	}
+	// Registered outputs don't exist yet; stand in empty values so that
+	// only genuinely broken references fail up front.
+	for _, step := range a.Steps {
+		if step.Register != "" {
+			data.steps[step.Register] = ""
+		}
+	}
+	for _, step := range a.steps() {
+		for _, text := range step.templates() {
+			if _, err := s.render(text); err != nil {
+				return nil, err
+			}
+		}
+	}
+	data.steps = make(map[string]string)
+	return s, nil
+}
+
+func copyCommand(alias string, args []string) error {
+	a, err := loadAlias(alias)
+	if err != nil {
+		return fmt.Errorf("retrieving command: %w", err)
+	}
+	if a, err = followDeprecation(a); err != nil {
+		return err
+	}
+	alias = a.Name
+	remembered, err := loadAnswers(alias)
+	if err != nil {
+		return err
+	}
+	s, err := newSequence(a, args, remembered)
+	if err != nil {
+		return err
+	}
+	var text string
+	if a.isScript() {
+		text, err = expandTemplate(a.body(), s.data)
+	} else {
+		var lines []string
+		for _, step := range a.steps() {
+			if step.Run == "" {
+				continue
+			}
+			line, err := s.render(step.Run)
+			if err != nil {
+				return err
+			}
+			lines = append(lines, line)
+		}
+		text = strings.Join(lines, "\n")
+	}
+	if err != nil {
+		return err
+	}
+	if err := copyToClipboard(text); err != nil {
+		return fmt.Errorf("copying to clipboard: %w", err)
+	}
+	fmt.Printf("Expanded command for alias %s copied to clipboard\n", paint("alias", alias))
+	return nil
+}
+
+func runCommand(ctx context.Context, alias string, args []string, opts runOptions) error {
+	s, err := prepareRun(alias, args, opts)
+	if err != nil {
+		return err
+	}
+
+	// Don't hold the database lock while the command runs.
+	closeDB()
+	return runSequence(ctx, s, alias, opts)
+}
+
+// runSequence executes a prepared sequence as run does and records it in
+// the history. label names it in the verbose output.
+func runSequence(ctx context.Context, s *sequence, label string, opts runOptions) error {
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+
+	finishCapture, err := s.capture(opts)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if s.events != nil {
+		started := runEvent{Event: "sequence_started", RunID: s.runID, Alias: s.alias.ref(), Args: s.args}
+		if !s.alias.isScript() {
+			started.Steps = len(s.alias.steps())
+		}
+		s.events.emit(started)
+	}
+	err = s.execute(ctx)
+	d := time.Since(start)
+	s.events.finished("finished", 0, d, err)
+	if eerr := s.events.close(); err == nil && eerr != nil {
+		err = fmt.Errorf("writing --events: %w", eerr)
+	}
+	s.trace(verbosityVerbose, "%s finished in %s (exit %d)", label, d.Round(time.Millisecond), exitCodeOf(err))
+	if cerr := finishCapture(); err == nil && cerr != nil {
+		err = fmt.Errorf("writing --tee file: %w", cerr)
+	}
+	if errors.Is(err, exec.ErrNotFound) || exitCodeOf(err) == 127 {
+		// 127 is the shell's status for a command it couldn't find.
+		warnMissingBinaries(s.alias)
+	}
+	recordHistory(s.historyEntry(start, d, err))
+	return err
+}
+
+// prepareRun loads alias, fills in its arguments, asks for confirmation if
+// the alias wants it and queues the use for recording. The returned
+// sequence is ready to execute once the database is closed.
+func prepareRun(alias string, args []string, opts runOptions) (*sequence, error) {
+	a, err := loadAlias(alias)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving command: %w", err)
+	}
+	if a, err = followDeprecation(a); err != nil {
+		return nil, err
+	}
+	alias = a.Name
+
+	if opts.forget {
+		if err := forgetAnswers(alias); err != nil {
+			return nil, err
+		}
+	}
+	remembered, err := loadAnswers(alias)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replace templates and placeholders
+	s, err := newSequence(a, args, remembered)
+	if err != nil {
+		return nil, err
+	}
+	if err := policy.checkAlias(a); err != nil {
+		return nil, err
+	}
+	if err := s.checkGuards(); err != nil {
+		return nil, err
+	}
+	if opts.remember && len(s.answers) > 0 {
+		if err := rememberAnswers(alias, s.answers); err != nil {
+			return nil, err
+		}
+	}
+
+	var prompt string
+	switch {
+	case a.isScript():
+		prompt = trf("Run script %s?", alias)
+	case len(a.Steps) > 0:
+		prompt = trf("Run the %d steps of %s?", len(a.Steps), alias)
+	default:
+		command, _ := s.render(a.command())
+		prompt = trf("Run %s?", command)
+	}
+	if needsConfirmation(a) && !opts.yes {
+		if !interactive() {
+			return nil, errorf("alias %s requires confirmation; pass --yes to run it non-interactively", alias)
+		}
+		ok, err := confirm(prompt, false)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("aborted")
+		}
+	}
+
+	if err := s.configure(opts); err != nil {
+		return nil, err
+	}
+
+	if a.needsApproval() {
+		if err := writableDB(); err != nil {
+			return nil, err
+		}
+		if err := requireApproval(a, s.args, currentUser(), "cli"); err != nil {
+			return nil, err
+		}
+	}
+
+	if a.Cooldown == "" {
+		deferWrite(useUpdate(auditEntry{Target: alias}))
+		return s, nil
+	}
+	// The use is recorded right away for the cooldown to catch a second
+	// run started while this one is still going.
+	if err := writableDB(); err != nil {
+		return nil, err
+	}
+	err = db.Update(cooldownUpdate(auditEntry{Target: alias}, opts.force))
+	var cooling *cooldownError
+	if errors.As(err, &cooling) {
+		return nil, fmt.Errorf("%w; pass --force to run it anyway", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// configure applies the per-invocation options to s.
+func (s *sequence) configure(opts runOptions) error {
+	s.quiet, s.raw = opts.launcher || verbosity < verbosityNormal, opts.raw
+	c := currentConfig()
+	s.snapshot = opts.snapshot || c.History.Snapshot
+	s.waitLock, s.scheduled = opts.wait, opts.scheduled
+	if opts.sandbox || opts.noNet {
+		s.sandbox = &sandbox{noNet: opts.noNet}
+	}
+	if err := s.setEnvironment(opts.envClear, opts.envAllow); err != nil {
+		return err
+	}
+	// Notifications carry the output too, and snapshots the environment.
+	if opts.tee != "" || opts.capture || c.History.Capture || s.snapshot || len(s.alias.notifyEntries(opts.scheduled)) > 0 {
+		var err error
+		if s.secrets, err = s.secretValues(); err != nil {
+			return fmt.Errorf("reading secrets to redact: %w", err)
+		}
+	}
+	if s.limits = s.alias.Limits.merge(opts.limits); !s.limits.empty() {
+		if err := s.limits.validate(); err != nil {
+			return usageError(err)
+		}
+	}
+	if opts.events != "" {
+		var err error
+		if s.events, err = openEvents(opts.events, opts.eventsTo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandHome replaces a leading ~ in path with the user's home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return home + path[1:]
+}
+
+// childError classifies the error returned by running a child process.
+func childError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return newError(exitTimeout, "timeout", fmt.Errorf("command timed out"))
+	case context.Canceled:
+		return newError(exitCanceled, "canceled", fmt.Errorf("command canceled"))
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		ce := newError(exitChildFailed, "child_failed", fmt.Errorf("command failed: %v", err))
+		ce.childExit = exitErr.ExitCode()
+		return ce
+	}
+	return fmt.Errorf("executing command: %w", err)
+}
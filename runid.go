@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// crockford is the alphabet of ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newRunID returns a ULID for a run starting at t: 26 characters that sort
+// by time, with 80 random bits after the millisecond timestamp.
+func newRunID(t time.Time) string {
+	var b [16]byte
+	ms := uint64(t.UnixMilli())
+	binary.BigEndian.PutUint16(b[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:6], uint32(ms))
+	rand.Read(b[6:])
+	// 128 bits in 26 characters of 5 bits each, the first carrying 3.
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	out := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out)
+}
+
+// stepRecord is what the history keeps of one step of a run.
+type stepRecord struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	// Command is the command as it ran, with its templates expanded and
+	// the values of secret variables masked.
+	Command  string        `json:"command,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	ExitCode int           `json:"exit_code,omitempty"`
+	Skipped  bool          `json:"skipped,omitempty"`
+}
+
+// recordStep adds step, which ran command for d, to the steps the history
+// records.
+func (s *sequence) recordStep(step Step, command string, d time.Duration, err error) {
+	s.steps = append(s.steps, stepRecord{Name: step.label(), Kind: step.kind(),
+		Command: s.maskSecrets(command), Duration: d, ExitCode: exitCodeOf(err)})
+}
+
+// maskSecrets replaces the values of the sensitive variables that command
+// uses, and the secrets the run redacts, in command.
+func (s *sequence) maskSecrets(command string) string {
+	for name := range s.data.used {
+		if v := s.data.vars[name]; v != "" && sensitiveName(name) {
+			command = strings.ReplaceAll(command, v, redactedMark)
+		}
+	}
+	for _, secret := range s.secrets {
+		command = strings.ReplaceAll(command, secret, redactedMark)
+	}
+	return command
+}
+
+// usedVars returns the names of the variables the run's templates read.
+func (s *sequence) usedVars() []string {
+	var names []string
+	for name := range s.data.used {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveRun finds the run ref names: its number in the history, its run
+// ID, or a prefix of the run ID that only one run has.
+func resolveRun(tx *bolt.Tx, ref string) (*historyEntry, error) {
+	if id, err := strconv.ParseUint(ref, 10, 64); err == nil {
+		return getHistory(tx, id)
+	}
+	prefix := strings.ToUpper(ref)
+	if len(prefix) < 4 || strings.Trim(prefix, crockford) != "" {
+		return nil, usageError(fmt.Errorf("invalid run %q (use its number or run ID from cmdex history)", ref))
+	}
+	var found []*historyEntry
+	err := forEachHistory(tx, func(e *historyEntry) bool {
+		if strings.HasPrefix(e.RunID, prefix) {
+			found = append(found, e)
+		}
+		return len(found) < 2
+	})
+	switch {
+	case err != nil:
+		return nil, err
+	case len(found) == 0:
+		return nil, fmt.Errorf("no run %s in the history", ref)
+	case len(found) > 1:
+		return nil, fmt.Errorf("run ID prefix %s is ambiguous: %s and %s", ref, found[0].RunID, found[1].RunID)
+	}
+	return found[0], nil
+}
+
+func historyShowCmd() *cobra.Command {
+	var showOutput bool
+	cmd := &cobra.Command{
+		Use:   "show <run>",
+		Short: "Show the details of a run",
+		Long: `show prints what the history recorded of one run: its alias and arguments,
+outcome and timing, every step with the command it ran and how long it
+took, the variables and environment it ran with, and where its --tee log
+and artifacts were saved. A run is given by its number or run ID, as
+listed by cmdex history, or by a prefix of the run ID. With --output it
+prints the output recorded of the run instead.`,
+		Example:     "  cmdex history show 42\n  cmdex history show 01J9Z3K4",
+		Annotations: readDB,
+		Args:        cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var (
+				e      *historyEntry
+				output []byte
+			)
+			err := db.View(func(tx *bolt.Tx) error {
+				var err error
+				if e, err = resolveRun(tx, args[0]); err != nil {
+					return err
+				}
+				output, err = getOutput(tx, historyKey(e.ID))
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("reading history: %w", err)
+			}
+			if showOutput {
+				if output == nil {
+					return fmt.Errorf("no output was recorded of run %d; record it with run --capture", e.ID)
+				}
+				os.Stdout.Write(output)
+				return nil
+			}
+			printRun(e, output)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&showOutput, "output", false, "Print the recorded output of the run")
+	return cmd
+}
+
+// printRun prints the details of run e, whose recorded output is output,
+// laid out like cmdex show.
+func printRun(e *historyEntry, output []byte) {
+	field := func(label, value string) {
+		if value != "" {
+			fmt.Printf("%-13s %s\n", label+":", value)
+		}
+	}
+	field("Run", fmt.Sprint(e.ID))
+	field("Run ID", e.RunID)
+	field("Alias", paint("alias", e.label()))
+	if e.Command != "" {
+		field("Command", e.Command)
+	} else {
+		field("Arguments", quoteArgs(e.Args))
+	}
+	field("Started", e.Time.Local().Format("2006-01-02 15:04:05.000"))
+	field("Duration", e.Duration.Round(time.Millisecond).String())
+	result := "succeeded"
+	if e.ExitCode != 0 {
+		result = paint("error", fmt.Sprintf("failed with exit code %d", e.ExitCode))
+	}
+	field("Result", result)
+	field("Host", e.Host)
+	field("User", e.User)
+	field("Directory", e.Dir)
+	switch {
+	case e.Sandboxed:
+		field("Environment", "sandboxed")
+	case e.EnvCleared:
+		field("Environment", "cleared (run --env-clear)")
+	case len(e.Env) > 0:
+		field("Environment", fmt.Sprintf("inherited, %d variables recorded (see cmdex history diff)", len(e.Env)))
+	case e.RunID != "":
+		field("Environment", "inherited")
+	}
+	field("Variables", strings.Join(e.Vars, ", "))
+	if len(e.Steps) > 0 {
+		fmt.Println("Steps:")
+		for i, step := range e.Steps {
+			outcome := fmt.Sprintf("exit %d, %s", step.ExitCode, step.Duration.Round(time.Millisecond))
+			switch {
+			case step.Skipped:
+				outcome = "skipped"
+			case step.ExitCode != 0:
+				outcome = paint("error", outcome)
+			}
+			fmt.Printf("  %d. %s  (%s)\n", i+1, step.Name, outcome)
+			if step.Command != "" && step.Command != step.Name {
+				fmt.Println("     " + step.Command)
+			}
+		}
+	}
+	field("Log", e.Log)
+	if output != nil {
+		field("Output", formatBytes(uint64(len(output)))+" recorded (cmdex history show --output)")
+	}
+	if e.Artifacts != "" {
+		if _, err := os.Stat(e.Artifacts); err != nil {
+			field("Artifacts", e.Artifacts+" (removed)")
+		} else {
+			field("Artifacts", e.Artifacts)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	goruntime "runtime"
+	"strings"
+)
+
+// sandbox restricts what a command can touch. It always runs with a
+// minimal environment; where bubblewrap (Linux) or sandbox-exec (macOS) is
+// installed the file system is also read-only apart from the working
+// directory and the temporary directory, and network access can be cut.
+type sandbox struct {
+	noNet bool
+}
+
+// sandboxEnv lists the variables passed through into a sandbox.
+var sandboxEnv = []string{"PATH", "HOME", "USER", "LOGNAME", "TERM", "LANG", "LC_ALL", "TZ"}
+
+// env returns the environment of sandboxed commands.
+func (sb *sandbox) env() []string {
+	env := []string{}
+	for _, name := range sandboxEnv {
+		if v, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+v)
+		}
+	}
+	return env
+}
+
+// sandboxDir returns the absolute working directory of a sandboxed command
+// run in dir, the current directory if dir is empty, and whether the
+// command may write to it.
+func sandboxDir(dir string) (string, bool, error) {
+	if dir == "" {
+		var err error
+		if dir, err = os.Getwd(); err != nil {
+			return "", false, err
+		}
+	}
+	dir, _ = filepath.Abs(dir)
+	home, _ := os.UserHomeDir()
+	// Never make HOME writable by running from it or above it.
+	writable := home == "" || (dir != home && !strings.HasPrefix(home, dir+string(filepath.Separator)) && dir != "/")
+	return dir, writable, nil
+}
+
+// wrap returns argv rewritten to run inside the sandbox with dir as its
+// writable working directory. binds lists files outside dir that the
+// command needs to read, such as the script being run.
+func (sb *sandbox) wrap(argv []string, dir string, binds []string) ([]string, error) {
+	dir, writable, err := sandboxDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	switch goruntime.GOOS {
+	case "linux":
+		if bwrap, err := exec.LookPath("bwrap"); err == nil {
+			wrapped := []string{bwrap, "--ro-bind", "/", "/", "--dev", "/dev", "--proc", "/proc", "--tmpfs", "/tmp"}
+			if writable {
+				wrapped = append(wrapped, "--bind", dir, dir)
+			}
+			for _, b := range binds {
+				wrapped = append(wrapped, "--ro-bind", b, b)
+			}
+			wrapped = append(wrapped, "--unshare-pid", "--unshare-ipc", "--unshare-uts", "--die-with-parent")
+			if sb.noNet {
+				wrapped = append(wrapped, "--unshare-net")
+			}
+			wrapped = append(wrapped, "--chdir", dir, "--")
+			return append(wrapped, argv...), nil
+		}
+	case "darwin":
+		if sbx, err := exec.LookPath("sandbox-exec"); err == nil {
+			profile := []string{
+				"(version 1)",
+				"(allow default)",
+				"(deny file-write*)",
+				`(allow file-write* (subpath "/dev") (subpath "/private/tmp") (subpath "/private/var/folders"))`,
+			}
+			if writable {
+				profile = append(profile, fmt.Sprintf("(allow file-write* (subpath %q))", dir))
+			}
+			if sb.noNet {
+				profile = append(profile, "(deny network*)")
+			}
+			return append([]string{sbx, "-p", strings.Join(profile, "\n")}, argv...), nil
+		}
+	}
+	if sb.noNet {
+		return nil, fmt.Errorf("--no-net needs bubblewrap (Linux) or sandbox-exec (macOS), neither was found")
+	}
+	printWarning("Warning: no sandbox tool found (install bubblewrap); running with a clean environment only")
+	return argv, nil
+}
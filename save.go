@@ -0,0 +1,533 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+func saveCmd() *cobra.Command {
+	var (
+		fromClipboard bool
+		file          string
+		useEditor     bool
+		specFile      string
+		forPlatform   string
+		steps         []string
+		meta          Alias
+		limits        Limits
+		env           []string
+	)
+	cmd := &cobra.Command{
+		Use:   "save <alias> <command> | save <alias> -",
+		Short: "Save a command set with an alias",
+		Long: `save stores a command under an alias. Instead of giving the command on the
+command line it can be read from the clipboard, from a script file with
+--file, from stdin with - in its place, or written in $EDITOR with
+--editor. Piped commands and heredocs are saved as given, newlines and
+quotes included, except that trailing newlines are dropped, and a body of
+a single line also loses its leading and trailing whitespace. Here
+--runtime sh has sh run the command, so that its quotes group the words:
+
+  cmdex save report --runtime sh - <<'EOF'
+  psql -c "select count(*) from users where name like 'a%'"
+  EOF
+
+Multi-line bodies are stored as scripts and run with the interpreter named
+by their shebang (sh by default), or by the interpreter chosen with
+--runtime.
+
+Commands run without a shell: they are split at whitespace and quotes,
+$VARIABLES, globs and operators like | reach the program as written. save
+warns about them, as it does about variables the shell expanded while
+saving, and suggests what to save instead, such as --runtime sh '<command>'
+to have sh run it.
+
+Multi-step aliases are given with a --step per command, or as a YAML
+definition with --spec:
+
+  description: Build and publish
+  steps:
+    - run: make build
+    - run: brew bottle cmdex
+      when: os == "darwin"
+    - name: report failure
+      run: notify-send "build failed"
+      when: exit_code != 0
+
+Instead of run:, a step can make an HTTP request:
+
+    - http:
+        method: POST
+        url: https://hooks.example.com/{{var "HOOK"}}
+        headers: {Content-Type: application/json}
+        body: '{"text": "deployed $1"}'
+        status: 200
+
+or write a file, relative to the alias's directory, with its content's
+templates expanded. With cleanup: true the file is temporary: once the alias
+finishes, it is removed, or its former content is restored.
+
+    - write_file:
+        path: k8s/job.yaml
+        content: |
+          image: app:{{gitSha}}
+        mode: "0600"
+        cleanup: true
+
+or ask the user a question, with register: keeping the answer. A prompt
+offers choices: to pick from, or with confirm: true asks yes or no,
+answered as true or false; a confirmation without register: stops the
+sequence on no. Without a terminal, the default: is the answer.
+
+    - prompt:
+        message: Release which version?
+        choices: [patch, minor, major]
+        default: patch
+      register: bump
+    - run: npm version {{steps.bump}}
+
+A wait_for: step waits until a command exits 0 (run:), a port accepts
+connections (tcp: host:port) or a URL answers with a 2xx status (http:,
+or the status: given), checking every interval: (1s) for up to timeout:
+(60s) before it fails:
+
+    - run: docker compose up -d db
+    - wait_for:
+        run: pg_isready -h localhost
+        interval: 2s
+        timeout: 1m
+    - run: make migrate
+
+A step with register: NAME captures its output for later steps to use as
+{{steps.NAME}}. A step without a when: condition only runs if the steps before it
+succeeded. Conditions can use os, arch, hostname, exit_code (of the
+previous step), http_status, env.NAME, steps.NAME, $1.. and placeholder
+names.
+
+A single alias can carry per-machine variants of its command: save it with
+--for darwin, --for linux or --for host:<hostname> (or list them under
+platforms: in a spec) and run picks the variant for the current host, then
+OS, falling back to the default command.
+
+A spec's matrix: lists values for placeholders, by name or as $N, and
+cmdex run --matrix runs the alias once for every combination, like a CI
+matrix, then shows a grid of the results; --axis name=a,b adds or
+overrides a parameter and --max-parallel runs several at once:
+
+  placeholders: [{name: env}, {name: region}]
+  matrix:
+    env: [staging, prod]
+    region: [us, eu]
+
+A command that reads its stdin, like psql, kubectl apply -f - or mail, can
+carry what it reads: --stdin '<text>', --stdin - to take it from a
+heredoc, or --stdin-file <path> to read a file each time it runs; stdin:
+or stdin_file: in a spec. The payload's {{...}} templates are expanded.
+
+Commands can use {{gitBranch}}, {{gitSha}} and {{gitDirty}} (true or false)
+of the git checkout they run in, and guards keep release aliases out of the
+wrong checkout: --require-clean-worktree refuses to run with uncommitted
+changes and --require-branch main (or a pattern such as release/*) on any
+other branch. For kubectl aliases, --require-kube-context prod-* and
+--forbid-kube-context prod-* check the current context of the kubeconfig
+before anything runs, and --require-aws-profile and --require-gcp-project
+the active cloud identity; with --cloud-mismatch confirm a mismatch asks
+whether to run anyway instead of refusing. In a spec they are
+require_clean_worktree:, require_branch:, require_kube_context:,
+forbid_kube_context:, require_aws_profile:, require_gcp_project: and
+cloud_mismatch:.
+
+Safety windows keep an alias from running at the wrong time: --deny
+"Fri 16:00-Mon 08:00" refuses runs over the weekend and --allow
+"Mon-Fri 08:00-18:00" all but those in office hours, in local time; days
+(Sat-Sun) and hours of every day (22:00-06:00) work too. With
+--outside-window confirm a run outside them asks twice, the second time
+for the alias's name, instead of refusing. Windows shared by many
+aliases, such as one for production, are defined in the config and named
+with --window:
+
+  windows:
+    prod:
+      deny: ["Fri 16:00-Mon 08:00", "22:00-07:00"]
+      outside: confirm
+
+In a spec they are deny:, allow:, window: and outside_window:.
+
+In stores and bundles shared by many people, --owner and --team (owner:
+and team: in a spec) record who maintains an alias. cmdex show and list
+--output json show them, the run report lists them, and notifications of
+failed runs name them.
+
+Finished runs can be reported to Slack, Discord, any webhook or by email,
+with the alias, host, exit code and last lines of output. Targets are
+configured under notify.targets in the config, and notify.default names
+those told of every alias's runs; --notify (notify: in a spec) replaces
+them for one alias with target names, webhook URLs, mailto:addresses or
+none:
+
+  notify:
+    targets:
+      ops: {url: "https://hooks.slack.com/services/...", on: failure, lines: 20}
+    default: [ops]
+
+--env sets variables for the commands. With --env-clear they run with only
+those and the few variables every program expects (PATH, HOME, LANG, ...;
+env_allow in the config replaces the list), so tokens in the caller's
+environment don't leak into them; --env-allow passes more through.
+--umask, --locale and --tz pin the file creation mask, LANG and LC_ALL,
+and TZ of the commands, so they behave the same on every machine.
+
+Saving <alias>@<variant> adds a named variant to an existing alias instead:
+an alternative body run with cmdex run <alias>@<variant>, while the alias's
+own body stays the default; see cmdex variants.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if fromClipboard || file != "" || useEditor || specFile != "" || len(steps) > 0 {
+				return cobra.ExactArgs(1)(cmd, args)
+			}
+			return cobra.MinimumNArgs(2)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, ok := runtimes[meta.Runtime]; meta.Runtime != "" && !ok {
+				return usageError(fmt.Errorf("unknown runtime %q (use %s)", meta.Runtime, strings.Join(runtimeNames(), ", ")))
+			}
+			if err := limits.validate(); err != nil {
+				return usageError(err)
+			}
+			if meta.Filter != "" {
+				if err := checkFilter(meta.Filter); err != nil {
+					return usageError(err)
+				}
+			}
+			if meta.Stdin != "" && meta.StdinFile != "" {
+				return usageError(fmt.Errorf("--stdin and --stdin-file can't be combined"))
+			}
+			fromStdin := len(args) == 2 && args[1] == "-"
+			if fromStdin && meta.Stdin == "-" {
+				return usageError(fmt.Errorf("the command and --stdin can't both be read from stdin"))
+			}
+			if meta.Stdin == "-" {
+				data, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					return fmt.Errorf("reading --stdin: %w", err)
+				}
+				meta.Stdin = string(data)
+			}
+			if err := checkArtifacts(meta.Artifacts); err != nil {
+				return usageError(err)
+			}
+			if err := checkNotify(meta.Notify); err != nil {
+				return usageError(err)
+			}
+			if err := meta.Guards.validate(); err != nil {
+				return usageError(err)
+			}
+			var err error
+			if meta.Env, err = parseEnv(env); err != nil {
+				return usageError(err)
+			}
+			if err := checkEnv(meta.Env, meta.EnvAllow); err != nil {
+				return usageError(err)
+			}
+			if err := meta.checkLocale(); err != nil {
+				return usageError(err)
+			}
+			if meta.Cooldown != "" {
+				if _, err := checkCooldown(meta.Cooldown); err != nil {
+					return usageError(err)
+				}
+			}
+			if forPlatform != "" && !validPlatform(forPlatform) {
+				return usageError(fmt.Errorf("invalid --for %q (use an OS such as darwin or linux, or host:<hostname>)", forPlatform))
+			}
+			alias := args[0]
+			body := &Alias{Command: strings.Join(args[1:], " ")}
+			switch {
+			case specFile != "":
+				var err error
+				if body, err = loadSpec(specFile); err != nil {
+					return err
+				}
+			case len(steps) > 0:
+				body.Command = ""
+				for _, run := range steps {
+					body.Steps = append(body.Steps, Step{Run: run})
+				}
+			case fromStdin:
+				if isTerminal(os.Stdin) {
+					fmt.Fprintln(os.Stderr, tr("Reading the command from stdin; end it with Ctrl-D"))
+				}
+				data, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					return fmt.Errorf("reading command: %w", err)
+				}
+				if strings.TrimSpace(string(data)) == "" {
+					return fmt.Errorf("empty command, nothing saved")
+				}
+				setBody(body, string(data))
+			case fromClipboard:
+				text, err := readClipboard()
+				if err != nil {
+					return fmt.Errorf("reading clipboard: %w", err)
+				}
+				if strings.TrimSpace(text) == "" {
+					return fmt.Errorf("clipboard is empty")
+				}
+				setBody(body, text)
+			case file != "":
+				data, err := os.ReadFile(file)
+				if err != nil {
+					return fmt.Errorf("reading script: %w", err)
+				}
+				body.Command, body.Script = "", strings.TrimRight(string(data), "\n")
+			case useEditor:
+				text, err := editText("")
+				if err != nil {
+					return err
+				}
+				if strings.TrimSpace(text) == "" {
+					return fmt.Errorf("empty command, nothing saved")
+				}
+				setBody(body, text)
+			}
+			flags := cmd.Flags()
+			var saved *Alias
+			err = db.Update(func(tx *bolt.Tx) error {
+				now := time.Now()
+				a, err := getAlias(tx, alias)
+				var variant string
+				if err == errAliasNotFound {
+					// New names with an @ add a variant to an existing
+					// alias.
+					name, v := splitVariant(alias)
+					if v == "" {
+						if err := checkNameClash(alias); err != nil {
+							return err
+						}
+						a = &Alias{Name: alias, Created: now, Provenance: newProvenance("save", specFile)}
+					} else {
+						if err := checkVariantName(v); err != nil {
+							return usageError(err)
+						}
+						if a, err = getAlias(tx, name); err == errAliasNotFound {
+							return fmt.Errorf("alias %s doesn't exist; save it before adding the variant %s", name, v)
+						} else if err != nil {
+							return err
+						}
+						variant = v
+					}
+				} else if err != nil {
+					return err
+				}
+				// target receives the new body: a itself, or its variant.
+				target := a
+				if variant != "" {
+					target = &Alias{}
+					if v, ok := a.Variants[variant]; ok {
+						target.setVariantBody(v)
+					}
+				}
+				switch {
+				case specFile != "" && variant != "":
+					target.setVariantBody(body.variantBody())
+				case specFile != "":
+					body.Name, body.Created, body.Uses, body.LastUsed, body.Provenance = a.Name, a.Created, a.Uses, a.LastUsed, a.Provenance
+					a, target = body, body
+				}
+				if forPlatform != "" {
+					if target.Platforms == nil {
+						target.Platforms = make(map[string]string)
+					}
+					target.Platforms[forPlatform] = body.Command
+				} else {
+					target.Command, target.Script, target.Steps = body.Command, body.Script, body.Steps
+				}
+				if flags.Changed("runtime") {
+					target.Runtime = meta.Runtime
+				}
+				a.Modified, target.Modified = now, now
+				if variant != "" {
+					if a.Variants == nil {
+						a.Variants = make(map[string]*Variant)
+					}
+					a.Variants[variant] = target.variantBody()
+				}
+				// Metadata of an existing alias is kept unless overridden.
+				if flags.Changed("description") {
+					a.Description = meta.Description
+				}
+				if flags.Changed("tag") {
+					a.Tags = meta.Tags
+				}
+				if flags.Changed("owner") {
+					a.Owner = meta.Owner
+				}
+				if flags.Changed("team") {
+					a.Team = meta.Team
+				}
+				if flags.Changed("dir") {
+					a.Dir = meta.Dir
+				}
+				if flags.Changed("stdin") || flags.Changed("stdin-file") {
+					a.Stdin, a.StdinFile = meta.Stdin, meta.StdinFile
+				}
+				if flags.Changed("chdir-to-git-root") {
+					a.GitRoot = meta.GitRoot
+				}
+				if flags.Changed("require-clean-worktree") {
+					a.RequireCleanWorktree = meta.RequireCleanWorktree
+				}
+				if flags.Changed("require-branch") {
+					a.RequireBranch = meta.RequireBranch
+				}
+				if flags.Changed("require-kube-context") {
+					a.RequireKubeContext = meta.RequireKubeContext
+				}
+				if flags.Changed("forbid-kube-context") {
+					a.ForbidKubeContext = meta.ForbidKubeContext
+				}
+				if flags.Changed("require-aws-profile") {
+					a.RequireAWSProfile = meta.RequireAWSProfile
+				}
+				if flags.Changed("require-gcp-project") {
+					a.RequireGCPProject = meta.RequireGCPProject
+				}
+				if flags.Changed("cloud-mismatch") {
+					a.CloudMismatch = meta.CloudMismatch
+				}
+				if flags.Changed("deny") {
+					a.Deny = meta.Deny
+				}
+				if flags.Changed("allow") {
+					a.Allow = meta.Allow
+				}
+				if flags.Changed("window") {
+					a.Window = meta.Window
+				}
+				if flags.Changed("outside-window") {
+					a.OutsideWindow = meta.OutsideWindow
+				}
+				if flags.Changed("env") {
+					a.Env = meta.Env
+				}
+				if flags.Changed("env-clear") {
+					a.EnvClear = meta.EnvClear
+				}
+				if flags.Changed("env-allow") {
+					a.EnvAllow = meta.EnvAllow
+				}
+				if flags.Changed("umask") {
+					a.Umask = meta.Umask
+				}
+				if flags.Changed("locale") {
+					a.Locale = meta.Locale
+				}
+				if flags.Changed("tz") {
+					a.TZ = meta.TZ
+				}
+				if flags.Changed("shim") {
+					a.Shim = meta.Shim
+				}
+				if flags.Changed("confirm") {
+					a.Confirm = meta.Confirm
+				}
+				if flags.Changed("cooldown") {
+					a.Cooldown = meta.Cooldown
+				}
+				if flags.Changed("lock") {
+					a.Lock = meta.Lock
+				}
+				if flags.Changed("example") {
+					a.Examples = meta.Examples
+				}
+				if flags.Changed("filter") {
+					a.Filter = meta.Filter
+				}
+				if flags.Changed("artifact") {
+					a.Artifacts = meta.Artifacts
+				}
+				if flags.Changed("notify") {
+					a.Notify = meta.Notify
+				}
+				for _, name := range []string{"max-mem", "max-cpu", "max-files", "nice"} {
+					if flags.Changed(name) {
+						if a.Limits = a.Limits.merge(limits); a.Limits.empty() {
+							a.Limits = nil
+						}
+						break
+					}
+				}
+				if err := policy.checkAlias(a); err != nil {
+					return err
+				}
+				if err := putAlias(tx, a); err != nil {
+					return err
+				}
+				saved = target
+				e := auditEntry{Action: "save", Target: a.Name}
+				switch {
+				case variant != "" && forPlatform != "":
+					e.Detail = "variant " + variant + " for " + forPlatform
+				case variant != "":
+					e.Detail = "variant " + variant
+				case forPlatform != "":
+					e.Detail = "for " + forPlatform
+				}
+				return writeAudit(tx, e)
+			})
+			if err != nil {
+				return fmt.Errorf("saving command: %w", err)
+			}
+			fmt.Println(trf("Command saved with alias: %s", paint("alias", alias)))
+			warnQuoting(alias, saved, len(args) > 1 && !fromStdin)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&fromClipboard, "from-clipboard", false, "Read the command from the system clipboard")
+	cmd.Flags().StringVarP(&file, "file", "f", "", "Store the contents of a script file")
+	cmd.Flags().BoolVarP(&useEditor, "editor", "e", false, "Write the command or script in $EDITOR")
+	cmd.Flags().StringArrayVar(&steps, "step", nil, "Add a step to a multi-step alias (repeatable)")
+	cmd.Flags().StringVar(&specFile, "spec", "", "Read the full alias definition from a YAML file")
+	cmd.Flags().StringVar(&forPlatform, "for", "", "Save the command as the variant for an OS (darwin, linux, ...) or host:<hostname>")
+	cmd.Flags().StringVarP(&meta.Description, "description", "d", "", "Describe what the alias does")
+	cmd.Flags().StringSliceVarP(&meta.Tags, "tag", "t", nil, "Tag the alias (repeatable)")
+	cmd.Flags().StringVar(&meta.Owner, "owner", "", "Record who maintains the alias, such as a name or email address")
+	cmd.Flags().StringVar(&meta.Team, "team", "", "Record the team that maintains the alias")
+	cmd.Flags().StringVar(&meta.Dir, "dir", "", "Working directory to run the command in")
+	cmd.Flags().StringVar(&meta.Stdin, "stdin", "", "Feed this text to the command's stdin; - reads it from cmdex's own stdin, such as a heredoc")
+	cmd.Flags().StringVar(&meta.StdinFile, "stdin-file", "", "Feed the contents of this file to the command's stdin when it runs")
+	cmd.Flags().BoolVar(&meta.GitRoot, "chdir-to-git-root", false, "Run at the root of the enclosing git repository (with --dir, in that directory below it)")
+	cmd.Flags().StringArrayVar(&env, "env", nil, "Set this variable, as NAME=VALUE, in the command's environment; the value may use {{...}} templates (repeatable)")
+	cmd.Flags().BoolVar(&meta.EnvClear, "env-clear", false, "Always run with a cleared environment, as run --env-clear does")
+	cmd.Flags().StringArrayVar(&meta.EnvAllow, "env-allow", nil, "Also pass this variable, or those matching a pattern such as AWS_*, into a cleared environment (repeatable)")
+	cmd.Flags().StringVar(&meta.Umask, "umask", "", "Create files with this umask, in octal (e.g. 022), whatever the caller's is")
+	cmd.Flags().StringVar(&meta.Locale, "locale", "", "Run with this locale as LANG and LC_ALL (e.g. C.UTF-8)")
+	cmd.Flags().StringVar(&meta.TZ, "tz", "", "Run in this time zone (e.g. UTC or Europe/Berlin)")
+	cmd.Flags().BoolVar(&meta.Shim, "shim", false, "Give the alias a command of its own, written by cmdex shims sync")
+	cmd.Flags().BoolVar(&meta.Confirm, "confirm", false, "Ask for confirmation before running")
+	cmd.Flags().BoolVar(&meta.RequireCleanWorktree, "require-clean-worktree", false, "Refuse to run from a git checkout with uncommitted changes")
+	cmd.Flags().StringVar(&meta.RequireBranch, "require-branch", "", "Refuse to run unless the checked out git branch matches this pattern (e.g. main, release/*)")
+	cmd.Flags().StringVar(&meta.RequireKubeContext, "require-kube-context", "", "Refuse to run unless the current kubectl context matches this pattern (e.g. prod-*)")
+	cmd.Flags().StringVar(&meta.ForbidKubeContext, "forbid-kube-context", "", "Refuse to run while the current kubectl context matches this pattern")
+	cmd.Flags().StringVar(&meta.RequireAWSProfile, "require-aws-profile", "", "Refuse to run unless the active AWS profile matches this pattern")
+	cmd.Flags().StringVar(&meta.RequireGCPProject, "require-gcp-project", "", "Refuse to run unless the active gcloud project matches this pattern")
+	cmd.Flags().StringVar(&meta.CloudMismatch, "cloud-mismatch", "", "What to do when the AWS profile or gcloud project doesn't match: refuse (default) or confirm")
+	cmd.Flags().StringArrayVar(&meta.Deny, "deny", nil, "Refuse to run at these times, in local time (e.g. \"Fri 16:00-Mon 08:00\", Sat-Sun, 22:00-06:00; repeatable)")
+	cmd.Flags().StringArrayVar(&meta.Allow, "allow", nil, "Refuse to run except at these times, in local time (e.g. \"Mon-Fri 08:00-18:00\"; repeatable)")
+	cmd.Flags().StringVar(&meta.Window, "window", "", "Apply the safety window of this name from windows in the config")
+	cmd.Flags().StringVar(&meta.OutsideWindow, "outside-window", "", "What to do on runs outside the allowed times: refuse (default) or confirm, which asks twice")
+	cmd.Flags().StringArrayVar(&meta.Artifacts, "artifact", nil, "Keep the files matching this path or glob, relative to the working directory, after every run (repeatable; see cmdex history artifacts)")
+	cmd.Flags().StringSliceVar(&meta.Notify, "notify", nil, "Tell these targets of finished runs: names from notify.targets in the config, webhook URLs, mailto:addresses or none (repeatable)")
+	cmd.Flags().StringVar(&meta.Cooldown, "cooldown", "", "Refuse runs within this time of the previous one (e.g. 5m); run --force overrides it")
+	cmd.Flags().BoolVar(&meta.Lock, "lock", false, "Run the alias one at a time; run --wait waits for the run in progress")
+	cmd.Flags().StringVar(&meta.Runtime, "runtime", "", "Run the body as a script with this interpreter: "+strings.Join(runtimeNames(), ", "))
+	cmd.Flags().StringArrayVar(&meta.Examples, "example", nil, "Record an example invocation, e.g. \"cmdex run deploy staging v1.2\" (repeatable)")
+	cmd.Flags().StringVar(&meta.Filter, "filter", "", "Pass the output through a filter: grep, head, tail, column or a command such as \"jq .items\"")
+	limitFlags(cmd, &limits)
+	return cmd
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// cronSchedule is a parsed five-field cron expression. A nil field matches
+// any value.
+type cronSchedule struct {
+	minute, hour, dom, month, dow []int
+}
+
+// cronMacros are the @ shorthands accepted in place of five fields.
+var cronMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+var (
+	monthNames   = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// parseCron parses a schedule: "minute hour day-of-month month day-of-week"
+// with *, lists, ranges and steps, or one of the @ macros.
+func parseCron(spec string) (*cronSchedule, error) {
+	if m, ok := cronMacros[strings.ToLower(strings.TrimSpace(spec))]; ok {
+		spec = m
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: want 5 fields (minute hour day month weekday) or a macro such as @daily", spec)
+	}
+	var (
+		c   cronSchedule
+		err error
+	)
+	parts := []struct {
+		name     string
+		dst      *[]int
+		min, max int
+		names    []string
+	}{
+		{"minute", &c.minute, 0, 59, nil},
+		{"hour", &c.hour, 0, 23, nil},
+		{"day of month", &c.dom, 1, 31, nil},
+		{"month", &c.month, 1, 12, monthNames},
+		{"weekday", &c.dow, 0, 7, weekdayNames},
+	}
+	for i, p := range parts {
+		if *p.dst, err = parseCronField(fields[i], p.min, p.max, p.names); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %s: %w", spec, p.name, err)
+		}
+	}
+	if c.dow != nil {
+		// Both 0 and 7 are Sunday.
+		var days []int
+		for _, d := range c.dow {
+			if d == 7 {
+				d = 0
+			}
+			if !containsInt(days, d) {
+				days = append(days, d)
+			}
+		}
+		sort.Ints(days)
+		if c.dow = days; len(days) == 7 {
+			c.dow = nil
+		}
+	}
+	return &c, nil
+}
+
+// parseCronField returns the values a field matches, or nil for all of
+// them. names, if given, are accepted for the values counting from min.
+func parseCronField(field string, min, max int, names []string) ([]int, error) {
+	value := func(s string) (int, error) {
+		for i, name := range names {
+			if strings.EqualFold(s, name) {
+				return min + i, nil
+			}
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("%q is not between %d and %d", s, min, max)
+		}
+		return n, nil
+	}
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = value(from); err != nil {
+				return nil, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = value(to); err != nil {
+					return nil, err
+				}
+			} else if hasStep {
+				hi = max
+			}
+			if hi < lo {
+				return nil, fmt.Errorf("range %q runs backwards", rng)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			seen[v] = true
+		}
+	}
+	if len(seen) == max-min+1 {
+		return nil, nil
+	}
+	values := make([]int, 0, len(seen))
+	for v := range seen {
+		values = append(values, v)
+	}
+	sort.Ints(values)
+	return values, nil
+}
+
+func containsInt(values []int, v int) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduledJob is what an installed schedule runs: cmdex itself, from the
+// directory holding the database.
+type scheduledJob struct {
+	exe, dir string
+	// env holds the variables the job needs, such as CMDEX_CONFIG.
+	env []string
+}
+
+func newScheduledJob() (*scheduledJob, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("locating cmdex: %w", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return nil, fmt.Errorf("locating cmdex: %w", err)
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	job := &scheduledJob{exe: exe, dir: dir}
+	if p := os.Getenv("CMDEX_CONFIG"); p != "" {
+		if abs, err := filepath.Abs(p); err == nil {
+			p = abs
+		}
+		job.env = append(job.env, "CMDEX_CONFIG="+p)
+	}
+	return job, nil
+}
+
+// argv returns the command line that runs alias.
+func (j *scheduledJob) argv(alias string) []string {
+	return []string{j.exe, "run", "--yes", "--scheduled", alias}
+}
+
+// unitFile is a generated timer, service or agent definition.
+type unitFile struct {
+	path, content string
+}
+
+// unitName turns an alias name into one that is safe in file and unit
+// names.
+func unitName(alias string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x80 && (r == '-' || r == '.' || r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return r
+		}
+		return '_'
+	}, alias)
+}
+
+func scheduleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schedule",
+		Short: "Run aliases on a schedule with systemd timers or launchd",
+		Long: `schedule attaches cron-style schedules to aliases and installs them as
+systemd user timers (or launchd agents on macOS), which run
+"cmdex run --yes --scheduled <alias>" from the directory the schedule was
+installed in.
+
+Schedules have the five cron fields "minute hour day month weekday", with
+*, lists (1,15), ranges (1-5), steps (*/15) and month and weekday names, or
+one of @hourly, @daily, @weekly, @monthly and @yearly.
+
+So that unattended runs don't fail silently, notify.scheduled in the config
+names notification targets told of every scheduled run, such as email
+recipients that get the output attached:
+
+  notify:
+    smtp: {host: smtp.example.com, username: cmdex@example.com}
+    targets:
+      oncall: {type: email, to: [oncall@example.com], on: failure}
+    scheduled: [oncall]
+
+The password is read from CMDEX_SMTP_PASSWORD or notify.smtp.password.`,
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "set <alias> <schedule>",
+		Short: "Set the schedule of an alias",
+		Example: `  cmdex schedule set backup "30 2 * * *"
+  cmdex schedule set report @weekly`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			spec := strings.Join(args[1:], " ")
+			if _, err := parseCron(spec); err != nil {
+				return usageError(err)
+			}
+			if err := setSchedule(args[0], spec); err != nil {
+				return fmt.Errorf("setting schedule: %w", err)
+			}
+			fmt.Printf("Schedule of %s set to %s; run cmdex schedule install to activate it\n", paint("alias", args[0]), spec)
+			return nil
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "unset <alias>",
+		Short: "Remove the schedule of an alias",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := setSchedule(args[0], ""); err != nil {
+				return fmt.Errorf("removing schedule: %w", err)
+			}
+			fmt.Printf("Schedule of %s removed; run cmdex schedule uninstall %s to remove its timer\n", paint("alias", args[0]), args[0])
+			return nil
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List scheduled aliases",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			aliases, err := scheduledAliases(nil)
+			if err != nil {
+				return err
+			}
+			var t table
+			t.color(0, "alias")
+			for _, a := range aliases {
+				state := "not installed"
+				if _, err := os.Stat(unitPath(a.Name)); err == nil {
+					state = "installed"
+				}
+				t.add(a.Name, a.Schedule, state)
+			}
+			printLines(t.lines(0))
+			return nil
+		},
+	})
+	cmd.AddCommand(scheduleInstallCmd())
+	cmd.AddCommand(scheduleUninstallCmd())
+	return cmd
+}
+
+// setSchedule changes the schedule of the alias name; an empty spec
+// removes it.
+func setSchedule(name, spec string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		a, err := getAlias(tx, name)
+		if err != nil {
+			return err
+		}
+		a.Schedule = spec
+		a.Modified = time.Now()
+		if err := putAlias(tx, a); err != nil {
+			return err
+		}
+		return writeAudit(tx, auditEntry{Action: "schedule", Target: name, Detail: spec})
+	})
+}
+
+// scheduledAliases returns the named aliases, or all scheduled ones when no
+// names are given.
+func scheduledAliases(names []string) ([]*Alias, error) {
+	var aliases []*Alias
+	err := db.View(func(tx *bolt.Tx) error {
+		if len(names) > 0 {
+			for _, name := range names {
+				a, err := getAlias(tx, name)
+				if err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+				if a.Schedule == "" {
+					return fmt.Errorf("alias %s has no schedule; set one with cmdex schedule set", name)
+				}
+				aliases = append(aliases, a)
+			}
+			return nil
+		}
+		return forEachAlias(tx, func(a *Alias) error {
+			if a.Schedule != "" {
+				aliases = append(aliases, a)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading schedules: %w", err)
+	}
+	return aliases, nil
+}
+
+func scheduleInstallCmd() *cobra.Command {
+	var print bool
+	cmd := &cobra.Command{
+		Use:   "install [alias...]",
+		Short: "Install timers for the given or all scheduled aliases",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			aliases, err := scheduledAliases(args)
+			if err != nil {
+				return err
+			}
+			if len(aliases) == 0 {
+				return fmt.Errorf("no aliases have a schedule; set one with cmdex schedule set")
+			}
+			var meta *encryptionMeta
+			err = db.View(func(tx *bolt.Tx) error {
+				meta, err = readEncryptionMeta(tx)
+				return err
+			})
+			if err == nil && meta != nil && meta.KDF != "keyring" {
+				printWarning("Warning: the database is encrypted with a passphrase; scheduled runs need CMDEX_PASSPHRASE in their environment or a key in the OS keyring (cmdex db encrypt --keyring)")
+			}
+			job, err := newScheduledJob()
+			if err != nil {
+				return err
+			}
+			for _, a := range aliases {
+				c, err := parseCron(a.Schedule)
+				if err != nil {
+					return fmt.Errorf("alias %s: %w", a.Name, err)
+				}
+				files, err := unitFiles(a.Name, c, job)
+				if err != nil {
+					return fmt.Errorf("alias %s: %w", a.Name, err)
+				}
+				if print {
+					for _, f := range files {
+						fmt.Printf("# %s\n%s\n", f.path, f.content)
+					}
+					continue
+				}
+				if err := installUnits(a.Name, files); err != nil {
+					return fmt.Errorf("installing schedule of %s: %w", a.Name, err)
+				}
+				fmt.Printf("Installed schedule of %s (%s)\n", paint("alias", a.Name), a.Schedule)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&print, "print", false, "Print the unit files instead of installing them")
+	return cmd
+}
+
+func scheduleUninstallCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "uninstall <alias>...",
+		Short: "Remove the timers of aliases",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, name := range args {
+				if err := uninstallUnits(name); err != nil {
+					return fmt.Errorf("removing schedule of %s: %w", name, err)
+				}
+				fmt.Printf("Removed the timer of %s\n", paint("alias", name))
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Schedules are installed as launchd agents in ~/Library/LaunchAgents.
+
+// maxCalendarIntervals bounds the launchd calendar entries a schedule may
+// expand to, since launchd has no lists, ranges or steps.
+const maxCalendarIntervals = 500
+
+func agentLabel(alias string) string {
+	return "com.cmdex." + unitName(alias)
+}
+
+// unitPath returns the path of the agent that runs alias.
+func unitPath(alias string) string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, "Library", "LaunchAgents", agentLabel(alias)+".plist")
+}
+
+func unitFiles(alias string, c *cronSchedule, job *scheduledJob) ([]unitFile, error) {
+	intervals, err := calendarIntervals(c)
+	if err != nil {
+		return nil, err
+	}
+	home, _ := os.UserHomeDir()
+	logFile := filepath.Join(home, "Library", "Logs", "cmdex", unitName(alias)+".log")
+
+	var b bytes.Buffer
+	str := func(s string) {
+		b.WriteString("<string>")
+		xml.EscapeText(&b, []byte(s))
+		b.WriteString("</string>")
+	}
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`)
+	b.WriteString("\t<key>Label</key>")
+	str(agentLabel(alias))
+	b.WriteString("\n\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, arg := range job.argv(alias) {
+		b.WriteString("\t\t")
+		str(arg)
+		b.WriteString("\n")
+	}
+	b.WriteString("\t</array>\n\t<key>WorkingDirectory</key>")
+	str(job.dir)
+	b.WriteString("\n")
+	if len(job.env) > 0 {
+		b.WriteString("\t<key>EnvironmentVariables</key>\n\t<dict>\n")
+		for _, env := range job.env {
+			k, v, _ := strings.Cut(env, "=")
+			b.WriteString("\t\t<key>")
+			xml.EscapeText(&b, []byte(k))
+			b.WriteString("</key>")
+			str(v)
+			b.WriteString("\n")
+		}
+		b.WriteString("\t</dict>\n")
+	}
+	b.WriteString("\t<key>StandardOutPath</key>")
+	str(logFile)
+	b.WriteString("\n\t<key>StandardErrorPath</key>")
+	str(logFile)
+	b.WriteString("\n\t<key>StartCalendarInterval</key>\n\t<array>\n")
+	for _, in := range intervals {
+		b.WriteString("\t\t<dict>")
+		for _, key := range []string{"Month", "Day", "Weekday", "Hour", "Minute"} {
+			if v, ok := in[key]; ok {
+				fmt.Fprintf(&b, "<key>%s</key><integer>%d</integer>", key, v)
+			}
+		}
+		b.WriteString("</dict>\n")
+	}
+	b.WriteString("\t</array>\n</dict>\n</plist>\n")
+	return []unitFile{{unitPath(alias), b.String()}}, nil
+}
+
+// calendarIntervals expands c into launchd calendar entries, one for each
+// combination of restricted field values. A schedule restricting both the
+// day of month and the weekday runs on either, as in cron.
+func calendarIntervals(c *cronSchedule) ([]map[string]int, error) {
+	expand := func(dom, dow []int) []map[string]int {
+		intervals := []map[string]int{{}}
+		for _, f := range []struct {
+			key    string
+			values []int
+		}{{"Month", c.month}, {"Day", dom}, {"Weekday", dow}, {"Hour", c.hour}, {"Minute", c.minute}} {
+			if f.values == nil {
+				continue
+			}
+			var next []map[string]int
+			for _, in := range intervals {
+				for _, v := range f.values {
+					m := map[string]int{f.key: v}
+					for k, x := range in {
+						m[k] = x
+					}
+					next = append(next, m)
+				}
+			}
+			intervals = next
+		}
+		return intervals
+	}
+	intervals := expand(c.dom, c.dow)
+	if c.dom != nil && c.dow != nil {
+		intervals = append(expand(c.dom, nil), expand(nil, c.dow)...)
+	}
+	if len(intervals) > maxCalendarIntervals {
+		return nil, fmt.Errorf("schedule expands to %d launchd calendar entries (at most %d)", len(intervals), maxCalendarIntervals)
+	}
+	return intervals, nil
+}
+
+func launchctl(args ...string) error {
+	out, err := exec.Command("launchctl", args...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("launchctl %s: %s", strings.Join(args, " "), msg)
+		}
+		return fmt.Errorf("launchctl %s: %w", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+func installUnits(alias string, files []unitFile) error {
+	home, _ := os.UserHomeDir()
+	if err := os.MkdirAll(filepath.Join(home, "Library", "Logs", "cmdex"), 0755); err != nil {
+		return err
+	}
+	path := unitPath(alias)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Reloading picks up a changed schedule; the agent may not be loaded.
+	launchctl("unload", path)
+	for _, f := range files {
+		if err := os.WriteFile(f.path, []byte(f.content), 0644); err != nil {
+			return err
+		}
+	}
+	return launchctl("load", "-w", path)
+}
+
+func uninstallUnits(alias string) error {
+	path := unitPath(alias)
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("no agent installed for %s", alias)
+	}
+	if err := launchctl("unload", "-w", path); err != nil {
+		printWarning("Warning: %v", err)
+	}
+	return os.Remove(path)
+}
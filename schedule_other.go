@@ -0,0 +1,140 @@
+//go:build !darwin
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Schedules are installed as systemd user units: a oneshot service running
+// the alias and a timer starting it.
+
+// unitDir returns the directory of the user's systemd units.
+func unitDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "systemd", "user")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", "systemd", "user")
+}
+
+// unitPath returns the path of the timer that runs alias.
+func unitPath(alias string) string {
+	return filepath.Join(unitDir(), "cmdex-"+unitName(alias)+".timer")
+}
+
+func unitFiles(alias string, c *cronSchedule, job *scheduledJob) ([]unitFile, error) {
+	var service strings.Builder
+	fmt.Fprintf(&service, "[Unit]\nDescription=cmdex alias %s\n\n[Service]\nType=oneshot\n", alias)
+	fmt.Fprintf(&service, "WorkingDirectory=%s\n", systemdQuote(job.dir))
+	for _, env := range job.env {
+		fmt.Fprintf(&service, "Environment=%s\n", systemdQuote(env))
+	}
+	var argv []string
+	for _, arg := range job.argv(alias) {
+		argv = append(argv, systemdQuote(arg))
+	}
+	fmt.Fprintf(&service, "ExecStart=%s\n", strings.Join(argv, " "))
+
+	var timer strings.Builder
+	fmt.Fprintf(&timer, "[Unit]\nDescription=Schedule of cmdex alias %s\n\n[Timer]\n", alias)
+	for _, cal := range onCalendar(c) {
+		fmt.Fprintf(&timer, "OnCalendar=%s\n", cal)
+	}
+	timer.WriteString("Persistent=true\n\n[Install]\nWantedBy=timers.target\n")
+
+	base := strings.TrimSuffix(unitPath(alias), ".timer")
+	return []unitFile{
+		{base + ".service", service.String()},
+		{base + ".timer", timer.String()},
+	}, nil
+}
+
+// onCalendar translates c to systemd calendar events. Cron runs a job when
+// either a restricted day of month or a restricted weekday matches, while
+// systemd requires both, so such schedules become two events.
+func onCalendar(c *cronSchedule) []string {
+	event := func(dom, dow []int) string {
+		var b strings.Builder
+		if dow != nil {
+			var days []string
+			for _, d := range dow {
+				name := weekdayNames[d]
+				days = append(days, strings.ToUpper(name[:1])+name[1:])
+			}
+			b.WriteString(strings.Join(days, ",") + " ")
+		}
+		fmt.Fprintf(&b, "*-%s-%s %s:%s:00", calendarList(c.month), calendarList(dom), calendarList(c.hour), calendarList(c.minute))
+		return b.String()
+	}
+	if c.dom != nil && c.dow != nil {
+		return []string{event(c.dom, nil), event(nil, c.dow)}
+	}
+	return []string{event(c.dom, c.dow)}
+}
+
+func calendarList(values []int) string {
+	if values == nil {
+		return "*"
+	}
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprintf("%02d", v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// systemdQuote quotes s for a unit file if it needs it.
+func systemdQuote(s string) string {
+	if strings.ContainsAny(s, " \t\"'\\") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func systemctl(args ...string) error {
+	out, err := exec.Command("systemctl", append([]string{"--user"}, args...)...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("systemctl --user %s: %s", strings.Join(args, " "), msg)
+		}
+		return fmt.Errorf("systemctl --user %s: %w", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+func installUnits(alias string, files []unitFile) error {
+	if err := os.MkdirAll(unitDir(), 0755); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.WriteFile(f.path, []byte(f.content), 0644); err != nil {
+			return err
+		}
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	return systemctl("enable", "--now", filepath.Base(unitPath(alias)))
+}
+
+func uninstallUnits(alias string) error {
+	timer := unitPath(alias)
+	if _, err := os.Stat(timer); err != nil {
+		return fmt.Errorf("no timer installed for %s", alias)
+	}
+	if err := systemctl("disable", "--now", filepath.Base(timer)); err != nil {
+		printWarning("Warning: %v", err)
+	}
+	for _, path := range []string{timer, strings.TrimSuffix(timer, ".timer") + ".service"} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return systemctl("daemon-reload")
+}
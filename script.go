@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// interpreterSpec describes an interpreter selectable with save --runtime.
+type interpreterSpec struct {
+	// binaries are tried in order on PATH.
+	binaries []string
+	// args precede the script path on the interpreter command line.
+	args []string
+	// ext is the script file extension the interpreter expects.
+	ext string
+}
+
+var runtimes = map[string]interpreterSpec{
+	"bash":   {binaries: []string{"bash"}, ext: ".sh"},
+	"sh":     {binaries: []string{"sh"}, ext: ".sh"},
+	"python": {binaries: []string{"python3", "python"}, ext: ".py"},
+	"node":   {binaries: []string{"node"}, ext: ".js"},
+	"pwsh":   {binaries: []string{"pwsh", "powershell"}, args: []string{"-NoProfile", "-File"}, ext: ".ps1"},
+}
+
+// runtimeNames returns the supported runtime names, sorted.
+func runtimeNames() []string {
+	var names []string
+	for name := range runtimes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// interpreter returns the command line that runs the alias body, either
+// from its runtime or from the script's shebang, and the file extension the
+// script should be written with.
+func (a *Alias) interpreter() ([]string, string, error) {
+	if a.Runtime == "" {
+		return scriptInterpreter(a.Script), "", nil
+	}
+	rt, ok := runtimes[a.Runtime]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown runtime %q", a.Runtime)
+	}
+	for _, bin := range rt.binaries {
+		if path, err := exec.LookPath(bin); err == nil {
+			return append([]string{path}, rt.args...), rt.ext, nil
+		}
+	}
+	return nil, "", newError(exitRuntimeMissing, "runtime_missing",
+		fmt.Errorf("runtime %s is not installed: none of %s found on PATH", a.Runtime, strings.Join(rt.binaries, ", ")))
+}
+
+// scriptInterpreter returns the interpreter command line named by the
+// script's shebang, defaulting to sh.
+func scriptInterpreter(body string) []string {
+	first, _, _ := strings.Cut(body, "\n")
+	if !strings.HasPrefix(first, "#!") {
+		return []string{"sh"}
+	}
+	argv := strings.Fields(strings.TrimPrefix(first, "#!"))
+	if len(argv) == 0 {
+		return []string{"sh"}
+	}
+	return argv
+}
+
+// writeScript stores body in a private temporary file with extension ext
+// and returns its path together with a function that removes it again.
+func writeScript(body, ext string) (string, func(), error) {
+	f, err := os.CreateTemp("", "cmdex-script-*"+ext)
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+	if !strings.HasSuffix(body, "\n") {
+		body += "\n"
+	}
+	_, err = f.WriteString(body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0700)
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return f.Name(), cleanup, nil
+}
+
+// setBody stores body as the alias command, or as a script when it spans
+// several lines.
+func setBody(a *Alias, body string) {
+	body = strings.TrimRight(body, "\n")
+	if strings.Contains(body, "\n") {
+		a.Command, a.Script = "", body
+	} else {
+		a.Command, a.Script = strings.TrimSpace(body), ""
+	}
+}
+
+// isScript reports whether a runs as a script rather than a command line.
+func (a *Alias) isScript() bool {
+	return a.Script != "" || a.Runtime != ""
+}
+
+// body returns the script or command of a, whichever it has.
+func (a *Alias) body() string {
+	if a.Script != "" {
+		return a.Script
+	}
+	return a.Command
+}
+
+// summary is a one-line rendering of the alias body for listings.
+func (a *Alias) summary() string {
+	if a.Deprecated != nil {
+		return "deprecated, use " + a.Deprecated.Use
+	}
+	if len(a.Steps) > 0 {
+		return fmt.Sprintf("%s (step 1 of %d)", a.Steps[0].label(), len(a.Steps))
+	}
+	if a.Script == "" {
+		if len(a.Platforms) > 0 {
+			return fmt.Sprintf("%s (%d platform variants)", a.command(), len(a.Platforms))
+		}
+		return a.Command
+	}
+	first, _, _ := strings.Cut(a.Script, "\n")
+	return fmt.Sprintf("%s (script, %d lines)", first, strings.Count(a.Script, "\n")+1)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// outputBucket holds the recorded output of runs, gzipped, by history
+	// key.
+	outputBucket = []byte("output")
+	// idxOutputBucket indexes the words of the recorded output: it is
+	// keyed by word, NUL, history key. Encrypted databases have no word
+	// index, as its keys would give the output away, and are searched by
+	// reading every recorded output.
+	idxOutputBucket = []byte("idx_output")
+)
+
+// maxCapture caps the output recorded of a run.
+const maxCapture = 1 << 20
+
+// outputWords returns the distinct words of text, lowercased.
+func outputWords(text []byte) []string {
+	seen := make(map[string]bool)
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(string(text)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(w) < 2 || len(w) > 64 || seen[w] {
+			continue
+		}
+		seen[w] = true
+		words = append(words, w)
+	}
+	return words
+}
+
+// putOutput stores the output of the run kept under key and indexes it.
+func putOutput(tx *bolt.Tx, key, output []byte) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(output)
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	v, err := encodeValue(string(key), buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(outputBucket).Put(key, v); err != nil {
+		return err
+	}
+	return indexOutput(tx, key, output, true)
+}
+
+// indexOutput adds the words of output to the index, or removes them.
+func indexOutput(tx *bolt.Tx, key, output []byte, add bool) error {
+	if storeKey != nil {
+		return nil
+	}
+	b := tx.Bucket(idxOutputBucket)
+	for _, w := range outputWords(output) {
+		k := append(append([]byte(w), 0), key...)
+		var err error
+		if add {
+			err = b.Put(k, nil)
+		} else {
+			err = b.Delete(k)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getOutput returns the recorded output of the run kept under key, or nil
+// if none was recorded.
+func getOutput(tx *bolt.Tx, key []byte) ([]byte, error) {
+	v := tx.Bucket(outputBucket).Get(key)
+	if v == nil {
+		return nil, nil
+	}
+	return decodeOutput(key, v)
+}
+
+func decodeOutput(key, v []byte) ([]byte, error) {
+	v, err := decodeValue(string(key), v)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(v))
+	if err != nil {
+		return nil, fmt.Errorf("reading output of run %d: %w", binary.BigEndian.Uint64(key), err)
+	}
+	return io.ReadAll(zr)
+}
+
+// deleteOutput removes the recorded output of the run kept under key.
+func deleteOutput(tx *bolt.Tx, key []byte) error {
+	output, err := getOutput(tx, key)
+	if err != nil || output == nil {
+		return err
+	}
+	if err := indexOutput(tx, key, output, false); err != nil {
+		return err
+	}
+	return tx.Bucket(outputBucket).Delete(key)
+}
+
+// rebuildOutputIndex recreates the word index from the recorded output.
+func rebuildOutputIndex(tx *bolt.Tx) error {
+	if tx.Bucket(idxOutputBucket) != nil {
+		if err := tx.DeleteBucket(idxOutputBucket); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.CreateBucket(idxOutputBucket); err != nil {
+		return err
+	}
+	return tx.Bucket(outputBucket).ForEach(func(k, v []byte) error {
+		output, err := decodeOutput(k, v)
+		if err != nil {
+			return err
+		}
+		return indexOutput(tx, k, output, true)
+	})
+}
+
+// searchHit is a run whose output matched, with the matching lines.
+type searchHit struct {
+	entry *historyEntry
+	lines []string
+	// more counts the matching lines left out.
+	more int
+}
+
+// searchOutput returns the runs, newest first, whose output contains
+// query, ignoring case, up to limit of them when limit is positive.
+func searchOutput(tx *bolt.Tx, query, alias string, limit int) ([]searchHit, error) {
+	needle := strings.ToLower(query)
+	var hits []searchHit
+	for _, key := range outputCandidates(tx, query) {
+		output, err := getOutput(tx, key)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.Contains(strings.ToLower(string(output)), needle) {
+			continue
+		}
+		e, err := getHistory(tx, binary.BigEndian.Uint64(key))
+		if err != nil {
+			continue
+		}
+		if alias != "" && e.label() != alias {
+			continue
+		}
+		hit := searchHit{entry: e}
+		for _, line := range strings.Split(string(output), "\n") {
+			if !strings.Contains(strings.ToLower(line), needle) {
+				continue
+			}
+			if len(hit.lines) == 3 {
+				hit.more++
+				continue
+			}
+			hit.lines = append(hit.lines, strings.TrimRight(line, "\r"))
+		}
+		if hits = append(hits, hit); limit > 0 && len(hits) == limit {
+			break
+		}
+	}
+	return hits, nil
+}
+
+// outputCandidates returns the keys of the runs whose output may contain
+// query, newest first. The word index narrows them down to the runs with
+// words starting with each of the query's words; without it every run with
+// recorded output is a candidate.
+func outputCandidates(tx *bolt.Tx, query string) [][]byte {
+	var keys [][]byte
+	words := outputWords([]byte(query))
+	if storeKey != nil || len(words) == 0 {
+		tx.Bucket(outputBucket).ForEach(func(k, v []byte) error {
+			keys = append(keys, append([]byte(nil), k...))
+			return nil
+		})
+	} else {
+		var common map[string]bool
+		for _, w := range words {
+			found := make(map[string]bool)
+			c := tx.Bucket(idxOutputBucket).Cursor()
+			prefix := []byte(w)
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				if i := bytes.IndexByte(k, 0); i >= 0 && (common == nil || common[string(k[i+1:])]) {
+					found[string(k[i+1:])] = true
+				}
+			}
+			common = found
+		}
+		for k := range common {
+			keys = append(keys, []byte(k))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) > 0 })
+	return keys
+}
+
+func historySearchCmd() *cobra.Command {
+	var (
+		alias string
+		limit int
+	)
+	cmd := &cobra.Command{
+		Use:   "search <text>",
+		Short: "Find the runs whose output contains some text",
+		Long: `search lists the runs whose recorded output contains the text, ignoring
+case, newest first, with the lines that matched. Output is recorded for
+runs started with run --capture or exec --capture, or for every run with
+history.capture in the config:
+
+  history:
+    capture: true
+
+Up to 1 MiB of every run's output is kept; cmdex history show --output
+prints it. Commands writing to a terminal are run on a pseudo-terminal
+to record their output, as with --tee.`,
+		Example:     "  cmdex history search \"connection refused\"\n  cmdex history search --alias deploy timeout",
+		Annotations: readDB,
+		Args:        cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query := strings.Join(args, " ")
+			var hits []searchHit
+			err := db.View(func(tx *bolt.Tx) error {
+				var err error
+				hits, err = searchOutput(tx, query, alias, limit)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("searching history: %w", err)
+			}
+			if len(hits) == 0 {
+				return fmt.Errorf("no recorded output contains %q", query)
+			}
+			for i, hit := range hits {
+				if i > 0 {
+					fmt.Println()
+				}
+				e := hit.entry
+				fmt.Printf("%d  %s  %s  %s  exit %d\n", e.ID, e.RunID, e.Time.Local().Format("2006-01-02 15:04:05"), paint("alias", e.label()), e.ExitCode)
+				for _, line := range hit.lines {
+					fmt.Println("    " + highlight(line, query))
+				}
+				if hit.more > 0 {
+					fmt.Printf("    (%d more lines)\n", hit.more)
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&alias, "alias", "", "Only search the runs of this alias, or (exec) for those of cmdex exec")
+	cmd.Flags().IntVarP(&limit, "limit", "n", 20, "Show at most this many runs (0 for all)")
+	return cmd
+}
+
+// highlight paints the occurrences of query in line, ignoring case.
+func highlight(line, query string) string {
+	lower, needle := strings.ToLower(line), strings.ToLower(query)
+	if len(lower) != len(line) {
+		return line
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(lower, needle)
+		if i < 0 || needle == "" {
+			b.WriteString(line)
+			return b.String()
+		}
+		b.WriteString(line[:i])
+		b.WriteString(paint("match", line[i:i+len(needle)]))
+		line, lower = line[i+len(needle):], lower[i+len(needle):]
+	}
+}
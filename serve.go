@@ -0,0 +1,747 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cmdex/store"
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// sharedDB is the database the daemon serves. It is only held open while
+// a request needs it, so cmdex commands run from a shell keep working next
+// to the daemon.
+var sharedDB = store.NewShared(func() (*bolt.DB, error) {
+	if err := openDB(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}, func(*bolt.DB) error {
+	closeDB()
+	return nil
+})
+
+// withDB runs fn with the database open, unless ctx is done by the time
+// the database is free.
+func withDB(ctx context.Context, fn func() error) error {
+	return sharedDB.Do(ctx, func(*bolt.DB) error { return fn() })
+}
+
+// aliasStore is the store the daemon serves. Its operations are bound to
+// the context of the request they are for and give up once it is done.
+type aliasStore interface {
+	get(ctx context.Context, name string) (*Alias, error)
+	// each calls fn for the aliases q selects and reports whether q.limit
+	// left some out.
+	each(ctx context.Context, q aliasQuery, fn func(a *Alias) error) (more bool, err error)
+	view(ctx context.Context, fn func(tx *bolt.Tx) error) error
+	update(ctx context.Context, fn func(tx *bolt.Tx) error) error
+}
+
+// sharedStore is the aliasStore of the database file, opened for every
+// operation.
+type sharedStore struct{}
+
+func (sharedStore) view(ctx context.Context, fn func(tx *bolt.Tx) error) error {
+	return sharedDB.View(ctx, fn)
+}
+
+func (sharedStore) update(ctx context.Context, fn func(tx *bolt.Tx) error) error {
+	return sharedDB.Update(ctx, fn)
+}
+
+func (st sharedStore) get(ctx context.Context, name string) (*Alias, error) {
+	var a *Alias
+	err := st.view(ctx, func(tx *bolt.Tx) error {
+		var err error
+		a, err = getVariantOrLayered(tx, name)
+		return err
+	})
+	return a, err
+}
+
+func (st sharedStore) each(ctx context.Context, q aliasQuery, fn func(a *Alias) error) (more bool, err error) {
+	err = st.view(ctx, func(tx *bolt.Tx) error {
+		var err error
+		more, err = q.each(tx, func(a *Alias) error {
+			// A client that went away doesn't keep a listing going.
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return fn(a)
+		})
+		return err
+	})
+	return more, err
+}
+
+// runner executes the runs the daemon has prepared.
+type runner interface {
+	run(ctx context.Context, s *sequence) error
+}
+
+// localRunner runs sequences in the daemon's own process.
+type localRunner struct{}
+
+func (localRunner) run(ctx context.Context, s *sequence) error {
+	return s.execute(ctx)
+}
+
+// server is the cmdex daemon: a small REST API over the alias store.
+type server struct {
+	token   string
+	metrics *runMetrics
+	store   aliasStore
+	runner  runner
+	// ctx ends when the daemon shuts down, canceling the runs that
+	// outlive their requests.
+	ctx     context.Context
+	changes changeFeed
+}
+
+func serveCmd() *cobra.Command {
+	var (
+		addr, grpcAddr string
+		watchInterval  time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run the cmdex daemon with a REST API",
+		Long: `serve exposes the alias store over HTTP:
+
+  GET  /aliases             list aliases; ?limit=N&after=<name> pages through
+                            them (the X-Next-After trailer holds the cursor
+                            of the next page), ?tag= and ?sort= select and
+                            order them
+  GET  /aliases/<name>      show an alias
+  PUT  /aliases/<name>      create or replace an alias, body as returned by GET
+  POST /aliases/<name>/run  run an alias, body {"args": [...], "yes": true, "timeout": "30s"};
+                            "force": true runs it within its cooldown
+  GET  /approvals           list pending approval requests; ?all=true lists
+                            decided ones too
+  GET  /approvals/<id>      show an approval request
+  POST /approvals/<id>/approve, POST /approvals/<id>/deny
+                            decide on a request, body {"reason": "..."}
+  POST /hooks/<name>        run the alias of a webhook (see below)
+  GET  /events              stream reloads of the config and layer files
+                            (see below)
+  GET  /metrics             Prometheus metrics
+  GET  /healthz             health check
+
+API requests authenticate with "Authorization: Bearer <token>". Users and
+their tokens are listed in the config file:
+
+  serve:
+    users:
+      alice: {token: "...", groups: [ops], admin: true}
+      bob:   {token: "...", groups: [dev]}
+
+and aliases restrict who may run or change them with an access: section in
+their spec:
+
+  access:
+    run: [alice, group:dev]
+    edit: [group:ops]
+    approve: [group:ops]
+    read_only: false
+
+Runs of aliases with approvers answer 202 Accepted with an approval request
+instead of running. Once another user approves the request, sending the
+same run again runs it (see cmdex approvals).
+
+--token (or CMDEX_SERVE_TOKEN) adds a shared administrator token. Without any
+tokens every client is treated as an administrator. /metrics and /healthz
+are always open.
+
+Webhooks run an alias for signed requests instead of bearer tokens:
+
+  serve:
+    hooks:
+      deploy:
+        alias: deploy            # defaults to the hook name
+        secret: "..."            # HMAC-SHA256 key
+        args: [ref, repository.name]
+        async: true
+
+The request body must be signed as "sha256=<hex HMAC>" in the
+X-Hub-Signature-256 or X-Cmdex-Signature header. The JSON payload fields
+listed in args become $1, $2, ...; without args the fields named like the
+alias's placeholders are used.
+
+With --grpc-addr the store and runner are also served over gRPC, with run
+output streamed as it is written. The service is defined in api/cmdex.proto;
+clients send the bearer token as "authorization" metadata.
+
+The daemon checks the config file and the layer files, .cmdex/aliases.yaml
+and the like, for edits every --watch-interval and reloads them, keeping
+the previous definitions of a file that doesn't parse. GET /events streams
+the reloads as server-sent events:
+
+  event: aliases_changed
+  data: {"event":"aliases_changed","files":[".../.cmdex/aliases.yaml"],"changed":["test"],...}
+
+with config_changed and reload_failed events alongside.`,
+		Args:        cobra.NoArgs,
+		Annotations: noDB,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkHooks(cfg); err != nil {
+				return err
+			}
+			// Check the database and unlock it, if encrypted, while the
+			// passphrase can still be asked for.
+			if err := withDB(cmd.Context(), func() error { return nil }); err != nil {
+				return err
+			}
+			// Nobody is at the daemon's terminal to answer prompts.
+			promptsDisabled = true
+			srv := &server{
+				token:   os.Getenv("CMDEX_SERVE_TOKEN"),
+				metrics: newRunMetrics(),
+				store:   sharedStore{},
+				runner:  localRunner{},
+				ctx:     cmd.Context(),
+			}
+			if t, _ := cmd.Flags().GetString("token"); t != "" {
+				srv.token = t
+			}
+			errc := make(chan error, 2)
+			if grpcAddr != "" {
+				go func() { errc <- srv.serveGRPC(grpcAddr) }()
+				fmt.Fprintf(os.Stderr, "cmdex daemon serving gRPC on %s\n", grpcAddr)
+			}
+			if watchInterval > 0 {
+				go srv.watchDefinitions(watchInterval)
+			}
+			go func() { errc <- http.ListenAndServe(addr, srv.routes()) }()
+			fmt.Fprintf(os.Stderr, "cmdex daemon listening on %s\n", addr)
+			select {
+			case err := <-errc:
+				return err
+			case <-srv.ctx.Done():
+				fmt.Fprintln(os.Stderr, "cmdex daemon stopped")
+				return nil
+			}
+		},
+	}
+	cmd.Flags().StringVar(&addr, "addr", "127.0.0.1:7070", "Address to listen on")
+	cmd.Flags().StringVar(&grpcAddr, "grpc-addr", "", "Also serve the gRPC API on this address (e.g. 127.0.0.1:7071)")
+	cmd.Flags().String("token", "", "Bearer token required for API requests")
+	cmd.Flags().DurationVar(&watchInterval, "watch-interval", 2*time.Second, "How often to check the config and layer files for edits (0: never)")
+	return cmd
+}
+
+func (srv *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", srv.handleHealth)
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		srv.metrics.write(w)
+	})
+	mux.HandleFunc("/aliases", srv.authorized(srv.handleList))
+	mux.HandleFunc("/aliases/", srv.authorized(srv.handleAlias))
+	mux.HandleFunc("/approvals", srv.authorized(srv.handleApprovals))
+	mux.HandleFunc("/approvals/", srv.authorized(srv.handleApproval))
+	mux.HandleFunc("/hooks/", srv.handleHook)
+	mux.HandleFunc("/events", srv.authorized(srv.handleEvents))
+	return mux
+}
+
+// watchDefinitions reloads the config and the layers whenever their files
+// change, every interval at most, and tells the clients of /events.
+func (srv *server) watchDefinitions(interval time.Duration) {
+	w := newDefinitionWatch()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-srv.ctx.Done():
+			return
+		case <-t.C:
+		}
+		paths := w.changed()
+		if len(paths) == 0 {
+			continue
+		}
+		// The handlers read the layers and the config while they hold
+		// sharedDB.
+		var events []changeEvent
+		sharedDB.Hold(func() { events = reloadDefinitions(paths) })
+		for _, e := range events {
+			fmt.Fprintf(os.Stderr, "cmdex daemon: %s\n", e)
+			srv.changes.publish(e)
+		}
+	}
+}
+
+// handleEvents streams the change events of the daemon to the client as
+// server-sent events, until it goes away.
+func (srv *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "streaming unsupported"})
+		return
+	}
+	events, stop := srv.changes.subscribe()
+	defer stop()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-srv.ctx.Done():
+			return
+		case e := <-events:
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Event, data)
+			flusher.Flush()
+		}
+	}
+}
+
+type userKey struct{}
+
+// requestUser returns the client authenticated for r.
+func requestUser(r *http.Request) *apiUser {
+	return r.Context().Value(userKey{}).(*apiUser)
+}
+
+// authenticate identifies the client sending token, returning nil if the
+// token is unknown.
+func (srv *server) authenticate(token string) *apiUser {
+	users := currentConfig().Serve.Users
+	if srv.token == "" && len(users) == 0 {
+		return &apiUser{name: currentUser(), admin: true}
+	}
+	equal := func(a, b string) bool {
+		return b != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+	}
+	if equal(token, srv.token) {
+		return &apiUser{name: currentUser(), admin: true}
+	}
+	for name, u := range users {
+		if equal(token, u.Token) {
+			return &apiUser{name: name, groups: u.Groups, admin: u.Admin}
+		}
+	}
+	return nil
+}
+
+// authorized wraps h with the bearer token check.
+func (srv *server) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := srv.authenticate(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if u == nil {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), userKey{}, u)))
+	}
+}
+
+func (srv *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if err := withDB(r.Context(), func() error { return nil }); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "error": classify(err).Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (srv *server) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	v := r.URL.Query()
+	q := aliasQuery{sortBy: "name", tag: v.Get("tag"), filter: v.Get("filter"), after: v.Get("after")}
+	if s := v.Get("sort"); s != "" {
+		q.sortBy = s
+	}
+	q.reverse = v.Get("reverse") == "true"
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, usageError(fmt.Errorf("invalid limit %q", s)))
+			return
+		}
+		q.limit = n
+	}
+
+	// The array is written as the aliases are read. A failure after the
+	// first alias leaves it unterminated, so clients see the listing is
+	// incomplete. The cursor of the next page follows as a trailer.
+	started := false
+	start := func() {
+		if !started {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Trailer", "X-Next-After")
+			w.WriteHeader(http.StatusOK)
+			io.WriteString(w, "[")
+			started = true
+		}
+	}
+	enc := json.NewEncoder(w)
+	var last string
+	more, err := srv.store.each(r.Context(), q, func(a *Alias) error {
+		start()
+		if last != "" {
+			io.WriteString(w, ",")
+		}
+		last = a.Name
+		return enc.Encode(aliasJSON{a.Name, a})
+	})
+	if err != nil {
+		if !started {
+			writeError(w, err)
+		}
+		return
+	}
+	start()
+	io.WriteString(w, "]\n")
+	if more {
+		w.Header().Set("X-Next-After", last)
+	}
+}
+
+func (srv *server) handleAlias(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/aliases/")
+	name, action, _ := strings.Cut(path, "/")
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		a, err := srv.store.get(r.Context(), name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, aliasJSON{a.Name, a})
+	case action == "" && r.Method == http.MethodPut:
+		srv.handlePut(w, r, name)
+	case action == "run" && r.Method == http.MethodPost:
+		srv.handleRun(w, r, name)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+}
+
+func (srv *server) handlePut(w http.ResponseWriter, r *http.Request, name string) {
+	body := aliasJSON{Alias: &Alias{}}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		writeError(w, usageError(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+	a := body.Alias
+	if err := a.validate(); err != nil {
+		writeError(w, usageError(err))
+		return
+	}
+	if err := srv.storeAlias(r.Context(), requestUser(r), name, a, r.RemoteAddr); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, aliasJSON{a.Name, a})
+}
+
+func (srv *server) handleApprovals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	all := r.URL.Query().Get("all") == "true"
+	list := []*approval{}
+	err := srv.store.view(r.Context(), func(tx *bolt.Tx) error {
+		return forEachApproval(tx, func(p *approval) error {
+			if all || p.Status == approvalPending {
+				list = append(list, p)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (srv *server) handleApproval(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/approvals/")
+	idText, action, _ := strings.Cut(path, "/")
+	id, err := parseApprovalID(idText)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var p *approval
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		err = srv.store.view(r.Context(), func(tx *bolt.Tx) error {
+			p, err = getApproval(tx, id)
+			return err
+		})
+	case (action == "approve" || action == "deny") && r.Method == http.MethodPost:
+		var req struct {
+			Reason string `json:"reason"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, usageError(fmt.Errorf("invalid request body: %w", err)))
+				return
+			}
+		}
+		err = srv.store.update(r.Context(), func(tx *bolt.Tx) error {
+			p, err = decideApproval(tx, requestUser(r), id, action == "approve", req.Reason, "serve "+r.RemoteAddr)
+			return err
+		})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// storeAlias saves a under name on behalf of u, keeping the usage
+// statistics of the alias it replaces. Only administrators may set access
+// rules. remote identifies the client in the audit log.
+func (srv *server) storeAlias(ctx context.Context, u *apiUser, name string, a *Alias, remote string) error {
+	return srv.store.update(ctx, func(tx *bolt.Tx) error {
+		now := time.Now()
+		a.Name, a.Created, a.Uses, a.LastUsed = name, now, 0, time.Time{}
+		a.Provenance = newProvenance("api", u.name+" at "+remote)
+		old, err := getAlias(tx, name)
+		switch {
+		case err == nil:
+			if !u.canEdit(old) {
+				return fmt.Errorf("%w: %s may not change %s", errForbidden, u.name, name)
+			}
+			a.Created, a.Uses, a.LastUsed = old.Created, old.Uses, old.LastUsed
+			if !u.admin {
+				a.Access = old.Access
+			}
+		case err != errAliasNotFound:
+			return err
+		case !u.admin && a.Access != nil:
+			return fmt.Errorf("%w: only administrators can set access rules", errForbidden)
+		}
+		a.Modified = now
+		if err := policy.checkAlias(a); err != nil {
+			return err
+		}
+		if err := putAlias(tx, a); err != nil {
+			return err
+		}
+		return writeAudit(tx, auditEntry{Action: "save", Target: name, User: u.name, Detail: "serve " + remote})
+	})
+}
+
+// runRequest is the body of POST /aliases/<name>/run.
+type runRequest struct {
+	Args []string `json:"args"`
+	// Yes confirms aliases that ask for confirmation before running.
+	Yes bool `json:"yes"`
+	// Force runs aliases within their cooldown.
+	Force   bool   `json:"force"`
+	Timeout string `json:"timeout"`
+}
+
+// runResponse reports the outcome of a run.
+type runResponse struct {
+	Alias    string  `json:"alias"`
+	ExitCode int     `json:"exit_code"`
+	Duration float64 `json:"duration_seconds"`
+	Output   string  `json:"output"`
+	Error    string  `json:"error,omitempty"`
+	Kind     string  `json:"kind,omitempty"`
+}
+
+func (srv *server) handleRun(w http.ResponseWriter, r *http.Request, name string) {
+	var req runRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, usageError(fmt.Errorf("invalid request body: %w", err)))
+			return
+		}
+	}
+	var timeout time.Duration
+	if req.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(req.Timeout); err != nil {
+			writeError(w, usageError(fmt.Errorf("invalid timeout: %w", err)))
+			return
+		}
+	}
+
+	srv.run(w, r, name, runSpec{
+		args:    func(*Alias) ([]string, error) { return req.Args, nil },
+		yes:     req.Yes,
+		force:   req.Force,
+		timeout: timeout,
+		user:    requestUser(r),
+	})
+}
+
+// runSpec describes a run started through the daemon.
+type runSpec struct {
+	// args returns the arguments to run the resolved alias with.
+	args    func(a *Alias) ([]string, error)
+	yes     bool
+	force   bool
+	timeout time.Duration
+	user    *apiUser
+	// async answers 202 Accepted right away instead of waiting for the
+	// run to finish.
+	async bool
+}
+
+// run runs the alias name for a request and writes the response.
+func (srv *server) run(w http.ResponseWriter, r *http.Request, name string, spec runSpec) {
+	s, err := srv.prepareRun(r.Context(), name, spec, r.RemoteAddr)
+	var pending *pendingError
+	if errors.As(err, &pending) {
+		writeJSON(w, http.StatusAccepted, pending.p)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var out bytes.Buffer
+	s.stdout, s.stderr = &out, &out
+	if spec.async {
+		// The run outlives the request.
+		go func() {
+			if resp := srv.execute(srv.ctx, s, spec.timeout); resp.Error != "" {
+				fmt.Fprintf(os.Stderr, "cmdex daemon: %s: %s\n", resp.Alias, resp.Error)
+			}
+		}()
+		writeJSON(w, http.StatusAccepted, map[string]string{"alias": s.alias.Name, "status": "accepted"})
+		return
+	}
+	resp := srv.execute(r.Context(), s, spec.timeout)
+	resp.Output = out.String()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// prepareRun resolves the alias name, checks that the client may run it and
+// records the use. remote identifies the client in the audit log.
+func (srv *server) prepareRun(ctx context.Context, name string, spec runSpec, remote string) (*sequence, error) {
+	var s *sequence
+	err := withDB(ctx, func() error {
+		a, err := loadAlias(name)
+		if err != nil {
+			return err
+		}
+		if a, err = followDeprecation(a); err != nil {
+			return err
+		}
+		name = a.Name
+		if u := spec.user; !u.canRun(a) {
+			return fmt.Errorf("%w: %s may not run %s", errForbidden, u.name, name)
+		}
+		if err := policy.checkAlias(a); err != nil {
+			return err
+		}
+		if needsConfirmation(a) && !spec.yes {
+			return usageError(fmt.Errorf("alias %s requires confirmation; send \"yes\": true", name))
+		}
+		args, err := spec.args(a)
+		if err != nil {
+			return err
+		}
+		if s, err = newSequence(a, args, nil); err != nil {
+			return err
+		}
+		if err := s.setEnvironment(false, nil); err != nil {
+			return err
+		}
+		// The output sent with notifications is redacted.
+		if len(a.notifyEntries(false)) > 0 {
+			if s.secrets, err = s.secretValues(); err != nil {
+				return fmt.Errorf("reading secrets to redact: %w", err)
+			}
+		}
+		if err := s.checkGuards(); err != nil {
+			return err
+		}
+		if a.needsApproval() {
+			if err := requireApproval(a, s.args, spec.user.name, "serve "+remote); err != nil {
+				return err
+			}
+		}
+		e := auditEntry{Target: name, User: spec.user.name, Detail: "serve " + remote}
+		if a.Cooldown != "" {
+			return db.Update(cooldownUpdate(e, spec.force))
+		}
+		return recordUse(e)
+	})
+	return s, err
+}
+
+// execute runs a prepared sequence and reports its outcome, without the
+// output, which goes wherever s writes it.
+func (srv *server) execute(ctx context.Context, s *sequence, timeout time.Duration) runResponse {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	err := srv.runner.run(ctx, s)
+	elapsed := time.Since(start)
+	srv.metrics.observe(s.alias.Name, elapsed, err)
+	resp := runResponse{Alias: s.alias.Name, Duration: elapsed.Seconds()}
+	if err != nil {
+		ce := classify(err)
+		resp.Error, resp.Kind, resp.ExitCode = ce.Error(), ce.kind, exitCodeOf(err)
+	}
+	return resp
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError reports a failure that happened before anything ran.
+func writeError(w http.ResponseWriter, err error) {
+	ce := classify(err)
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errAliasNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, errForbidden):
+		status = http.StatusForbidden
+	case ce.code == exitUsage || ce.code == exitPlaceholderMissing:
+		status = http.StatusBadRequest
+	case ce.code == exitDBLocked:
+		status = http.StatusServiceUnavailable
+	case ce.code == exitCooldown:
+		status = http.StatusTooManyRequests
+	case ce.code == exitAliasLocked:
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, map[string]interface{}{"error": ce.Error(), "kind": ce.kind, "code": ce.code})
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/term"
+)
+
+func shellCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "shell",
+		Short: "Start an interactive cmdex prompt",
+		Long: `shell reads cmdex commands line by line: any subcommand (list, save,
+edit, ...) without the leading "cmdex", or the name of an alias to run it.
+Tab completes commands and alias names and the arrow keys recall earlier
+lines. Edits of the layer files, such as .cmdex/aliases.yaml, and of the
+config apply from the next line on. "help" lists the commands, "help <command>" explains one, and
+"exit" or Ctrl-D leaves the shell.`,
+		Args:        cobra.NoArgs,
+		Annotations: noDB,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !interactive() || !isTerminal(os.Stdout) {
+				return fmt.Errorf("shell needs a terminal")
+			}
+			return runShell()
+		},
+	}
+}
+
+// shell is the state of an interactive session.
+type shell struct {
+	term     *term.Terminal
+	commands []string
+	aliases  []string
+}
+
+func runShell() error {
+	sh := &shell{}
+	for _, c := range newRootCmd().Commands() {
+		if !c.Hidden && c.Name() != "shell" {
+			sh.commands = append(sh.commands, c.Name())
+		}
+	}
+	sh.commands = append(sh.commands, "exit")
+	if err := sh.refresh(); err != nil {
+		return err
+	}
+
+	// Ctrl-C is for the commands run from the shell, not the shell itself.
+	// Catching the signal, unlike ignoring it, leaves it at its default in
+	// the commands started.
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer func() { term.Restore(fd, state) }()
+
+	sh.term = term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, paint("alias", "cmdex")+"> ")
+	sh.term.AutoCompleteCallback = sh.complete
+	fmt.Fprintln(sh.term, `cmdex shell: type "help" for commands, "exit" to leave`)
+	watch := newDefinitionWatch()
+	for {
+		if width, height, ok := terminalSize(); ok && width > 0 {
+			sh.term.SetSize(width, height)
+		}
+		line, err := sh.term.ReadLine()
+		if err == io.EOF {
+			os.Stdout.WriteString("\r\n")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		args, err := splitArgs(line)
+		if err != nil {
+			fmt.Fprintln(sh.term, paint("error", "Error: "+err.Error()))
+			continue
+		}
+		if len(args) > 0 && args[0] == "cmdex" {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			continue
+		}
+		switch args[0] {
+		case "exit", "quit":
+			return nil
+		case "shell":
+			fmt.Fprintln(sh.term, "Already in the cmdex shell")
+			continue
+		case "?":
+			args[0] = "help"
+		}
+		// Edits of the layer files since the last command apply to this
+		// one.
+		if paths := watch.changed(); len(paths) > 0 {
+			for _, e := range reloadDefinitions(paths) {
+				fmt.Fprintln(sh.term, paint("reload", e.String()))
+			}
+		}
+
+		// Commands get the terminal in its normal mode, so that their own
+		// prompts and editors work.
+		term.Restore(fd, state)
+		root := newRootCmd()
+		root.SetArgs(args)
+		cmd, err := root.ExecuteC()
+		flushWrites()
+		closeDB()
+		if err != nil {
+			reportError(cmd, err)
+		}
+		if err := sh.refresh(); err != nil {
+			printError("Error reading aliases: %v", err)
+		}
+		if state, err = term.MakeRaw(fd); err != nil {
+			return err
+		}
+	}
+}
+
+// refresh reloads the alias names used for completion.
+func (sh *shell) refresh() error {
+	if err := openDB(); err != nil {
+		return err
+	}
+	defer closeDB()
+	sh.aliases = sh.aliases[:0]
+	return db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(commandsBucket).ForEach(func(k, v []byte) error {
+			sh.aliases = append(sh.aliases, string(k))
+			return nil
+		})
+	})
+}
+
+// complete is the terminal's tab completion: commands and aliases for the
+// first word, aliases after it. When several names match, the common prefix
+// is filled in and the candidates are listed.
+func (sh *shell) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	start := strings.LastIndexAny(line[:pos], " \t") + 1
+	word := line[start:pos]
+	candidates := sh.aliases
+	if strings.TrimSpace(line[:start]) == "" {
+		candidates = append(append([]string(nil), sh.commands...), sh.aliases...)
+	}
+	var matches []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) {
+			matches = append(matches, c)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return line, pos, true
+	case 1:
+		completed := matches[0] + " "
+		return line[:start] + completed + line[pos:], start + len(completed), true
+	}
+	sort.Strings(matches)
+	prefix := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if prefix == word {
+		fmt.Fprintln(sh.term, strings.Join(matches, "  "))
+	}
+	return line[:start] + prefix + line[pos:], start + len(prefix), true
+}
+
+// splitArgs splits a shell line into words, honouring single and double
+// quotes and backslash escapes.
+func splitArgs(line string) ([]string, error) {
+	var (
+		args    []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	goruntime "runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// shimMarker is in every shim cmdex writes, so sync only ever replaces or
+// removes its own files.
+const shimMarker = "cmdex shim for alias"
+
+// shimNamePattern matches the alias names that can be command names.
+var shimNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.+-]*$`)
+
+// shimDir returns the directory the shims are written to.
+func shimDir() (string, error) {
+	if cfg.Shims.Dir != "" {
+		return expandHome(cfg.Shims.Dir), nil
+	}
+	return dataDir("shims")
+}
+
+// shimTarget is what the shims run: cmdex itself, with the database and
+// the config they were written with, whatever directory they are run in.
+type shimTarget struct {
+	exe string
+	env []string
+}
+
+func newShimTarget() (*shimTarget, error) {
+	job, err := newScheduledJob()
+	if err != nil {
+		return nil, err
+	}
+	store, err := filepath.Abs(dbPath())
+	if err != nil {
+		return nil, err
+	}
+	return &shimTarget{exe: job.exe, env: append([]string{"CMDEX_DB=" + store}, job.env...)}, nil
+}
+
+// shimAliases returns the aliases that want shims, in name order, and
+// reports those whose names can't be command names.
+func shimAliases() ([]*Alias, error) {
+	var aliases []*Alias
+	err := db.View(func(tx *bolt.Tx) error {
+		q := aliasQuery{sortBy: "name"}
+		_, err := q.each(tx, func(a *Alias) error {
+			if !a.Shim {
+				return nil
+			}
+			if !shimNamePattern.MatchString(a.Name) {
+				printWarning("Warning: alias %s can't be a command name; it gets no shim", a.Name)
+				return nil
+			}
+			aliases = append(aliases, a)
+			return nil
+		})
+		return err
+	})
+	return aliases, err
+}
+
+// shQuote quotes s for POSIX shells.
+func shQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shimFile returns the file name and contents of the shim of alias.
+func (t *shimTarget) shimFile(alias string) (string, string) {
+	var b strings.Builder
+	if goruntime.GOOS == "windows" {
+		fmt.Fprintf(&b, "@echo off\r\nrem %s %s; regenerate with cmdex shims sync\r\nsetlocal\r\n", shimMarker, alias)
+		for _, kv := range t.env {
+			fmt.Fprintf(&b, "set \"%s\"\r\n", kv)
+		}
+		fmt.Fprintf(&b, "\"%s\" run %s -- %%*\r\n", t.exe, alias)
+		return alias + ".cmd", b.String()
+	}
+	fmt.Fprintf(&b, "#!/bin/sh\n# %s %s; regenerate with cmdex shims sync\n", shimMarker, alias)
+	for _, kv := range t.env {
+		name, value, _ := strings.Cut(kv, "=")
+		fmt.Fprintf(&b, "%s=%s; export %s\n", name, shQuote(value), name)
+	}
+	fmt.Fprintf(&b, "exec %s run %s -- \"$@\"\n", shQuote(t.exe), shQuote(alias))
+	return alias, b.String()
+}
+
+// function returns a shell function running alias, for shell.
+func (t *shimTarget) function(shell, alias string) string {
+	var env []string
+	for _, kv := range t.env {
+		name, value, _ := strings.Cut(kv, "=")
+		env = append(env, name+"="+shQuote(value))
+	}
+	prefix := strings.Join(env, " ")
+	if shell == "fish" {
+		return fmt.Sprintf("function %s; env %s %s run %s -- $argv; end", alias, prefix, shQuote(t.exe), shQuote(alias))
+	}
+	return fmt.Sprintf("%s() { %s %s run %s -- \"$@\"; }", alias, prefix, shQuote(t.exe), shQuote(alias))
+}
+
+// isShim reports whether the file at path is a shim cmdex wrote.
+func isShim(path string) bool {
+	data, err := os.ReadFile(path)
+	return err == nil && bytes.Contains(data, []byte(shimMarker))
+}
+
+// onPath reports whether dir is one of the directories on PATH.
+func onPath(dir string) bool {
+	for _, d := range filepath.SplitList(os.Getenv("PATH")) {
+		if filepath.Clean(d) == filepath.Clean(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func shimsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "shims",
+		Short: "List the commands that run aliases directly",
+		Long: `Aliases saved with --shim (shim: true in a spec) get a command of their own,
+so that deploy runs what cmdex run deploy does. cmdex shims sync writes
+them as tiny scripts to a directory that belongs on PATH: shims.dir in the
+config, or cmdex/shims in the user's data directory. Run it again after
+saving or removing such aliases; it only touches the shims it wrote.
+
+Shims run the aliases from the database and config they were written with,
+in whatever directory they are started. cmdex shims functions prints shell
+functions doing the same, for eval in a shell's startup file instead.`,
+		Annotations: readDB,
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, err := shimDir()
+			if err != nil {
+				return err
+			}
+			note := ""
+			if !onPath(dir) {
+				note = " (not on PATH)"
+			}
+			fmt.Printf("Shims in %s%s:\n", dir, note)
+			entries, err := os.ReadDir(dir)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			n := 0
+			for _, e := range entries {
+				if isShim(filepath.Join(dir, e.Name())) {
+					fmt.Println("  " + paint("alias", strings.TrimSuffix(e.Name(), ".cmd")))
+					n++
+				}
+			}
+			if n == 0 {
+				fmt.Println("  none; save aliases with --shim and run cmdex shims sync")
+			}
+			return nil
+		},
+	}
+	cmd.AddCommand(shimsSyncCmd(), shimsFunctionsCmd())
+	return cmd
+}
+
+func shimsSyncCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:         "sync",
+		Short:       "Write the shims of the aliases that want them and remove the others",
+		Annotations: readDB,
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, err := shimDir()
+			if err != nil {
+				return err
+			}
+			target, err := newShimTarget()
+			if err != nil {
+				return err
+			}
+			aliases, err := shimAliases()
+			if err != nil {
+				return fmt.Errorf("reading aliases: %w", err)
+			}
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return fmt.Errorf("creating %s: %w", dir, err)
+			}
+			wanted := make(map[string]bool)
+			var written, removed int
+			for _, a := range aliases {
+				name, content := target.shimFile(a.Name)
+				path := filepath.Join(dir, name)
+				old, err := os.ReadFile(path)
+				if err == nil && !bytes.Contains(old, []byte(shimMarker)) {
+					printWarning("Warning: %s exists and isn't a cmdex shim; alias %s gets no shim", path, a.Name)
+					continue
+				}
+				other := nameClash(a.Name)
+				if other != "" && cfg.ForbidShadowing {
+					printWarning("Warning: shim %s would shadow %s, and forbid_shadowing is set in the config; skipping it", a.Name, other)
+					continue
+				}
+				wanted[name] = true
+				if err == nil && string(old) == content {
+					continue
+				}
+				if err := os.WriteFile(path, []byte(content), 0o755); err != nil {
+					return fmt.Errorf("writing shim: %w", err)
+				}
+				written++
+				if other != "" {
+					printWarning("Warning: shim %s has the name of %s; whichever comes first on PATH wins", a.Name, other)
+				}
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				path := filepath.Join(dir, e.Name())
+				if !wanted[e.Name()] && isShim(path) {
+					if err := os.Remove(path); err != nil {
+						return fmt.Errorf("removing shim: %w", err)
+					}
+					removed++
+				}
+			}
+			fmt.Printf("%d shims in %s: %d written, %d removed\n", len(wanted), dir, written, removed)
+			if !onPath(dir) {
+				printWarning("Warning: %s is not on PATH; add it to run the shims", dir)
+			}
+			return nil
+		},
+	}
+}
+
+// otherCommand returns the program on PATH, outside dir, named like the
+// shim name, if there is one.
+func otherCommand(name, dir string) string {
+	exts := []string{""}
+	if goruntime.GOOS == "windows" {
+		exts = filepath.SplitList(os.Getenv("PATHEXT"))
+	}
+	for _, d := range filepath.SplitList(os.Getenv("PATH")) {
+		if d == "" || filepath.Clean(d) == filepath.Clean(dir) {
+			continue
+		}
+		for _, ext := range exts {
+			path := filepath.Join(d, name+ext)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+func shimsFunctionsCmd() *cobra.Command {
+	var shell string
+	cmd := &cobra.Command{
+		Use:   "functions",
+		Short: "Print shell functions running the aliases that want shims",
+		Long: `functions prints a shell function for every alias saved with --shim, as an
+alternative to the shim scripts. Add this to the shell's startup file:
+
+  eval "$(cmdex shims functions)"            # bash, zsh
+  cmdex shims functions --shell fish | source`,
+		Annotations: readDB,
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if shell == "" {
+				shell = filepath.Base(os.Getenv("SHELL"))
+			}
+			switch shell {
+			case "bash", "zsh", "sh", "fish":
+			default:
+				return usageError(fmt.Errorf("unsupported --shell %q (use bash, zsh or fish)", shell))
+			}
+			target, err := newShimTarget()
+			if err != nil {
+				return err
+			}
+			aliases, err := shimAliases()
+			if err != nil {
+				return fmt.Errorf("reading aliases: %w", err)
+			}
+			for _, a := range aliases {
+				fmt.Println(target.function(shell, a.Name))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&shell, "shell", "", "Shell to write functions for: bash, zsh or fish (default: from $SHELL)")
+	return cmd
+}
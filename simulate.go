@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// planNode is one thing a simulated run would do, with the things it is
+// made of beneath it.
+type planNode struct {
+	text, role string
+	children   []*planNode
+}
+
+// add appends a child described by text, formatted like fmt.Sprintf.
+func (n *planNode) add(format string, args ...interface{}) *planNode {
+	child := &planNode{text: fmt.Sprintf(format, args...)}
+	n.children = append(n.children, child)
+	return child
+}
+
+// problem appends a child for what would make the real run fail.
+func (n *planNode) problem(format string, args ...interface{}) {
+	n.add(format, args...).role = "error"
+}
+
+// lines draws the tree below n, one line per node.
+func (n *planNode) lines() []string {
+	lines := []string{paint("alias", n.text)}
+	var walk func(n *planNode, indent string)
+	walk = func(n *planNode, indent string) {
+		for i, child := range n.children {
+			branch, next := "├── ", "│   "
+			if i == len(n.children)-1 {
+				branch, next = "└── ", "    "
+			}
+			lines = append(lines, indent+branch+paint(child.role, child.text))
+			walk(child, indent+next)
+		}
+	}
+	walk(n, "")
+	return lines
+}
+
+// simulateRun prints what run would do for alias with args: every step, as
+// expanded, the programs they start and the aliases they run in turn,
+// without running anything or recording a use.
+func simulateRun(ctx context.Context, alias string, args []string, opts runOptions) error {
+	a, err := loadAlias(alias)
+	if err != nil {
+		return fmt.Errorf("retrieving command: %w", err)
+	}
+	if a, err = followDeprecation(a); err != nil {
+		return err
+	}
+	remembered, err := loadAnswers(a.Name)
+	if err != nil {
+		return err
+	}
+	s, err := newSequence(a, args, remembered)
+	if err != nil {
+		return err
+	}
+	if err := s.configure(opts); err != nil {
+		return err
+	}
+	s.quiet = true
+	root := &planNode{text: strings.TrimSpace(a.Name + " " + quoteArgs(s.args))}
+	s.plan, s.planned = root, []string{a.Name}
+	if err := policy.checkAlias(a); err != nil {
+		root.problem("blocked: %v", err)
+	}
+	if err := s.checkGuards(); err != nil {
+		root.problem("guard: %v", err)
+	}
+	s.describe(root)
+	if err := s.executeRaw(ctx); err != nil {
+		root.problem("%v", err)
+	}
+	printLines(root.lines())
+	return nil
+}
+
+// describe adds what sets the run of s apart, other than its steps, to n.
+func (s *sequence) describe(n *planNode) {
+	a := s.alias
+	if s.dir != "" {
+		n.add("directory: %s", s.dir)
+	}
+	switch {
+	case s.sandbox != nil && s.sandbox.noNet:
+		n.add("sandboxed, without network access")
+	case s.sandbox != nil:
+		n.add("sandboxed")
+	case s.envCleared:
+		n.add("environment: cleared")
+	}
+	if len(a.Env) > 0 {
+		n.add("environment: %d variables set", len(a.Env))
+	}
+	if s.umask >= 0 {
+		n.add("umask: %03o", s.umask)
+	}
+	if a.Filter != "" {
+		n.add("output filtered through %s", a.Filter)
+	}
+	if needsConfirmation(a) {
+		n.add("asks for confirmation")
+	}
+}
+
+// simulateStep records what step i would do under s.plan instead of doing
+// it. Steps succeed; a registered output stands in as <steps.NAME>.
+func (s *sequence) simulateStep(ctx context.Context, i int, step Step, stdout io.Writer) {
+	render := func(text string) string {
+		if rendered, err := s.render(text); err == nil {
+			return rendered
+		}
+		return text
+	}
+	var n *planNode
+	output := "<steps." + step.Register + ">"
+	switch {
+	case step.HTTP != nil:
+		n = s.plan.add("[%d] http %s %s", i+1, step.HTTP.method(), render(step.HTTP.URL))
+		if step.HTTP.Body != "" {
+			n.add("body: %s", render(step.HTTP.Body))
+		}
+		if s.sandbox != nil && s.sandbox.noNet {
+			n.problem("http steps can't run with --no-net")
+		}
+	case step.WriteFile != nil:
+		n = s.plan.add("[%d] write_file %s (%d bytes)", i+1, render(step.WriteFile.Path), len(render(step.WriteFile.Content)))
+		if step.WriteFile.Cleanup {
+			n.add("removed again once the alias finishes")
+		}
+	case step.WaitFor != nil:
+		w := *step.WaitFor
+		w.Run, w.TCP, w.HTTP = render(w.Run), render(w.TCP), render(w.HTTP)
+		n = s.plan.add("[%d] wait_for %s", i+1, w.target())
+	case step.Prompt != nil:
+		n = s.plan.add("[%d] prompt %q", i+1, render(step.Prompt.Message))
+		switch {
+		case step.Prompt.Confirm:
+			output = "true"
+		case step.Prompt.Default != "":
+			output = step.Prompt.Default
+		default:
+			output = "<answer>"
+		}
+	default:
+		command := render(step.Run)
+		n = s.plan.add("[%d] %s", i+1, command)
+		s.simulateCommand(ctx, n, command)
+	}
+	if step.When != "" {
+		n.add("when %s", step.When)
+	}
+	if step.Register != "" {
+		fmt.Fprint(stdout, output)
+		n.add("output registered as steps.%s", step.Register)
+	}
+}
+
+// simulateCommand adds to n the program command would start and, if it
+// runs another alias with cmdex run, that alias's plan.
+func (s *sequence) simulateCommand(ctx context.Context, n *planNode, command string) {
+	argv := strings.Fields(command)
+	if len(argv) == 0 {
+		n.problem("empty command")
+		return
+	}
+	if err := policy.checkCommand(command); err != nil {
+		n.problem("blocked: %v", err)
+	}
+	if path, err := exec.LookPath(argv[0]); err != nil {
+		n.problem("%s not found on PATH; %s", argv[0], binaryHint(argv[0]))
+	} else if path != argv[0] {
+		n.add("program: %s", path)
+	}
+	alias, args, ok := composedRun(argv)
+	if !ok {
+		return
+	}
+	for _, name := range s.planned {
+		if name == alias {
+			n.problem("alias %s runs itself again: %s", alias, strings.Join(append(s.planned, alias), " → "))
+			return
+		}
+	}
+	a, err := loadAlias(alias)
+	if err == nil {
+		a, err = followDeprecation(a)
+	}
+	var sub *sequence
+	if err == nil {
+		sub, err = newSequence(a, args, nil)
+	}
+	if err != nil {
+		n.problem("alias %s: %v", alias, err)
+		return
+	}
+	sub.quiet = true
+	if err := sub.setEnvironment(false, nil); err != nil {
+		n.problem("alias %s: %v", alias, err)
+		return
+	}
+	sub.plan = n.add("alias %s", strings.TrimSpace(a.Name+" "+quoteArgs(sub.args)))
+	sub.plan.role = "alias"
+	sub.planned = append(append([]string(nil), s.planned...), a.Name)
+	sub.describe(sub.plan)
+	if err := sub.executeRaw(ctx); err != nil {
+		sub.plan.problem("%v", err)
+	}
+}
+
+// composedRun reports whether argv runs an alias with cmdex run, and which
+// one with what arguments.
+func composedRun(argv []string) (alias string, args []string, ok bool) {
+	self := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	if name := programName(argv[0]); name != "cmdex" && name != self {
+		return "", nil, false
+	}
+	rest := argv[1:]
+	for len(rest) > 0 && strings.HasPrefix(rest[0], "-") {
+		rest = rest[1:]
+	}
+	if len(rest) == 0 || rest[0] != "run" {
+		return "", nil, false
+	}
+	cmd := runCmd()
+	if err := cmd.ParseFlags(rest[1:]); err != nil {
+		return "", nil, false
+	}
+	if tag, _ := cmd.Flags().GetString("tag"); tag != "" {
+		return "", nil, false
+	}
+	positional := cmd.Flags().Args()
+	if len(positional) == 0 {
+		return "", nil, false
+	}
+	return positional[0], positional[1:], true
+}
+
+// simulateScript records what running the script of s would do.
+func (s *sequence) simulateScript(interp []string, body string) {
+	shown := append(append(append([]string(nil), interp...), "<script>"), s.args...)
+	n := s.plan.add("%s", quoteArgs(shown))
+	if err := policy.checkCommand(quoteArgs(interp) + "\n" + body); err != nil {
+		n.problem("blocked: %v", err)
+	}
+	if _, err := exec.LookPath(interp[0]); err != nil {
+		n.problem("%s not found on PATH; %s", interp[0], binaryHint(interp[0]))
+	}
+	for _, line := range strings.Split(strings.TrimRight(body, "\n"), "\n") {
+		n.add("%s", line)
+	}
+}
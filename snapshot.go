@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// secretVarWords mark the names of variables whose values history
+// snapshots don't store in the clear.
+var secretVarWords = []string{"TOKEN", "SECRET", "PASSWORD", "PASSWD", "PASSPHRASE", "KEY", "CREDENTIAL", "AUTH"}
+
+// isSecretVar reports whether the variable name likely holds a secret.
+func isSecretVar(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, w := range secretVarWords {
+		if strings.Contains(upper, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// workDir returns the absolute path of the directory the commands run in.
+func (s *sequence) workDir() string {
+	if s.dir == "" {
+		dir, _ := os.Getwd()
+		return dir
+	}
+	if abs, err := filepath.Abs(s.dir); err == nil {
+		return abs
+	}
+	return s.dir
+}
+
+// environment returns the working directory and environment the sequence's
+// commands run with, for recording in the history. Values of sensitive
+// variables, and values holding a secret the run redacts, are replaced by
+// a digest, which still shows when they changed.
+func (s *sequence) environment() (string, map[string]string) {
+	dir := s.workDir()
+	environ := os.Environ()
+	if s.env != nil {
+		environ = s.env
+	}
+	env := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		k, v, _ := strings.Cut(kv, "=")
+		if sensitiveName(k) || s.holdsSecret(v) {
+			sum := sha256.Sum256([]byte(v))
+			v = "sha256:" + hex.EncodeToString(sum[:6])
+		}
+		env[k] = v
+	}
+	return dir, env
+}
+
+// historyEntry returns the history record of a run of the sequence, with
+// a snapshot if the sequence takes one.
+func (s *sequence) historyEntry(start time.Time, d time.Duration, err error) historyEntry {
+	host, _ := os.Hostname()
+	e := historyEntry{RunID: s.runID, Alias: s.alias.ref(), Args: s.args, Time: start, Duration: d, ExitCode: exitCodeOf(err),
+		Host: host, User: currentUser(), Sandboxed: s.sandbox != nil, EnvCleared: s.envCleared, Vars: s.usedVars(), Steps: s.steps, Log: s.log, Artifacts: s.artifacts, output: s.output.output()}
+	if s.alias.Name == "" {
+		e.Command = s.maskSecrets(s.alias.Command)
+	}
+	if s.snapshot {
+		e.Dir, e.Env = s.environment()
+	} else {
+		e.Dir = s.workDir()
+	}
+	return e
+}
+
+// getHistory returns the history entry with the given id.
+func getHistory(tx *bolt.Tx, id uint64) (*historyEntry, error) {
+	key := historyKey(id)
+	v := tx.Bucket(historyBucket).Get(key)
+	if v == nil {
+		return nil, fmt.Errorf("no run %d in the history", id)
+	}
+	return decodeHistory(key, v)
+}
+
+func historyDiffCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "diff <run1> <run2>",
+		Short: "Compare two runs, including their environment snapshots",
+		Long: `diff compares two runs from the history by their numbers or run IDs: the alias, its
+arguments, exit code and duration and, for runs recorded with
+run --snapshot (or history.snapshot in the config), the working directory
+and every environment variable that was added, removed or changed.`,
+		Example: "  cmdex run --snapshot deploy staging\n  cmdex history\n  cmdex history diff 41 42",
+		Args:    cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var a, b *historyEntry
+			err := db.View(func(tx *bolt.Tx) error {
+				var err error
+				if a, err = resolveRun(tx, args[0]); err != nil {
+					return err
+				}
+				b, err = resolveRun(tx, args[1])
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("reading history: %w", err)
+			}
+			printLines(diffHistory(a, b))
+			return nil
+		},
+	}
+}
+
+// diffHistory describes how run b differs from run a.
+func diffHistory(a, b *historyEntry) []string {
+	var t table
+	field := func(name, x, y string) {
+		if x == y {
+			return
+		}
+		if x == "" {
+			x = "(none)"
+		}
+		if y == "" {
+			y = "(none)"
+		}
+		t.add(name, x, "->", y)
+	}
+	field("alias", a.label(), b.label())
+	field("args", a.argsText(), b.argsText())
+	field("exit code", strconv.Itoa(a.ExitCode), strconv.Itoa(b.ExitCode))
+	field("duration", a.Duration.Round(time.Millisecond).String(), b.Duration.Round(time.Millisecond).String())
+
+	lines := []string{fmt.Sprintf("Run %d (%s) -> run %d (%s)", a.ID, formatTime(a.Time), b.ID, formatTime(b.Time))}
+	lines = append(lines, t.lines(0)...)
+	for _, e := range []*historyEntry{a, b} {
+		if e.Env == nil {
+			lines = append(lines, fmt.Sprintf("Run %d has no environment snapshot; record one with cmdex run --snapshot", e.ID))
+		}
+	}
+	if a.Env == nil || b.Env == nil {
+		return lines
+	}
+	if a.Dir != b.Dir {
+		lines = append(lines, "", "Working directory:", paint("removed", "  - "+a.Dir), paint("added", "  + "+b.Dir))
+	}
+	names := make(map[string]bool)
+	for k := range a.Env {
+		names[k] = true
+	}
+	for k := range b.Env {
+		names[k] = true
+	}
+	var keys []string
+	for k := range names {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var env []string
+	for _, k := range keys {
+		x, inA := a.Env[k]
+		y, inB := b.Env[k]
+		switch {
+		case !inA:
+			env = append(env, paint("added", "  + "+k+"="+y))
+		case !inB:
+			env = append(env, paint("removed", "  - "+k+"="+x))
+		case x != y:
+			env = append(env, paint("removed", "  - "+k+"="+x), paint("added", "  + "+k+"="+y))
+		}
+	}
+	if len(env) == 0 {
+		return append(lines, "", "Environment: identical")
+	}
+	return append(append(lines, "", "Environment:"), env...)
+}
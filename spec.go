@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadSpec reads an alias definition from a YAML file. Unknown fields are
+// rejected so that typos don't silently change what an alias does.
+func loadSpec(path string) (*Alias, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading spec: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	a := &Alias{}
+	if err := dec.Decode(a); err != nil {
+		return nil, fmt.Errorf("parsing spec %s: %w", path, err)
+	}
+	if err := a.validate(); err != nil {
+		return nil, fmt.Errorf("spec %s: %w", path, err)
+	}
+	return a, nil
+}
+
+// validate checks that a definition read from outside the store is complete.
+func (a *Alias) validate() error {
+	if a.Command == "" && a.Script == "" && len(a.Steps) == 0 {
+		return fmt.Errorf("defines no command, script or steps")
+	}
+	if err := a.Limits.validate(); err != nil {
+		return err
+	}
+	if a.Filter != "" {
+		if err := checkFilter(a.Filter); err != nil {
+			return err
+		}
+	}
+	if err := checkArtifacts(a.Artifacts); err != nil {
+		return err
+	}
+	if err := checkNotify(a.Notify); err != nil {
+		return err
+	}
+	if _, err := a.matrixAxes(a.Matrix); err != nil {
+		return err
+	}
+	if err := checkEnv(a.Env, a.EnvAllow); err != nil {
+		return err
+	}
+	if err := a.checkLocale(); err != nil {
+		return err
+	}
+	if a.Stdin != "" && a.StdinFile != "" {
+		return fmt.Errorf("stdin and stdin_file can't both be set")
+	}
+	if err := a.Guards.validate(); err != nil {
+		return err
+	}
+	if a.Cooldown != "" {
+		if _, err := checkCooldown(a.Cooldown); err != nil {
+			return err
+		}
+	}
+	if a.Schedule != "" {
+		if _, err := parseCron(a.Schedule); err != nil {
+			return err
+		}
+	}
+	for i, step := range a.Steps {
+		kinds := 0
+		for _, set := range []bool{step.Run != "", step.HTTP != nil, step.WriteFile != nil, step.Prompt != nil, step.WaitFor != nil} {
+			if set {
+				kinds++
+			}
+		}
+		switch {
+		case kinds == 0:
+			return fmt.Errorf("step %d has none of run, http, write_file, prompt and wait_for", i+1)
+		case kinds > 1:
+			return fmt.Errorf("step %d has more than one of run, http, write_file, prompt and wait_for", i+1)
+		case step.HTTP != nil && step.HTTP.URL == "":
+			return fmt.Errorf("step %d: http needs a url", i+1)
+		case step.WriteFile != nil && step.WriteFile.Path == "":
+			return fmt.Errorf("step %d: write_file needs a path", i+1)
+		}
+		if step.WriteFile != nil {
+			if _, err := step.WriteFile.fileMode(); err != nil {
+				return fmt.Errorf("step %d: %w", i+1, err)
+			}
+		}
+		if step.Prompt != nil {
+			if err := step.Prompt.validate(step.Register); err != nil {
+				return fmt.Errorf("step %d: %w", i+1, err)
+			}
+		}
+		if step.WaitFor != nil {
+			if err := step.WaitFor.validate(); err != nil {
+				return fmt.Errorf("step %d: %w", i+1, err)
+			}
+		}
+	}
+	return nil
+}
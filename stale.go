@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	goruntime "runtime"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// shellBuiltins are the builtins and keywords of POSIX shells, which are
+// never on PATH.
+var shellBuiltins = map[string]bool{
+	".": true, ":": true, "[": true, "[[": true, "{": true, "}": true, "!": true, "alias": true, "bg": true,
+	"break": true, "builtin": true, "case": true, "cd": true, "command": true, "continue": true, "do": true,
+	"done": true, "echo": true, "elif": true, "else": true, "esac": true, "eval": true, "exec": true,
+	"exit": true, "export": true, "false": true, "fg": true, "fi": true, "for": true, "function": true,
+	"getopts": true, "hash": true, "if": true, "jobs": true, "kill": true, "local": true, "popd": true,
+	"printf": true, "pushd": true, "pwd": true, "read": true, "readonly": true, "return": true, "set": true,
+	"shift": true, "source": true, "test": true, "then": true, "time": true, "trap": true, "true": true,
+	"type": true, "ulimit": true, "umask": true, "unalias": true, "unset": true, "until": true, "wait": true,
+	"while": true,
+}
+
+// shells are the interpreters whose scripts are checked line by line for
+// the programs they run.
+var shells = map[string]bool{"sh": true, "bash": true, "dash": true, "zsh": true, "ksh": true}
+
+// requiredBinaries returns the programs a needs on this machine's PATH, as
+// far as they can be told before it runs: the program each of its commands
+// and command steps starts, or the interpreter of its script and, for shell
+// scripts, the programs the script's lines start.
+func requiredBinaries(a *Alias) []string {
+	seen := make(map[string]bool)
+	var names []string
+	add := func(name string) {
+		switch {
+		case name == "", seen[name], strings.ContainsAny(name, "${}\"'"):
+		case strings.ContainsRune(name, '/') && !filepath.IsAbs(name):
+			// Relative paths depend on the directory the alias runs in.
+		default:
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if a.isScript() {
+		shell := a.Runtime == "sh" || a.Runtime == "bash"
+		if a.Runtime == "" {
+			argv := scriptInterpreter(a.Script)
+			if programName(argv[0]) == "env" && len(argv) > 1 {
+				argv = argv[1:]
+			}
+			add(argv[0])
+			shell = shells[programName(argv[0])]
+		}
+		// Other runtimes are found by their own check.
+		if shell {
+			for _, line := range policyLines(a.Script) {
+				for _, name := range programNames(line) {
+					if !shellBuiltins[name] {
+						add(name)
+					}
+				}
+			}
+		}
+		return names
+	}
+	texts := []string{a.command()}
+	for _, step := range a.Steps {
+		texts = append(texts, step.Run)
+	}
+	for _, text := range texts {
+		for _, name := range commandPrograms(text) {
+			add(name)
+		}
+	}
+	return names
+}
+
+// commandPrograms returns the programs a command runs: the one its first
+// word names and, past wrappers like sudo and their options, the one the
+// wrapper runs. Commands run without a shell, so that is all of them.
+func commandPrograms(command string) []string {
+	var names []string
+	for i, word := range strings.Fields(command) {
+		if i > 0 && (strings.HasPrefix(word, "-") || strings.Contains(word, "=") && !strings.Contains(word, "/")) {
+			continue
+		}
+		name := programName(word)
+		names = append(names, name)
+		if !commandWrappers[name] {
+			break
+		}
+	}
+	return names
+}
+
+// missingBinaries returns the programs a needs that aren't installed.
+func missingBinaries(a *Alias) []string {
+	var missing []string
+	for _, name := range requiredBinaries(a) {
+		if _, err := exec.LookPath(name); err != nil {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// versionManagerDirs are glob patterns, under the home directory unless
+// absolute, of where version managers and installers put programs that may
+// have dropped off PATH.
+var versionManagerDirs = []string{
+	".nvm/versions/node/*/bin", ".volta/bin", ".asdf/shims", ".asdf/installs/*/*/bin",
+	".pyenv/shims", ".pyenv/versions/*/bin", ".rbenv/shims", ".sdkman/candidates/*/current/bin",
+	".cargo/bin", "go/bin", ".local/bin", "bin", "/opt/homebrew/bin", "/usr/local/bin", "/snap/bin",
+}
+
+// binaryHint suggests what to do about the missing program name: where it
+// can still be found, if one of the version managers has it, or otherwise
+// to install it.
+func binaryHint(name string) string {
+	home, _ := os.UserHomeDir()
+	var found []string
+	for _, pattern := range versionManagerDirs {
+		if !filepath.IsAbs(pattern) {
+			if home == "" {
+				continue
+			}
+			pattern = filepath.Join(home, pattern)
+		}
+		matches, _ := filepath.Glob(filepath.Join(pattern, name))
+		for _, path := range matches {
+			if info, err := os.Stat(path); err == nil && !info.IsDir() && (goruntime.GOOS == "windows" || info.Mode()&0o111 != 0) {
+				found = append(found, path)
+			}
+		}
+	}
+	if len(found) == 0 {
+		return "install it, or change the alias"
+	}
+	// Of several versions, suggest the last one in name order.
+	sort.Strings(found)
+	path := found[len(found)-1]
+	if home != "" && strings.HasPrefix(path, home+string(filepath.Separator)) {
+		path = "~" + strings.TrimPrefix(path, home)
+	}
+	return fmt.Sprintf("%s exists; add %s to PATH or switch to that version", path, filepath.Dir(path))
+}
+
+// lintMissingBinaries flags aliases whose programs disappeared, as after
+// an uninstall or switching versions in a version manager.
+func lintMissingBinaries(a *Alias) []lintFinding {
+	if a.Deprecated != nil {
+		return nil
+	}
+	var findings []lintFinding
+	for _, name := range missingBinaries(a) {
+		findings = append(findings, lintFinding{
+			message: fmt.Sprintf("%s not found; alias %s will fail (%s)", name, a.Name, binaryHint(name)),
+		})
+	}
+	return findings
+}
+
+// warnMissingBinaries explains a run of a that failed because a program
+// wasn't found.
+func warnMissingBinaries(a *Alias) {
+	for _, name := range missingBinaries(a) {
+		printWarning("Warning: %s not found on PATH; %s", name, binaryHint(name))
+	}
+}
+
+func doctorCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "doctor",
+		Short: "Find aliases that will fail because their programs are missing",
+		Long: `doctor checks every alias, including those from layers, for programs it
+runs that aren't on PATH, as after an uninstall or switching versions in a
+version manager, and suggests what to do about each, naming the owner and
+team of aliases that record them. It exits with an error when it finds any.`,
+		Args:        cobra.NoArgs,
+		Annotations: readDB,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var aliases []*Alias
+			err := db.View(func(tx *bolt.Tx) error {
+				for _, a := range layeredAliases(tx) {
+					aliases = append(aliases, a)
+				}
+				return forEachAlias(tx, func(a *Alias) error {
+					aliases = append(aliases, a)
+					return nil
+				})
+			})
+			if err != nil {
+				return fmt.Errorf("checking aliases: %w", err)
+			}
+			sort.Slice(aliases, func(i, j int) bool { return aliases[i].Name < aliases[j].Name })
+
+			var (
+				t      table
+				broken int
+			)
+			t.color(0, "alias")
+			for _, a := range aliases {
+				if a.Deprecated != nil {
+					continue
+				}
+				missing := missingBinaries(a)
+				if len(missing) > 0 {
+					broken++
+				}
+				for _, name := range missing {
+					hint := binaryHint(name)
+					// Shared aliases name whom to ask about them.
+					if m := a.maintainer(); m != "" {
+						hint += "; maintained by " + m
+					}
+					t.add(a.Name, name+" not found", hint)
+				}
+			}
+			if broken == 0 {
+				fmt.Printf("All %d aliases can find their programs\n", len(aliases))
+				return nil
+			}
+			printLines(t.lines(0))
+			return fmt.Errorf("doctor found %d aliases that will fail", broken)
+		},
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// hasStdin reports whether a feeds its commands a stdin payload.
+func (a *Alias) hasStdin() bool {
+	return a.Stdin != "" || a.StdinFile != ""
+}
+
+// stdinPayload returns the stdin payload of s's alias with its templates
+// expanded, reading it from the alias's stdin file if it has one. The
+// payload is prepared before anything runs, so it can't refer to the
+// output of steps.
+func (s *sequence) stdinPayload() (string, error) {
+	a := s.alias
+	payload := a.Stdin
+	if a.StdinFile != "" {
+		path, err := expandTemplate(a.StdinFile, s.data)
+		if err != nil {
+			return "", fmt.Errorf("stdin file: %w", err)
+		}
+		if path = expandHome(path); !filepath.IsAbs(path) {
+			path = filepath.Join(s.dir, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("reading the stdin of %s: %w", a.Name, err)
+		}
+		payload = string(data)
+	}
+	if !strings.Contains(payload, "{{") {
+		return payload, nil
+	}
+	if s.data.vars == nil {
+		vars, err := loadVars()
+		if err != nil {
+			return "", fmt.Errorf("loading variables: %w", err)
+		}
+		s.data.vars = vars
+	}
+	payload, err := expandTemplate(payload, s.data)
+	if err != nil {
+		return "", fmt.Errorf("stdin: %w", err)
+	}
+	return payload, nil
+}
@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	goruntime "runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Step is one command of a multi-step alias.
+type Step struct {
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	Run  string `json:"run" yaml:"run"`
+	// When is a condition deciding whether the step runs, for example
+	// `os == "darwin"` or `exit_code != 0`. Without one, a step only runs
+	// if every step before it succeeded.
+	When string `json:"when,omitempty" yaml:"when,omitempty"`
+	// Register captures the step's stdout, without its trailing newline,
+	// for later steps to use as {{steps.NAME}}.
+	Register string `json:"register,omitempty" yaml:"register,omitempty"`
+	// HTTP makes this a built-in HTTP request step instead of a command.
+	HTTP *HTTPStep `json:"http,omitempty" yaml:"http,omitempty"`
+	// WriteFile makes this a built-in step that writes a file.
+	WriteFile *WriteFileStep `json:"write_file,omitempty" yaml:"write_file,omitempty"`
+	// Prompt makes this a built-in step that asks the user a question;
+	// register: keeps the answer.
+	Prompt *PromptStep `json:"prompt,omitempty" yaml:"prompt,omitempty"`
+	// WaitFor makes this a built-in step that waits until a check passes.
+	WaitFor *WaitForStep `json:"wait_for,omitempty" yaml:"wait_for,omitempty"`
+}
+
+// label is how the step is referred to in progress output.
+func (s Step) label() string {
+	switch {
+	case s.Name != "":
+		return s.Name
+	case s.HTTP != nil:
+		return s.HTTP.method() + " " + s.HTTP.URL
+	case s.WriteFile != nil:
+		return "write " + s.WriteFile.Path
+	case s.Prompt != nil:
+		return s.Prompt.Message
+	case s.WaitFor != nil:
+		return "wait for " + s.WaitFor.target()
+	}
+	return s.Run
+}
+
+// text describes what the step does, for listings of the steps.
+func (s Step) text() string {
+	switch {
+	case s.HTTP != nil:
+		return "http " + s.HTTP.method() + " " + s.HTTP.URL
+	case s.WriteFile != nil:
+		text := "write_file " + s.WriteFile.Path
+		if s.WriteFile.Cleanup {
+			text += " (cleaned up)"
+		}
+		return text
+	case s.Prompt != nil:
+		text := "prompt " + strconv.Quote(s.Prompt.Message)
+		if len(s.Prompt.Choices) > 0 {
+			text += " [" + strings.Join(s.Prompt.Choices, "|") + "]"
+		}
+		return text
+	case s.WaitFor != nil:
+		return "wait_for " + s.WaitFor.target()
+	}
+	return s.Run
+}
+
+// templates returns every templated string of the step.
+func (s Step) templates() []string {
+	texts := []string{s.Run}
+	if s.HTTP != nil {
+		texts = append(texts, s.HTTP.URL, s.HTTP.Body)
+		for _, v := range s.HTTP.Headers {
+			texts = append(texts, v)
+		}
+	}
+	if s.WriteFile != nil {
+		texts = append(texts, s.WriteFile.Path, s.WriteFile.Content)
+	}
+	if s.Prompt != nil {
+		texts = append(texts, s.Prompt.Message)
+	}
+	if s.WaitFor != nil {
+		texts = append(texts, s.WaitFor.Run, s.WaitFor.TCP, s.WaitFor.HTTP)
+	}
+	return texts
+}
+
+// steps returns the steps to run for a: its declared steps, or its command
+// for this platform as a single step.
+func (a *Alias) steps() []Step {
+	if len(a.Steps) > 0 {
+		return a.Steps
+	}
+	return []Step{{Run: a.command()}}
+}
+
+// sequence is the state of one run through an alias's steps.
+type sequence struct {
+	alias *Alias
+	args  []string
+	data  *templateData
+	// argv, when set, is the command of cmdex exec, which runs exactly as
+	// given: without templates, placeholders or splitting into words.
+	argv []string
+	// dir is the working directory of the commands, empty for cmdex's.
+	dir string
+	// stdin is the alias's stdin payload with its templates expanded.
+	stdin string
+	// exitCode is the exit status of the most recently run step.
+	exitCode int
+	// httpStatus is the response status of the most recent HTTP step.
+	httpStatus int
+	// stdout and stderr receive the output of everything that runs.
+	stdout, stderr io.Writer
+	// progress, when set, receives the step headers instead of stderr,
+	// which then only carries the commands' output.
+	progress io.Writer
+	// tty, when set, is the pseudo-terminal the commands take as stdin
+	// and controlling terminal.
+	tty *os.File
+	// sandbox, when set, confines the commands that run.
+	sandbox *sandbox
+	// env is the environment of the commands, nil for cmdex's own;
+	// envCleared says it holds only the allowed variables of cmdex's.
+	env        []string
+	envCleared bool
+	// umask is the file mode creation mask of the commands, negative for
+	// cmdex's own.
+	umask int
+	// limits caps the resources of every process started.
+	limits *Limits
+	// quiet leaves out the step headers.
+	quiet bool
+	// raw shows the output without the alias's filter.
+	raw bool
+	// snapshot records the run's environment in the history.
+	snapshot bool
+	// waitLock waits for the lock of an alias that runs one at a time.
+	waitLock bool
+	// scheduled marks a run started by cmdex schedule.
+	scheduled bool
+	// events, when set, receives the run's progress events.
+	events *eventLog
+	// spans records the run for OpenTelemetry when tracing is on.
+	spans *runTrace
+	// runID is the run's ULID.
+	runID string
+	// steps records the steps that ran, or were skipped, for the history.
+	steps []stepRecord
+	// log is the file the output is teed to.
+	log string
+	// output, when set, records the output for the history.
+	output *outputTail
+	// secrets are redacted from the output that is recorded.
+	secrets []string
+	// artifacts is the directory the run's artifacts were saved in.
+	artifacts string
+	// cleanups undo the temporary files written by write_file steps.
+	cleanups []func() error
+	// plan, when set, receives what the steps would do instead of them
+	// running; planned are the aliases being simulated, outermost first.
+	plan    *planNode
+	planned []string
+	// answers are the placeholder values the user entered, by
+	// placeholder number.
+	answers map[int]string
+}
+
+// lookup resolves the names usable in when: conditions.
+func (s *sequence) lookup(name string) (string, bool) {
+	switch name {
+	case "os":
+		return goruntime.GOOS, true
+	case "arch":
+		return goruntime.GOARCH, true
+	case "hostname":
+		host, _ := os.Hostname()
+		return host, true
+	case "exit_code":
+		return strconv.Itoa(s.exitCode), true
+	case "http_status":
+		return strconv.Itoa(s.httpStatus), true
+	}
+	if env, ok := strings.CutPrefix(name, "env."); ok {
+		return os.Getenv(env), true
+	}
+	if reg, ok := strings.CutPrefix(name, "steps."); ok {
+		v, ok := s.data.steps[reg]
+		return v, ok
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(name, "$")); err == nil && strings.HasPrefix(name, "$") {
+		if n >= 1 && n <= len(s.args) {
+			return s.args[n-1], true
+		}
+		return "", true
+	}
+	for i, ph := range s.alias.Placeholders {
+		if ph.Name == name {
+			if i < len(s.args) {
+				return s.args[i], true
+			}
+			return "", true
+		}
+	}
+	return "", false
+}
+
+// render expands templates and placeholders in a step command.
+func (s *sequence) render(command string) (string, error) {
+	return expandCommand(command, s.args, s.data)
+}
+
+// execute runs the alias, passing its output through the alias's filter
+// unless the sequence is raw.
+func (s *sequence) execute(ctx context.Context) (err error) {
+	if s.spans = newRunTrace(s.runID, s.alias, s.args); s.spans != nil {
+		defer func() { s.spans.export(err) }()
+	}
+	if s.alias.Lock {
+		unlock, err := lockAlias(ctx, s.alias.Name, s.waitLock)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	if len(s.alias.Artifacts) > 0 {
+		defer s.collectArtifacts(time.Now())
+	}
+	if targets := s.alias.notifyTargets(s.scheduled); len(targets) > 0 {
+		tail, start := s.tailOutput(targets), time.Now()
+		defer func() { s.notify(targets, tail, start, err) }()
+	}
+	if s.alias.Filter == "" || s.raw {
+		return s.executeRaw(ctx)
+	}
+	f, err := newFilter(ctx, s.alias.Filter, s.stdout, s.stderr, s.command)
+	if err != nil {
+		return err
+	}
+	stdout := s.stdout
+	s.stdout = f
+	err = s.executeRaw(ctx)
+	s.stdout = stdout
+	if ferr := f.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// executeRaw runs the alias: its script, or its steps in order.
+func (s *sequence) executeRaw(ctx context.Context) (err error) {
+	if !s.alias.isScript() {
+		return s.run(ctx)
+	}
+
+	// Scripts receive the arguments as their own positional parameters.
+	body, err := expandTemplate(s.alias.body(), s.data)
+	if err != nil {
+		return err
+	}
+	interp, ext, err := s.alias.interpreter()
+	if err != nil {
+		return err
+	}
+	if s.plan != nil {
+		s.simulateScript(interp, body)
+		return nil
+	}
+	if err := policy.checkCommand(quoteArgs(interp) + "\n" + body); err != nil {
+		return err
+	}
+	path, cleanup, err := writeScript(body, ext)
+	if err != nil {
+		return fmt.Errorf("writing script: %w", err)
+	}
+	defer cleanup()
+	argv := append(interp, path)
+	argv = append(argv, s.args...)
+	s.trace(verbosityVerbose, "%s", quoteArgs(argv))
+	start := time.Now()
+	defer func() {
+		shown := append(append(append([]string(nil), interp...), "<script>"), s.args...)
+		s.recordStep(Step{Name: "script"}, quoteArgs(shown), time.Since(start), err)
+	}()
+
+	// Create the command
+	cmd, err := s.command(ctx, argv, path)
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = s.stdout
+	cmd.Stderr = s.stderr
+
+	// Run the command
+	return s.wait(ctx, cmd)
+}
+
+// command prepares argv to run in the alias's directory, inside the
+// sandbox if there is one. binds names files the command reads from
+// outside its directory.
+func (s *sequence) command(ctx context.Context, argv []string, binds ...string) (*exec.Cmd, error) {
+	dir := s.dir
+	if verbosity >= verbosityDebug {
+		shown := dir
+		if shown == "" {
+			shown, _ = os.Getwd()
+		}
+		s.trace(verbosityDebug, "in %s", shown)
+		if !s.limits.empty() {
+			s.trace(verbosityDebug, "limits: %s", s.limits)
+		}
+	}
+	if s.sandbox != nil {
+		var err error
+		if argv, err = s.sandbox.wrap(argv, dir, binds); err != nil {
+			return nil, err
+		}
+		s.trace(verbosityDebug, "sandboxed: %s", quoteArgs(argv))
+	}
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Env = s.env
+	switch {
+	case s.alias.hasStdin():
+		cmd.Stdin = strings.NewReader(s.stdin)
+	case s.tty != nil:
+		cmd.Stdin, cmd.SysProcAttr = s.tty, ptyAttr()
+	}
+	return cmd, nil
+}
+
+// run executes the steps in order. A failing step doesn't stop the sequence
+// outright, so later steps can react to it with a when: condition; the first
+// failure is what run reports.
+func (s *sequence) run(ctx context.Context) error {
+	steps := s.alias.steps()
+	multi := len(steps) > 1 || len(s.alias.Steps) > 0
+	defer s.cleanUp()
+	var failure error
+	for i, step := range steps {
+		if step.When != "" {
+			ok, err := evalCondition(step.When, s.lookup)
+			if err != nil {
+				return fmt.Errorf("step %d: when %q: %w", i+1, step.When, err)
+			}
+			if !ok {
+				s.skip(i, step)
+				continue
+			}
+		} else if failure != nil {
+			s.skip(i, step)
+			continue
+		}
+		if multi {
+			s.header(i, "run", step.label())
+		}
+		s.events.stepStarted(i, step)
+		sp := s.spans.startStep(i, step)
+
+		stdout := s.stdout
+		var captured bytes.Buffer
+		if step.Register != "" {
+			stdout = &captured
+		}
+		// The history records run steps as they were expanded.
+		command := step.text()
+		if step.kind() == "run" && s.argv == nil {
+			if rendered, err := s.render(step.Run); err == nil {
+				command = rendered
+			}
+		}
+		start := time.Now()
+		var err error
+		switch {
+		case s.plan != nil:
+			s.simulateStep(ctx, i, step, stdout)
+		case step.HTTP != nil:
+			if s.sandbox != nil && s.sandbox.noNet {
+				err = fmt.Errorf("step %d: http steps can't run with --no-net", i+1)
+				break
+			}
+			err = s.runHTTP(ctx, step.HTTP, stdout)
+		case step.WriteFile != nil:
+			err = s.runWriteFile(step.WriteFile)
+		case step.WaitFor != nil:
+			err = s.runWaitFor(ctx, step.WaitFor)
+		case step.Prompt != nil:
+			var answer string
+			if answer, err = s.runPrompt(step.Prompt, step.Register); err == nil && step.Register != "" {
+				fmt.Fprint(stdout, answer)
+			}
+		default:
+			err = s.runCommand(ctx, step.Run, stdout)
+		}
+		if step.Register != "" {
+			s.data.steps[step.Register] = strings.TrimRight(captured.String(), "\r\n")
+		}
+		s.exitCode = 0
+		if err != nil {
+			s.exitCode = classify(err).childExit
+		}
+		s.trace(verbosityDebug, "[%d] finished in %s (exit %d)", i+1, time.Since(start).Round(time.Millisecond), s.exitCode)
+		s.events.finished("step_finished", i+1, time.Since(start), err)
+		s.recordStep(step, command, time.Since(start), err)
+		s.spans.endSpan(sp, err)
+		if err != nil {
+			ce := classify(err)
+			if ce.code != exitChildFailed {
+				// Timeouts and exec failures abort the sequence.
+				return err
+			}
+			if failure == nil {
+				failure = err
+			}
+		}
+	}
+	return failure
+}
+
+// runCommand executes a command step.
+func (s *sequence) runCommand(ctx context.Context, command string, stdout io.Writer) error {
+	argv := s.argv
+	if argv == nil {
+		var err error
+		if command, err = s.render(command); err != nil {
+			return err
+		}
+		argv = strings.Fields(command)
+	}
+	if len(argv) == 0 {
+		return fmt.Errorf("empty command")
+	}
+	if err := policy.checkCommand(command); err != nil {
+		return err
+	}
+	s.trace(verbosityVerbose, "%s", command)
+	cmd, err := s.command(ctx, argv)
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = s.stderr
+	return s.wait(ctx, cmd)
+}
+
+// wait starts cmd with the alias's umask, applies the resource limits and
+// waits for it to exit. The limits take effect right after the process
+// starts, before it can do much work.
+func (s *sequence) wait(ctx context.Context, cmd *exec.Cmd) error {
+	if err := startWithUmask(cmd, s.umask); err != nil {
+		return childError(ctx, err)
+	}
+	if s.limits.empty() {
+		return childError(ctx, cmd.Wait())
+	}
+	if err := applyLimits(cmd.Process.Pid, s.limits); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("applying resource limits: %w", err)
+	}
+	return childError(ctx, cmd.Wait())
+}
+
+// header announces a step on the sequence's stderr, unless it's quiet.
+func (s *sequence) header(i int, action, label string) {
+	if !s.quiet {
+		banner(s.progressWriter(), verbosityNormal, fmt.Sprintf("==> [%d] %s: %s", i+1, action, label))
+	}
+}
+
+// skip announces that step i doesn't run.
+func (s *sequence) skip(i int, step Step) {
+	s.header(i, "skip", step.label())
+	if s.plan != nil {
+		n := s.plan.add("[%d] skipped: %s", i+1, step.label())
+		n.role = "skipped"
+		if step.When != "" {
+			n.add("when %s", step.When)
+		}
+	}
+	s.events.emit(runEvent{Event: "step_skipped", Step: i + 1, Name: step.label(), Kind: step.kind()})
+	s.steps = append(s.steps, stepRecord{Name: step.label(), Kind: step.kind(), Skipped: true})
+}
+
+// trace describes what the sequence does on its stderr when the verbosity
+// is at least level.
+func (s *sequence) trace(level int, format string, args ...interface{}) {
+	if !s.quiet {
+		banner(s.progressWriter(), level, "==> "+fmt.Sprintf(format, args...))
+	}
+}
+
+func (s *sequence) progressWriter() io.Writer {
+	if s.progress != nil {
+		return s.progress
+	}
+	return s.stderr
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/text/unicode/norm"
+)
+
+var commandsBucket = []byte("commands")
+
+var errAliasNotFound = errors.New(tr("alias not found"))
+
+// Alias is a saved command together with its bookkeeping metadata. Records
+// are stored as JSON in the commands bucket, keyed by alias name.
+type Alias struct {
+	Name    string `json:"-" yaml:"-"`
+	Command string `json:"command" yaml:"command,omitempty"`
+	// Script holds a multi-line script body. When set, Command is empty and
+	// the script runs with the interpreter named by its shebang.
+	Script string `json:"script,omitempty" yaml:"script,omitempty"`
+	// ScriptBlob is set in stored records only: large scripts are kept in
+	// the blobs bucket under this key instead of in Script.
+	ScriptBlob string `json:"script_blob,omitempty" yaml:"-"`
+	// Runtime names the interpreter (python, node, ...) that runs the body
+	// as a script, overriding any shebang.
+	Runtime string `json:"runtime,omitempty" yaml:"runtime,omitempty"`
+	// Platforms holds command variants keyed by OS ("darwin", "linux") or
+	// "host:<hostname>", used instead of Command on matching machines.
+	Platforms map[string]string `json:"platforms,omitempty" yaml:"platforms,omitempty"`
+	// Variants are alternative bodies run as name@variant; the body above
+	// is the default.
+	Variants map[string]*Variant `json:"variants,omitempty" yaml:"variants,omitempty"`
+	// Variant is set on an alias loaded as name@variant, whose body is
+	// then the variant's.
+	Variant string `json:"-" yaml:"-"`
+	// layer is the layer an alias not in the store was loaded from.
+	layer *aliasLayer
+	// Steps replace Command for aliases that run a sequence of commands.
+	Steps        []Step        `json:"steps,omitempty" yaml:"steps,omitempty"`
+	Description  string        `json:"description,omitempty" yaml:"description,omitempty"`
+	Tags         []string      `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Placeholders []Placeholder `json:"placeholders,omitempty" yaml:"placeholders,omitempty"`
+	// Owner is who maintains the alias, such as a name or email address,
+	// and Team the team that does, for shared stores and bundles.
+	Owner string `json:"owner,omitempty" yaml:"owner,omitempty"`
+	Team  string `json:"team,omitempty" yaml:"team,omitempty"`
+	// Matrix lists values for placeholders, by name or as $N; run --matrix
+	// runs the alias once for every combination.
+	Matrix map[string][]string `json:"matrix,omitempty" yaml:"matrix,omitempty"`
+	// Examples are sample invocations, such as "cmdex run deploy staging".
+	Examples []string `json:"examples,omitempty" yaml:"examples,omitempty"`
+	// Filter post-processes what the alias prints on stdout; see
+	// newFilter.
+	Filter string `json:"filter,omitempty" yaml:"filter,omitempty"`
+	// Stdin is fed to the command's standard input, or StdinFile names a
+	// file that is, relative to the working directory. Both have their
+	// {{...}} templates expanded.
+	Stdin     string `json:"stdin,omitempty" yaml:"stdin,omitempty"`
+	StdinFile string `json:"stdin_file,omitempty" yaml:"stdin_file,omitempty"`
+	// Artifacts are paths or glob patterns, relative to the working
+	// directory, of files copied out after every run and kept with its
+	// history entry.
+	Artifacts []string `json:"artifacts,omitempty" yaml:"artifacts,omitempty"`
+	// Notify names the targets told of finished runs: targets from
+	// notify.targets in the config, webhook URLs, or none. Empty means
+	// notify.default.
+	Notify []string `json:"notify,omitempty" yaml:"notify,omitempty"`
+	// Dir is the working directory to run in; empty means the caller's.
+	Dir string `json:"dir,omitempty" yaml:"dir,omitempty"`
+	// GitRoot runs the alias at the root of the git repository enclosing
+	// the caller's directory, with a relative Dir taken from there.
+	GitRoot bool `json:"git_root,omitempty" yaml:"git_root,omitempty"`
+	// Env sets variables in the environment of the commands, with their
+	// {{...}} templates and placeholders expanded.
+	Env map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	// EnvClear runs the commands with only the allowed variables of the
+	// caller's environment, as run --env-clear does; EnvAllow allows more,
+	// by name or pattern.
+	EnvClear bool     `json:"env_clear,omitempty" yaml:"env_clear,omitempty"`
+	EnvAllow []string `json:"env_allow,omitempty" yaml:"env_allow,omitempty"`
+	// Umask, in octal such as 022, is the file mode creation mask of the
+	// commands; Locale sets their LANG and LC_ALL, and TZ their time zone,
+	// whatever the caller's are.
+	Umask  string `json:"umask,omitempty" yaml:"umask,omitempty"`
+	Locale string `json:"locale,omitempty" yaml:"locale,omitempty"`
+	TZ     string `json:"tz,omitempty" yaml:"tz,omitempty"`
+	// Shim has cmdex shims sync write a command named after the alias, so
+	// it runs without typing cmdex run.
+	Shim bool `json:"shim,omitempty" yaml:"shim,omitempty"`
+	// Confirm asks the user before the command is executed.
+	Confirm bool `json:"confirm,omitempty" yaml:"confirm,omitempty"`
+	// Cooldown, such as 5m, refuses runs that start within that time of
+	// the previous one, catching accidental double invocations.
+	Cooldown string `json:"cooldown,omitempty" yaml:"cooldown,omitempty"`
+	// Lock runs the alias one at a time: a run started while another is
+	// going fails, or waits with run --wait.
+	Lock bool `json:"lock,omitempty" yaml:"lock,omitempty"`
+	// Schedule is a cron expression for running the alias from a systemd
+	// timer or launchd agent; see cmdex schedule.
+	Schedule string `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+	// Limits caps the memory, CPU time and open files of the command.
+	Limits *Limits `json:"limits,omitempty" yaml:"limits,omitempty"`
+	// Deprecated forwards runs of the alias to its replacement.
+	Deprecated *Deprecation `json:"deprecated,omitempty" yaml:"deprecated,omitempty"`
+	// Access limits who may run or change the alias in a shared store.
+	Access *Access `json:"access,omitempty" yaml:"access,omitempty"`
+	Guards `yaml:",inline"`
+
+	Created  time.Time `json:"created" yaml:"-"`
+	Modified time.Time `json:"modified" yaml:"-"`
+	Uses     int       `json:"uses" yaml:"-"`
+	LastUsed time.Time `json:"last_used" yaml:"-"`
+
+	// Provenance is how the alias was created; nil for aliases saved
+	// before it was recorded.
+	Provenance *Provenance `json:"provenance,omitempty" yaml:"-"`
+}
+
+// maintainer describes who maintains a, such as "alice@example.com
+// (payments)", or is empty if nobody is recorded.
+func (a *Alias) maintainer() string {
+	switch {
+	case a.Owner != "" && a.Team != "":
+		return a.Owner + " (" + a.Team + ")"
+	case a.Owner != "":
+		return a.Owner
+	}
+	return a.Team
+}
+
+// aliasJSON is the representation of an alias outside the store, in the
+// daemon's API and in export archives.
+type aliasJSON struct {
+	Name string `json:"name"`
+	*Alias
+}
+
+// Placeholder describes the positional argument $N, where N is its index in
+// Alias.Placeholders plus one.
+type Placeholder struct {
+	Name        string `json:"name,omitempty" yaml:"name,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Default     string `json:"default,omitempty" yaml:"default,omitempty"`
+	// Choices, when set, are the only values the placeholder accepts.
+	Choices []string `json:"choices,omitempty" yaml:"choices,omitempty"`
+}
+
+// decodeAlias parses a stored value, loading its script from the blobs
+// bucket if it's kept there. Values written before records became
+// structured hold the bare command string and are upgraded in memory.
+func decodeAlias(tx *bolt.Tx, name string, v []byte) (*Alias, error) {
+	v, err := decodeValue(name, v)
+	if err != nil {
+		return nil, err
+	}
+	a := &Alias{Name: name}
+	if !bytes.HasPrefix(v, []byte("{")) || json.Unmarshal(v, a) != nil {
+		return &Alias{Name: name, Command: string(v)}, nil
+	}
+	if a.ScriptBlob != "" {
+		b, err := getBlob(tx, a.ScriptBlob)
+		if err != nil {
+			return nil, fmt.Errorf("alias %s: %w", name, err)
+		}
+		a.Script, a.ScriptBlob = b.Body, ""
+	}
+	return a, nil
+}
+
+// aliasKey returns the key the alias name is stored under: its NFC form,
+// so that a name typed with a combining accent, as macOS produces, is the
+// same alias as one typed with the precomposed character.
+func aliasKey(name string) string {
+	return norm.NFC.String(name)
+}
+
+// lookupKey returns the key of the stored alias name, falling back to name
+// as typed for records saved before names were normalized.
+func lookupKey(tx *bolt.Tx, name string) string {
+	key := aliasKey(name)
+	if key != name && tx.Bucket(commandsBucket).Get([]byte(key)) == nil {
+		return name
+	}
+	return key
+}
+
+// getAlias loads the alias stored under name.
+func getAlias(tx *bolt.Tx, name string) (*Alias, error) {
+	name = lookupKey(tx, name)
+	v := tx.Bucket(commandsBucket).Get([]byte(name))
+	if v == nil {
+		return nil, errAliasNotFound
+	}
+	return decodeAlias(tx, name, v)
+}
+
+// putAlias writes a to the store under its normalized name, keeping a
+// large script as a blob, and updates the indexes.
+func putAlias(tx *bolt.Tx, a *Alias) error {
+	old, err := storedAlias(tx, a.Name)
+	if err != nil {
+		return err
+	}
+	a.Name = aliasKey(a.Name)
+	if old != nil && old.Name != a.Name {
+		// The alias was stored under its name as typed.
+		if err := tx.Bucket(commandsBucket).Delete([]byte(old.Name)); err != nil {
+			return err
+		}
+	}
+	stored := *a
+	stored.ScriptBlob = ""
+	if len(a.Script) >= blobMinSize {
+		if stored.ScriptBlob, err = retainBlob(tx, a.Script); err != nil {
+			return err
+		}
+		stored.Script = ""
+	}
+	if old != nil && old.ScriptBlob != "" {
+		if err := releaseBlob(tx, old.ScriptBlob); err != nil {
+			return err
+		}
+	}
+	if err := updateIndexes(tx, old, a); err != nil {
+		return err
+	}
+	v, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+	if v, err = encodeValue(a.Name, v); err != nil {
+		return err
+	}
+	return tx.Bucket(commandsBucket).Put([]byte(a.Name), v)
+}
+
+// deleteAlias removes the alias stored under name.
+func deleteAlias(tx *bolt.Tx, name string) error {
+	old, err := storedAlias(tx, name)
+	if err != nil {
+		return err
+	}
+	if old == nil {
+		return errAliasNotFound
+	}
+	if err := tx.Bucket(commandsBucket).Delete([]byte(old.Name)); err != nil {
+		return err
+	}
+	if old.ScriptBlob != "" {
+		if err := releaseBlob(tx, old.ScriptBlob); err != nil {
+			return err
+		}
+	}
+	return updateIndexes(tx, old, nil)
+}
+
+// forEachAlias calls fn for every stored alias in key order.
+func forEachAlias(tx *bolt.Tx, fn func(a *Alias) error) error {
+	return tx.Bucket(commandsBucket).ForEach(func(k, v []byte) error {
+		a, err := decodeAlias(tx, string(k), v)
+		if err != nil {
+			return err
+		}
+		return fn(a)
+	})
+}
+
+// loadAlias reads a single alias, or with name@variant its variant, in its
+// own transaction.
+func loadAlias(name string) (*Alias, error) {
+	var a *Alias
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		a, err = getVariantOrLayered(tx, name)
+		return err
+	})
+	return a, err
+}
+
+// recordUse bumps the usage counter and last-used time of the alias e
+// targets and logs the run in the audit log. Concurrent calls share a
+// transaction.
+func recordUse(e auditEntry) error {
+	return db.Batch(useUpdate(e))
+}
+
+// useUpdate returns the transaction recordUse runs, for queueing with
+// deferWrite. The use is timed when useUpdate is called.
+func useUpdate(e auditEntry) func(tx *bolt.Tx) error {
+	now := time.Now()
+	if e.Time.IsZero() {
+		e.Time = now
+	}
+	e.Action = "run"
+	return func(tx *bolt.Tx) error {
+		a, err := getAlias(tx, e.Target)
+		if err == errAliasNotFound && layered(e.Target) != nil {
+			// Aliases from layers are read-only; their runs are only
+			// audited.
+			return writeAudit(tx, e)
+		}
+		if err != nil {
+			return err
+		}
+		a.Uses++
+		a.LastUsed = now
+		if err := putAlias(tx, a); err != nil {
+			return err
+		}
+		return writeAudit(tx, e)
+	}
+}
@@ -0,0 +1,72 @@
+// Package store shares a bbolt database between the goroutines of a
+// long-running process, such as the cmdex daemon, and the other processes
+// using the same file. The database is only open while an operation needs
+// it, operations take turns, and every operation is bound to a context:
+// one whose context is done by the time the database is free doesn't run.
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Shared is a database opened for every operation and closed after it.
+type Shared struct {
+	mu    sync.Mutex
+	open  func() (*bolt.DB, error)
+	close func(db *bolt.DB) error
+}
+
+// NewShared returns the Shared database that open opens and close closes
+// again.
+func NewShared(open func() (*bolt.DB, error), close func(db *bolt.DB) error) *Shared {
+	return &Shared{open: open, close: close}
+}
+
+// Open returns the Shared database in the file path. Opening it waits up
+// to a second for other processes to release the file.
+func Open(path string) *Shared {
+	return NewShared(func() (*bolt.DB, error) {
+		return bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	}, (*bolt.DB).Close)
+}
+
+// Do runs fn with the database open, unless ctx is done by the time the
+// database is free.
+func (s *Shared) Do(ctx context.Context, fn func(db *bolt.DB) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	err = fn(db)
+	if cerr := s.close(db); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// View runs fn in a read-only transaction; see Do.
+func (s *Shared) View(ctx context.Context, fn func(tx *bolt.Tx) error) error {
+	return s.Do(ctx, func(db *bolt.DB) error { return db.View(fn) })
+}
+
+// Update runs fn in a read-write transaction; see Do.
+func (s *Shared) Update(ctx context.Context, fn func(tx *bolt.Tx) error) error {
+	return s.Do(ctx, func(db *bolt.DB) error { return db.Update(fn) })
+}
+
+// Hold runs fn while no operation is under way, without opening the
+// database, for work that must not overlap with the operations.
+func (s *Shared) Hold(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn()
+}
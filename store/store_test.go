@@ -0,0 +1,87 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestShared(t *testing.T) {
+	s := Open(filepath.Join(t.TempDir(), "test.db"))
+	ctx := context.Background()
+	err := s.Update(ctx, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("counts"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("n"), []byte{0})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Increments that overlapped would lose some of their counts.
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.Update(ctx, func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("counts"))
+				return b.Put([]byte("n"), []byte{b.Get([]byte("n"))[0] + 1})
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	var n byte
+	err = s.View(ctx, func(tx *bolt.Tx) error {
+		n = tx.Bucket([]byte("counts")).Get([]byte("n"))[0]
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 20 {
+		t.Errorf("count = %d, want 20", n)
+	}
+}
+
+func TestSharedDone(t *testing.T) {
+	opened := 0
+	s := NewShared(func() (*bolt.DB, error) {
+		opened++
+		return nil, errors.New("not reached")
+	}, func(*bolt.DB) error { return nil })
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ran := false
+	err := s.Do(ctx, func(*bolt.DB) error {
+		ran = true
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Do = %v, want %v", err, context.Canceled)
+	}
+	if ran || opened > 0 {
+		t.Errorf("Do ran fn or opened the database with ctx done")
+	}
+}
+
+func TestSharedCloses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s := Open(path)
+	var held *bolt.DB
+	if err := s.Do(context.Background(), func(db *bolt.DB) error { held = db; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	// A closed database refuses transactions.
+	if err := held.View(func(*bolt.Tx) error { return nil }); !errors.Is(err, bolt.ErrDatabaseNotOpen) {
+		t.Errorf("database still open after Do: %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// suggestion is a command worth saving as an alias.
+type suggestion struct {
+	command string
+	count   int
+	// source says where the command was seen.
+	source string
+}
+
+func suggestCmd() *cobra.Command {
+	var (
+		shellHistory bool
+		historyFile  string
+		minCount     int
+		limit        int
+	)
+	cmd := &cobra.Command{
+		Use:   "suggest",
+		Short: "Suggest aliases for commands you run often",
+		Long: `suggest looks for commands that are run repeatedly but have no alias yet: alias
+runs that keep passing the same arguments and, with --shell, lines from your
+shell history ($HISTFILE, ~/.bash_history, ~/.zsh_history or fish's history).
+
+On a terminal each suggestion can be saved with a single key press.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			counts := make(map[string]*suggestion)
+			add := func(command, source string) {
+				command = strings.TrimSpace(command)
+				if command == "" {
+					return
+				}
+				if s, ok := counts[command]; ok {
+					s.count++
+					return
+				}
+				counts[command] = &suggestion{command: command, count: 1, source: source}
+			}
+
+			known := make(map[string]bool)
+			err := db.View(func(tx *bolt.Tx) error {
+				aliases := make(map[string]*Alias)
+				err := forEachAlias(tx, func(a *Alias) error {
+					aliases[a.Name] = a
+					known[strings.TrimSpace(a.command())] = true
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				return forEachHistory(tx, func(e *historyEntry) bool {
+					a := aliases[e.Alias]
+					if a == nil || len(e.Args) == 0 || a.isScript() || len(a.Steps) > 0 {
+						return true
+					}
+					if command, err := expandCommand(a.command(), e.Args, &templateData{}); err == nil {
+						add(command, "runs of "+e.Alias)
+					}
+					return true
+				})
+			})
+			if err != nil {
+				return fmt.Errorf("reading history: %w", err)
+			}
+			if shellHistory || historyFile != "" {
+				lines, err := readShellHistory(historyFile)
+				if err != nil {
+					return err
+				}
+				for _, line := range lines {
+					// Running cmdex itself is already covered by aliases.
+					if !strings.HasPrefix(line, "cmdex ") {
+						add(line, "shell history")
+					}
+				}
+			}
+
+			var suggestions []*suggestion
+			for _, s := range counts {
+				if s.count >= minCount && !known[s.command] && strings.Contains(s.command, " ") {
+					suggestions = append(suggestions, s)
+				}
+			}
+			sort.Slice(suggestions, func(i, j int) bool {
+				if suggestions[i].count != suggestions[j].count {
+					return suggestions[i].count > suggestions[j].count
+				}
+				return suggestions[i].command < suggestions[j].command
+			})
+			if limit > 0 && len(suggestions) > limit {
+				suggestions = suggestions[:limit]
+			}
+			if len(suggestions) == 0 {
+				fmt.Println("No suggestions")
+				return nil
+			}
+
+			if !interactive() {
+				var t table
+				for _, s := range suggestions {
+					t.add(fmt.Sprintf("%dx", s.count), suggestedName(s.command), s.command)
+				}
+				t.color(1, "alias")
+				printLines(t.lines(0))
+				return nil
+			}
+			return reviewSuggestions(suggestions)
+		},
+	}
+	cmd.Flags().BoolVar(&shellHistory, "shell", false, "Also look at your shell history")
+	cmd.Flags().StringVar(&historyFile, "history-file", "", "Read shell history from this file (implies --shell)")
+	cmd.Flags().IntVar(&minCount, "min", 3, "Only suggest commands run at least this many times")
+	cmd.Flags().IntVar(&limit, "limit", 10, "Show at most this many suggestions (0 for all)")
+	return cmd
+}
+
+// reviewSuggestions walks the user through the suggestions, saving the
+// ones they pick.
+func reviewSuggestions(suggestions []*suggestion) error {
+	for i, s := range suggestions {
+		fmt.Printf("\n[%d/%d] %s\n      run %d times (%s)\n", i+1, len(suggestions), s.command, s.count, s.source)
+		fmt.Print("[s]ave, [n]ext, [q]uit? ")
+		key, err := readKey()
+		fmt.Println(string(key))
+		if err != nil {
+			return err
+		}
+		switch unicode.ToLower(key) {
+		case 'q', 3, 4:
+			return nil
+		case 's':
+		default:
+			continue
+		}
+		name, err := ask("Alias name", suggestedName(s.command))
+		if err != nil {
+			return err
+		}
+		err = db.Update(func(tx *bolt.Tx) error {
+			if _, err := getAlias(tx, name); err == nil {
+				return fmt.Errorf("alias %s already exists", name)
+			}
+			now := time.Now()
+			a := &Alias{Name: name, Created: now, Modified: now, Provenance: newProvenance("suggest", s.source)}
+			setBody(a, s.command)
+			if err := policy.checkAlias(a); err != nil {
+				return err
+			}
+			if err := putAlias(tx, a); err != nil {
+				return err
+			}
+			return writeAudit(tx, auditEntry{Action: "save", Target: name, Detail: "suggest"})
+		})
+		if err != nil {
+			printError("Error saving command: %v", err)
+			continue
+		}
+		fmt.Println(trf("Command saved with alias: %s", paint("alias", name)))
+	}
+	return nil
+}
+
+// suggestedName derives an alias name from the first words of command,
+// such as git-status for "git status --short".
+func suggestedName(command string) string {
+	var words []string
+	for _, w := range strings.Fields(command) {
+		if strings.HasPrefix(w, "-") || len(words) == 2 {
+			break
+		}
+		w = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return unicode.ToLower(r)
+			}
+			return -1
+		}, filepath.Base(w))
+		if w != "" {
+			words = append(words, w)
+		}
+	}
+	return strings.Join(words, "-")
+}
+
+// readShellHistory returns the commands in the shell history file, path
+// or the first one found in the usual places.
+func readShellHistory(path string) ([]string, error) {
+	if path == "" {
+		home, _ := os.UserHomeDir()
+		candidates := []string{
+			os.Getenv("HISTFILE"),
+			filepath.Join(home, ".zsh_history"),
+			filepath.Join(home, ".bash_history"),
+			filepath.Join(home, ".local", "share", "fish", "fish_history"),
+		}
+		for _, c := range candidates {
+			if c == "" {
+				continue
+			}
+			if _, err := os.Stat(c); err == nil {
+				path = c
+				break
+			}
+		}
+		if path == "" {
+			return nil, fmt.Errorf("no shell history found; name the file with --history-file")
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading shell history: %w", err)
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, ": ") && strings.Contains(line, ";"):
+			// zsh extended history: ": <time>:<duration>;command"
+			_, line, _ = strings.Cut(line, ";")
+		case strings.HasPrefix(line, "- cmd: "):
+			// fish history
+			line = strings.TrimPrefix(line, "- cmd: ")
+		case strings.HasPrefix(line, "  when: "), strings.HasPrefix(line, "#"):
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading shell history: %w", err)
+	}
+	return lines, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+func tagCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tag",
+		Short: "Add, remove or rename tags across many aliases",
+	}
+	cmd.AddCommand(tagChangeCmd("add", "Add a tag to aliases", func(a *Alias, tag string) bool {
+		if contains(a.Tags, tag) {
+			return false
+		}
+		a.Tags = append(a.Tags, tag)
+		return true
+	}))
+	cmd.AddCommand(tagChangeCmd("remove", "Remove a tag from aliases", removeTag))
+	cmd.AddCommand(&cobra.Command{
+		Use:   "rename <old> <new>",
+		Short: "Rename a tag on every alias carrying it",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			from, to := args[0], args[1]
+			n, err := updateTags(nil, "", func(a *Alias) bool {
+				if !removeTag(a, from) {
+					return false
+				}
+				if !contains(a.Tags, to) {
+					a.Tags = append(a.Tags, to)
+				}
+				return true
+			}, auditEntry{Action: "tag-rename", Target: from, Detail: to})
+			if err != nil {
+				return fmt.Errorf("renaming tag: %w", err)
+			}
+			if n == 0 {
+				return fmt.Errorf("no aliases tagged %s", from)
+			}
+			fmt.Printf("Renamed tag %s to %s on %d aliases\n", paint("tag", from), paint("tag", to), n)
+			return nil
+		},
+	})
+	return cmd
+}
+
+// tagChangeCmd builds the add and remove subcommands, which apply change
+// to the named aliases and those matching --pattern.
+func tagChangeCmd(verb, short string, change func(a *Alias, tag string) bool) *cobra.Command {
+	var pattern string
+	cmd := &cobra.Command{
+		Use:   verb + " <tag> [alias...]",
+		Short: short,
+		Long: short + `. Aliases are named as arguments, selected by a --pattern glob
+on their names, or both. All of them change in one transaction.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tag, names := args[0], args[1:]
+			if len(names) == 0 && pattern == "" {
+				return usageError(fmt.Errorf("name the aliases to %s or select them with --pattern", verb))
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return usageError(fmt.Errorf("invalid --pattern: %w", err))
+			}
+			n, err := updateTags(names, pattern, func(a *Alias) bool {
+				return change(a, tag)
+			}, auditEntry{Action: "tag-" + verb, Target: tag})
+			if err != nil {
+				return fmt.Errorf("updating tags: %w", err)
+			}
+			fmt.Printf("Tag %s: %d aliases changed\n", paint("tag", tag), n)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&pattern, "pattern", "", "Also select aliases whose name matches this glob (e.g. 'docker-*')")
+	return cmd
+}
+
+// removeTag drops tag from a, reporting whether a had it.
+func removeTag(a *Alias, tag string) bool {
+	for i, t := range a.Tags {
+		if t == tag {
+			a.Tags = append(a.Tags[:i:i], a.Tags[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// updateTags applies change to the named aliases and those matching
+// pattern, or to every alias when neither is given, in a single
+// transaction. It returns how many aliases change reported as modified and
+// logs the operation once for all of them.
+func updateTags(names []string, pattern string, change func(a *Alias) bool, e auditEntry) (int, error) {
+	n := 0
+	err := db.Update(func(tx *bolt.Tx) error {
+		var selected []*Alias
+		seen := make(map[string]bool)
+		for _, name := range names {
+			a, err := getAlias(tx, name)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			seen[name] = true
+			selected = append(selected, a)
+		}
+		if pattern != "" || len(names) == 0 {
+			err := forEachAlias(tx, func(a *Alias) error {
+				if ok, _ := path.Match(pattern, a.Name); (ok || pattern == "") && !seen[a.Name] {
+					selected = append(selected, a)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for _, a := range selected {
+			if !change(a) {
+				continue
+			}
+			a.Modified = time.Now()
+			if err := putAlias(tx, a); err != nil {
+				return err
+			}
+			n++
+		}
+		if n == 0 {
+			return nil
+		}
+		if e.Detail == "" {
+			e.Detail = fmt.Sprintf("%d aliases", n)
+		} else {
+			e.Detail = fmt.Sprintf("%s (%d aliases)", e.Detail, n)
+		}
+		return writeAudit(tx, e)
+	})
+	return n, err
+}
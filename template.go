@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var templatePattern = regexp.MustCompile(`\{\{\s*(.*?)\s*\}\}`)
+
+// templateData supplies the values {{...}} expressions can refer to.
+type templateData struct {
+	vars map[string]string
+	// used records the variables read.
+	used map[string]bool
+	// steps holds the output registered by steps that have run so far.
+	steps map[string]string
+	// dir is where the git values are looked up; gitValues caches them.
+	dir       string
+	gitValues map[string]string
+}
+
+// git returns the git value name for the checkout in d.dir, looking it up
+// once per run.
+func (d *templateData) git(name string) (string, error) {
+	if v, ok := d.gitValues[name]; ok {
+		return v, nil
+	}
+	v, err := gitValue(d.dir, name)
+	if err != nil {
+		return "", err
+	}
+	if d.gitValues == nil {
+		d.gitValues = make(map[string]string)
+	}
+	d.gitValues[name] = v
+	return v, nil
+}
+
+// expandTemplate evaluates the {{...}} expressions in s. Expressions cmdex
+// does not recognise are left untouched, so commands that use braces for
+// their own templating (docker --format, helm, ...) keep working.
+func expandTemplate(s string, data *templateData) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	var firstErr error
+	out := templatePattern.ReplaceAllStringFunc(s, func(m string) string {
+		expr := templatePattern.FindStringSubmatch(m)[1]
+		value, ok, err := data.eval(expr)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if !ok {
+			return m
+		}
+		return value
+	})
+	return out, firstErr
+}
+
+// eval evaluates a single expression. ok is false if the expression is not
+// one cmdex handles.
+func (d *templateData) eval(expr string) (value string, ok bool, err error) {
+	fn, arg, _ := strings.Cut(expr, " ")
+	if name, ok := strings.CutPrefix(fn, "steps."); ok && arg == "" {
+		v, found := d.steps[name]
+		if !found {
+			return "", true, fmt.Errorf("{{%s}}: no earlier step registered %q", expr, name)
+		}
+		return v, true, nil
+	}
+	switch fn {
+	case "gitBranch", "gitSha", "gitDirty":
+		if arg != "" {
+			return "", false, nil
+		}
+		v, err := d.git(fn)
+		if err != nil {
+			return "", true, fmt.Errorf("{{%s}}: %w", expr, err)
+		}
+		return v, true, nil
+	case "var":
+		name, err := templateString(arg)
+		if err != nil {
+			return "", true, fmt.Errorf("{{%s}}: %w", expr, err)
+		}
+		v, found := d.vars[name]
+		if !found {
+			return "", true, fmt.Errorf("variable %s is not set (use cmdex var set %s <value>)", name, name)
+		}
+		if d.used == nil {
+			d.used = make(map[string]bool)
+		}
+		d.used[name] = true
+		return v, true, nil
+	}
+	return "", false, nil
+}
+
+// templateString parses a template argument, which may be a quoted string
+// or a bare word.
+func templateString(arg string) (string, error) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return "", fmt.Errorf("missing argument")
+	}
+	if arg[0] == '"' || arg[0] == '`' {
+		return strconv.Unquote(arg)
+	}
+	return arg, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TracingConfig sends every run as a trace to an OpenTelemetry collector.
+// The OTEL_EXPORTER_OTLP_* environment variables override it.
+type TracingConfig struct {
+	// Endpoint is the collector's OTLP/HTTP address, such as
+	// http://localhost:4318; tracing is off without one.
+	Endpoint string `yaml:"endpoint"`
+	// Headers are sent with every export, for authentication.
+	Headers map[string]string `yaml:"headers"`
+	// Service is the service.name of the traces, cmdex by default.
+	Service string `yaml:"service"`
+}
+
+// tracesURL returns where traces are exported, or "" if tracing is off.
+func tracesURL() string {
+	if u := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); u != "" {
+		return u
+	}
+	base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if base == "" {
+		base = currentConfig().Tracing.Endpoint
+	}
+	if base == "" {
+		return ""
+	}
+	return strings.TrimRight(base, "/") + "/v1/traces"
+}
+
+// span is one recorded OpenTelemetry span.
+type span struct {
+	id, parent string
+	name       string
+	start, end time.Time
+	attrs      map[string]interface{}
+	err        error
+}
+
+// runTrace records the spans of one run: the run itself, and a child span
+// per step.
+type runTrace struct {
+	mu    sync.Mutex
+	id    string
+	root  *span
+	spans []*span
+}
+
+// newRunTrace starts the trace of a run of a, or returns nil when tracing
+// is off.
+func newRunTrace(runID string, a *Alias, args []string) *runTrace {
+	if tracesURL() == "" {
+		return nil
+	}
+	name := "cmdex run " + a.ref()
+	if a.Name == "" {
+		name = "cmdex exec"
+	}
+	t := &runTrace{id: randomHex(16)}
+	t.root = &span{id: randomHex(8), name: name, start: time.Now(), attrs: map[string]interface{}{
+		"cmdex.run_id":    runID,
+		"cmdex.alias":     a.ref(),
+		"cmdex.args_hash": argsHash(args),
+		"cmdex.args":      len(args),
+	}}
+	return t
+}
+
+// argsHash identifies the arguments of a run without recording them, as
+// they may hold secrets.
+func argsHash(args []string) string {
+	sum := sha256.Sum256([]byte(quoteArgs(args)))
+	return hex.EncodeToString(sum[:8])
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// startStep starts the span of step i, from 0.
+func (t *runTrace) startStep(i int, step Step) *span {
+	if t == nil {
+		return nil
+	}
+	sp := &span{id: randomHex(8), parent: t.root.id, name: fmt.Sprintf("step %d: %s", i+1, step.label()), start: time.Now(),
+		attrs: map[string]interface{}{"cmdex.step": i + 1, "cmdex.step.kind": step.kind()}}
+	t.mu.Lock()
+	t.spans = append(t.spans, sp)
+	t.mu.Unlock()
+	return sp
+}
+
+// endSpan ends sp, a step or the run, with the outcome err.
+func (t *runTrace) endSpan(sp *span, err error) {
+	if t == nil || sp == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sp.end, sp.err = time.Now(), err
+	sp.attrs["cmdex.exit_code"] = exitCodeOf(err)
+	sp.attrs["cmdex.duration_ms"] = sp.end.Sub(sp.start).Milliseconds()
+}
+
+// export ends the run's span with err and sends the trace to the
+// collector. A collector that can't be reached doesn't fail the run.
+func (t *runTrace) export(err error) {
+	if t == nil {
+		return
+	}
+	t.endSpan(t.root, err)
+	body, jerr := json.Marshal(t.otlp())
+	if jerr != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, rerr := http.NewRequestWithContext(ctx, http.MethodPost, tracesURL(), bytes.NewReader(body))
+	if rerr != nil {
+		printWarning("Warning: exporting the trace: %v", rerr)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range currentConfig().Tracing.Headers {
+		req.Header.Set(k, v)
+	}
+	// OTEL_EXPORTER_OTLP_HEADERS holds comma-separated key=value pairs.
+	for _, kv := range splitList(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")) {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			req.Header.Set(strings.TrimSpace(k), strings.TrimSpace(v))
+		}
+	}
+	resp, herr := http.DefaultClient.Do(req)
+	if herr != nil {
+		printWarning("Warning: exporting the trace: %v", herr)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		printWarning("Warning: exporting the trace: the collector returned %s", resp.Status)
+	}
+}
+
+// otlp returns the trace as an OTLP/JSON ExportTraceServiceRequest.
+func (t *runTrace) otlp() interface{} {
+	type m = map[string]interface{}
+	attributes := func(attrs map[string]interface{}) []m {
+		var out []m
+		for k, v := range attrs {
+			var value m
+			switch v := v.(type) {
+			case int:
+				value = m{"intValue": strconv.Itoa(v)}
+			case int64:
+				value = m{"intValue": strconv.FormatInt(v, 10)}
+			default:
+				value = m{"stringValue": fmt.Sprint(v)}
+			}
+			out = append(out, m{"key": k, "value": value})
+		}
+		return out
+	}
+	var spans []m
+	for _, sp := range append([]*span{t.root}, t.spans...) {
+		if sp.end.IsZero() {
+			// A step cut short by a timeout ends with the run.
+			sp.end = t.root.end
+		}
+		status := m{"code": 1}
+		if sp.err != nil {
+			status = m{"code": 2, "message": sp.err.Error()}
+		}
+		s := m{
+			"traceId":           t.id,
+			"spanId":            sp.id,
+			"name":              sp.name,
+			"kind":              1,
+			"startTimeUnixNano": strconv.FormatInt(sp.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(sp.end.UnixNano(), 10),
+			"attributes":        attributes(sp.attrs),
+			"status":            status,
+		}
+		if sp.parent != "" {
+			s["parentSpanId"] = sp.parent
+		}
+		spans = append(spans, s)
+	}
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = currentConfig().Tracing.Service
+	}
+	if service == "" {
+		service = "cmdex"
+	}
+	host, _ := os.Hostname()
+	resource := attributes(map[string]interface{}{"service.name": service, "service.version": version, "host.name": host, "user.name": currentUser()})
+	return m{"resourceSpans": []m{{
+		"resource":   m{"attributes": resource},
+		"scopeSpans": []m{{"scope": m{"name": "cmdex", "version": version}, "spans": spans}},
+	}}}
+}
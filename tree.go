@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// namespaceSeparators split alias names into namespaces for list --tree:
+// k8s/prod/deploy, docker:build and db.migrate are all namespaced.
+const namespaceSeparators = "/:."
+
+// aliasNode is a namespace, an alias or both in the tree of list --tree.
+type aliasNode struct {
+	name string
+	// alias is the alias named by the path to the node, if there is one.
+	alias *Alias
+	// children are in the order of the listing they were added from.
+	children []*aliasNode
+	index    map[string]*aliasNode
+	// count is the number of aliases at and below the node.
+	count int
+}
+
+// namespaceParts splits name at its namespace separators.
+func namespaceParts(name string) []string {
+	parts := strings.FieldsFunc(name, func(r rune) bool { return strings.ContainsRune(namespaceSeparators, r) })
+	if len(parts) == 0 {
+		return []string{name}
+	}
+	return parts
+}
+
+// inNamespace reports whether name is in the namespace ns, or one nested
+// in it: k8s/prod/deploy is in k8s and k8s/prod.
+func inNamespace(name, ns string) bool {
+	parts, prefix := namespaceParts(aliasKey(name)), namespaceParts(aliasKey(ns))
+	if len(parts) <= len(prefix) {
+		return false
+	}
+	for i, part := range prefix {
+		if parts[i] != part {
+			return false
+		}
+	}
+	return true
+}
+
+// buildTree arranges aliases, in listing order, by namespace.
+func buildTree(aliases []*Alias) *aliasNode {
+	root := &aliasNode{}
+	for _, a := range aliases {
+		n := root
+		n.count++
+		for _, part := range namespaceParts(a.Name) {
+			child := n.index[part]
+			if child == nil {
+				child = &aliasNode{name: part}
+				if n.index == nil {
+					n.index = make(map[string]*aliasNode)
+				}
+				n.index[part] = child
+				n.children = append(n.children, child)
+			}
+			n = child
+			n.count++
+		}
+		n.alias = a
+	}
+	return root
+}
+
+// treeLines renders the tree below root with box-drawing branches, each
+// alias followed by its summary. Namespaces deeper than depth, when it's
+// positive, are collapsed into their counts. maxWidth truncates the lines
+// like table.lines.
+func treeLines(root *aliasNode, depth, maxWidth int) []string {
+	type row struct {
+		prefix, label, role, summary string
+	}
+	var rows []row
+	var walk func(n *aliasNode, prefix string, level int)
+	walk = func(n *aliasNode, indent string, level int) {
+		for i, child := range n.children {
+			branch, next := "├── ", "│   "
+			if i == len(n.children)-1 {
+				branch, next = "└── ", "    "
+			}
+			if level == 0 {
+				branch, next = "", ""
+			}
+			r := row{prefix: indent + branch, label: child.name, role: "alias"}
+			if child.alias != nil {
+				r.summary = child.alias.summary()
+			}
+			if len(child.children) > 0 {
+				r.label += fmt.Sprintf(" (%d)", child.count)
+				if child.alias == nil {
+					r.role = "tag"
+				}
+			}
+			rows = append(rows, r)
+			if len(child.children) > 0 && (depth <= 0 || level+1 < depth) {
+				walk(child, indent+next, level+1)
+			}
+		}
+	}
+	walk(root, "", 0)
+
+	width := 0
+	for _, r := range rows {
+		if w := displayWidth(r.prefix + r.label); r.summary != "" && w > width {
+			width = w
+		}
+	}
+	lines := make([]string, 0, len(rows))
+	for _, r := range rows {
+		line := r.prefix + paint(r.role, r.label)
+		if r.summary != "" {
+			used := width + 2
+			summary := r.summary
+			if maxWidth > 0 {
+				summary = runewidth.Truncate(summary, maxWidth-used, "…")
+			}
+			line += strings.Repeat(" ", used-displayWidth(r.prefix+r.label)) + summary
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package main
+
+import (
+	"os/exec"
+	"sync"
+	"syscall"
+)
+
+// umaskMu serializes starting commands under a umask of their own, as the
+// umask belongs to the whole cmdex process.
+var umaskMu sync.Mutex
+
+// startWithUmask starts cmd with the file mode creation mask umask, or
+// cmdex's own if it's negative. The child keeps the mask it started with.
+func startWithUmask(cmd *exec.Cmd, umask int) error {
+	if umask < 0 {
+		return cmd.Start()
+	}
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+	old := syscall.Umask(umask)
+	defer syscall.Umask(old)
+	return cmd.Start()
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// startWithUmask starts cmd. Windows has no umask, so asking for one is an
+// error.
+func startWithUmask(cmd *exec.Cmd, umask int) error {
+	if umask >= 0 {
+		return fmt.Errorf("umask is not supported on Windows")
+	}
+	return cmd.Start()
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Variant is an alternative version of an alias's body, kept under a name
+// and run as alias@name, while the alias's own body stays the default.
+// Variants let a better version of a command be tried out without losing
+// the known-good one.
+type Variant struct {
+	Command   string            `json:"command,omitempty" yaml:"command,omitempty"`
+	Script    string            `json:"script,omitempty" yaml:"script,omitempty"`
+	Runtime   string            `json:"runtime,omitempty" yaml:"runtime,omitempty"`
+	Platforms map[string]string `json:"platforms,omitempty" yaml:"platforms,omitempty"`
+	Steps     []Step            `json:"steps,omitempty" yaml:"steps,omitempty"`
+	Modified  time.Time         `json:"modified" yaml:"-"`
+}
+
+// splitVariant splits a reference like deploy@safe into the alias and
+// variant names. variant is empty if ref names no variant.
+func splitVariant(ref string) (alias, variant string) {
+	i := strings.LastIndex(ref, "@")
+	if i <= 0 || i == len(ref)-1 {
+		return ref, ""
+	}
+	return ref[:i], ref[i+1:]
+}
+
+// checkVariantName reports whether name can name a variant.
+func checkVariantName(name string) error {
+	if strings.ContainsAny(name, "@ \t\n") {
+		return fmt.Errorf("invalid variant name %q", name)
+	}
+	return nil
+}
+
+// ref returns how a is referred to on the command line: its name, or
+// name@variant when it was loaded as a variant.
+func (a *Alias) ref() string {
+	if a.Variant != "" {
+		return a.Name + "@" + a.Variant
+	}
+	return a.Name
+}
+
+// variantBody returns a's body as a variant.
+func (a *Alias) variantBody() *Variant {
+	return &Variant{Command: a.Command, Script: a.Script, Runtime: a.Runtime, Platforms: a.Platforms, Steps: a.Steps, Modified: a.Modified}
+}
+
+// setVariantBody puts the body of v in place of a's.
+func (a *Alias) setVariantBody(v *Variant) {
+	a.Command, a.Script, a.Runtime, a.Platforms, a.Steps = v.Command, v.Script, v.Runtime, v.Platforms, v.Steps
+}
+
+// variantNames returns the names of a's variants, sorted.
+func (a *Alias) variantNames() []string {
+	names := make([]string, 0, len(a.Variants))
+	for name := range a.Variants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// withVariant returns a copy of a that runs its variant name.
+func (a *Alias) withVariant(name string) (*Alias, error) {
+	v, ok := a.Variants[name]
+	if !ok {
+		if len(a.Variants) == 0 {
+			return nil, fmt.Errorf("alias %s has no variants", a.Name)
+		}
+		return nil, fmt.Errorf("alias %s has no variant %s (variants: %s)", a.Name, name, strings.Join(a.variantNames(), ", "))
+	}
+	c := *a
+	c.setVariantBody(v)
+	c.Variant = name
+	return &c, nil
+}
+
+// getVariant loads the alias ref refers to, which is either an alias name
+// or alias@variant. An alias whose name contains @ takes precedence.
+func getVariant(tx *bolt.Tx, ref string) (*Alias, error) {
+	a, err := getAlias(tx, ref)
+	if err != errAliasNotFound {
+		return a, err
+	}
+	name, variant := splitVariant(ref)
+	if variant == "" {
+		return nil, err
+	}
+	if a, err = getAlias(tx, name); err != nil {
+		return nil, err
+	}
+	return a.withVariant(variant)
+}
+
+func variantsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "variants",
+		Short: "Manage the named variants of an alias",
+		Long: `An alias can keep alternative versions of its command, script or steps as
+named variants, saved with cmdex save <alias>@<variant> and run with
+cmdex run <alias>@<variant>. The alias's own body is the default that runs
+without a variant. Promoting a variant makes it the default and keeps the
+former default under the variant's name, so promoting again switches back.`,
+		Example: "  cmdex save deploy@fast 'rsync -a --no-checksum build/ prod:/srv'\n  cmdex run deploy@fast\n  cmdex variants list deploy\n  cmdex variants promote deploy@fast",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:         "list <alias>",
+		Short:       "List the variants of an alias",
+		Annotations: readDB,
+		Args:        cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := loadAlias(args[0])
+			if err != nil {
+				return fmt.Errorf("retrieving command: %w", err)
+			}
+			var t table
+			t.color(0, "alias")
+			t.add(a.Name, "(default)", formatTime(a.Modified), a.summary())
+			for _, name := range a.variantNames() {
+				v, _ := a.withVariant(name)
+				t.add(v.ref(), "", formatTime(a.Variants[name].Modified), v.summary())
+			}
+			width, _, _ := terminalSize()
+			printLines(t.lines(width))
+			return nil
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "promote <alias>@<variant>",
+		Short: "Make a variant the default, keeping the old default as the variant",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, variant := splitVariant(args[0])
+			if variant == "" {
+				return usageError(fmt.Errorf("name the variant to promote as <alias>@<variant>"))
+			}
+			err := db.Update(func(tx *bolt.Tx) error {
+				a, err := getAlias(tx, name)
+				if err != nil {
+					return err
+				}
+				v, ok := a.Variants[variant]
+				if !ok {
+					_, err := a.withVariant(variant)
+					return err
+				}
+				now := time.Now()
+				former := a.variantBody()
+				former.Modified = now
+				a.setVariantBody(v)
+				a.Variants[variant] = former
+				a.Modified = now
+				if err := putAlias(tx, a); err != nil {
+					return err
+				}
+				return writeAudit(tx, auditEntry{Action: "promote", Target: name, Detail: "variant " + variant})
+			})
+			if err != nil {
+				return fmt.Errorf("promoting variant: %w", err)
+			}
+			fmt.Printf("Variant %s is now the default of %s; the former default runs as %s\n", variant, paint("alias", name), paint("alias", args[0]))
+			return nil
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "rm <alias>@<variant>",
+		Short: "Remove a variant of an alias",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, variant := splitVariant(args[0])
+			if variant == "" {
+				return usageError(fmt.Errorf("name the variant to remove as <alias>@<variant>"))
+			}
+			err := db.Update(func(tx *bolt.Tx) error {
+				a, err := getAlias(tx, name)
+				if err != nil {
+					return err
+				}
+				if _, err := a.withVariant(variant); err != nil {
+					return err
+				}
+				delete(a.Variants, variant)
+				if len(a.Variants) == 0 {
+					a.Variants = nil
+				}
+				a.Modified = time.Now()
+				if err := putAlias(tx, a); err != nil {
+					return err
+				}
+				return writeAudit(tx, auditEntry{Action: "variant-rm", Target: name, Detail: variant})
+			})
+			if err != nil {
+				return fmt.Errorf("removing variant: %w", err)
+			}
+			fmt.Printf("Removed variant %s of %s\n", variant, paint("alias", name))
+			return nil
+		},
+	})
+	return cmd
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+var varsBucket = []byte("vars")
+
+// getVar returns the value of a variable and whether it is set.
+func getVar(tx *bolt.Tx, name string) (string, bool, error) {
+	v := tx.Bucket(varsBucket).Get([]byte(name))
+	if v == nil {
+		return "", false, nil
+	}
+	v, err := decodeValue(name, v)
+	return string(v), err == nil, err
+}
+
+// putVar sets a variable.
+func putVar(tx *bolt.Tx, name, value string) error {
+	v, err := encodeValue(name, []byte(value))
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(varsBucket).Put([]byte(name), v)
+}
+
+// forEachVar calls fn for every variable in name order.
+func forEachVar(tx *bolt.Tx, fn func(name, value string) error) error {
+	return tx.Bucket(varsBucket).ForEach(func(k, v []byte) error {
+		v, err := decodeValue(string(k), v)
+		if err != nil {
+			return err
+		}
+		return fn(string(k), string(v))
+	})
+}
+
+// loadVars returns all global variables.
+func loadVars() (map[string]string, error) {
+	vars := make(map[string]string)
+	err := db.View(func(tx *bolt.Tx) error {
+		return forEachVar(tx, func(name, value string) error {
+			vars[name] = value
+			return nil
+		})
+	})
+	return vars, err
+}
+
+// loadTemplateData gathers the values needed to expand the templates in
+// body, skipping the database when body has no templates at all.
+func loadTemplateData(body string) (*templateData, error) {
+	data := &templateData{}
+	if !strings.Contains(body, "{{") {
+		return data, nil
+	}
+	var err error
+	data.vars, err = loadVars()
+	return data, err
+}
+
+func varCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "var",
+		Short: "Manage global variables used in commands as {{var \"NAME\"}}",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "set <name> <value>",
+		Short: "Set a variable",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, value := args[0], strings.Join(args[1:], " ")
+			err := db.Update(func(tx *bolt.Tx) error {
+				if err := putVar(tx, name, value); err != nil {
+					return err
+				}
+				return writeAudit(tx, auditEntry{Action: "var-set", Target: name})
+			})
+			if err != nil {
+				return fmt.Errorf("setting variable: %w", err)
+			}
+			fmt.Printf("Variable %s set\n", name)
+			return nil
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "get <name>",
+		Short: "Print the value of a variable",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var (
+				value string
+				ok    bool
+			)
+			err := db.View(func(tx *bolt.Tx) error {
+				var err error
+				value, ok, err = getVar(tx, args[0])
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("reading variable: %w", err)
+			}
+			if !ok {
+				return fmt.Errorf("variable %s is not set", args[0])
+			}
+			fmt.Println(value)
+			return nil
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List all variables",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var t table
+			err := db.View(func(tx *bolt.Tx) error {
+				return forEachVar(tx, func(name, value string) error {
+					t.add(name, value)
+					return nil
+				})
+			})
+			if err != nil {
+				return fmt.Errorf("listing variables: %w", err)
+			}
+			printLines(t.lines(0))
+			return nil
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "unset <name>...",
+		Short: "Remove variables",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := db.Update(func(tx *bolt.Tx) error {
+				b := tx.Bucket(varsBucket)
+				for _, name := range args {
+					if b.Get([]byte(name)) == nil {
+						return fmt.Errorf("variable %s is not set", name)
+					}
+					if err := b.Delete([]byte(name)); err != nil {
+						return err
+					}
+					if err := writeAudit(tx, auditEntry{Action: "var-unset", Target: name}); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("unsetting variable: %w", err)
+			}
+			return nil
+		},
+	})
+	return cmd
+}
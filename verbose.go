@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Verbosity levels of what cmdex itself prints on stderr around the output
+// of the commands it runs, chosen with the global -q and -v flags. Errors
+// are reported at every level.
+const (
+	// verbosityQuiet leaves only the commands' own output, for piping.
+	verbosityQuiet = -1
+	// verbosityNormal shows step headers, warnings and batch summaries.
+	verbosityNormal = 0
+	// verbosityVerbose (-v) also shows each expanded command before it
+	// runs and how long the run took.
+	verbosityVerbose = 1
+	// verbosityDebug (-vv) also shows the working directory, sandbox and
+	// resource limits of each command and the duration of every step.
+	verbosityDebug = 2
+)
+
+var verbosity = verbosityNormal
+
+// quiet and verbose are the values of the -q and -v flags.
+var (
+	quiet   bool
+	verbose int
+)
+
+// setVerbosity applies the -q and -v flags.
+func setVerbosity() error {
+	if quiet && verbose > 0 {
+		return usageError(fmt.Errorf("--quiet and --verbose can't be combined"))
+	}
+	verbosity = verbose
+	if quiet {
+		verbosity = verbosityQuiet
+	}
+	return nil
+}
+
+// printWarning prints a warning like printError, unless cmdex is quiet.
+func printWarning(format string, args ...interface{}) {
+	if verbosity >= verbosityNormal {
+		printError(format, args...)
+	}
+}
+
+// banner writes one of cmdex's own lines, such as "==> deploy", to w in the
+// step color if the verbosity is at least level.
+func banner(w io.Writer, level int, line string) {
+	if verbosity < level {
+		return
+	}
+	if f, ok := w.(*os.File); ok {
+		line = paintFor(f, "step", line)
+	}
+	fmt.Fprintln(w, line)
+}
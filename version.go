@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Build metadata, injected at build time with
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%FT%TZ)"
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// releaseRepo is the GitHub repository self-update downloads releases from.
+const releaseRepo = "mholtzhausen/alias-alias-go"
+
+// buildInfo returns the version metadata, falling back to what the Go
+// toolchain recorded when the binary was built without ldflags.
+func buildInfo() (ver, rev, built string) {
+	ver, rev, built = version, commit, date
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && rev == "":
+				rev = s.Value
+			case s.Key == "vcs.time" && built == "":
+				built = s.Value
+			}
+		}
+	}
+	return ver, rev, built
+}
+
+func versionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:         "version",
+		Short:       "Print version and build information",
+		Args:        cobra.NoArgs,
+		Annotations: noDB,
+		Run: func(cmd *cobra.Command, args []string) {
+			ver, rev, built := buildInfo()
+			fmt.Printf("cmdex %s\n", ver)
+			if rev != "" {
+				fmt.Printf("  commit:  %s\n", rev)
+			}
+			if built != "" {
+				fmt.Printf("  built:   %s\n", built)
+			}
+			fmt.Printf("  go:      %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+		},
+	}
+}
+
+// githubRelease is the subset of the GitHub releases API response we use.
+type githubRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (r *githubRelease) assetURL(name string) string {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL
+		}
+	}
+	return ""
+}
+
+func selfUpdateCmd() *cobra.Command {
+	var (
+		checkOnly bool
+		force     bool
+	)
+	cmd := &cobra.Command{
+		Use:         "self-update",
+		Short:       "Update cmdex to the latest GitHub release",
+		Args:        cobra.NoArgs,
+		Annotations: noDB,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := &http.Client{Timeout: 60 * time.Second}
+			rel, err := latestRelease(client)
+			if err != nil {
+				return fmt.Errorf("checking for updates: %w", err)
+			}
+			if rel.TagName == version && !force {
+				fmt.Printf("cmdex %s is up to date\n", version)
+				return nil
+			}
+			if checkOnly {
+				fmt.Printf("cmdex %s is available (current: %s)\n", rel.TagName, version)
+				return nil
+			}
+
+			asset := fmt.Sprintf("cmdex_%s_%s", runtime.GOOS, runtime.GOARCH)
+			if runtime.GOOS == "windows" {
+				asset += ".exe"
+			}
+			binURL := rel.assetURL(asset)
+			sumURL := rel.assetURL("checksums.txt")
+			if binURL == "" || sumURL == "" {
+				return fmt.Errorf("release %s has no %s binary with checksums", rel.TagName, asset)
+			}
+			want, err := fetchChecksum(client, sumURL, asset)
+			if err != nil {
+				return fmt.Errorf("fetching checksums: %w", err)
+			}
+			if err := replaceExecutable(client, binURL, want); err != nil {
+				return fmt.Errorf("updating binary: %w", err)
+			}
+			fmt.Printf("Updated cmdex %s -> %s\n", version, rel.TagName)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&checkOnly, "check", false, "Only report whether an update is available")
+	cmd.Flags().BoolVar(&force, "force", false, "Reinstall even if already on the latest version")
+	return cmd
+}
+
+func latestRelease(client *http.Client) (*githubRelease, error) {
+	url := "https://api.github.com/repos/" + releaseRepo + "/releases/latest"
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GitHub API returned %s", resp.Status)
+	}
+	var rel githubRelease
+	if err := json.NewDecoder(resp.Body).Decode(&rel); err != nil {
+		return nil, err
+	}
+	return &rel, nil
+}
+
+// fetchChecksum returns the SHA-256 listed for asset in a checksums file of
+// "<hex digest>  <file name>" lines.
+func fetchChecksum(client *http.Client, url, asset string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download returned %s", resp.Status)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == asset {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no checksum listed for %s", asset)
+}
+
+// replaceExecutable downloads the new binary next to the running one,
+// verifies its checksum and renames it into place, so the swap is atomic.
+func replaceExecutable(client *http.Client, url, wantSum string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download returned %s", resp.Status)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".cmdex-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != wantSum {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", wantSum, got)
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+
+	if runtime.GOOS == "windows" {
+		// A running executable can't be overwritten on Windows, but it can
+		// be moved out of the way.
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), exe)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// vscodeTask is a task in .vscode/tasks.json.
+type vscodeTask struct {
+	Label          string   `json:"label"`
+	Type           string   `json:"type"`
+	Command        string   `json:"command"`
+	Args           []string `json:"args"`
+	Detail         string   `json:"detail,omitempty"`
+	ProblemMatcher []string `json:"problemMatcher"`
+}
+
+// vscodeInput is a value VS Code asks for when a task references it as
+// ${input:id}.
+type vscodeInput struct {
+	ID          string   `json:"id"`
+	Type        string   `json:"type"`
+	Description string   `json:"description"`
+	Default     string   `json:"default,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// renderVSCodeTasks writes aliases as a VS Code tasks file. Each task runs
+// cmdex run <alias>, and each placeholder becomes an input prompted for, or
+// picked from its choices, when the task starts.
+func renderVSCodeTasks(aliases []*Alias) ([]byte, error) {
+	tasks := struct {
+		Version string        `json:"version"`
+		Tasks   []vscodeTask  `json:"tasks"`
+		Inputs  []vscodeInput `json:"inputs,omitempty"`
+	}{Version: "2.0.0", Tasks: []vscodeTask{}}
+	for _, a := range aliases {
+		task := vscodeTask{
+			Label:          "cmdex: " + a.Name,
+			Type:           "process",
+			Command:        "cmdex",
+			Args:           []string{"run", a.Name},
+			Detail:         a.Description,
+			ProblemMatcher: []string{},
+		}
+		for i, ph := range docPlaceholders(a) {
+			in := vscodeInput{
+				ID:          fmt.Sprintf("%s-%d", a.Name, i+1),
+				Type:        "promptString",
+				Description: fmt.Sprintf("%s %s", a.Name, ph.Arg),
+				Default:     ph.Default,
+			}
+			if ph.Name != "" {
+				in.Description += " " + ph.Name
+			}
+			if ph.Description != "" {
+				in.Description += ": " + ph.Description
+			}
+			if i < len(a.Placeholders) && len(a.Placeholders[i].Choices) > 0 {
+				in.Type, in.Options = "pickString", a.Placeholders[i].Choices
+			}
+			tasks.Inputs = append(tasks.Inputs, in)
+			task.Args = append(task.Args, "${input:"+in.ID+"}")
+		}
+		tasks.Tasks = append(tasks.Tasks, task)
+	}
+	return json.MarshalIndent(tasks, "", "  ")
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WaitForStep is a built-in step that polls until something is ready: a
+// command exits 0, a TCP port accepts connections or a URL answers with a
+// 2xx status (or Status). Exactly one of Run, TCP and HTTP is set.
+type WaitForStep struct {
+	Run  string `json:"run,omitempty" yaml:"run,omitempty"`
+	TCP  string `json:"tcp,omitempty" yaml:"tcp,omitempty"`
+	HTTP string `json:"http,omitempty" yaml:"http,omitempty"`
+	// Status is the expected status of the HTTP check.
+	Status int `json:"status,omitempty" yaml:"status,omitempty"`
+	// Interval is the time between checks, 1s if unset.
+	Interval string `json:"interval,omitempty" yaml:"interval,omitempty"`
+	// Timeout is how long to wait before the step fails, 60s if unset.
+	Timeout string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// durations parses the interval and timeout of a wait_for step.
+func (w *WaitForStep) durations() (interval, timeout time.Duration, err error) {
+	interval, timeout = time.Second, time.Minute
+	for _, d := range []struct {
+		name, value string
+		into        *time.Duration
+	}{{"interval", w.Interval, &interval}, {"timeout", w.Timeout, &timeout}} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil || v <= 0 {
+			return 0, 0, fmt.Errorf("invalid wait_for %s %q (use a duration such as 2s or 5m)", d.name, d.value)
+		}
+		*d.into = v
+	}
+	return interval, timeout, nil
+}
+
+// validate checks the settings of a wait_for step.
+func (w *WaitForStep) validate() error {
+	checks := 0
+	for _, set := range []bool{w.Run != "", w.TCP != "", w.HTTP != ""} {
+		if set {
+			checks++
+		}
+	}
+	if checks != 1 {
+		return fmt.Errorf("wait_for needs exactly one of run, tcp and http")
+	}
+	if w.Status != 0 && w.HTTP == "" {
+		return fmt.Errorf("wait_for status only applies to http checks")
+	}
+	_, _, err := w.durations()
+	return err
+}
+
+// target describes what the step waits for.
+func (w *WaitForStep) target() string {
+	switch {
+	case w.TCP != "":
+		return "tcp " + w.TCP
+	case w.HTTP != "":
+		return "http " + w.HTTP
+	}
+	return w.Run
+}
+
+// runWaitFor performs a wait_for step, checking until the check passes or
+// the timeout runs out. The output of a command check is only shown when
+// the wait fails.
+func (s *sequence) runWaitFor(ctx context.Context, w *WaitForStep) error {
+	interval, timeout, err := w.durations()
+	if err != nil {
+		return err
+	}
+	var check func(ctx context.Context) error
+	var output strings.Builder
+	switch {
+	case w.TCP != "":
+		addr, err := s.render(w.TCP)
+		if err != nil {
+			return err
+		}
+		if s.sandbox != nil && s.sandbox.noNet {
+			return fmt.Errorf("tcp checks can't run with --no-net")
+		}
+		check = func(ctx context.Context) error {
+			conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}
+	case w.HTTP != "":
+		url, err := s.render(w.HTTP)
+		if err != nil {
+			return err
+		}
+		if s.sandbox != nil && s.sandbox.noNet {
+			return fmt.Errorf("http checks can't run with --no-net")
+		}
+		check = func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			ok := resp.StatusCode >= 200 && resp.StatusCode < 300
+			if w.Status != 0 {
+				ok = resp.StatusCode == w.Status
+			}
+			if !ok {
+				return fmt.Errorf("%s returned %s", url, resp.Status)
+			}
+			return nil
+		}
+	default:
+		command, err := s.render(w.Run)
+		if err != nil {
+			return err
+		}
+		argv := strings.Fields(command)
+		if len(argv) == 0 {
+			return fmt.Errorf("empty command")
+		}
+		if err := policy.checkCommand(command); err != nil {
+			return err
+		}
+		check = func(ctx context.Context) error {
+			cmd, err := s.command(ctx, argv)
+			if err != nil {
+				return err
+			}
+			output.Reset()
+			cmd.Stdout, cmd.Stderr = &output, &output
+			return cmd.Run()
+		}
+	}
+	s.trace(verbosityVerbose, "waiting up to %s for %s", timeout, w.target())
+
+	start := time.Now()
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for attempt := 1; ; attempt++ {
+		err := check(waitCtx)
+		if err == nil {
+			s.trace(verbosityVerbose, "ready after %s: %s", time.Since(start).Round(time.Millisecond), w.target())
+			return nil
+		}
+		s.trace(verbosityDebug, "check %d: %v", attempt, err)
+		if waitCtx.Err() == nil {
+			select {
+			case <-time.After(interval):
+				continue
+			case <-waitCtx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			return childError(ctx, ctx.Err())
+		}
+		if output.Len() > 0 {
+			fmt.Fprint(s.stderr, output.String())
+		}
+		return stepFailed(fmt.Errorf("%s not ready after %s (%d checks): %v", w.target(), timeout, attempt, err))
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileStamp is what a definitionWatch knows of a file: enough to tell that
+// it changed. Files that don't exist have the zero stamp.
+type fileStamp struct {
+	modified time.Time
+	size     int64
+}
+
+// definitionWatch polls the files that define aliases outside the store,
+// the layers, and the config file for changes.
+type definitionWatch struct {
+	stamps map[string]fileStamp
+}
+
+func newDefinitionWatch() *definitionWatch {
+	// The first reload compares against the layers as they are now.
+	loadLayers()
+	return &definitionWatch{stamps: definitionStamps()}
+}
+
+// definitionStamps stamps the config file and the layer files, including
+// those that don't exist yet but would be read if they did.
+func definitionStamps() map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	stamp := func(path string) {
+		var s fileStamp
+		if info, err := os.Stat(path); err == nil {
+			s = fileStamp{info.ModTime(), info.Size()}
+		}
+		stamps[path] = s
+	}
+	stamp(configPath())
+	files, dirs := layerSources()
+	for _, src := range files {
+		stamp(src.path)
+	}
+	// New files in the layer directories are layers too.
+	for _, dir := range dirs {
+		for _, path := range layerFiles(dir) {
+			stamp(path)
+		}
+	}
+	return stamps
+}
+
+// changed returns the files that changed, appeared or disappeared since
+// the last call, in name order.
+func (w *definitionWatch) changed() []string {
+	stamps := definitionStamps()
+	var paths []string
+	for path, s := range stamps {
+		if old, ok := w.stamps[path]; !ok && s != (fileStamp{}) || ok && old != s {
+			paths = append(paths, path)
+		}
+	}
+	for path, old := range w.stamps {
+		if _, ok := stamps[path]; !ok && old != (fileStamp{}) {
+			paths = append(paths, path)
+		}
+	}
+	w.stamps = stamps
+	sort.Strings(paths)
+	return paths
+}
+
+// changeEvent describes a reload of the definitions, for the clients of
+// the daemon's event stream.
+type changeEvent struct {
+	// Event is aliases_changed, config_changed or reload_failed.
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+	Files   []string  `json:"files"`
+	Added   []string  `json:"added,omitempty"`
+	Changed []string  `json:"changed,omitempty"`
+	Removed []string  `json:"removed,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+func (e changeEvent) String() string {
+	switch e.Event {
+	case "reload_failed":
+		return fmt.Sprintf("reloading %s failed: %s", strings.Join(e.Files, ", "), e.Error)
+	case "config_changed":
+		return fmt.Sprintf("reloaded the config %s", strings.Join(e.Files, ", "))
+	}
+	var parts []string
+	for _, p := range []struct {
+		verb  string
+		names []string
+	}{{"added", e.Added}, {"changed", e.Changed}, {"removed", e.Removed}} {
+		if len(p.names) > 0 {
+			parts = append(parts, p.verb+" "+strings.Join(p.names, ", "))
+		}
+	}
+	return fmt.Sprintf("reloaded %s: %s", strings.Join(e.Files, ", "), strings.Join(parts, "; "))
+}
+
+// layeredSpecs returns the aliases the layers define as they would run,
+// encoded, by name.
+func layeredSpecs(ls []*aliasLayer) map[string]string {
+	specs := make(map[string]string)
+	for _, l := range ls {
+		for _, a := range l.aliases {
+			data, _ := json.Marshal(a)
+			specs[a.Name] = string(data)
+		}
+	}
+	return specs
+}
+
+// reloadDefinitions rereads the config and the layers after the files
+// paths changed and returns what changed. A file that can't be read
+// keeps its previous definitions.
+func reloadDefinitions(paths []string) []changeEvent {
+	now := time.Now()
+	var events []changeEvent
+	for _, path := range paths {
+		if path != configPath() {
+			continue
+		}
+		next, err := readConfig()
+		if err == nil {
+			err = checkHooks(next)
+		}
+		// A file caught halfway through being written must not open the
+		// daemon to everyone either.
+		if err == nil && len(cfg.Serve.Users) > 0 && len(next.Serve.Users) == 0 {
+			err = errors.New("removing every user of serve.users takes a restart")
+		}
+		if err != nil {
+			events = append(events, changeEvent{Event: "reload_failed", Time: now, Files: []string{path}, Error: err.Error()})
+			break
+		}
+		cfgMu.Lock()
+		cfg = next
+		cfgMu.Unlock()
+		events = append(events, changeEvent{Event: "config_changed", Time: now, Files: []string{path}})
+	}
+
+	old := loadLayers()
+	layers = readLayers(old, func(src layerSource, err error) {
+		// Files that failed before and haven't changed since were
+		// reported then.
+		for _, path := range paths {
+			if path == src.path {
+				events = append(events, changeEvent{Event: "reload_failed", Time: now, Files: []string{path}, Error: err.Error()})
+			}
+		}
+	})
+	before, after := layeredSpecs(old), layeredSpecs(layers)
+	e := changeEvent{Event: "aliases_changed", Time: now}
+	for name, spec := range after {
+		switch previous, ok := before[name]; {
+		case !ok:
+			e.Added = append(e.Added, name)
+		case previous != spec:
+			e.Changed = append(e.Changed, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			e.Removed = append(e.Removed, name)
+		}
+	}
+	if len(e.Added)+len(e.Changed)+len(e.Removed) > 0 {
+		for _, path := range paths {
+			if path != configPath() {
+				e.Files = append(e.Files, path)
+			}
+		}
+		if e.Files == nil {
+			// The config changed which layers there are.
+			e.Files = paths
+		}
+		sort.Strings(e.Added)
+		sort.Strings(e.Changed)
+		sort.Strings(e.Removed)
+		events = append(events, e)
+	}
+	return events
+}
+
+// changeFeed hands the change events of the daemon to the clients
+// listening for them.
+type changeFeed struct {
+	mu   sync.Mutex
+	subs map[chan changeEvent]bool
+}
+
+// subscribe returns a channel receiving the events from now on, and the
+// function to stop them with.
+func (f *changeFeed) subscribe() (<-chan changeEvent, func()) {
+	ch := make(chan changeEvent, 16)
+	f.mu.Lock()
+	if f.subs == nil {
+		f.subs = make(map[chan changeEvent]bool)
+	}
+	f.subs[ch] = true
+	f.mu.Unlock()
+	return ch, func() {
+		f.mu.Lock()
+		delete(f.subs, ch)
+		f.mu.Unlock()
+	}
+}
+
+// publish sends e to every subscriber. Subscribers that fall behind miss
+// events rather than hold up the others.
+func (f *changeFeed) publish(e changeEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestReloadDuringRun reloads the config while runs read it, as the daemon
+// does; run it with -race.
+func TestReloadDuringRun(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer collector.Close()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	write := func(service string) {
+		config := fmt.Sprintf("tracing:\n  endpoint: %s\n  service: %s\nnotify:\n  default: [hook]\n  targets:\n    hook:\n      url: %s\n      on: always\n", collector.URL, service, collector.URL)
+		if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+			t.Error(err)
+		}
+	}
+	write("before")
+	t.Setenv("CMDEX_CONFIG", path)
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { cfg = Config{} }()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			write(fmt.Sprintf("reload-%d", i))
+			reloadDefinitions([]string{path})
+		}
+	}()
+	var runs sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		runs.Add(1)
+		go func() {
+			defer runs.Done()
+			s, err := newSequence(&Alias{Name: "hello", Command: "true"}, nil, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			s.stdout, s.stderr = io.Discard, io.Discard
+			if err := s.execute(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	runs.Wait()
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+func whichCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:         "which <alias>",
+		Annotations: readDB,
+		Short:       "Show where an alias comes from and what it runs",
+		Long: `which shows how an alias name resolves: the store or read-only layer it
+was loaded from, the layers it shadows, its storage key, any deprecation
+redirects, the platform variant in effect and the binary each step's first
+word resolves to on PATH.
+
+Aliases are looked up in the store first, then in the layers:
+.cmdex/aliases.yaml and .cmdex/aliases.d/*.yaml of the current project,
+the files and directories listed under layers in the config, last first,
+and the system-wide /etc/cmdex/aliases.d/*.yaml (%ProgramData%\cmdex\aliases.d
+on Windows), the file sorting last first. The first to have the name wins.
+A layer file lists aliases by name, each written like a spec:
+
+  aliases:
+    deploy:
+      command: ./scripts/deploy.sh $1
+      description: Deploy to an environment
+      confirm: true
+
+Layers are read-only: cmdex edit saves an edited copy in the store, which
+shadows the layer from then on.`,
+		Args: cobra.ExactArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) > 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return completeAliasArgs(cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := loadAlias(args[0])
+			if err != nil {
+				return fmt.Errorf("retrieving command: %w", err)
+			}
+			field := func(label, value string) {
+				fmt.Printf("%-13s %s\n", label+":", value)
+			}
+			shadowed := layersWith(a.Name)
+			field("Alias", paint("alias", a.Name))
+			if a.layer != nil {
+				field("Source", a.layer.String()+" (read-only)")
+				shadowed = shadowed[1:]
+			} else {
+				source := db.Path()
+				if abs, err := filepath.Abs(source); err == nil {
+					source = abs
+				}
+				if storeKey != nil {
+					source += " (encrypted)"
+				}
+				field("Source", "database "+source)
+				field("Key", string(commandsBucket)+"/"+a.Name)
+				var stored *Alias
+				db.View(func(tx *bolt.Tx) error {
+					stored, err = storedAlias(tx, a.Name)
+					return err
+				})
+				if stored != nil && stored.ScriptBlob != "" {
+					field("Script blob", string(blobsBucket)+"/"+stored.ScriptBlob)
+				}
+			}
+			for _, l := range shadowed {
+				field("Shadows", l.String())
+			}
+
+			chain := []string{paint("alias", a.Name)}
+			seen := map[string]bool{a.Name: true}
+			for a.Deprecated != nil {
+				d := a.Deprecated
+				if d.Strict {
+					chain = append(chain, paint("alias", d.Use)+" (strict, not followed)")
+					break
+				}
+				if seen[d.Use] || len(seen) > maxRedirects {
+					chain = append(chain, paint("alias", d.Use)+" (loop)")
+					break
+				}
+				next, err := loadAlias(d.Use)
+				if err != nil {
+					chain = append(chain, paint("alias", d.Use)+" (missing)")
+					break
+				}
+				seen[next.Name] = true
+				chain = append(chain, paint("alias", next.Name))
+				a = next
+			}
+			if len(chain) > 1 {
+				field("Redirects", strings.Join(chain, " -> "))
+			}
+
+			if len(a.Platforms) > 0 {
+				key, _ := a.platform()
+				if key == "" {
+					key = "default"
+				}
+				field("Variant", key)
+			}
+			if a.isScript() {
+				argv, _, err := a.interpreter()
+				if err != nil {
+					field("Interpreter", err.Error())
+				} else {
+					field("Interpreter", resolveBinary(argv[0]))
+				}
+				return nil
+			}
+			if len(a.Steps) == 0 && a.command() == "" && len(a.Platforms) > 0 {
+				field("Binary", errNoPlatformCommand(a).Error())
+				return nil
+			}
+			steps := a.steps()
+			for i, step := range steps {
+				label := "Binary"
+				if len(steps) > 1 {
+					label = fmt.Sprintf("Step %d", i+1)
+				}
+				if step.Run == "" {
+					field(label, step.text())
+					continue
+				}
+				field(label, resolveBinary(firstWord(step.Run)))
+			}
+			return nil
+		},
+	}
+}
+
+// firstWord returns the program a command line starts.
+func firstWord(command string) string {
+	if fields := strings.Fields(command); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// resolveBinary describes what name runs as: its path on PATH, or why it
+// can't be told before the alias runs.
+func resolveBinary(name string) string {
+	switch {
+	case name == "":
+		return "(empty command)"
+	case strings.Contains(name, "$") || strings.Contains(name, "{{"):
+		return name + " (resolved at run time)"
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return name + " (not found on PATH)"
+	}
+	if path == name {
+		return path
+	}
+	return name + " -> " + path
+}
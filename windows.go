@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WindowConfig is a named safety window from windows in the config, shared
+// by the aliases that name it with window:, such as one for everything
+// touching production.
+type WindowConfig struct {
+	Deny  []string `yaml:"deny"`
+	Allow []string `yaml:"allow"`
+	// Outside is what happens on runs outside the window: refuse (the
+	// default) or confirm.
+	Outside string `yaml:"outside"`
+}
+
+const (
+	minutesPerDay  = 24 * 60
+	minutesPerWeek = 7 * minutesPerDay
+)
+
+// window is a parsed time window, such as Fri 16:00-Mon 08:00.
+type window struct {
+	text string
+	// spans are [start, end) in minutes from Sunday 00:00. An end past the
+	// week wraps around into the next.
+	spans [][2]int
+}
+
+var (
+	weekSpanRE = regexp.MustCompile(`^([a-z]+)\s+(\d{1,2}:\d\d)\s*-\s*([a-z]+)\s+(\d{1,2}:\d\d)$`)
+	daySpanRE  = regexp.MustCompile(`^([a-z][a-z,\s-]*?)?\s*(?:(\d{1,2}:\d\d)\s*-\s*(\d{1,2}:\d\d))?$`)
+)
+
+// parseWindow parses a time window in local time: a span across the week
+// such as "Fri 16:00-Mon 08:00", days such as "Sat-Sun" or "Mon,Wed", hours
+// of every day such as "22:00-06:00", or hours of some days such as
+// "Mon-Fri 18:00-08:00". Hours ending before they start run into the next
+// day.
+func parseWindow(text string) (window, error) {
+	w := window{text: text}
+	s := strings.ToLower(strings.TrimSpace(text))
+	s = strings.NewReplacer("–", "-", "—", "-").Replace(s)
+	if m := weekSpanRE.FindStringSubmatch(s); m != nil {
+		from, err := parseDay(m[1])
+		if err != nil {
+			return w, err
+		}
+		to, err := parseDay(m[3])
+		if err != nil {
+			return w, err
+		}
+		start, err := parseClock(m[2])
+		if err != nil {
+			return w, err
+		}
+		end, err := parseClock(m[4])
+		if err != nil {
+			return w, err
+		}
+		span := [2]int{from*minutesPerDay + start, to*minutesPerDay + end}
+		if span[1] <= span[0] {
+			span[1] += minutesPerWeek
+		}
+		w.spans = append(w.spans, span)
+		return w, nil
+	}
+	m := daySpanRE.FindStringSubmatch(s)
+	if m == nil || s == "" {
+		return w, fmt.Errorf("invalid time window %q (use e.g. \"Fri 16:00-Mon 08:00\", \"Sat-Sun\" or \"Mon-Fri 18:00-08:00\")", text)
+	}
+	days := []int{0, 1, 2, 3, 4, 5, 6}
+	if strings.TrimSpace(m[1]) != "" {
+		var err error
+		if days, err = parseDays(m[1]); err != nil {
+			return w, err
+		}
+	}
+	start, end := 0, minutesPerDay
+	if m[2] != "" {
+		var err error
+		if start, err = parseClock(m[2]); err != nil {
+			return w, err
+		}
+		if end, err = parseClock(m[3]); err != nil {
+			return w, err
+		}
+		if end <= start {
+			end += minutesPerDay
+		}
+	}
+	for _, d := range days {
+		w.spans = append(w.spans, [2]int{d*minutesPerDay + start, d*minutesPerDay + end})
+	}
+	return w, nil
+}
+
+// parseDays parses days such as "mon-fri" or "sat,sun" into weekday
+// numbers. Ranges may wrap around the week, as in fri-mon.
+func parseDays(s string) ([]int, error) {
+	var days []int
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		first, err := parseDay(strings.TrimSpace(from))
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if isRange {
+			if last, err = parseDay(strings.TrimSpace(to)); err != nil {
+				return nil, err
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseDay parses a weekday name, or the first three letters or more of
+// one, into its number from Sunday.
+func parseDay(s string) (int, error) {
+	for i, name := range []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"} {
+		if len(s) >= 3 && strings.HasPrefix(name, s) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid day %q (use Mon, Tue, ...)", s)
+}
+
+// parseClock parses a time of day such as 8:00 or 16:30 into minutes since
+// midnight. 24:00 is the end of the day.
+func parseClock(s string) (int, error) {
+	h, m, _ := strings.Cut(s, ":")
+	hours, err := strconv.Atoi(h)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	minutes, err := strconv.Atoi(m)
+	if err != nil || minutes > 59 || hours > 24 || (hours == 24 && minutes > 0) {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return hours*60 + minutes, nil
+}
+
+// contains reports whether t, in local time, falls in w.
+func (w window) contains(t time.Time) bool {
+	m := int(t.Weekday())*minutesPerDay + t.Hour()*60 + t.Minute()
+	for _, span := range w.spans {
+		if (m >= span[0] && m < span[1]) || (m+minutesPerWeek >= span[0] && m+minutesPerWeek < span[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWindows parses each of texts with parseWindow.
+func parseWindows(texts []string) ([]window, error) {
+	var windows []window
+	for _, text := range texts {
+		w, err := parseWindow(text)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// checkOutsideWindow checks the action taken outside a window.
+func checkOutsideWindow(action string) error {
+	switch action {
+	case "", "refuse", "confirm":
+		return nil
+	}
+	return fmt.Errorf("invalid outside window action %q (use refuse or confirm)", action)
+}
+
+// safetyWindow returns the times g denies and allows runs at, with those of
+// the window it names in the config, and what to do outside them.
+func (g Guards) safetyWindow() (deny, allow []window, outside string, err error) {
+	denied, allowed, outside := g.Deny, g.Allow, g.OutsideWindow
+	if g.Window != "" {
+		wc, ok := currentConfig().Windows[g.Window]
+		if !ok {
+			return nil, nil, "", fmt.Errorf("window %s isn't defined under windows in the config", g.Window)
+		}
+		if err := checkOutsideWindow(wc.Outside); err != nil {
+			return nil, nil, "", fmt.Errorf("windows.%s: %w", g.Window, err)
+		}
+		denied = append(append([]string(nil), wc.Deny...), denied...)
+		allowed = append(append([]string(nil), wc.Allow...), allowed...)
+		if outside == "" {
+			outside = wc.Outside
+		}
+	}
+	if deny, err = parseWindows(denied); err == nil {
+		allow, err = parseWindows(allowed)
+	}
+	if err != nil && g.Window != "" {
+		err = fmt.Errorf("windows.%s: %w", g.Window, err)
+	}
+	return deny, allow, outside, err
+}
+
+// checkWindow refuses to run s outside its alias's safety window, or, if
+// the alias says to, asks twice whether to run anyway.
+func (s *sequence) checkWindow() error {
+	g := s.alias.Guards
+	if len(g.Deny) == 0 && len(g.Allow) == 0 && g.Window == "" {
+		return nil
+	}
+	deny, allow, outside, err := g.safetyWindow()
+	if err != nil {
+		return fmt.Errorf("alias %s: %w", s.alias.Name, err)
+	}
+	now := time.Now()
+	var problem string
+	for _, w := range deny {
+		if w.contains(now) {
+			problem = fmt.Sprintf("alias %s must not run %s, and it's %s", s.alias.Name, w.text, now.Format("Mon 15:04"))
+			break
+		}
+	}
+	if problem == "" && len(allow) > 0 {
+		var texts []string
+		for _, w := range allow {
+			if w.contains(now) {
+				return nil
+			}
+			texts = append(texts, w.text)
+		}
+		problem = fmt.Sprintf("alias %s only runs %s, and it's %s", s.alias.Name, strings.Join(texts, ", "), now.Format("Mon 15:04"))
+	}
+	if problem == "" {
+		return nil
+	}
+	if outside != "confirm" || !interactive() {
+		return errors.New(problem)
+	}
+	ok, err := confirm(trf("%s. Run it anyway?", problem), false)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("aborted")
+	}
+	name, err := ask(trf("Type %s to run it outside its window", s.alias.Name), "")
+	if err != nil {
+		return err
+	}
+	if name != s.alias.Name {
+		return fmt.Errorf("aborted")
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// WriteFileStep is a built-in step that writes a file, such as a config
+// file or a manifest for the steps after it to use.
+type WriteFileStep struct {
+	Path    string `json:"path" yaml:"path"`
+	Content string `json:"content,omitempty" yaml:"content,omitempty"`
+	// Mode is the file's permissions in octal, 0644 if unset.
+	Mode string `json:"mode,omitempty" yaml:"mode,omitempty"`
+	// Cleanup marks the file as temporary: once the alias finishes, it is
+	// removed again, or its former content is put back if it existed.
+	Cleanup bool `json:"cleanup,omitempty" yaml:"cleanup,omitempty"`
+}
+
+// fileMode parses the mode of a write_file step.
+func (w *WriteFileStep) fileMode() (fs.FileMode, error) {
+	if w.Mode == "" {
+		return 0644, nil
+	}
+	m, err := strconv.ParseUint(strings.TrimPrefix(w.Mode, "0o"), 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("invalid file mode %q (use octal permissions such as 0600)", w.Mode)
+	}
+	return fs.FileMode(m), nil
+}
+
+// runWriteFile performs a write_file step. The path is relative to the
+// working directory of the alias.
+func (s *sequence) runWriteFile(w *WriteFileStep) error {
+	path, err := s.render(w.Path)
+	if err != nil {
+		return err
+	}
+	content, err := s.render(w.Content)
+	if err != nil {
+		return err
+	}
+	mode, err := w.fileMode()
+	if err != nil {
+		return err
+	}
+	path = expandHome(path)
+	if !filepath.IsAbs(path) && s.dir != "" {
+		path = filepath.Join(s.dir, path)
+	}
+	if s.sandbox != nil {
+		if err := checkSandboxWrite(s.dir, path); err != nil {
+			return err
+		}
+	}
+	s.trace(verbosityVerbose, "write %s (%s, %d bytes)", path, mode, len(content))
+
+	if w.Cleanup {
+		if err := s.markCleanup(path); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return stepFailed(fmt.Errorf("writing %s: %w", path, err))
+	}
+	if err := os.WriteFile(path, []byte(content), mode); err != nil {
+		return stepFailed(fmt.Errorf("writing %s: %w", path, err))
+	}
+	// WriteFile keeps the permissions of a file that exists already.
+	if err := os.Chmod(path, mode); err != nil {
+		return stepFailed(fmt.Errorf("writing %s: %w", path, err))
+	}
+	return nil
+}
+
+// checkSandboxWrite reports whether a sandboxed alias run in dir may write
+// path. Sandboxed commands may only write to their directory, and not at all
+// when it is HOME or above it; the files written for them are held to the
+// same rule. Symbolic links are followed to where they lead, and the file
+// itself may not be one.
+func checkSandboxWrite(dir, path string) error {
+	dir, writable, err := sandboxDir(dir)
+	if err != nil {
+		return err
+	}
+	if !writable {
+		return fmt.Errorf("sandboxed aliases can't write files when run in %s, which is or contains HOME", dir)
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		return fmt.Errorf("sandboxed aliases can't write through the symbolic link %s", path)
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return err
+	}
+	abs, _ := filepath.Abs(path)
+	parent, err := resolveExisting(filepath.Dir(abs))
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(parent, filepath.Base(abs))
+	if rel, err := filepath.Rel(dir, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("sandboxed aliases can only write files in %s, not %s", dir, path)
+	}
+	return nil
+}
+
+// resolveExisting resolves the symbolic links of path as far as it exists;
+// the missing rest, which write_file creates, is joined on unchanged. A
+// link to something missing is followed to where it leads.
+func resolveExisting(path string) (string, error) {
+	var missing []string
+	for links := 0; ; {
+		real, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{real}, missing...)...), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) || filepath.Dir(path) == path {
+			return "", err
+		}
+		if target, err := os.Readlink(path); err == nil {
+			if links++; links > 255 {
+				return "", fmt.Errorf("%s: too many levels of symbolic links", path)
+			}
+			if !filepath.IsAbs(target) {
+				parent, err := filepath.EvalSymlinks(filepath.Dir(path))
+				if err != nil {
+					return "", err
+				}
+				target = filepath.Join(parent, target)
+			}
+			path = target
+			continue
+		}
+		missing = append([]string{filepath.Base(path)}, missing...)
+		path = filepath.Dir(path)
+	}
+}
+
+// markCleanup records how to undo writing path once the sequence is done:
+// remove it and the directories created for it, or put back what it held.
+func (s *sequence) markCleanup(path string) error {
+	info, err := os.Stat(path)
+	switch {
+	case err == nil:
+		if info.IsDir() {
+			return stepFailed(fmt.Errorf("writing %s: is a directory", path))
+		}
+		old, err := os.ReadFile(path)
+		if err != nil {
+			return stepFailed(fmt.Errorf("writing %s: %w", path, err))
+		}
+		s.cleanups = append(s.cleanups, func() error {
+			if err := os.WriteFile(path, old, info.Mode().Perm()); err != nil {
+				return err
+			}
+			return os.Chmod(path, info.Mode().Perm())
+		})
+	case errors.Is(err, fs.ErrNotExist):
+		// The missing parent directories go again too, deepest first.
+		var created []string
+		for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+			if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+				break
+			}
+			created = append(created, dir)
+		}
+		s.cleanups = append(s.cleanups, func() error {
+			if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			for _, dir := range created {
+				// Directories the alias has since put other files in stay.
+				if os.Remove(dir) != nil {
+					break
+				}
+			}
+			return nil
+		})
+	default:
+		return stepFailed(fmt.Errorf("writing %s: %w", path, err))
+	}
+	s.trace(verbosityDebug, "%s is cleaned up when %s finishes", path, s.alias.ref())
+	return nil
+}
+
+// cleanUp undoes the temporary files the sequence wrote, newest first.
+func (s *sequence) cleanUp() {
+	for i := len(s.cleanups) - 1; i >= 0; i-- {
+		if err := s.cleanups[i](); err != nil {
+			printWarning("Warning: cleaning up: %v", err)
+		}
+	}
+	s.cleanups = nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sandboxTree lays out a directory for sandboxed runs in a new temporary
+// directory, which it returns with its symbolic links resolved:
+//
+//	home/
+//	outside/
+//	work/sub/
+//	work/inner -> work/sub
+//	work/out -> outside
+//	work/file -> outside/f
+//	work/gone -> outside/gone/dir
+//	work/rel -> ../outside/rel
+func sandboxTree(t *testing.T) string {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"home", "outside", "work/sub"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{"work/inner": "work/sub", "work/out": "outside", "work/file": "outside/f",
+		"work/gone": "outside/gone/dir"}
+	for link, target := range links {
+		if err := os.Symlink(filepath.Join(root, target), filepath.Join(root, link)); err != nil {
+			t.Skipf("can't create symbolic links: %v", err)
+		}
+	}
+	if err := os.Symlink("../outside/rel", filepath.Join(root, "work/rel")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", filepath.Join(root, "home"))
+	return root
+}
+
+func TestCheckSandboxWrite(t *testing.T) {
+	root := sandboxTree(t)
+	tests := []struct {
+		dir, path string
+		// want is part of the error, or empty if the write is allowed.
+		want string
+	}{
+		{"work", "work/a.txt", ""},
+		{"work", "work/sub/a.txt", ""},
+		{"work", "work/new/deep/a.txt", ""},
+		{"work", "work/inner/a.txt", ""},
+		{"work", "work/inner/new/a.txt", ""},
+		{"work", "outside/a.txt", "can only write files in"},
+		{"work", "work/../outside/a.txt", "can only write files in"},
+		{"work", "work/out/a.txt", "can only write files in"},
+		{"work", "work/out/new/a.txt", "can only write files in"},
+		{"work", "work/file", "through the symbolic link"},
+		{"work", "work/gone/a.txt", "can only write files in"},
+		{"work", "work/rel/a.txt", "can only write files in"},
+		{"work/sub", "work/a.txt", "can only write files in"},
+		{"home", "home/a.txt", "which is or contains HOME"},
+		{".", "work/a.txt", "which is or contains HOME"},
+	}
+	for _, tt := range tests {
+		dir, path := filepath.Join(root, tt.dir), filepath.Join(root, tt.path)
+		err := checkSandboxWrite(dir, path)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("checkSandboxWrite(%s, %s) = %v, want nil", tt.dir, tt.path, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("checkSandboxWrite(%s, %s) = %v, want an error with %q", tt.dir, tt.path, err, tt.want)
+		}
+	}
+}
+
+func TestResolveExisting(t *testing.T) {
+	root := sandboxTree(t)
+	tests := []struct {
+		path, want string
+	}{
+		{"work/sub", "work/sub"},
+		{"work/inner", "work/sub"},
+		{"work/inner/x/y", "work/sub/x/y"},
+		{"work/out/z", "outside/z"},
+		{"work/file", "outside/f"},
+		{"work/gone/a", "outside/gone/dir/a"},
+		{"work/rel", "outside/rel"},
+		{"missing/a/b", "missing/a/b"},
+	}
+	for _, tt := range tests {
+		got, err := resolveExisting(filepath.Join(root, tt.path))
+		if want := filepath.Join(root, tt.want); err != nil || got != want {
+			t.Errorf("resolveExisting(%s) = %q, %v, want %q", tt.path, got, err, want)
+		}
+	}
+}